@@ -0,0 +1,2047 @@
+// Package agent is System 3's coding agent: the chat loop, the model
+// providers and the built-in tools. The system3 command is a thin wrapper
+// around it, and other programs can embed it with their own tools:
+//
+//	config, err := agent.LoadConfig(os.Args[1:])
+//	...
+//	err = agent.Setup(config)
+//	...
+//	provider, err := agent.NewProvider(config)
+//	...
+//	a := agent.NewAgent(provider, readLine, agent.DefaultTools(), config, agent.NewSession(config.Model))
+//	a.RegisterTool(myTool)
+//	defer a.Close()
+//	answer, err := a.RunOnce(ctx, anthropic.NewTextBlock("..."))
+//
+// The agent reports what it does as Events to its frontends. It prints them
+// to the terminal unless Config.Headless is set; other interfaces follow
+// along with Attach:
+//
+//	detach := a.Attach(agent.FrontendFunc(func(event agent.Event) { ... }))
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/invopop/jsonschema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Version is reported to telemetry and printed at startup. The system3
+// command sets it from its build version.
+var Version = "dev"
+
+// Setup applies config's workspace and tool settings. It must be called
+// before any tool runs.
+func Setup(config Config) error {
+	err := SetWorkspaceRoot(config.Workspace)
+	if err != nil {
+		return err
+	}
+	defaultGitIdentity = config.GitIdentity
+	forgeSettings = config.Forge
+	fetchDomains = config.Permissions.Domains
+	secretFiles = config.Permissions.SecretFiles
+	pathRules = config.Permissions.Paths
+	embeddingSettings = config.Embeddings
+	sandboxSettings = config.Sandbox
+	syncWrites = config.SyncWrites
+	if config.AuditLog != "" {
+		auditLog, err = openAuditLog(config.AuditLog)
+		if err != nil {
+			return err
+		}
+	}
+	return checkSandbox(config.Sandbox)
+}
+
+// DefaultTools returns the built-in tools.
+func DefaultTools() []ToolDefinition {
+	return []ToolDefinition{ReadFileToolDefinition, ListFilesDefinition, EditFileDefinition, GitToolDefinition, ShellToolDefinition, SearchFilesDefinition, WriteFileDefinition, MkdirDefinition, ScaffoldDefinition, RunTestsDefinition, GoBuildDefinition, UndoEditDefinition, ForgeDefinition, FetchURLDefinition, StartProcessDefinition, StopProcessDefinition, ProcessLogsDefinition, DispatchAgentDefinition, LintDefinition, CodeIntelDefinition, RenameSymbolDefinition, GetOutlineDefinition, SemanticSearchDefinition, MultiEditDefinition, GlobDefinition, StatDefinition, ReadNotebookDefinition, EditNotebookDefinition, ReadOutputDefinition}
+}
+
+// NewAgent creates an agent. The most recently created agent is the one
+// dispatch_agent's sub-agents inherit tools and settings from.
+func NewAgent(provider Provider, getUserMessage func() (string, bool), tools []ToolDefinition, config Config, session *Session) *Agent {
+	memory, err := loadProjectMemory()
+	if err != nil {
+		slog.Warn("starting without project memory", "error", err)
+	}
+
+	if slices.Contains(config.ServerTools, ServerToolTextEditor) {
+		tools = append(tools, TextEditorDefinition)
+	}
+	if config.ReadOnly {
+		tools = readOnlyTools(tools)
+	}
+	var secrets *redactor
+	if config.Redact {
+		// The patterns were checked when the permissions file was loaded.
+		secrets, err = newRedactor(config.Permissions.Redact)
+		if err != nil {
+			slog.Warn("not redacting secrets from tool results", "error", err)
+		}
+	}
+	agent := &Agent{
+		provider:       provider,
+		getUserMessage: getUserMessage,
+		tools:          tools,
+		config:         config,
+		session:        session,
+		approver:       NewApprover(config.Permissions, config.AutoApprove, getUserMessage),
+		commands:       defaultCommands(),
+		frontends:      &frontends{},
+		budget:         newBudget(config),
+		redactor:       secrets,
+		planMode:       config.Plan,
+		memory:         memory,
+		hooks:          append([]Hook(nil), config.Hooks...),
+		state:          newToolState(),
+	}
+	// Formatting comes first so the hooks file's post_tool hooks see the
+	// formatted file.
+	if config.Format {
+		agent.hooks = append([]Hook{formatHook(agent.state.watched)}, agent.hooks...)
+	}
+	if !config.Headless {
+		agent.Attach(newTerminalFrontend(config))
+	}
+	subAgentParent = agent
+	return agent
+}
+
+// RegisterTool adds a tool, replacing any tool with the same name.
+func (a *Agent) RegisterTool(tool ToolDefinition) {
+	for i, existing := range a.tools {
+		if existing.Name == tool.Name {
+			a.tools[i] = tool
+			return
+		}
+	}
+	a.tools = append(a.tools, tool)
+}
+
+// Close fires the session_end hooks, stops the background processes and
+// language servers started by tools and the file watcher, removes saved
+// tool results, writes the transcript requested with --transcript and
+// flushes telemetry. Call it once the agent is done.
+func (a *Agent) Close() {
+	a.closeSession()
+	lspClients.StopAll()
+	flushTelemetry()
+}
+
+// OnExit registers cleanup, such as flushing logs, for when Ctrl+C ends the
+// chat: that exits the process without returning from Run, after closing
+// the agent and calling cleanup.
+func (a *Agent) OnExit(cleanup func()) {
+	a.onExit = cleanup
+}
+
+// exit closes the agent, runs the OnExit cleanup and exits as an
+// interrupted process does.
+func (a *Agent) exit() {
+	a.Close()
+	if a.onExit != nil {
+		a.onExit()
+	}
+	os.Exit(130)
+}
+
+// closeSession does what Close does for the agent's own session, leaving
+// the language servers, which the agents of a server share, running.
+func (a *Agent) closeSession() {
+	if a.started {
+		a.runSessionHook(HookSessionEnd)
+	}
+	a.state.close()
+	a.exportTranscript()
+}
+
+type Agent struct {
+	provider       Provider
+	getUserMessage func() (string, bool)
+	tools          []ToolDefinition
+	config         Config
+	session        *Session
+	approver       *Approver
+	commands       []SlashCommand
+	// frontends receive the agent's events, such as its streamed replies
+	// and tool calls.
+	frontends *frontends
+	// running is held while the agent works on a message, so messages from
+	// several frontends are handled one at a time.
+	running sync.Mutex
+	// mu guards the session's messages, which frontends may read while the
+	// agent works, and its title.
+	mu sync.Mutex
+	// titling is the session whose title is being or has been generated.
+	titling *Session
+	// budget is what the session spent against --max-cost and --max-turns.
+	budget *budget
+	// redactor removes secrets from tool results; nil leaves them.
+	redactor *redactor
+	// planMode describes mutating tool calls instead of running them.
+	planMode bool
+	// memory is the project memory included in the system prompt.
+	memory ProjectMemory
+	// parent is the agent that dispatched this one, nil for the chat's own
+	// agent. Sub-agent conversations are not saved as sessions.
+	parent *Agent
+	// quiet suppresses streamed replies and thinking, for sub-agents
+	// running alongside others.
+	quiet bool
+	// hooks fire around tool calls and when the session starts and ends.
+	hooks []Hook
+	// started records that the session_start hooks have fired.
+	started bool
+	// repoMap is the repository map included in the system prompt, built
+	// when the session starts.
+	repoMap string
+	// attachments are the images added with /attach, sent with the next
+	// message.
+	attachments []attachment
+	// loop stops runs of tool calls that look stuck.
+	loop loopGuard
+	// results caches read-only tool results within a few turns.
+	results resultCache
+	// state holds the undo journal, seen files and background processes
+	// of the agent's tool calls.
+	state *toolState
+	// lastUsage is the token usage of the latest model call, guarded by mu.
+	lastUsage anthropic.Usage
+	// onExit is the cleanup registered with OnExit.
+	onExit func()
+}
+
+func (a *Agent) Run(ctx context.Context) error {
+	fmt.Println("Chat with Claude (type /help for commands, Ctrl+C to interrupt a reply or exit)")
+	fmt.Println(`For multi-line input end lines with \ or wrap them in """; Ctrl+X then Enter opens $EDITOR`)
+
+	interrupts := handleInterrupts(a.exit)
+	defer interrupts.Stop()
+	a.running.Lock()
+	a.startSession()
+	a.running.Unlock()
+
+	owesReply := a.awaitsReply()
+	for {
+		var userInput string
+		if !owesReply {
+			fmt.Printf("\u001b[94mYou\u001b[0m%s: ", a.contextIndicator())
+			input, ok := a.getUserMessage()
+			if !ok {
+				break
+			}
+			if strings.TrimSpace(input) == "" {
+				continue
+			}
+			if isSlashCommand(input) {
+				err := a.runChatCommand(input)
+				if errors.Is(err, errExit) {
+					break
+				}
+				if err != nil {
+					slog.Error(err.Error())
+				}
+				// Commands such as /retry leave the prompt to answer again.
+				owesReply = a.awaitsReply()
+				continue
+			}
+			userInput = input
+		}
+		owesReply = false
+
+		err := a.respond(ctx, interrupts, userInput)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newPrompt builds the message for a prompt from the user, with the images
+// attached to it and a notice of files changed outside the agent, and starts
+// following a new run of tool calls.
+func (a *Agent) newPrompt(input string) anthropic.MessageParam {
+	a.loop.reset()
+	a.results.clear()
+	blocks := a.userMessageBlocks(input)
+	if notice := a.externalChangesNotice(); notice != "" {
+		blocks = append(blocks, anthropic.NewTextBlock(notice))
+	}
+	return anthropic.NewUserMessage(blocks...)
+}
+
+// awaitsReply reports whether the session stopped before the model
+// answered, waiting for it or in the middle of its tool calls. A resumed
+// session can stop that way.
+func (a *Agent) awaitsReply() bool {
+	return a.loopState() != StateAwaitingUser
+}
+
+// runChatCommand runs a slash command typed into the chat, while no message
+// is being handled.
+func (a *Agent) runChatCommand(input string) error {
+	a.running.Lock()
+	defer a.running.Unlock()
+	session, before := a.session, len(a.Conversation())
+	next, err := a.runCommand(input, a.Conversation())
+	a.setConversation(next)
+	// Commands such as /retry change the conversation and with it the
+	// state of the loop; a session switched to keeps its own.
+	if a.session == session && len(next) != before {
+		a.saveState(next, deriveLoopState(next), "")
+	}
+	return err
+}
+
+// respond adds the user's input to the conversation, unless it is empty
+// because the conversation already owes the model a reply, and runs turns
+// until the model answers without calling tools, the user interrupts it or
+// the loop guard stops it.
+func (a *Agent) respond(ctx context.Context, interrupts *interruptHandler, userInput string) error {
+	a.running.Lock()
+	defer a.running.Unlock()
+	defer a.emit(Event{Type: EventIdle})
+
+	conversation := a.Conversation()
+	if userInput != "" {
+		conversation = append(conversation, a.newPrompt(userInput))
+		a.saveState(conversation, StateAwaitingModel, "")
+	}
+
+	for {
+		turnCtx, done := interrupts.begin(ctx)
+		next, _, toolsCalled, err := a.turn(turnCtx, conversation)
+		interrupted := turnCtx.Err() != nil && ctx.Err() == nil
+		done()
+		conversation = next
+		if interrupted {
+			return nil
+		}
+		if errors.Is(err, ErrLoopStopped) {
+			a.emit(Event{Type: EventWarning, Text: err.Error() + "; tell the model how to proceed"})
+			return nil
+		}
+		if errors.Is(err, ErrBudgetExceeded) {
+			a.emit(Event{Type: EventWarning, Text: err.Error() + "; the next message asks again"})
+			return nil
+		}
+		if err != nil || !toolsCalled {
+			return err
+		}
+	}
+}
+
+// setConversation replaces the session's messages.
+func (a *Agent) setConversation(conversation []anthropic.MessageParam) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.session.Messages = conversation
+}
+
+// Conversation returns a copy of the conversation so far. It is safe to call
+// while the agent works.
+func (a *Agent) Conversation() []anthropic.MessageParam {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]anthropic.MessageParam(nil), a.session.Messages...)
+}
+
+// startSession builds the repository map and fires the session_start hooks
+// the first time the agent runs. The caller holds running.
+func (a *Agent) startSession() {
+	if a.started {
+		return
+	}
+	a.started = true
+	if a.parent == nil && a.config.RepoMap {
+		err := a.refreshRepoMap()
+		if err != nil {
+			slog.Warn("starting without a repository map", "error", err)
+		}
+	}
+	a.runSessionHook(HookSessionStart)
+}
+
+// RunOnce sends a single prompt and keeps running tools until the model
+// answers without calling any, then returns that final answer. Prompts sent
+// from several goroutines are handled one after another.
+func (a *Agent) RunOnce(ctx context.Context, prompt ...anthropic.ContentBlockParamUnion) (string, error) {
+	a.running.Lock()
+	defer a.running.Unlock()
+	return a.runPrompt(ctx, prompt...)
+}
+
+// ErrBusy is returned by Send while the agent is handling another message.
+var ErrBusy = errors.New("the agent is busy with another message")
+
+// Send is RunOnce for frontends that share an agent: instead of waiting for
+// a message another frontend sent to be handled, it fails with ErrBusy.
+func (a *Agent) Send(ctx context.Context, prompt ...anthropic.ContentBlockParamUnion) (string, error) {
+	if !a.running.TryLock() {
+		return "", ErrBusy
+	}
+	defer a.running.Unlock()
+	return a.runPrompt(ctx, prompt...)
+}
+
+// runPrompt runs a prompt to its final answer. The caller holds running.
+func (a *Agent) runPrompt(ctx context.Context, prompt ...anthropic.ContentBlockParamUnion) (string, error) {
+	defer a.emit(Event{Type: EventIdle})
+	a.startSession()
+	a.loop.reset()
+	a.results.clear()
+	conversation := a.Conversation()
+	if a.loopState() == StateExecutingTools {
+		// The tool calls a resumed session stopped in are finished first,
+		// as each needs its result before the conversation goes on.
+		var err error
+		conversation, _, _, err = a.turn(ctx, conversation)
+		if err != nil {
+			return "", err
+		}
+	}
+	conversation = append(conversation, anthropic.NewUserMessage(prompt...))
+	a.saveState(conversation, StateAwaitingModel, "")
+
+	for {
+		next, message, toolsCalled, err := a.turn(ctx, conversation)
+		if err != nil {
+			return "", err
+		}
+		conversation = next
+		if toolsCalled {
+			continue
+		}
+
+		var answer strings.Builder
+		for _, content := range message.Content {
+			if content.Type == "text" {
+				answer.WriteString(content.Text)
+			}
+		}
+		return answer.String(), nil
+	}
+}
+
+// turn takes the loop from awaiting_model, or from a batch of tool calls a
+// resumed session stopped in, to the next state: it sends the conversation
+// to the model and runs any tools it calls, returning the extended
+// conversation, the model's reply and whether tool results are waiting to
+// be sent back.
+func (a *Agent) turn(ctx context.Context, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, *anthropic.Message, bool, error) {
+	ctx, span := tracer.Start(ctx, "agent.turn")
+	defer span.End()
+
+	if a.loopState() == StateExecutingTools {
+		conversation, message, results, err := pendingToolCalls(conversation)
+		if err != nil {
+			return conversation, nil, false, fmt.Errorf("failed to resume the tool calls: %w", err)
+		}
+		return a.runToolCalls(ctx, conversation, message, results)
+	}
+
+	err := a.checkBudget()
+	if err != nil {
+		return conversation, nil, false, err
+	}
+	conversation = a.compactIfNeeded(ctx, conversation)
+	a.results.nextTurn()
+
+	message, err := a.runInterface(ctx, conversation)
+	if err != nil {
+		return conversation, nil, false, err
+	}
+	conversation = append(conversation, messageParam(message))
+	a.reportServerTools(message)
+
+	if !slices.ContainsFunc(message.Content, func(content anthropic.ContentBlockUnion) bool { return content.Type == "tool_use" }) {
+		// A reply paused during long server tool calls is continued by
+		// sending it back as it is.
+		if message.StopReason == "pause_turn" && ctx.Err() == nil {
+			a.saveState(conversation, StateAwaitingModel, "")
+			return conversation, message, true, nil
+		}
+		a.saveState(conversation, StateAwaitingUser, "")
+		return conversation, message, false, nil
+	}
+	a.saveState(conversation, StateExecutingTools, "")
+	a.checkpointBeforeTools(message)
+	return a.runToolCalls(ctx, conversation, message, nil)
+}
+
+// runToolCalls runs the tool calls of message, the last message of
+// conversation, that results does not answer yet, saving the session
+// before and after each one. It then adds the results to the conversation,
+// moving the loop to awaiting_model.
+func (a *Agent) runToolCalls(ctx context.Context, conversation []anthropic.MessageParam, message *anthropic.Message, results []anthropic.ContentBlockParamUnion) ([]anthropic.MessageParam, *anthropic.Message, bool, error) {
+	answered := map[string]bool{}
+	for _, result := range results {
+		if block := result.OfRequestToolResultBlock; block != nil {
+			answered[block.ToolUseID] = true
+		}
+	}
+	a.mu.Lock()
+	stoppedCall := a.session.RunningCall
+	a.mu.Unlock()
+
+	// progress is the conversation with the results so far, which a
+	// resumed session continues from.
+	progress := func() []anthropic.MessageParam {
+		if len(results) == 0 {
+			return conversation
+		}
+		return append(conversation, anthropic.NewUserMessage(results...))
+	}
+	for _, content := range message.Content {
+		if content.Type != "tool_use" || answered[content.ID] {
+			continue
+		}
+		// Every tool call needs a result, so calls skipped after an
+		// interrupt are answered with one.
+		if ctx.Err() != nil {
+			results = append(results, anthropic.NewToolResultBlock(content.ID, markToolError("interrupted by the user"), true))
+			continue
+		}
+		if content.ID == stoppedCall && !a.rerunnable(content.Name, content.Input) {
+			results = append(results, stoppedCallResult(content.ID, content.Name))
+			continue
+		}
+		a.saveState(progress(), StateExecutingTools, content.ID)
+		results = append(results, a.executeTool(ctx, content.ID, content.Name, content.Input))
+		a.saveState(progress(), StateExecutingTools, "")
+	}
+
+	if notice := a.externalChangesNotice(); notice != "" {
+		results = append(results, anthropic.NewTextBlock(notice))
+	}
+	var stopped error
+	if ctx.Err() == nil {
+		stopped = a.checkLoop(message, results)
+	}
+	if stopped != nil {
+		results = append(results, loopStoppedNotice(stopped))
+	}
+
+	conversation = progress()
+	a.saveState(conversation, StateAwaitingModel, "")
+	if stopped != nil {
+		return conversation, message, true, stopped
+	}
+	return conversation, message, true, ctx.Err()
+}
+
+// saveSession persists the conversation so far. Failing to save is reported
+// but never interrupts the chat.
+func (a *Agent) saveSession(conversation []anthropic.MessageParam) {
+	a.setConversation(conversation)
+	if a.parent != nil {
+		return
+	}
+	err := a.persistSession()
+	if err != nil {
+		slog.Warn("failed to save session", "error", err)
+	}
+	a.titleSession(conversation)
+}
+
+// persistSession writes the session to disk. It holds mu, as the session's
+// title is set in the background.
+func (a *Agent) persistSession() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.session.Save()
+}
+
+func (a *Agent) executeTool(ctx context.Context, id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
+	ctx, span := tracer.Start(ctx, "tool "+name, trace.WithAttributes(
+		attribute.String("tool.name", name),
+		attribute.String("tool.call_id", id),
+	))
+	defer span.End()
+
+	slog.Debug("tool call", "tool", name, "id", id, "input", input)
+	start := time.Now()
+	output, isError, approval := a.callToolRecovering(ctx, name, input)
+	output, redacted := a.redactor.redact(output)
+	if redacted > 0 {
+		slog.Info("redacted secrets from tool result", "tool", name, "id", id, "count", redacted)
+		a.notice("redacted %d possible secret(s) from the %s result", redacted, name)
+	}
+	result := Event{Type: EventToolResult, Tool: name, ID: id, Input: input, Text: output, IsError: isError}
+	if isError {
+		result.ErrorKind, _ = parseToolError(output)
+	}
+	a.emit(result)
+	output = a.limitResult(name, output)
+	duration := time.Since(start)
+	slog.Debug("tool result", "tool", name, "id", id, "duration", duration, "is_error", isError,
+		"output", TruncateOutput(output, maxLoggedOutput))
+	recordToolCall(ctx, span, name, duration, output, isError)
+	a.audit(auditEntry{Tool: name, CallID: id, Input: input, Approval: approval, IsError: isError, Duration: duration}, output)
+	return anthropic.NewToolResultBlock(id, output, isError)
+}
+
+// callToolRecovering runs a tool call, turning a panic into an error result
+// so a bug in one tool, or input it did not expect, cannot end the session
+// and the model can try again.
+func (a *Agent) callToolRecovering(ctx context.Context, name string, input json.RawMessage) (output string, isError bool, approval Approval) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("tool panicked", "tool", name, "panic", r)
+			slog.Debug("tool panic stack", "tool", name, "stack", string(debug.Stack()))
+			output, isError = formatToolError(fmt.Errorf("tool %s failed unexpectedly: %v; check the input and try again", name, r)), true
+		}
+	}()
+	return a.callToolCached(ctx, name, input)
+}
+
+// callToolCached runs a call, caching the results of read-only calls and
+// forgetting them once anything may have changed.
+func (a *Agent) callToolCached(ctx context.Context, name string, input json.RawMessage) (string, bool, Approval) {
+	output, isError, approval := a.callTool(ctx, name, input)
+	if approval == ApprovalCached {
+		return output, isError, approval
+	}
+	if toolDef, found := a.findTool(name); found && a.mutating(toolDef, input) {
+		a.results.clear()
+	} else if !isError {
+		a.results.store(name, input, output)
+	}
+	return output, isError, approval
+}
+
+// mutating reports whether a call of tool changes state, asking its
+// Mutating func on a's behalf.
+func (a *Agent) mutating(tool ToolDefinition, input json.RawMessage) bool {
+	return tool.Mutating != nil && tool.Mutating(context.WithValue(context.Background(), callingAgentKey{}, a), input)
+}
+
+// callTool runs a tool call through plan mode and the approval gate,
+// returning the result text, whether it is an error and how the call was
+// approved. A repeated read-only call is answered from the result cache,
+// but only once it passed the same checks and pre_tool hooks as running it
+// would.
+func (a *Agent) callTool(ctx context.Context, name string, input json.RawMessage) (string, bool, Approval) {
+	toolDef, found := a.findTool(name)
+	if !found {
+		return formatToolError(toolErrorf(ErrorNotFound, "tool %s not found", name)), true, ApprovalDenied
+	}
+
+	cached, isCached := a.results.lookup(name, input)
+	a.emit(Event{Type: EventToolCall, Tool: name, Input: input, Cached: isCached})
+	err := a.refuseInReadOnly(toolDef, input)
+	if err != nil {
+		return formatToolError(err), true, ApprovalDenied
+	}
+	if a.planMode && a.mutating(toolDef, input) {
+		return planResult(toolDef, input), false, ApprovalPlanned
+	}
+
+	approval, err := a.approver.Approve(toolDef, input)
+	if err != nil {
+		return formatToolError(err), true, approval
+	}
+
+	hook := &HookContext{Event: HookPreTool, Tool: name, Input: input}
+	err = a.runHooks(hook)
+	if err != nil {
+		return formatToolError(err), true, ApprovalDenied
+	}
+	if isCached {
+		slog.Debug("tool result from cache", "tool", name)
+		return cached, false, ApprovalCached
+	}
+
+	ctx, cancel := a.toolContext(context.WithValue(ctx, callingAgentKey{}, a), name)
+	response, err := toolDef.Function(ctx, input)
+	if err != nil {
+		err = a.toolContextError(ctx, name, err)
+	}
+	cancel()
+	hook.Event = HookPostTool
+	hook.Output = response
+	if err != nil {
+		hook.Output = formatToolError(err)
+		hook.IsError = true
+	}
+	err = a.runHooks(hook)
+	if err != nil {
+		if !hook.IsError {
+			return strings.TrimSpace(formatToolError(err) + "\n\n" + hook.Output), true, approval
+		}
+		return strings.TrimSpace(hook.Output + "\n\n" + err.Error()), true, approval
+	}
+	if hook.IsError {
+		return markToolError(hook.Output), true, approval
+	}
+	return hook.Output, false, approval
+}
+
+func (a *Agent) runInterface(ctc context.Context, conversation []anthropic.MessageParam) (*anthropic.Message, error) {
+	params := anthropic.MessageNewParams{
+		Model:     a.config.Model,
+		MaxTokens: a.config.MaxTokens,
+		Messages:  conversation,
+		Tools:     a.toolParams(),
+		System:    a.systemPrompt(),
+	}
+	if a.config.Temperature != nil {
+		params.Temperature = anthropic.Float(*a.config.Temperature)
+	}
+	if a.config.TopP != nil {
+		params.TopP = anthropic.Float(*a.config.TopP)
+	}
+	a.thinkingParams(&params)
+
+	ctc, span := tracer.Start(ctc, "model.call")
+	defer span.End()
+
+	// Text is shown as it streams in rather than after the reply is done.
+	start := time.Now()
+	onText := func(text string) {
+		a.emit(Event{Type: EventText, Text: text})
+	}
+	onThinking := func(text string) {
+		a.emit(Event{Type: EventThinking, Text: text})
+	}
+	if a.quiet {
+		onText, onThinking = func(string) {}, func(string) {}
+	}
+	message, err := a.provider.StreamMessage(ctc, params, onText, onThinking)
+	if !a.quiet {
+		a.emit(Event{Type: EventReplyDone})
+	}
+
+	recordModelCall(ctc, span, a.provider.Name(), params.Model, time.Since(start), message)
+	if err != nil {
+		slog.Debug("api call failed", "provider", a.provider.Name(), "model", params.Model,
+			"messages", len(conversation), "duration", time.Since(start), "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	a.recordUsage(params.Model, message)
+	a.mu.Lock()
+	a.lastUsage = message.Usage
+	a.mu.Unlock()
+	slog.Debug("api call", "provider", a.provider.Name(), "model", params.Model,
+		"messages", len(conversation), "duration", time.Since(start), "stop_reason", message.StopReason,
+		"input_tokens", message.Usage.InputTokens, "output_tokens", message.Usage.OutputTokens,
+		"cache_read_tokens", message.Usage.CacheReadInputTokens, "cache_write_tokens", message.Usage.CacheCreationInputTokens)
+	return message, nil
+}
+
+// toolParams describes the agent's tools, and the server tools it offers,
+// to the model.
+func (a *Agent) toolParams() []anthropic.ToolUnionParam {
+	var anthropicTools []anthropic.ToolUnionParam
+	for _, tool := range a.tools {
+		if tool.Name == textEditorName {
+			anthropicTools = append(anthropicTools, textEditorParam())
+			continue
+		}
+		anthropicTools = append(anthropicTools, anthropic.ToolUnionParam{
+			OfTool: &anthropic.ToolParam{
+				Name:        tool.Name,
+				Description: anthropic.String(tool.Description),
+				InputSchema: tool.InputSchema,
+			},
+		})
+	}
+	return append(anthropicTools, serverToolParams(a.config.ServerTools)...)
+}
+
+// systemPrompt returns the system prompt blocks: the configured prompt, the
+// project memory, the repository map and the instructions of the current
+// mode.
+func (a *Agent) systemPrompt() []anthropic.TextBlockParam {
+	var system []anthropic.TextBlockParam
+	if a.config.SystemPrompt != "" {
+		system = append(system, anthropic.TextBlockParam{Text: a.config.SystemPrompt})
+	}
+	if prompt := a.memory.prompt(); prompt != "" {
+		system = append(system, anthropic.TextBlockParam{Text: prompt})
+	}
+	if a.repoMap != "" {
+		system = append(system, anthropic.TextBlockParam{Text: repoMapPrompt(a.repoMap)})
+	}
+	if a.planMode {
+		system = append(system, anthropic.TextBlockParam{Text: planModePrompt})
+	}
+	if a.config.ReadOnly {
+		system = append(system, anthropic.TextBlockParam{Text: readOnlyPrompt})
+	}
+	return system
+}
+
+type ToolDefinition struct {
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	InputSchema anthropic.ToolInputSchemaParam `json:"input_schema"`
+	// Function runs a call. It should stop and return when ctx is done,
+	// which happens when the user interrupts or the tool's timeout passes.
+	Function func(ctx context.Context, input json.RawMessage) (string, error)
+	// Preview describes what a call would change and whether it is
+	// destructive. Destructive calls go through the approval gate.
+	Preview func(input json.RawMessage) (string, bool) `json:"-"`
+	// Mutating reports whether a call changes files, repositories or other
+	// state. Nil means the tool only reads. Plan mode describes mutating
+	// calls instead of running them. ctx carries the agent asking, as for
+	// Function.
+	Mutating func(ctx context.Context, input json.RawMessage) bool `json:"-"`
+	// Command returns the shell command a call runs, for tools that run
+	// one, so that the permissions file's shell rules apply to it.
+	Command func(input json.RawMessage) (string, bool) `json:"-"`
+}
+
+// alwaysMutating is the Mutating func for tools whose every call changes
+// state.
+func alwaysMutating(ctx context.Context, input json.RawMessage) bool {
+	return true
+}
+
+func GenerateSchema[T any]() anthropic.ToolInputSchemaParam {
+	reflector := jsonschema.Reflector{
+		AllowAdditionalProperties: false,
+		DoNotReference:            true,
+	}
+
+	var v T
+	schema := reflector.Reflect(v)
+
+	return anthropic.ToolInputSchemaParam{
+		Properties: schema.Properties,
+	}
+}
+
+// read_file tool
+
+var ReadFileToolDefinition = ToolDefinition{
+	Name:        "read_file",
+	Description: "Reads a file's contents, given a relative path. Useful for inspecting a file but does not work with directory names. For large files, pass start_line and/or end_line to read only part of the file; the lines are returned numbered. PDF and Word (.docx) files are returned as their extracted text, page by page.",
+	InputSchema: ReadFileInputSchema,
+	Function:    ReadFile,
+}
+
+type ReadFileInput struct {
+	Path      string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	StartLine int    `json:"start_line,omitempty" jsonschema_description:"Optional 1-based line to start reading from."`
+	EndLine   int    `json:"end_line,omitempty" jsonschema_description:"Optional 1-based line to stop reading at, inclusive."`
+}
+
+var ReadFileInputSchema = GenerateSchema[ReadFileInput]()
+
+func ReadFile(ctx context.Context, input json.RawMessage) (string, error) {
+	readFileInput := ReadFileInput{}
+	err := json.Unmarshal(input, &readFileInput)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := resolvePath(readFileInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", toolErrorf(ErrorInvalidInput, "%s is a directory, use list_files instead", readFileInput.Path)
+	}
+	if isSecretFile(path) {
+		return "", refuseSecretFile(readFileInput.Path)
+	}
+
+	ranged := readFileInput.StartLine != 0 || readFileInput.EndLine != 0
+	if extractor, ok := documentExtractorFor(path); ok {
+		text, err := readDocument(path, extractor)
+		if err != nil {
+			return "", err
+		}
+		toolStateFor(ctx).watched.seen(path)
+		if ranged {
+			return numberedLines(text, readFileInput.StartLine, readFileInput.EndLine)
+		}
+		if len(text) > maxReadFileBytes {
+			shown := trimPartialRune([]byte(text[:maxReadFileBytes]))
+			return fmt.Sprintf("%s\n... (truncated: showing the first %d of %d bytes of extracted text, use start_line/end_line to read the rest)",
+				shown, len(shown), len(text)), nil
+		}
+		return text, nil
+	}
+
+	// Whole-file reads only load as much as can be returned; line ranges
+	// need the full file to find their lines.
+	limit := info.Size()
+	if !ranged && limit > maxReadFileBytes {
+		limit = maxReadFileBytes
+	}
+	if ranged && limit > maxRangedReadBytes {
+		return "", toolErrorf(ErrorTooLarge, "%s is %d bytes, too large to read by line; use search_files to find what you need in it", readFileInput.Path, limit)
+	}
+
+	content, err := readFilePrefix(path, limit)
+	if err != nil {
+		return "", err
+	}
+	toolStateFor(ctx).watched.seen(path)
+
+	if isBinary(content) {
+		return describeBinaryFile(readFileInput.Path, info.Size(), content), nil
+	}
+
+	if !ranged {
+		if info.Size() > int64(len(content)) {
+			content = trimPartialRune(content)
+			return fmt.Sprintf("%s\n... (truncated: showing the first %d of %d bytes, use start_line/end_line to read the rest)",
+				content, len(content), info.Size()), nil
+		}
+		return string(content), nil
+	}
+
+	return numberedLines(string(content), readFileInput.StartLine, readFileInput.EndLine)
+}
+
+func readFilePrefix(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, limit))
+}
+
+// maxReadFileBytes caps how much of a file read_file returns at once.
+const maxReadFileBytes = 256 * 1024
+
+// maxRangedReadBytes caps the files read_file reads line ranges from, as
+// finding the lines loads the whole file.
+const maxRangedReadBytes = 64 << 20
+
+// binarySniffLength is how much of a file is inspected to decide whether it
+// is binary.
+const binarySniffLength = 8000
+
+// isBinary uses the same heuristic as git: content with a NUL byte near the
+// start is treated as binary.
+func isBinary(content []byte) bool {
+	sniff := content
+	if len(sniff) > binarySniffLength {
+		sniff = sniff[:binarySniffLength]
+	}
+	return bytes.IndexByte(sniff, 0) != -1 || !utf8.Valid(trimPartialRune(sniff))
+}
+
+// trimPartialRune drops a multi-byte UTF-8 sequence cut off at the end of a
+// prefix, so a sniffed prefix isn't mistaken for invalid text and a
+// truncated one doesn't end in half a character.
+func trimPartialRune(content []byte) []byte {
+	for i := 0; i < utf8.UTFMax && i < len(content); i++ {
+		if utf8.RuneStart(content[len(content)-1-i]) {
+			if !utf8.FullRune(content[len(content)-1-i:]) {
+				return content[:len(content)-1-i]
+			}
+			break
+		}
+	}
+	return content
+}
+
+func describeBinaryFile(path string, size int64, content []byte) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+	return fmt.Sprintf("Binary file not shown\npath: %s\nsize: %d bytes\ntype: %s", path, size, mimeType)
+}
+
+// numberedLines returns lines start through end (1-based, inclusive) prefixed
+// with their line numbers. Zero start or end means the first or last line.
+func numberedLines(content string, start, end int) (string, error) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	total := len(lines)
+
+	if start <= 0 {
+		start = 1
+	}
+	if end <= 0 || end > total {
+		end = total
+	}
+	if start > total {
+		return "", toolErrorf(ErrorInvalidInput, "start_line %d is past the end of the file (%d lines)", start, total)
+	}
+	if start > end {
+		return "", toolErrorf(ErrorInvalidInput, "start_line %d is after end_line %d", start, end)
+	}
+
+	var output strings.Builder
+	for i := start; i <= end; i++ {
+		output.WriteString(fmt.Sprintf("%6d\t%s\n", i, lines[i-1]))
+	}
+	output.WriteString(fmt.Sprintf("(lines %d-%d of %d)", start, end, total))
+
+	return output.String(), nil
+}
+
+// list_files tool
+
+var ListFilesDefinition = ToolDefinition{
+	Name: "list_files",
+	Description: `List files and directories at a given path. If no path is provided, lists files in the current directory.
+
+Directories end in "/". At most max_entries entries (1000 by default) are returned; use max_depth and dirs_only to get an overview of a large tree before listing its parts.`,
+	InputSchema: ListFilesInputSchema,
+	Function:    ListFiles,
+}
+
+// defaultListFilesMaxEntries keeps listing a large repository from flooding
+// the context.
+const defaultListFilesMaxEntries = 1000
+
+type ListFilesInput struct {
+	Path       string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	MaxDepth   int    `json:"max_depth,omitempty" jsonschema_description:"Optional number of directory levels to descend; 1 lists only the direct children of path. Defaults to no limit."`
+	MaxEntries int    `json:"max_entries,omitempty" jsonschema_description:"Maximum number of entries to return. Defaults to 1000."`
+	DirsOnly   bool   `json:"dirs_only,omitempty" jsonschema_description:"List only directories. Defaults to false."`
+}
+
+var ListFilesInputSchema = GenerateSchema[ListFilesInput]()
+
+func ListFiles(ctx context.Context, input json.RawMessage) (string, error) {
+	listFilesInput := ListFilesInput{}
+	err := json.Unmarshal(input, &listFilesInput)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := resolvePath(listFilesInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	maxEntries := defaultListFilesMaxEntries
+	if listFilesInput.MaxEntries > 0 {
+		maxEntries = listFilesInput.MaxEntries
+	}
+
+	files := []string{}
+	truncated := false
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := slashRel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if !info.IsDir() && listFilesInput.DirsOnly {
+			return nil
+		}
+
+		if len(files) == maxEntries {
+			truncated = true
+			return filepath.SkipAll
+		}
+		if info.IsDir() {
+			files = append(files, relPath+"/")
+		} else {
+			files = append(files, relPath)
+		}
+
+		depth := strings.Count(relPath, "/") + 1
+		if info.IsDir() && listFilesInput.MaxDepth > 0 && depth >= listFilesInput.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(files)
+	if err != nil {
+		return "", err
+	}
+
+	if truncated {
+		return fmt.Sprintf("%s\n... (truncated at %d entries; narrow the listing with path, max_depth or dirs_only, or raise max_entries)", result, maxEntries), nil
+	}
+	return string(result), nil
+}
+
+// edit_file tool
+
+var EditFileDefinition = ToolDefinition{
+	Name: "edit_file",
+	Description: `Make edits to a text file.
+
+Replaces 'old_str' with 'new_str' in the given file. 'old_str' and 'new_str' MUST be different from each other.
+
+By default 'old_str' must match exactly once; include enough surrounding context to make it unique. Set replace_all to replace every match, or expected_occurrences to replace exactly that many.
+
+If the file changed since you last read it, for example because the user edited it, the edit is refused so their changes are not lost; read it again, or set force to edit anyway.
+
+Write line endings as \n: files with CRLF line endings keep them, and a file keeps its final newline, or lack of one.
+
+If the file specified with path doesn't exist, it will be created. To create a new file or rewrite a whole file, prefer write_file.
+`,
+	InputSchema: EditFileInputSchema,
+	Function:    EditFile,
+	Preview:     PreviewEditFile,
+	Mutating:    alwaysMutating,
+}
+
+type EditFileInput struct {
+	Path   string `json:"path" jsonschema_description:"The path to the file"`
+	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly and must only have one match exactly"`
+	NewStr string `json:"new_str" jsonschema_description:"Text to replace old_str with"`
+	// ReplaceAll and ExpectedOccurrences relax the default of exactly one
+	// match.
+	ReplaceAll          bool `json:"replace_all,omitempty" jsonschema_description:"Replace every occurrence of old_str. Defaults to false."`
+	ExpectedOccurrences int  `json:"expected_occurrences,omitempty" jsonschema_description:"Optional number of occurrences of old_str that must be found; all of them are replaced."`
+	Force               bool `json:"force,omitempty" jsonschema_description:"Edit even if the file changed since you last read it. Defaults to false; prefer reading the file again."`
+}
+
+var EditFileInputSchema = GenerateSchema[EditFileInput]()
+
+func EditFile(ctx context.Context, input json.RawMessage) (string, error) {
+	editFileInput := EditFileInput{}
+	err := json.Unmarshal(input, &editFileInput)
+	if err != nil {
+		return "", err
+	}
+
+	state := toolStateFor(ctx)
+	edit, err := planEdit(editFileInput, state.watched)
+	if err != nil {
+		return "", err
+	}
+	if edit.create {
+		err = state.journal.Record("edit_file", edit.path)
+		if err != nil {
+			return "", err
+		}
+		err = createNewFile(edit.path, edit.newContent)
+		if err != nil {
+			return "", err
+		}
+		state.watched.seen(edit.path)
+		return fmt.Sprintf("Successfully created file %s", editFileInput.Path), nil
+	}
+
+	err = state.journal.Record("edit_file", edit.path)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeFileAtomic(edit.path, []byte(edit.newContent), fileMode(edit.path))
+	if err != nil {
+		return "", err
+	}
+	state.watched.seen(edit.path)
+
+	if edit.occurrences > 1 {
+		return fmt.Sprintf("OK, replaced %d occurrences", edit.occurrences), nil
+	}
+	return "OK", nil
+}
+
+// plannedEdit is the change an edit_file call makes, worked out before
+// anything is written so it can be previewed exactly as it will be applied.
+type plannedEdit struct {
+	path        string
+	create      bool
+	oldContent  string
+	newContent  string
+	occurrences int
+}
+
+// planEdit checks an edit_file call against the file and computes its new
+// content. With watched set, edits to files changed since they were read
+// are refused.
+func planEdit(editFileInput EditFileInput, watched *fileWatcher) (plannedEdit, error) {
+	if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
+		return plannedEdit{}, toolErrorf(ErrorInvalidInput, "invalid input parameters")
+	}
+
+	path, err := resolvePath(editFileInput.Path)
+	if err != nil {
+		return plannedEdit{}, err
+	}
+	if watched != nil && !editFileInput.Force && watched.check(path) {
+		return plannedEdit{}, fmt.Errorf("%w, or set force to edit it anyway", errChangedSinceRead(path))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && editFileInput.OldStr == "" {
+			return plannedEdit{path: path, create: true, newContent: editFileInput.NewStr}, nil
+		}
+		return plannedEdit{}, err
+	}
+
+	oldContent := string(content)
+	if editFileInput.OldStr == "" {
+		return plannedEdit{}, toolErrorf(ErrorInvalidInput, "old_str is empty but %s already exists, use write_file to replace the whole file", editFileInput.Path)
+	}
+
+	// Matching ignores CRLF line endings, which the model does not write,
+	// and the file keeps them and its final newline or lack of one.
+	format := detectLineFormat(oldContent)
+	text := format.normalize(oldContent)
+	oldStr, newStr := format.normalize(editFileInput.OldStr), format.normalize(editFileInput.NewStr)
+	occurrences := strings.Count(text, oldStr)
+	switch {
+	case occurrences == 0:
+		return plannedEdit{}, toolErrorf(ErrorNotFound, "old_str not found in file")
+	case editFileInput.ExpectedOccurrences > 0 && occurrences != editFileInput.ExpectedOccurrences:
+		return plannedEdit{}, toolErrorf(ErrorInvalidInput, "old_str found %d times, expected %d", occurrences, editFileInput.ExpectedOccurrences)
+	case editFileInput.ExpectedOccurrences == 0 && !editFileInput.ReplaceAll && occurrences > 1:
+		return plannedEdit{}, toolErrorf(ErrorInvalidInput, "old_str found %d times, add surrounding context to make it unique or set replace_all", occurrences)
+	}
+
+	return plannedEdit{
+		path:        path,
+		oldContent:  oldContent,
+		newContent:  format.restore(strings.Replace(text, oldStr, newStr, -1)),
+		occurrences: occurrences,
+	}, nil
+}
+
+// PreviewEditFile shows the edit as a unified diff of the file. An edit that
+// would be refused is not asked about, as the call only reports why.
+func PreviewEditFile(input json.RawMessage) (string, bool) {
+	editFileInput := EditFileInput{}
+	err := json.Unmarshal(input, &editFileInput)
+	if err != nil {
+		return fmt.Sprintf("edit with invalid input: %s", input), true
+	}
+
+	edit, err := planEdit(editFileInput, nil)
+	if err != nil {
+		return fmt.Sprintf("edit %s: %v", editFileInput.Path, err), false
+	}
+	if edit.create {
+		return fmt.Sprintf("create %s:\n%s", editFileInput.Path, prefixLines(editFileInput.NewStr, "+ ")), true
+	}
+	name := filepath.ToSlash(editFileInput.Path)
+	return fmt.Sprintf("edit %s:\n%s", editFileInput.Path,
+		strings.TrimRight(unifiedDiff("a/"+name, "b/"+name, edit.oldContent, edit.newContent), "\n")), true
+}
+
+func prefixLines(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func createNewFile(filePath, content string) error {
+	dirPath := filepath.Dir(filePath)
+	if dirPath != "." {
+		err := os.MkdirAll(dirPath, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+
+	err := writeFileAtomic(filePath, []byte(content), newFileMode)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	return nil
+}
+
+// Git tool definition
+
+var GitToolDefinition = ToolDefinition{
+	Name:        "git",
+	Description: "Perform Git operations like init, clone, add, commit, fetch, pull, push, merge, rebase, cherry-pick, tag, and status on repositories. Conflicts are reported per file with their conflict regions. For tag, a message creates an annotated tag; without one the tag is lightweight.",
+	InputSchema: GitInputSchema,
+	Function:    GitOperation,
+	Preview:     PreviewGitOperation,
+	Mutating:    GitMutating,
+}
+
+type GitInput struct {
+	Command     string `json:"command" jsonschema_description:"Git command to execute. Supported commands: init, clone, add, commit, status, log, show, branch, diff, reset, fetch, pull, push, remote-update, checkout, merge, rebase, cherry-pick, tag, delete-tag, push-tag"`
+	Path        string `json:"path,omitempty" jsonschema_description:"Path where the repository is located or should be created"`
+	URL         string `json:"url,omitempty" jsonschema_description:"URL of the repository to clone"`
+	Files       string `json:"files,omitempty" jsonschema_description:"Files to add, comma-separated or glob pattern. For log, show and diff: comma-separated paths to limit the output to"`
+	Message     string `json:"message,omitempty" jsonschema_description:"Commit message"`
+	BranchName  string `json:"branch_name,omitempty" jsonschema_description:"Branch name for branch operations. For merge, the branch or revision to merge into the current branch. For rebase, the branch to rebase the current branch onto"`
+	Remote      string `json:"remote,omitempty" jsonschema_description:"Remote name for fetch, pull and push. Defaults to origin, or the first remote if there is no origin"`
+	Create      bool   `json:"create,omitempty" jsonschema_description:"For checkout: create the branch from HEAD and switch to it, like git checkout -b"`
+	Abort       bool   `json:"abort,omitempty" jsonschema_description:"For merge, rebase and cherry-pick: abort the operation in progress"`
+	Continue    bool   `json:"continue,omitempty" jsonschema_description:"For rebase and cherry-pick: continue after conflicts were resolved and staged"`
+	Mode        string `json:"mode,omitempty" jsonschema_description:"For reset: soft (keep index and files), mixed (reset the index, keep files; the default) or hard (discard all changes)"`
+	ConfirmHard bool   `json:"confirm_hard,omitempty" jsonschema_description:"For reset: must be true for a hard reset, confirming that uncommitted changes will be lost"`
+	Tag         string `json:"tag,omitempty" jsonschema_description:"Tag name for tag, delete-tag and push-tag. tag without a name lists tags; push-tag without a name pushes all tags"`
+	Count       int    `json:"count,omitempty" jsonschema_description:"For log: maximum number of commits to return. Defaults to 10, maximum 200"`
+	Skip        int    `json:"skip,omitempty" jsonschema_description:"For log: number of matching commits to skip, for paging through history"`
+	Author      string `json:"author,omitempty" jsonschema_description:"For log: only commits whose author name or email contains this text"`
+	Since       string `json:"since,omitempty" jsonschema_description:"For log: only commits on or after this date (YYYY-MM-DD or RFC 3339)"`
+	Until       string `json:"until,omitempty" jsonschema_description:"For log: only commits on or before this date (YYYY-MM-DD or RFC 3339)"`
+	Revision    string `json:"revision,omitempty" jsonschema_description:"For show: the commit to show, defaults to HEAD. For log: the ref to list history from, defaults to HEAD. For reset: the commit to reset to, defaults to HEAD. For diff: compare refs instead of the working tree, as base..head, base...head (from the merge base) or a single ref compared with HEAD. For tag: the commit or ref to tag, defaults to HEAD. For cherry-pick: comma-separated commits or ranges (a..b) to apply"`
+}
+
+var GitInputSchema = GenerateSchema[GitInput]()
+
+func GitOperation(ctx context.Context, input json.RawMessage) (string, error) {
+	gitInput := GitInput{}
+	err := json.Unmarshal(input, &gitInput)
+	if err != nil {
+		return "", err
+	}
+
+	// An empty path is the workspace root
+	gitInput.Path, err = resolvePath(gitInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	switch gitInput.Command {
+	case "init":
+		return gitInit(gitInput.Path)
+	case "clone":
+		return gitClone(ctx, gitInput.URL, gitInput.Path)
+	case "add":
+		return gitAdd(gitInput.Path, gitInput.Files)
+	case "commit":
+		return gitCommit(gitInput.Path, gitInput.Message)
+	case "status":
+		return gitStatus(gitInput.Path)
+	case "log":
+		return gitLog(gitInput.Path, logQuery{
+			Revision: gitInput.Revision,
+			Count:    gitInput.Count,
+			Skip:     gitInput.Skip,
+			Author:   gitInput.Author,
+			Files:    gitInput.Files,
+			Since:    gitInput.Since,
+			Until:    gitInput.Until,
+		})
+	case "branch":
+		return gitBranch(gitInput.Path, gitInput.BranchName)
+	case "reset":
+		return gitReset(gitInput.Path, gitInput.Mode, gitInput.Revision, gitInput.ConfirmHard)
+	case "diff":
+		if gitInput.Revision != "" {
+			return gitDiffRefs(gitInput.Path, gitInput.Revision, gitInput.Files)
+		}
+		return gitDiff(gitInput.Path, gitInput.Files)
+	case "show":
+		return gitShow(gitInput.Path, gitInput.Revision, gitInput.Files)
+	case "fetch":
+		return gitFetch(ctx, gitInput.Path, gitInput.Remote, gitInput.BranchName)
+	case "pull":
+		return gitPull(ctx, gitInput.Path, gitInput.Remote, gitInput.BranchName)
+	case "push":
+		return gitPush(ctx, gitInput.Path, gitInput.Remote, gitInput.BranchName)
+	case "checkout":
+		return gitCheckout(gitInput.Path, gitInput.BranchName, gitInput.Create)
+	case "merge":
+		return gitMerge(gitInput.Path, gitInput.BranchName, gitInput.Abort)
+	case "rebase":
+		return gitRebase(gitInput.Path, gitInput.BranchName, gitInput.Abort, gitInput.Continue)
+	case "cherry-pick":
+		return gitCherryPick(gitInput.Path, gitInput.Revision, gitInput.Abort, gitInput.Continue)
+	case "tag":
+		return gitTag(gitInput.Path, gitInput.Tag, gitInput.Revision, gitInput.Message)
+	case "delete-tag":
+		return gitDeleteTag(gitInput.Path, gitInput.Tag)
+	case "push-tag":
+		return gitPushTag(ctx, gitInput.Path, gitInput.Remote, gitInput.Tag)
+	default:
+		return "", toolErrorf(ErrorInvalidInput, "unsupported git command: %s", gitInput.Command)
+	}
+}
+
+// GitMutating reports whether a git call changes the repository or working
+// tree. Fetch only updates remote-tracking refs and counts as reading.
+func GitMutating(ctx context.Context, input json.RawMessage) bool {
+	gitInput := GitInput{}
+	err := json.Unmarshal(input, &gitInput)
+	if err != nil {
+		return true
+	}
+
+	switch gitInput.Command {
+	case "status", "log", "show", "diff", "fetch":
+		return false
+	case "branch":
+		return gitInput.BranchName != ""
+	case "tag":
+		return gitInput.Tag != ""
+	default:
+		return true
+	}
+}
+
+func PreviewGitOperation(input json.RawMessage) (string, bool) {
+	gitInput := GitInput{}
+	err := json.Unmarshal(input, &gitInput)
+	if err != nil {
+		return "", false
+	}
+
+	if gitInput.Path == "" {
+		gitInput.Path = "."
+	}
+
+	switch gitInput.Command {
+	case "reset":
+		revision := orDefault(gitInput.Revision, "HEAD")
+		switch orDefault(gitInput.Mode, "mixed") {
+		case "hard":
+			description := fmt.Sprintf("hard reset %s to %s, discarding all uncommitted changes", gitInput.Path, revision)
+			if path, err := resolvePath(gitInput.Path); err == nil {
+				if status, err := gitStatus(path); err == nil && status != "" {
+					description += ":\n" + status
+				}
+			}
+			return description, true
+		default:
+			// Unstaging at HEAD loses nothing; moving the branch can orphan
+			// commits.
+			description := fmt.Sprintf("%s reset %s to %s", orDefault(gitInput.Mode, "mixed"), gitInput.Path, revision)
+			return description, revision != "HEAD"
+		}
+	case "push":
+		return fmt.Sprintf("push branch %s of %s to remote %s", orDefault(gitInput.BranchName, "(current)"), gitInput.Path, orDefault(gitInput.Remote, "(default)")), true
+	case "rebase":
+		if gitInput.Abort || gitInput.Continue {
+			return fmt.Sprintf("git rebase in %s", gitInput.Path), false
+		}
+		return fmt.Sprintf("rebase the current branch of %s onto %s, rewriting its commits", gitInput.Path, gitInput.BranchName), true
+	case "cherry-pick":
+		if gitInput.Abort || gitInput.Continue {
+			return fmt.Sprintf("git cherry-pick in %s", gitInput.Path), false
+		}
+		return fmt.Sprintf("cherry-pick %s onto the current branch of %s", gitInput.Revision, gitInput.Path), true
+	case "merge":
+		if gitInput.Abort {
+			return fmt.Sprintf("git merge in %s", gitInput.Path), false
+		}
+		return fmt.Sprintf("merge %s into the current branch of %s", gitInput.BranchName, gitInput.Path), true
+	case "commit":
+		return fmt.Sprintf("commit the staged changes in %s: %s", gitInput.Path, gitInput.Message), true
+	case "push-tag":
+		return fmt.Sprintf("push tag %s of %s to remote %s", orDefault(gitInput.Tag, "(all tags)"), gitInput.Path, orDefault(gitInput.Remote, "(default)")), true
+	case "delete-tag":
+		return fmt.Sprintf("delete tag %s in %s", gitInput.Tag, gitInput.Path), true
+	default:
+		return fmt.Sprintf("git %s in %s", gitInput.Command, gitInput.Path), false
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func gitInit(path string) (string, error) {
+	_, err := git.PlainInit(path, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize git repository: %w", err)
+	}
+
+	return fmt.Sprintf("Initialized empty Git repository in %s", path), nil
+}
+
+func gitClone(ctx context.Context, url, path string) (string, error) {
+	if url == "" {
+		return "", toolErrorf(ErrorInvalidInput, "URL is required for clone operation")
+	}
+
+	_, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
+		URL: url,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	return fmt.Sprintf("Cloned repository %s to %s", url, path), nil
+}
+
+func gitAdd(path, files string) (string, error) {
+	if files == "" {
+		return "", toolErrorf(ErrorInvalidInput, "files parameter is required for add operation")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// Handle comma-separated file list
+	fileList := strings.Split(files, ",")
+	for _, file := range fileList {
+		file = strings.TrimSpace(file)
+		_, err := w.Add(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to add file %s: %w", file, err)
+		}
+	}
+
+	return fmt.Sprintf("Added files: %s", files), nil
+}
+
+func gitCommit(path, message string) (string, error) {
+	if message == "" {
+		return "", toolErrorf(ErrorInvalidInput, "commit message is required")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	author, committer, err := commitSignatures(r)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := commitSigner(r)
+	if err != nil {
+		return "", err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	commit, err := w.Commit(message, &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+		Signer:    signer,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to commit: %w", err)
+	}
+
+	obj, err := r.CommitObject(commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit object: %w", err)
+	}
+
+	return fmt.Sprintf("Created commit: %s with message: %s", obj.Hash, message), nil
+}
+
+func gitStatus(path string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return "", fmt.Errorf("failed to get status: %w", err)
+	}
+
+	return status.String(), nil
+}
+
+func gitBranch(path, branchName string) (string, error) {
+	if branchName == "" {
+		// List branches if no branch name provided
+		return listBranches(path)
+	}
+
+	// Create new branch
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Get HEAD reference
+	head, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	// Create new branch reference
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branchName), head.Hash())
+
+	// Save branch
+	err = r.Storer.SetReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to create branch: %w", err)
+	}
+
+	return fmt.Sprintf("Created branch: %s", branchName), nil
+}
+
+// resetModes maps the reset mode names accepted by the git tool to go-git's.
+var resetModes = map[string]git.ResetMode{
+	"soft":  git.SoftReset,
+	"mixed": git.MixedReset,
+	"hard":  git.HardReset,
+}
+
+// gitReset moves the current branch to revision (HEAD by default). Soft
+// keeps the index and working tree, mixed (the default) resets the index,
+// and hard also discards working tree changes, which must be confirmed.
+func gitReset(path, mode, revision string, confirmHard bool) (string, error) {
+	mode = orDefault(mode, "mixed")
+	resetMode, ok := resetModes[mode]
+	if !ok {
+		return "", toolErrorf(ErrorInvalidInput, "unknown reset mode %q, expected soft, mixed or hard", mode)
+	}
+	if resetMode == git.HardReset && !confirmHard {
+		return "", fmt.Errorf("a hard reset discards all uncommitted changes; set confirm_hard to true to do it anyway, or use mode mixed or soft")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	revision = orDefault(revision, "HEAD")
+	target, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", revision, err)
+	}
+
+	err = w.Reset(&git.ResetOptions{
+		Commit: *target,
+		Mode:   resetMode,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to reset: %w", err)
+	}
+
+	return fmt.Sprintf("Reset (%s) to %s (%s)", mode, revision, target.String()[:7]), nil
+}
+
+func gitDiff(path, files string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// Get the current worktree status
+	status, err := w.Status()
+	if err != nil {
+		return "", fmt.Errorf("failed to get status: %w", err)
+	}
+
+	// If no files are specified, show diff for all modified files
+	if files == "" {
+		var output strings.Builder
+		for filePath, fileStatus := range status {
+			if fileStatus.Worktree != git.Unmodified || fileStatus.Staging != git.Unmodified {
+				diffOutput, err := diffFile(r, w, filePath)
+				if err != nil {
+					output.WriteString(fmt.Sprintf("Error getting diff for %s: %s\n", filePath, err))
+					continue
+				}
+				output.WriteString(diffOutput)
+			}
+		}
+		if output.Len() == 0 {
+			return "No changes detected", nil
+		}
+		return output.String(), nil
+	}
+
+	// Show diff for specific files
+	fileList := strings.Split(files, ",")
+	var output strings.Builder
+	for _, filePath := range fileList {
+		filePath = strings.TrimSpace(filePath)
+		diffOutput, err := diffFile(r, w, filePath)
+		if err != nil {
+			output.WriteString(fmt.Sprintf("Error getting diff for %s: %s\n", filePath, err))
+			continue
+		}
+		output.WriteString(diffOutput)
+	}
+
+	if output.Len() == 0 {
+		return "No changes detected in specified files", nil
+	}
+
+	return output.String(), nil
+}
+
+// Helper function to get a unified diff between HEAD and the worktree for a
+// single file
+func diffFile(r *git.Repository, w *git.Worktree, filePath string) (string, error) {
+	newName := "b/" + filePath
+
+	// Get the current file content
+	currentContentBytes, err := os.ReadFile(filepath.Join(w.Filesystem.Root(), filePath))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// File was deleted
+		newName = "/dev/null"
+	}
+	currentContent := string(currentContentBytes)
+
+	// Get the content from HEAD
+	oldName, previousContent, err := headFileContent(r, filePath, "a/"+filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(oldName, newName, previousContent, currentContent), nil
+}
+
+// headFileContent returns the content of filePath at HEAD. Files missing from
+// HEAD, including in a repository without commits, are reported as /dev/null
+// with empty content.
+func headFileContent(r *git.Repository, filePath, name string) (string, string, error) {
+	head, err := r.Head()
+	if err != nil {
+		return "/dev/null", "", nil
+	}
+
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return "", "", err
+	}
+
+	fileInHead, err := commit.File(filePath)
+	if err != nil {
+		return "/dev/null", "", nil
+	}
+
+	content, err := fileInHead.Contents()
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, content, nil
+}
+
+func gitFetch(ctx context.Context, path, remote, branchName string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	remoteName, err := resolveRemote(r, remote)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return "", err
+	}
+
+	// Create fetch options
+	fetchOpts := &git.FetchOptions{
+		RemoteName: remoteName,
+		Force:      false,
+		Auth:       auth,
+	}
+
+	// If a specific branch is requested, fetch only that branch
+	if branchName != "" {
+		// Construct proper refspec for the branch
+		refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/remotes/%s/%s", branchName, remoteName, branchName))
+		fetchOpts.RefSpecs = []config.RefSpec{refSpec}
+	}
+
+	// Perform the fetch
+	err = r.FetchContext(ctx, fetchOpts)
+
+	// Handle common errors
+	if err != nil {
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			if branchName != "" {
+				return fmt.Sprintf("Branch '%s' is already up-to-date", branchName), nil
+			}
+			return "Repository is already up-to-date", nil
+		} else if isAuthError(err) {
+			return "", authFailure(err)
+		} else {
+			return "", fmt.Errorf("fetch failed: %w", err)
+		}
+	}
+
+	// Success message
+	if branchName != "" {
+		return fmt.Sprintf("Successfully fetched updates from '%s' for branch '%s'", remoteName, branchName), nil
+	}
+	return fmt.Sprintf("Successfully fetched all updates from '%s'", remoteName), nil
+}
+
+func gitPull(ctx context.Context, path, remote, branchName string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	remoteName, err := resolveRemote(r, remote)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return "", err
+	}
+
+	pullOpts := &git.PullOptions{
+		RemoteName: remoteName,
+		Auth:       auth,
+	}
+	if branchName != "" {
+		pullOpts.ReferenceName = plumbing.NewBranchReferenceName(branchName)
+	}
+
+	err = w.PullContext(ctx, pullOpts)
+	if err != nil {
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return "Already up-to-date", nil
+		} else if errors.Is(err, git.ErrNonFastForwardUpdate) {
+			return "", fmt.Errorf("pull failed: local and remote branches have diverged, only fast-forward pulls are supported")
+		} else if isAuthError(err) {
+			return "", authFailure(err)
+		}
+		return "", fmt.Errorf("pull failed: %w", err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	return fmt.Sprintf("Pulled from '%s', HEAD is now at %s", remoteName, head.Hash().String()[:7]), nil
+}
+
+func gitPush(ctx context.Context, path, remote, branchName string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	remoteName, err := resolveRemote(r, remote)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return "", err
+	}
+
+	// Push the current branch unless one is named explicitly
+	if branchName == "" {
+		head, err := r.Head()
+		if err != nil {
+			return "", fmt.Errorf("failed to get HEAD: %w", err)
+		}
+		if !head.Name().IsBranch() {
+			return "", fmt.Errorf("HEAD is detached, specify a branch to push")
+		}
+		branchName = head.Name().Short()
+	}
+
+	refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", branchName, branchName))
+	err = r.PushContext(ctx, &git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       auth,
+	})
+	if err != nil {
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return fmt.Sprintf("Branch '%s' is already up-to-date on '%s'", branchName, remoteName), nil
+		} else if isAuthError(err) {
+			return "", authFailure(err)
+		}
+		return "", fmt.Errorf("push failed: %w", err)
+	}
+
+	return fmt.Sprintf("Pushed branch '%s' to '%s'", branchName, remoteName), nil
+}
+
+func isAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		strings.Contains(strings.ToLower(err.Error()), "auth")
+}
+
+func authFailure(err error) error {
+	return fmt.Errorf("authentication failed: set GITHUB_TOKEN or GITLAB_TOKEN for HTTPS remotes on github.com or gitlab.com, or GIT_TOKEN and SYSTEM3_GIT_HOSTS for other hosts; for SSH remotes, start an SSH agent or add a key in ~/.ssh: %w", err)
+}
+
+func gitCheckout(path, branchName string, create bool) (string, error) {
+	if branchName == "" {
+		return "", toolErrorf(ErrorInvalidInput, "branch name is required for checkout operation")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// First try to find the branch locally
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+
+	// Check if the branch exists locally
+	_, err = r.Reference(branchRef, true)
+	exists := err == nil
+
+	if create {
+		// Equivalent to: git checkout -b <branch>
+		if exists {
+			return "", fmt.Errorf("branch '%s' already exists", branchName)
+		}
+
+		err = w.Checkout(&git.CheckoutOptions{
+			Branch: branchRef,
+			Create: true,
+			Keep:   true,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create branch '%s': %w", branchName, err)
+		}
+
+		return fmt.Sprintf("Switched to a new branch '%s'", branchName), nil
+	}
+
+	// If the branch doesn't exist locally, check if it exists as a remote branch
+	if !exists {
+		// Check if it's a remote branch that we need to create locally
+		remoteRef := plumbing.NewRemoteReferenceName("origin", branchName)
+		remoteRefObj, err := r.Reference(remoteRef, true)
+		if err != nil {
+			return "", toolErrorf(ErrorNotFound, "branch '%s' not found locally or on origin, set create to make a new branch", branchName)
+		}
+
+		// Remote branch exists, create a local branch tracking the remote one
+		// Equivalent to: git checkout -b <branch> origin/<branch>
+		ref := plumbing.NewHashReference(branchRef, remoteRefObj.Hash())
+		err = r.Storer.SetReference(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to create local branch from remote: %w", err)
+		}
+
+		err = r.CreateBranch(&config.Branch{
+			Name:   branchName,
+			Remote: "origin",
+			Merge:  branchRef,
+		})
+		if err != nil && !errors.Is(err, git.ErrBranchExists) {
+			return "", fmt.Errorf("failed to set upstream for branch '%s': %w", branchName, err)
+		}
+	}
+
+	// Perform the checkout
+	err = w.Checkout(&git.CheckoutOptions{
+		Branch: branchRef,
+		Force:  false,
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to checkout branch '%s': %w", branchName, err)
+	}
+
+	return fmt.Sprintf("Switched to branch '%s'", branchName), nil
+}
+
+func listBranches(path string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	branchRefs, err := r.Branches()
+	if err != nil {
+		return "", fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	var branches []string
+	err = branchRefs.ForEach(func(ref *plumbing.Reference) error {
+		branch := ref.Name().Short()
+		if branch != "" {
+			branches = append(branches, branch)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to iterate over branches: %w", err)
+	}
+
+	if len(branches) == 0 {
+		return "No branches found", nil
+	}
+
+	return strings.Join(branches, "\n"), nil
+}
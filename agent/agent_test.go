@@ -0,0 +1,491 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// newTestAgent creates a headless agent answered by provider, in a workspace
+// holding files and with sessions saved under a temporary home.
+func newTestAgent(t *testing.T, provider Provider, config Config, files map[string]string, input ...string) *Agent {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	workspace := t.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(workspace, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Workspace = workspace
+	config.Model = "fake"
+	config.SummaryModel = "fake-summary"
+	config.MaxTokens = 1024
+	config.Headless = true
+	err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getUserMessage := func() (string, bool) {
+		if len(input) == 0 {
+			return "", false
+		}
+		line := input[0]
+		input = input[1:]
+		return line, true
+	}
+	session := NewSession(config.Model)
+	// A titled session is not titled in the background, which would race
+	// with the temporary directories' removal.
+	session.Title = "test"
+	a := NewAgent(provider, getUserMessage, DefaultTools(), config, session)
+	t.Cleanup(a.Close)
+	return a
+}
+
+func TestRunOnce(t *testing.T) {
+	errOverloaded := errors.New("overloaded")
+	tests := []struct {
+		name        string
+		files       map[string]string
+		permissions Permissions
+		replies     []fakeReply
+		// tool registers an extra tool.
+		tool             *ToolDefinition
+		wantAnswer       string
+		wantErr          error
+		wantConversation []string
+		// wantResults maps tool call IDs to text their result must contain.
+		wantResults map[string]string
+	}{
+		{
+			name:             "answer without tools",
+			replies:          []fakeReply{replyWith(textBlock("Hello!"))},
+			wantAnswer:       "Hello!",
+			wantConversation: []string{"user: text", "assistant: text"},
+		},
+		{
+			name:  "tool result sent back",
+			files: map[string]string{"notes.txt": "remember the milk\n"},
+			replies: []fakeReply{
+				replyWith(textBlock("Reading it."), toolUseBlock("t1", "read_file", `{"path":"notes.txt"}`)),
+				replyWith(textBlock("It says to remember the milk.")),
+			},
+			wantAnswer: "It says to remember the milk.",
+			wantConversation: []string{
+				"user: text",
+				"assistant: text, tool_use read_file",
+				"user: tool_result t1",
+				"assistant: text",
+			},
+			wantResults: map[string]string{"t1": "remember the milk"},
+		},
+		{
+			name:  "parallel calls answered in order",
+			files: map[string]string{"a.txt": "alpha\n"},
+			replies: []fakeReply{
+				replyWith(
+					toolUseBlock("t1", "read_file", `{"path":"a.txt"}`),
+					toolUseBlock("t2", "read_file", `{"path":"missing.txt"}`),
+				),
+				replyWith(textBlock("One of them is missing.")),
+			},
+			wantAnswer: "One of them is missing.",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file, tool_use read_file",
+				"user: tool_result t1, tool_result t2 error",
+				"assistant: text",
+			},
+			wantResults: map[string]string{"t1": "alpha", "t2": "no such file"},
+		},
+		{
+			name: "unknown tool",
+			replies: []fakeReply{
+				replyWith(toolUseBlock("t1", "teleport", `{}`)),
+				replyWith(textBlock("I cannot do that.")),
+			},
+			wantAnswer: "I cannot do that.",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use teleport",
+				"user: tool_result t1 error",
+				"assistant: text",
+			},
+			wantResults: map[string]string{"t1": "tool teleport not found"},
+		},
+		{
+			name:        "tool denied by policy",
+			files:       map[string]string{"a.txt": "alpha\n"},
+			permissions: Permissions{Tools: map[string]Policy{"read_file": PolicyDeny}},
+			replies: []fakeReply{
+				replyWith(toolUseBlock("t1", "read_file", `{"path":"a.txt"}`)),
+				replyWith(textBlock("Not allowed.")),
+			},
+			wantAnswer: "Not allowed.",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file",
+				"user: tool_result t1 error",
+				"assistant: text",
+			},
+			wantResults: map[string]string{"t1": "denied by policy"},
+		},
+		{
+			name: "tool panic becomes an error result",
+			tool: &ToolDefinition{
+				Name:        "explode",
+				InputSchema: GenerateSchema[struct{}](),
+				Function: func(ctx context.Context, input json.RawMessage) (string, error) {
+					panic("boom")
+				},
+			},
+			replies: []fakeReply{
+				replyWith(toolUseBlock("t1", "explode", `{}`)),
+				replyWith(textBlock("It broke.")),
+			},
+			wantAnswer: "It broke.",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use explode",
+				"user: tool_result t1 error",
+				"assistant: text",
+			},
+			wantResults: map[string]string{"t1": "failed unexpectedly: boom"},
+		},
+		{
+			name:             "provider error",
+			replies:          []fakeReply{replyError(errOverloaded)},
+			wantErr:          errOverloaded,
+			wantConversation: []string{"user: text"},
+		},
+		{
+			name:  "provider error after a tool call",
+			files: map[string]string{"a.txt": "alpha\n"},
+			replies: []fakeReply{
+				replyWith(toolUseBlock("t1", "read_file", `{"path":"a.txt"}`)),
+				replyError(errOverloaded),
+			},
+			wantErr: errOverloaded,
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file",
+				"user: tool_result t1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newFakeProvider(test.replies...)
+			a := newTestAgent(t, provider, Config{Permissions: test.permissions}, test.files)
+			if test.tool != nil {
+				a.RegisterTool(*test.tool)
+			}
+
+			answer, err := a.RunOnce(context.Background(), anthropic.NewTextBlock("Do the task."))
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("RunOnce error = %v, want %v", err, test.wantErr)
+			}
+			if answer != test.wantAnswer {
+				t.Errorf("answer = %q, want %q", answer, test.wantAnswer)
+			}
+			conversation := a.Conversation()
+			if got := describeConversation(conversation); !slices.Equal(got, test.wantConversation) {
+				t.Errorf("conversation =\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(test.wantConversation, "\n  "))
+			}
+			for id, want := range test.wantResults {
+				if got := toolResultText(conversation, id); !strings.Contains(got, want) {
+					t.Errorf("result of %s = %q, want it to contain %q", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunOnceSendsToolResultsToTheModel(t *testing.T) {
+	provider := newFakeProvider(
+		replyWith(toolUseBlock("t1", "read_file", `{"path":"a.txt"}`)),
+		replyWith(textBlock("Done.")),
+	)
+	a := newTestAgent(t, provider, Config{}, map[string]string{"a.txt": "alpha\n"})
+
+	_, err := a.RunOnce(context.Background(), anthropic.NewTextBlock("Read a.txt."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"user: text"},
+		{"user: text", "assistant: tool_use read_file", "user: tool_result t1"},
+	}
+	for i, wantRequest := range want {
+		if got := provider.request(i); !slices.Equal(got, wantRequest) {
+			t.Errorf("request %d = %q, want %q", i, got, wantRequest)
+		}
+	}
+}
+
+func TestRunOnceInterrupted(t *testing.T) {
+	provider := newFakeProvider(
+		replyWith(
+			toolUseBlock("t1", "read_file", `{"path":"a.txt"}`),
+			toolUseBlock("t2", "read_file", `{"path":"a.txt"}`),
+		),
+	)
+	a := newTestAgent(t, provider, Config{}, map[string]string{"a.txt": "alpha\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	a.RegisterTool(ToolDefinition{
+		Name:        "read_file",
+		InputSchema: ReadFileInputSchema,
+		Function: func(ctx context.Context, input json.RawMessage) (string, error) {
+			cancel()
+			return "alpha", nil
+		},
+	})
+
+	_, err := a.RunOnce(ctx, anthropic.NewTextBlock("Read a.txt twice."))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunOnce error = %v, want %v", err, context.Canceled)
+	}
+	// Every call needs a result, so the one skipped gets one too.
+	conversation := a.Conversation()
+	want := []string{"user: text", "assistant: tool_use read_file, tool_use read_file", "user: tool_result t1, tool_result t2 error"}
+	if got := describeConversation(conversation); !slices.Equal(got, want) {
+		t.Errorf("conversation = %q, want %q", got, want)
+	}
+	if got := toolResultText(conversation, "t2"); !strings.Contains(got, "interrupted") {
+		t.Errorf("result of t2 = %q, want it to mention the interruption", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	errOverloaded := errors.New("overloaded")
+	tests := []struct {
+		name             string
+		input            []string
+		replies          []fakeReply
+		wantErr          error
+		wantConversation []string
+	}{
+		{
+			name:             "chat until input ends",
+			input:            []string{"Hi", "  ", "Bye"},
+			replies:          []fakeReply{replyWith(textBlock("Hello!")), replyWith(textBlock("Goodbye!"))},
+			wantConversation: []string{"user: text", "assistant: text", "user: text", "assistant: text"},
+		},
+		{
+			name:             "exit command",
+			input:            []string{"Hi", "/exit", "never sent"},
+			replies:          []fakeReply{replyWith(textBlock("Hello!"))},
+			wantConversation: []string{"user: text", "assistant: text"},
+		},
+		{
+			name:             "retry answers the last prompt again",
+			input:            []string{"Hi", "/retry"},
+			replies:          []fakeReply{replyWith(textBlock("Hello!")), replyWith(textBlock("Hello again!"))},
+			wantConversation: []string{"user: text", "assistant: text"},
+		},
+		{
+			name:             "provider error ends the chat",
+			input:            []string{"Hi", "never sent"},
+			replies:          []fakeReply{replyError(errOverloaded)},
+			wantErr:          errOverloaded,
+			wantConversation: []string{"user: text"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newFakeProvider(test.replies...)
+			a := newTestAgent(t, provider, Config{}, nil, test.input...)
+
+			err := a.Run(context.Background())
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Run error = %v, want %v", err, test.wantErr)
+			}
+			if got := describeConversation(a.Conversation()); !slices.Equal(got, test.wantConversation) {
+				t.Errorf("conversation = %q, want %q", got, test.wantConversation)
+			}
+			if len(provider.replies) != 0 {
+				t.Errorf("%d scripted replies were not used", len(provider.replies))
+			}
+		})
+	}
+}
+
+func TestResumeStoppedToolCalls(t *testing.T) {
+	calls := toolUseBlock("t1", "read_file", `{"path":"a.txt"}`) + "," +
+		toolUseBlock("t2", "write_file", `{"path":"b.txt","content":"written twice"}`) + "," +
+		toolUseBlock("t3", "read_file", `{"path":"a.txt"}`)
+	tests := []struct {
+		name string
+		// answered are the calls whose results were saved before the
+		// process stopped, and running the call it stopped in.
+		answered         []string
+		running          string
+		wantConversation []string
+		wantWritten      bool
+	}{
+		{
+			name: "stopped before any call ran",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file, tool_use write_file, tool_use read_file",
+				"user: tool_result t1, tool_result t2, tool_result t3",
+				"assistant: text",
+			},
+			wantWritten: true,
+		},
+		{
+			name:     "stopped during a call that changes files",
+			answered: []string{"t1"},
+			running:  "t2",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file, tool_use write_file, tool_use read_file",
+				"user: tool_result t1, tool_result t2 error, tool_result t3",
+				"assistant: text",
+			},
+		},
+		{
+			name:     "stopped during a call that only reads",
+			answered: []string{"t1", "t2"},
+			running:  "t3",
+			wantConversation: []string{
+				"user: text",
+				"assistant: tool_use read_file, tool_use write_file, tool_use read_file",
+				"user: tool_result t1, tool_result t2, tool_result t3",
+				"assistant: text",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newFakeProvider(replyWith(textBlock("Done.")))
+			a := newTestAgent(t, provider, Config{AutoApprove: true}, map[string]string{"a.txt": "alpha\n"})
+
+			reply, err := fakeMessage([]string{calls})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conversation := []anthropic.MessageParam{
+				anthropic.NewUserMessage(anthropic.NewTextBlock("Copy a.txt.")),
+				reply.ToParam(),
+			}
+			var results []anthropic.ContentBlockParamUnion
+			for _, id := range test.answered {
+				results = append(results, anthropic.NewToolResultBlock(id, "saved result", false))
+			}
+			if len(results) > 0 {
+				conversation = append(conversation, anthropic.NewUserMessage(results...))
+			}
+			a.saveState(conversation, StateExecutingTools, test.running)
+			session, err := LoadSession(a.session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resumed := NewAgent(provider, a.getUserMessage, DefaultTools(), a.config, session)
+			t.Cleanup(resumed.Close)
+
+			err = resumed.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeConversation(resumed.Conversation()); !slices.Equal(got, test.wantConversation) {
+				t.Errorf("conversation =\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(test.wantConversation, "\n  "))
+			}
+			if state := resumed.loopState(); state != StateAwaitingUser {
+				t.Errorf("state = %s, want %s", state, StateAwaitingUser)
+			}
+			_, err = os.Stat(filepath.Join(workspaceRoot, "b.txt"))
+			if written := err == nil; written != test.wantWritten {
+				t.Errorf("b.txt written = %v, want %v", written, test.wantWritten)
+			}
+		})
+	}
+}
+
+func TestDeriveLoopState(t *testing.T) {
+	prompt := anthropic.NewUserMessage(anthropic.NewTextBlock("Hi"))
+	answer := anthropic.NewAssistantMessage(anthropic.NewTextBlock("Hello"))
+	calls := anthropic.NewAssistantMessage(
+		anthropic.ContentBlockParamOfRequestToolUseBlock("t1", json.RawMessage(`{}`), "read_file"),
+		anthropic.ContentBlockParamOfRequestToolUseBlock("t2", json.RawMessage(`{}`), "read_file"),
+	)
+	someResults := anthropic.NewUserMessage(anthropic.NewToolResultBlock("t1", "ok", false))
+	allResults := anthropic.NewUserMessage(anthropic.NewToolResultBlock("t1", "ok", false), anthropic.NewToolResultBlock("t2", "ok", false))
+
+	tests := []struct {
+		name         string
+		conversation []anthropic.MessageParam
+		want         LoopState
+	}{
+		{"empty", nil, StateAwaitingUser},
+		{"unanswered prompt", []anthropic.MessageParam{prompt}, StateAwaitingModel},
+		{"answered", []anthropic.MessageParam{prompt, answer}, StateAwaitingUser},
+		{"calls without results", []anthropic.MessageParam{prompt, calls}, StateExecutingTools},
+		{"calls with some results", []anthropic.MessageParam{prompt, calls, someResults}, StateExecutingTools},
+		{"calls with all results", []anthropic.MessageParam{prompt, calls, allResults}, StateAwaitingModel},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := deriveLoopState(test.conversation); got != test.want {
+				t.Errorf("deriveLoopState = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	r, err := newRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text, want string
+	}{
+		{"password: hunter22hunter22", "password: [REDACTED]"},
+		{`API_KEY="a1b2c3d4e5f6g7h8i9"`, `API_KEY="[REDACTED]"`},
+		{"key: ghp_" + strings.Repeat("a1", 18), "key: [REDACTED]"},
+		{"token = loadToken(ctx)", "token = loadToken(ctx)"},
+		{"MaxTokens: a.config.MaxTokens,", "MaxTokens: a.config.MaxTokens,"},
+		{"outputTokens = response.Usage.OutputTokens", "outputTokens = response.Usage.OutputTokens"},
+		{"token: SYSTEM3_SERVE_TOKEN,", "token: SYSTEM3_SERVE_TOKEN,"},
+	}
+	for _, test := range tests {
+		if got, _ := r.redact(test.text); got != test.want {
+			t.Errorf("redact(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+
+	// The agent's own source holds no secrets, so reading it must not
+	// redact anything.
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range sources {
+		if strings.HasSuffix(source, "_test.go") {
+			continue
+		}
+		content, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, count := r.redact(string(content)); count > 0 || got != string(content) {
+			t.Errorf("redacted %d secrets from %s", count, source)
+		}
+	}
+}
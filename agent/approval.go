@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Policy decides what happens when the model calls a tool.
+type Policy string
+
+const (
+	// PolicyAllow runs the tool without asking.
+	PolicyAllow Policy = "allow"
+	// PolicyAsk asks the user before running calls the tool's Preview
+	// reports as destructive.
+	PolicyAsk Policy = "ask"
+	// PolicyDeny refuses every call to the tool.
+	PolicyDeny Policy = "deny"
+)
+
+// Approval records how a tool call got past the approval gate, or why it
+// did not, for the audit log.
+type Approval string
+
+const (
+	// ApprovalNotNeeded is a call that did not need asking about, such as
+	// one its tool's Preview does not report as destructive.
+	ApprovalNotNeeded Approval = "not_needed"
+	// ApprovalAllowed is a call allowed by a policy or permissions rule.
+	ApprovalAllowed Approval = "allowed"
+	// ApprovalAutoApproved is a call let through by --auto-approve.
+	ApprovalAutoApproved Approval = "auto_approved"
+	// ApprovalUserApproved is a call the user approved, now or by answering
+	// always earlier.
+	ApprovalUserApproved Approval = "user_approved"
+	// ApprovalDenied is a call refused by a policy, a permissions rule, a
+	// hook or read-only mode.
+	ApprovalDenied Approval = "denied"
+	// ApprovalUserDenied is a call the user refused, or could not be asked
+	// about.
+	ApprovalUserDenied Approval = "user_denied"
+	// ApprovalPlanned is a call described in plan mode instead of run.
+	ApprovalPlanned Approval = "planned"
+	// ApprovalCached is a call answered from the result cache after
+	// passing the approval gate.
+	ApprovalCached Approval = "cached"
+)
+
+// Approver is the gate every tool call passes through before it runs.
+type Approver struct {
+	// Policies holds per-tool overrides; tools without an entry use
+	// PolicyAsk.
+	Policies map[string]Policy
+	// Permissions holds the path and shell command rules from the
+	// permissions file.
+	Permissions Permissions
+	AutoApprove bool
+
+	readLine func() (string, bool)
+	// mu serializes approvals, which parallel sub-agents request at the
+	// same time.
+	mu     sync.Mutex
+	always map[string]bool
+}
+
+func NewApprover(permissions Permissions, autoApprove bool, readLine func() (string, bool)) *Approver {
+	policies := map[string]Policy{}
+	for tool, policy := range permissions.Tools {
+		policies[tool] = policy
+	}
+
+	return &Approver{
+		Policies:    policies,
+		Permissions: permissions,
+		AutoApprove: autoApprove,
+		readLine:    readLine,
+		always:      map[string]bool{},
+	}
+}
+
+// Approve returns nil when the call may run, or an error explaining why it
+// was refused, along with how it was decided. The error is sent back to the
+// model as the tool result.
+func (ap *Approver) Approve(tool ToolDefinition, input json.RawMessage) (Approval, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	// The text editor's calls are decided as calls of the file tool each is
+	// forwarded to, so the policies of edit_file, write_file and undo_edit
+	// apply to them.
+	if tool.Name == textEditorName {
+		if forwarded, forwardedInput, err := translateTextEditor(input); err == nil {
+			tool, input = forwarded, forwardedInput
+		}
+	}
+
+	policy, ok := ap.Policies[tool.Name]
+	if !ok {
+		policy = PolicyAsk
+	}
+
+	if policy == PolicyDeny {
+		return ApprovalDenied, toolErrorf(ErrorPermissionDenied, "tool %s is denied by policy", tool.Name)
+	}
+
+	err := ap.Permissions.checkPaths(tool.Name, input)
+	if err != nil {
+		return ApprovalDenied, err
+	}
+
+	// Every tool that runs a shell command, such as start_process and
+	// custom tools, follows the same rules as the shell tool.
+	if tool.Command != nil {
+		if command, ok := tool.Command(input); ok {
+			switch ap.Permissions.commandPolicy(command) {
+			case PolicyDeny:
+				return ApprovalDenied, toolErrorf(ErrorPermissionDenied, "command %q is denied by permissions", command)
+			case PolicyAllow:
+				return ApprovalAllowed, nil
+			}
+		}
+	}
+
+	if policy == PolicyAllow {
+		return ApprovalAllowed, nil
+	}
+
+	if tool.Preview == nil {
+		return ApprovalNotNeeded, nil
+	}
+	description, destructive := tool.Preview(input)
+	switch {
+	case !destructive:
+		return ApprovalNotNeeded, nil
+	case ap.AutoApprove:
+		return ApprovalAutoApproved, nil
+	case ap.always[tool.Name]:
+		return ApprovalUserApproved, nil
+	}
+	if ap.readLine == nil {
+		// Agents driven through another frontend have nobody at the
+		// terminal to ask.
+		return ApprovalUserDenied, toolErrorf(ErrorPermissionDenied, "%s needs approval, which cannot be asked for here; allow it in the permissions file or run with --auto-approve", tool.Name)
+	}
+
+	fmt.Printf("\u001b[93mapprove\u001b[0m: %s wants to:\n%s\n", tool.Name, prefixLines(colorDiff(strings.TrimRight(description, "\n")), "  "))
+	for {
+		fmt.Print("Allow? [y]es / [n]o / [a]lways: ")
+		answer, ok := ap.readLine()
+		if !ok {
+			return ApprovalUserDenied, toolErrorf(ErrorPermissionDenied, "user did not approve %s", tool.Name)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return ApprovalUserApproved, nil
+		case "a", "always":
+			ap.always[tool.Name] = true
+			return ApprovalUserApproved, nil
+		case "n", "no":
+			return ApprovalUserDenied, toolErrorf(ErrorPermissionDenied, "user denied %s", tool.Name)
+		}
+	}
+}
+
+// Confirm asks the user a yes or no question about something other than a
+// tool call, such as going on past a budget, defaulting to no. Without a
+// user to ask, the answer is no; auto-approve does not apply.
+func (ap *Approver) Confirm(reason, question string) bool {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	if ap.readLine == nil {
+		return false
+	}
+
+	fmt.Printf("\u001b[93mconfirm\u001b[0m: %s\n", reason)
+	for {
+		fmt.Printf("%s [y]es / [n]o: ", question)
+		answer, ok := ap.readLine()
+		if !ok {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no", "":
+			return false
+		}
+	}
+}
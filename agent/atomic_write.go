@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// newFileMode is the permissions of files the tools create.
+const newFileMode os.FileMode = 0644
+
+// syncWrites is set from --fsync.
+var syncWrites bool
+
+// writeFileAtomic replaces the file at path with content. The content is
+// written to a temporary file beside it that is then renamed into place, so
+// an interrupt or crash during the write leaves either the old content or
+// the new, never a truncated file.
+func writeFileAtomic(path string, content []byte, mode os.FileMode) error {
+	temporary, err := stageFileContent(path, content, mode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporary, path)
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+// stageFileContent writes content with mode to a new temporary file in
+// path's directory, flushed to disk when --fsync is set, and returns its
+// name for the caller to rename over path.
+func stageFileContent(path string, content []byte, mode os.FileMode) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if err == nil && syncWrites {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// fileMode returns the permissions of the file at path, which a tool
+// replacing it keeps so executables stay executable, or newFileMode when
+// there is no such file yet.
+func fileMode(path string) os.FileMode {
+	info, err := os.Stat(path)
+	if err != nil {
+		return newFileMode
+	}
+	return info.Mode().Perm()
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// maxImageBytes is the largest image the Anthropic API accepts.
+const maxImageBytes = 5 * 1024 * 1024
+
+// imageMediaTypes are the image formats the models accept, by extension.
+var imageMediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// attachment is an image waiting to be sent with the next message.
+type attachment struct {
+	path  string
+	block anthropic.ContentBlockParamUnion
+}
+
+// loadImage reads an image into a content block. Unlike tool paths, the
+// user's paths may point outside the workspace, for example at a screenshot
+// on the desktop; relative paths are taken from the workspace.
+func loadImage(path string) (attachment, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return attachment{}, fmt.Errorf("failed to expand %s: %w", path, err)
+		}
+		path = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceRoot, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return attachment{}, err
+	}
+	if info.IsDir() {
+		return attachment{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxImageBytes {
+		return attachment{}, toolErrorf(ErrorTooLarge, "%s is %d bytes, images can be at most %d", path, info.Size(), maxImageBytes)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return attachment{}, err
+	}
+
+	// The content decides the type, since screenshots are often saved with
+	// the wrong extension.
+	mediaType := http.DetectContentType(content)
+	supported := false
+	for _, imageType := range imageMediaTypes {
+		supported = supported || imageType == mediaType
+	}
+	if !supported {
+		return attachment{}, fmt.Errorf("%s is %s, not a PNG, JPEG, GIF or WebP image", path, mediaType)
+	}
+	block := anthropic.NewImageBlockBase64(mediaType, base64.StdEncoding.EncodeToString(content))
+	return attachment{path: path, block: block}, nil
+}
+
+// droppedImages finds the image files named in a message, as terminals
+// paste them when a file is dragged in: absolute, possibly quoted, with
+// spaces escaped by backslashes. Words that are not existing images are
+// ignored, and relative paths are left for /attach so mentioning a file
+// does not send it.
+func droppedImages(input string) []attachment {
+	var images []attachment
+	for _, word := range splitWords(input) {
+		if !filepath.IsAbs(word) && !strings.HasPrefix(word, "~/") {
+			continue
+		}
+		if _, ok := imageMediaTypes[strings.ToLower(filepath.Ext(word))]; !ok {
+			continue
+		}
+		image, err := loadImage(word)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("\u001b[91mwarning\u001b[0m: not attaching %s: %s\n", word, err)
+			}
+			continue
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// splitWords splits input on spaces the way a shell would, honoring single
+// and double quotes and backslash escapes.
+func splitWords(input string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range input {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// userMessageBlocks turns what the user typed into the content of their
+// message, with any images they dropped in or attached with /attach.
+func (a *Agent) userMessageBlocks(input string) []anthropic.ContentBlockParamUnion {
+	blocks := []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock(input)}
+	images := append(a.attachments, droppedImages(input)...)
+	a.attachments = nil
+	for _, image := range images {
+		blocks = append(blocks, image.block)
+	}
+	if len(images) > 0 {
+		fmt.Printf("\u001b[93msystem\u001b[0m: sending %d image(s)\n", len(images))
+	}
+	return blocks
+}
+
+// /attach command
+
+var AttachCommand = SlashCommand{
+	Name:        "attach",
+	Usage:       "[image path...]",
+	Description: "Attach images to your next message, or list the pending ones",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		paths := splitWords(args)
+		if len(paths) == 0 {
+			if len(a.attachments) == 0 {
+				fmt.Println("\u001b[93msystem\u001b[0m: no images attached")
+			}
+			for _, image := range a.attachments {
+				fmt.Printf("\u001b[93msystem\u001b[0m: attached %s\n", image.path)
+			}
+			return conversation, nil
+		}
+
+		for _, path := range paths {
+			image, err := loadImage(path)
+			if err != nil {
+				return conversation, fmt.Errorf("failed to attach image: %w", err)
+			}
+			a.attachments = append(a.attachments, image)
+			fmt.Printf("\u001b[93msystem\u001b[0m: attached %s, it will be sent with your next message\n", image.path)
+		}
+		return conversation, nil
+	},
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditLog is the append-only record of tool calls requested with
+// --audit-log, set at setup and shared by every agent in the process. It is
+// nil when there is none.
+var auditLog *auditFile
+
+// auditFile appends one JSON line per tool call to the audit log. The file
+// is only ever appended to, and each entry is written with a single write,
+// so concurrent agents do not interleave.
+type auditFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// auditEntry is one tool call in the audit log. The result is recorded by
+// its hash and size, so the log shows what the model was told without
+// holding file contents.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id"`
+	// SubAgent is set for calls made by a dispatched sub-agent, which are
+	// logged under their parent's session.
+	SubAgent     bool            `json:"sub_agent,omitempty"`
+	Workspace    string          `json:"workspace"`
+	Tool         string          `json:"tool"`
+	CallID       string          `json:"call_id"`
+	Input        json.RawMessage `json:"input"`
+	Approval     Approval        `json:"approval"`
+	IsError      bool            `json:"is_error"`
+	ResultSHA256 string          `json:"result_sha256"`
+	ResultBytes  int             `json:"result_bytes"`
+	Duration     time.Duration   `json:"-"`
+	DurationMS   int64           `json:"duration_ms"`
+}
+
+// openAuditLog opens the audit log at path for appending, creating it and
+// its directory when needed.
+func openAuditLog(path string) (*auditFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditFile{file: file}, nil
+}
+
+func (f *auditFile) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// audit records a finished tool call, with the result exactly as it is sent
+// to the model, in the audit log.
+func (a *Agent) audit(entry auditEntry, output string) {
+	if auditLog == nil {
+		return
+	}
+	root := a
+	for root.parent != nil {
+		root = root.parent
+	}
+	sum := sha256.Sum256([]byte(output))
+	if !json.Valid(entry.Input) {
+		// Kept as a string, so a malformed call is still logged.
+		entry.Input, _ = json.Marshal(string(entry.Input))
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.SessionID = root.session.ID
+	entry.SubAgent = a.parent != nil
+	entry.Workspace = workspaceRoot
+	entry.ResultSHA256 = hex.EncodeToString(sum[:])
+	entry.ResultBytes = len(output)
+	entry.DurationMS = entry.Duration.Milliseconds()
+	err := auditLog.write(entry)
+	if err != nil {
+		slog.Error("tool call missing from the audit log", "tool", entry.Tool, "id", entry.CallID, "error", err)
+		a.emit(Event{Type: EventWarning, Text: err.Error()})
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// ErrBudgetExceeded is returned when the session reached --max-cost or
+// --max-turns and the user chose not to go on, or nobody could be asked.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// modelPrice is what a model charges, in US dollars per million tokens.
+type modelPrice struct {
+	input, output, cacheWrite, cacheRead float64
+}
+
+// modelPrices are Anthropic's list prices by model family. Other models,
+// such as those of OpenAI-compatible and Ollama providers, have no known
+// price, and their calls are left out of the cost.
+var modelPrices = []struct {
+	prefix string
+	price  modelPrice
+}{
+	{"claude-3-7-sonnet", modelPrice{3, 15, 3.75, 0.30}},
+	{"claude-3-5-sonnet", modelPrice{3, 15, 3.75, 0.30}},
+	{"claude-3-5-haiku", modelPrice{0.80, 4, 1, 0.08}},
+	{"claude-3-opus", modelPrice{15, 75, 18.75, 1.50}},
+	{"claude-3-haiku", modelPrice{0.25, 1.25, 0.30, 0.03}},
+}
+
+// messageCost estimates what a model call cost from its token usage, and
+// reports whether the model's price is known.
+func messageCost(model anthropic.Model, usage anthropic.Usage) (float64, bool) {
+	for _, entry := range modelPrices {
+		if strings.HasPrefix(string(model), entry.prefix) {
+			price := entry.price
+			cost := float64(usage.InputTokens)*price.input +
+				float64(usage.OutputTokens)*price.output +
+				float64(usage.CacheCreationInputTokens)*price.cacheWrite +
+				float64(usage.CacheReadInputTokens)*price.cacheRead
+			return cost / 1e6, true
+		}
+	}
+	return 0, false
+}
+
+// budget tracks what the session spent on model calls in this run, shared
+// by an agent and its sub-agents, against --max-cost and --max-turns.
+type budget struct {
+	mu    sync.Mutex
+	cost  float64
+	turns int
+	// maxCost and maxTurns are the limits in force, raised by the original
+	// amount each time the user chooses to go on. Zero disables a limit.
+	maxCost  float64
+	maxTurns int
+	// unpriced are the models used whose price is not known.
+	unpriced map[anthropic.Model]bool
+}
+
+func newBudget(config Config) *budget {
+	return &budget{maxCost: config.MaxCost, maxTurns: config.MaxTurns, unpriced: map[anthropic.Model]bool{}}
+}
+
+// exceeded describes the limit the session has reached, or returns "".
+func (b *budget) exceeded() string {
+	switch {
+	case b.maxCost > 0 && b.cost >= b.maxCost:
+		return fmt.Sprintf("spent about $%.2f, the limit is $%.2f (--max-cost)", b.cost, b.maxCost)
+	case b.maxTurns > 0 && b.turns >= b.maxTurns:
+		return fmt.Sprintf("made %d model turns, the limit is %d (--max-turns)", b.turns, b.maxTurns)
+	}
+	return ""
+}
+
+// recordUsage adds a model call's cost to the budget. The first call to a
+// model without a known price is reported, as --max-cost cannot count it.
+func (a *Agent) recordUsage(model anthropic.Model, message *anthropic.Message) {
+	cost, priced := messageCost(model, message.Usage)
+	b := a.budget
+	b.mu.Lock()
+	b.cost += cost
+	warn := !priced && !b.unpriced[model] && a.config.MaxCost > 0
+	if !priced {
+		b.unpriced[model] = true
+	}
+	b.mu.Unlock()
+	if warn {
+		a.emit(Event{Type: EventWarning, Text: fmt.Sprintf("the price of %s is not known, so --max-cost does not count its calls; use --max-turns to limit them", model)})
+	}
+}
+
+// checkBudget is called before each model turn. Once the session reaches a
+// limit it pauses and asks the user whether to go on, raising the limit if
+// so; otherwise the turn is not taken and ErrBudgetExceeded is returned.
+func (a *Agent) checkBudget() error {
+	b := a.budget
+	// The lock is held while the user is asked, so parallel sub-agents
+	// wait for the answer instead of asking again.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reached := b.exceeded()
+	if reached == "" {
+		b.turns++
+		return nil
+	}
+
+	if !a.approver.Confirm(fmt.Sprintf("the session %s", reached), "Continue?") {
+		return fmt.Errorf("%w: the session %s", ErrBudgetExceeded, reached)
+	}
+	for b.maxCost > 0 && b.cost >= b.maxCost {
+		b.maxCost += a.config.MaxCost
+	}
+	for b.maxTurns > 0 && b.turns >= b.maxTurns {
+		b.maxTurns += a.config.MaxTurns
+	}
+	b.turns++
+	return nil
+}
+
+// Spent returns the estimated dollars and the model turns the session has
+// spent in this run, including its sub-agents'.
+func (a *Agent) Spent() (float64, int) {
+	a.budget.mu.Lock()
+	defer a.budget.mu.Unlock()
+	return a.budget.cost, a.budget.turns
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// cachedUserTurns is how many of the most recent user messages get a cache
+// breakpoint. Marking the previous turn as well as the current one lets the
+// next request hit the cache written by this one. Together with the tools
+// and system prompt this uses all four breakpoints the API allows.
+const cachedUserTurns = 2
+
+// withPromptCaching marks the stable prefix of a request with cache_control
+// breakpoints: the tool definitions, the system prompt and the latest user
+// turns. Everything before a breakpoint is served from Anthropic's prompt
+// cache on later requests, so long sessions only pay full price for new
+// messages.
+//
+// The conversation is copied before marking so breakpoints never leak into
+// the saved session.
+func withPromptCaching(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	if len(params.Tools) > 0 {
+		tools := append([]anthropic.ToolUnionParam(nil), params.Tools...)
+		last := len(tools) - 1
+		if tool := tools[last].OfTool; tool != nil {
+			marked := *tool
+			marked.CacheControl = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+			tools[last] = anthropic.ToolUnionParam{OfTool: &marked}
+		}
+		params.Tools = tools
+	}
+
+	if len(params.System) > 0 {
+		system := append([]anthropic.TextBlockParam(nil), params.System...)
+		system[len(system)-1].CacheControl = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+		params.System = system
+	}
+
+	messages := append([]anthropic.MessageParam(nil), params.Messages...)
+	marked := 0
+	for i := len(messages) - 1; i >= 0 && marked < cachedUserTurns; i-- {
+		if messages[i].Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		message, ok := markLastBlock(messages[i])
+		if ok {
+			messages[i] = message
+			marked++
+		}
+	}
+	params.Messages = messages
+
+	return params
+}
+
+// markLastBlock returns a copy of message whose last cacheable block carries
+// a cache breakpoint.
+func markLastBlock(message anthropic.MessageParam) (anthropic.MessageParam, bool) {
+	content := append([]anthropic.ContentBlockParamUnion(nil), message.Content...)
+	for i := len(content) - 1; i >= 0; i-- {
+		block, ok := withCacheControl(content[i])
+		if ok {
+			content[i] = block
+			message.Content = content
+			return message, true
+		}
+	}
+	return message, false
+}
+
+// withCacheControl copies the block's variant so the original, which may be
+// shared with the session, is left untouched. Thinking blocks cannot carry a
+// breakpoint.
+func withCacheControl(block anthropic.ContentBlockParamUnion) (anthropic.ContentBlockParamUnion, bool) {
+	ephemeral := anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+	switch {
+	case block.OfRequestTextBlock != nil:
+		marked := *block.OfRequestTextBlock
+		marked.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestTextBlock: &marked}, true
+	case block.OfRequestImageBlock != nil:
+		marked := *block.OfRequestImageBlock
+		marked.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestImageBlock: &marked}, true
+	case block.OfRequestToolUseBlock != nil:
+		marked := *block.OfRequestToolUseBlock
+		marked.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestToolUseBlock: &marked}, true
+	case block.OfRequestToolResultBlock != nil:
+		marked := *block.OfRequestToolResultBlock
+		marked.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &marked}, true
+	case block.OfRequestDocumentBlock != nil:
+		marked := *block.OfRequestDocumentBlock
+		marked.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestDocumentBlock: &marked}, true
+	}
+	return block, false
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// checkpointRef is where checkpoint commits are recorded. It lives outside
+// refs/heads so checkpoints never show up as branches or move HEAD.
+const checkpointRef = "refs/system3/checkpoints"
+
+// createCheckpoint commits a snapshot of the working tree at dir, including
+// untracked but not ignored files, onto checkpointRef. HEAD, the index and
+// the working tree are left untouched. It returns the checkpoint commit,
+// which is the previous checkpoint when nothing changed since.
+func createCheckpoint(dir, reason string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("checkpoints need the git binary, which was not found in PATH")
+	}
+
+	// A throwaway index keeps the user's staged changes intact.
+	tmp, err := os.MkdirTemp("", "system3-checkpoint-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	env := append(os.Environ(),
+		"GIT_INDEX_FILE="+filepath.Join(tmp, "index"),
+		"GIT_AUTHOR_NAME=System 3", "GIT_AUTHOR_EMAIL=system3@localhost",
+		"GIT_COMMITTER_NAME=System 3", "GIT_COMMITTER_EMAIL=system3@localhost",
+	)
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = env
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err := cmd.Run()
+		if err != nil {
+			return "", fmt.Errorf("git %s failed: %w\n%s", args[0], err, strings.TrimSpace(output.String()))
+		}
+		return strings.TrimSpace(output.String()), nil
+	}
+
+	head, _ := git("rev-parse", "--verify", "-q", "HEAD")
+	previous, _ := git("rev-parse", "--verify", "-q", checkpointRef)
+
+	if head != "" {
+		_, err = git("read-tree", head)
+	} else {
+		_, err = git("read-tree", "--empty")
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := git("add", "-A"); err != nil {
+		return "", err
+	}
+	tree, err := git("write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	if previous != "" {
+		if previousTree, _ := git("rev-parse", previous+"^{tree}"); previousTree == tree {
+			return previous, nil
+		}
+	}
+
+	args := []string{"commit-tree", tree, "-m", "system3 checkpoint: " + reason}
+	for _, parent := range []string{previous, head} {
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+	}
+	commit, err := git(args...)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = git("update-ref", "-m", "system3 checkpoint", checkpointRef, commit)
+	if err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// checkpointBeforeTools creates a checkpoint when a reply is about to run
+// mutating tool calls. It is called once per batch, before the first call
+// runs.
+func (a *Agent) checkpointBeforeTools(message *anthropic.Message) {
+	if !a.config.Checkpoints || a.planMode || a.config.ReadOnly {
+		return
+	}
+
+	var mutating []string
+	for _, content := range message.Content {
+		if content.Type != "tool_use" {
+			continue
+		}
+		for _, tool := range a.tools {
+			if tool.Name == content.Name && a.mutating(tool, content.Input) {
+				mutating = append(mutating, tool.Name)
+			}
+		}
+	}
+	if len(mutating) == 0 {
+		return
+	}
+
+	a.checkpoint("before " + strings.Join(mutating, ", "))
+}
+
+func (a *Agent) checkpoint(reason string) {
+	commit, err := createCheckpoint(workspaceRoot, reason)
+	if err != nil {
+		slog.Warn("failed to create checkpoint", "error", err)
+		return
+	}
+	a.notice("checkpoint %s (restore with: git restore --source=%s --worktree -- .)", shortHash(commit), shortHash(commit))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// /checkpoint command
+
+var CheckpointCommand = SlashCommand{
+	Name:        "checkpoint",
+	Description: "Snapshot the working tree onto " + checkpointRef,
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		a.checkpoint(orDefault(args, "requested by the user"))
+		return conversation, nil
+	},
+}
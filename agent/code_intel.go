@@ -0,0 +1,353 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxReferences caps how many references are listed.
+const maxReferences = 200
+
+// code_intel tool
+
+var CodeIntelDefinition = ToolDefinition{
+	Name: "code_intel",
+	Description: `Semantic code navigation backed by a language server (gopls for Go).
+
+Operations:
+- definition: where the symbol is defined, with the defining line
+- references: every use of the symbol across the workspace
+- hover: the symbol's type, signature and documentation
+- rename: rename the symbol everywhere it is used, updating every file that refers to it
+
+Point at the symbol with path, line and the symbol's name as it appears on that line; pass column (1-based) as well when the name appears more than once on the line. Prefer this over search_files for finding definitions and uses of code symbols.`,
+	InputSchema: CodeIntelInputSchema,
+	Function:    CodeIntel,
+	Preview:     PreviewCodeIntel,
+	Mutating:    CodeIntelMutating,
+}
+
+type CodeIntelInput struct {
+	Operation string `json:"operation" jsonschema_description:"The operation to perform: definition, references, hover or rename."`
+	Path      string `json:"path" jsonschema_description:"The relative path of the file containing the symbol."`
+	Line      int    `json:"line" jsonschema_description:"The 1-based line the symbol is on."`
+	Symbol    string `json:"symbol,omitempty" jsonschema_description:"The symbol's name as written on the line."`
+	Column    int    `json:"column,omitempty" jsonschema_description:"Optional 1-based column of the symbol, to pick one of several matches on the line or when symbol is not given."`
+	NewName   string `json:"new_name,omitempty" jsonschema_description:"The new name, for rename."`
+}
+
+var CodeIntelInputSchema = GenerateSchema[CodeIntelInput]()
+
+func CodeIntel(ctx context.Context, input json.RawMessage) (string, error) {
+	codeIntelInput := CodeIntelInput{}
+	err := json.Unmarshal(input, &codeIntelInput)
+	if err != nil {
+		return "", err
+	}
+	switch codeIntelInput.Operation {
+	case "definition", "references", "hover":
+	case "rename":
+		if codeIntelInput.NewName == "" {
+			return "", fmt.Errorf("new_name is required for rename")
+		}
+	default:
+		return "", fmt.Errorf("unknown operation %q, expected definition, references, hover or rename", codeIntelInput.Operation)
+	}
+
+	path, err := resolvePath(codeIntelInput.Path)
+	if err != nil {
+		return "", err
+	}
+	server, err := serverFor(path)
+	if err != nil {
+		return "", err
+	}
+	client, err := lspClients.get(ctx, server)
+	if err != nil {
+		return "", err
+	}
+	text, err := client.sync(path)
+	if err != nil {
+		return "", err
+	}
+	position, err := symbolPosition(client, text, codeIntelInput)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]any{
+		"textDocument": map[string]string{"uri": fileURI(path)},
+		"position":     position,
+	}
+	switch codeIntelInput.Operation {
+	case "definition":
+		var result json.RawMessage
+		err = client.call(ctx, "textDocument/definition", params, &result)
+		if err != nil {
+			return "", err
+		}
+		locations, err := decodeLocations(result)
+		if err != nil {
+			return "", err
+		}
+		if len(locations) == 0 {
+			return "No definition found.", nil
+		}
+		return describeLocations(client, locations, len(locations)), nil
+
+	case "references":
+		params["context"] = map[string]bool{"includeDeclaration": true}
+		var locations []lspLocation
+		err = client.call(ctx, "textDocument/references", params, &locations)
+		if err != nil {
+			return "", err
+		}
+		if len(locations) == 0 {
+			return "No references found.", nil
+		}
+		sort.SliceStable(locations, func(i, j int) bool {
+			a, b := locations[i], locations[j]
+			if a.URI != b.URI {
+				return a.URI < b.URI
+			}
+			return a.Range.Start.Line < b.Range.Start.Line
+		})
+		return fmt.Sprintf("%d references:\n%s", len(locations), describeLocations(client, locations, maxReferences)), nil
+
+	case "hover":
+		var result struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		err = client.call(ctx, "textDocument/hover", params, &result)
+		if err != nil {
+			return "", err
+		}
+		hover := hoverText(result.Contents)
+		if hover == "" {
+			return "No information for this position.", nil
+		}
+		return hover, nil
+
+	default:
+		params["newName"] = codeIntelInput.NewName
+		var edit lspWorkspaceEdit
+		err = client.call(ctx, "textDocument/rename", params, &edit)
+		if err != nil {
+			return "", err
+		}
+		return applyWorkspaceEdit(ctx, client, edit, "code_intel")
+	}
+}
+
+// symbolPosition finds the LSP position of the symbol the input points at.
+func symbolPosition(client *lspClient, text string, input CodeIntelInput) (lspPosition, error) {
+	lines := strings.Split(text, "\n")
+	if input.Line < 1 || input.Line > len(lines) {
+		return lspPosition{}, toolErrorf(ErrorInvalidInput, "line %d is outside %s, which has %d lines", input.Line, input.Path, len(lines))
+	}
+	lineText := strings.TrimSuffix(lines[input.Line-1], "\r")
+
+	offset := -1
+	if input.Column > 0 {
+		offset = min(input.Column-1, len(lineText))
+	}
+	if input.Symbol != "" {
+		match := findIdentifier(lineText, input.Symbol, max(offset, 0))
+		if match < 0 {
+			return lspPosition{}, toolErrorf(ErrorNotFound, "%q not found on line %d of %s: %s", input.Symbol, input.Line, input.Path, strings.TrimSpace(lineText))
+		}
+		offset = match
+	}
+	if offset < 0 {
+		return lspPosition{}, fmt.Errorf("symbol or column is required")
+	}
+	return client.position(input.Line-1, lineText, offset), nil
+}
+
+// findIdentifier returns the byte offset of the first whole-word occurrence
+// of name in line at or after from, or -1.
+func findIdentifier(line, name string, from int) int {
+	isIdent := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for start := from; start <= len(line); {
+		index := strings.Index(line[start:], name)
+		if index < 0 {
+			return -1
+		}
+		index += start
+		before, _ := utf8.DecodeLastRuneInString(line[:index])
+		after, _ := utf8.DecodeRuneInString(line[index+len(name):])
+		if (index == 0 || !isIdent(before)) && (index+len(name) == len(line) || !isIdent(after)) {
+			return index
+		}
+		start = index + 1
+	}
+	return -1
+}
+
+// decodeLocations accepts the single location or list definition returns.
+func decodeLocations(result json.RawMessage) ([]lspLocation, error) {
+	trimmed := strings.TrimSpace(string(result))
+	if trimmed == "" || trimmed == "null" {
+		return nil, nil
+	}
+	if strings.HasPrefix(trimmed, "{") {
+		var location lspLocation
+		err := json.Unmarshal(result, &location)
+		return []lspLocation{location}, err
+	}
+	var locations []lspLocation
+	err := json.Unmarshal(result, &locations)
+	return locations, err
+}
+
+// describeLocations lists up to limit locations as path:line:column with
+// the line's text.
+func describeLocations(client *lspClient, locations []lspLocation, limit int) string {
+	files := map[string]string{}
+	var lines []string
+	for i, location := range locations {
+		if i == limit {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(locations)-limit))
+			break
+		}
+		path := uriPath(location.URI)
+		text, ok := files[path]
+		if !ok {
+			content, _ := os.ReadFile(path)
+			text = string(content)
+			files[path] = text
+		}
+
+		offset := client.offset(text, location.Range.Start)
+		lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+		lineEnd := strings.IndexByte(text[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text) - offset
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", relativeToWorkspace(path), location.Range.Start.Line+1, offset-lineStart+1,
+			strings.TrimSpace(text[lineStart:offset+lineEnd])))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hoverText flattens the forms hover contents come in: markup content, a
+// marked string or a list of them.
+func hoverText(contents json.RawMessage) string {
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(contents, &markup) == nil && markup.Value != "" {
+		return strings.TrimSpace(markup.Value)
+	}
+	var plain string
+	if json.Unmarshal(contents, &plain) == nil {
+		return strings.TrimSpace(plain)
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			if part := hoverText(item); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+type lspWorkspaceEdit struct {
+	Changes         map[string][]lspTextEdit `json:"changes"`
+	DocumentChanges []struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []lspTextEdit `json:"edits"`
+	} `json:"documentChanges"`
+}
+
+// applyWorkspaceEdit writes a server's edits to disk as one change that
+// edits either every file or none, recording each file in the journal so
+// the change can be undone. Edits outside the workspace are refused before
+// anything is written.
+func applyWorkspaceEdit(ctx context.Context, client *lspClient, edit lspWorkspaceEdit, tool string) (string, error) {
+	changes := map[string][]lspTextEdit{}
+	for uri, edits := range edit.Changes {
+		changes[uriPath(uri)] = append(changes[uriPath(uri)], edits...)
+	}
+	for _, change := range edit.DocumentChanges {
+		path := uriPath(change.TextDocument.URI)
+		changes[path] = append(changes[path], change.Edits...)
+	}
+	if len(changes) == 0 {
+		return "", fmt.Errorf("the language server returned no edits")
+	}
+
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		if !withinRoot(workspaceRoot, path) {
+			return "", fmt.Errorf("the change would edit %s, which is outside the workspace", path)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var files []*pendingFile
+	var summary []string
+	total := 0
+	for _, path := range paths {
+		// The server worked from the files as they are on disk, which
+		// client.sync sent it.
+		file, err := stageFile(ctx, path, true)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", relativeToWorkspace(path), err)
+		}
+		text := string(file.content)
+
+		// Later edits first, so earlier offsets stay valid.
+		edits := changes[path]
+		sort.SliceStable(edits, func(i, j int) bool {
+			return client.offset(text, edits[i].Range.Start) > client.offset(text, edits[j].Range.Start)
+		})
+		for _, textEdit := range edits {
+			start, end := client.offset(text, textEdit.Range.Start), client.offset(text, textEdit.Range.End)
+			text = text[:start] + textEdit.NewText + text[end:]
+		}
+
+		file.edited = file.format.normalize(text)
+		file.edits = len(edits)
+		files = append(files, file)
+		total += len(edits)
+		summary = append(summary, fmt.Sprintf("%s (%d edits)", relativeToWorkspace(path), len(edits)))
+	}
+	err := commitFiles(ctx, tool, files)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Applied %d edits in %d files:\n%s", total, len(paths), strings.Join(summary, "\n")), nil
+}
+
+func CodeIntelMutating(ctx context.Context, input json.RawMessage) bool {
+	codeIntelInput := CodeIntelInput{}
+	return json.Unmarshal(input, &codeIntelInput) == nil && codeIntelInput.Operation == "rename"
+}
+
+// PreviewCodeIntel asks before a rename, which can touch many files.
+func PreviewCodeIntel(input json.RawMessage) (string, bool) {
+	codeIntelInput := CodeIntelInput{}
+	err := json.Unmarshal(input, &codeIntelInput)
+	if err != nil {
+		return fmt.Sprintf("code_intel with invalid input: %s", input), true
+	}
+	target := fmt.Sprintf("%s at %s:%d", orDefault(codeIntelInput.Symbol, "the symbol"), codeIntelInput.Path, codeIntelInput.Line)
+	if codeIntelInput.Operation != "rename" {
+		return fmt.Sprintf("Look up the %s of %s", codeIntelInput.Operation, target), false
+	}
+	return fmt.Sprintf("Rename %s to %s in every file that uses it", target, codeIntelInput.NewName), true
+}
@@ -0,0 +1,284 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// SlashCommand is a REPL command such as /help. Commands are handled locally
+// and never sent to the model.
+type SlashCommand struct {
+	Name        string
+	Usage       string
+	Description string
+	// Run receives the text after the command name and the conversation so
+	// far, and returns the conversation to continue with.
+	Run func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error)
+}
+
+// errExit is returned by a command to end the chat.
+var errExit = errors.New("exit requested")
+
+func defaultCommands() []SlashCommand {
+	return []SlashCommand{
+		HelpCommand,
+		ClearCommand,
+		ModelCommand,
+		ToolsCommand,
+		SaveCommand,
+		SessionsCommand,
+		ForkCommand,
+		RetryCommand,
+		EditCommand,
+		PromptCommand,
+		ExportCommand,
+		PlanCommand,
+		UndoCommand,
+		CheckpointCommand,
+		MemoryCommand,
+		MapCommand,
+		AttachCommand,
+		ThinkCommand,
+		ContextCommand,
+		ExitCommand,
+	}
+}
+
+// RegisterCommand adds a slash command, replacing any command with the same
+// name.
+func (a *Agent) RegisterCommand(command SlashCommand) {
+	for i, existing := range a.commands {
+		if existing.Name == command.Name {
+			a.commands[i] = command
+			return
+		}
+	}
+	a.commands = append(a.commands, command)
+}
+
+// isSlashCommand reports whether input should be dispatched as a command.
+// Only a leading "/word" counts, so messages starting with a path such as
+// /usr/bin/env still reach the model.
+func isSlashCommand(input string) bool {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, "/") {
+		return false
+	}
+	name, _, _ := strings.Cut(input[1:], " ")
+	return name != "" && !strings.Contains(name, "/")
+}
+
+func (a *Agent) runCommand(input string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(input)[1:], " ")
+	for _, command := range a.commands {
+		if command.Name == name {
+			return command.Run(a, strings.TrimSpace(args), conversation)
+		}
+	}
+	return conversation, fmt.Errorf("unknown command /%s, type /help for a list of commands", name)
+}
+
+// /help command
+
+var HelpCommand = SlashCommand{
+	Name:        "help",
+	Description: "Show available commands",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		commands := append([]SlashCommand(nil), a.commands...)
+		sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+		for _, command := range commands {
+			usage := "/" + command.Name
+			if command.Usage != "" {
+				usage += " " + command.Usage
+			}
+			fmt.Printf("  %-20s %s\n", usage, command.Description)
+		}
+		return conversation, nil
+	},
+}
+
+// /clear command
+
+var ClearCommand = SlashCommand{
+	Name:        "clear",
+	Description: "Start a new conversation; the current one stays saved",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		a.mu.Lock()
+		a.session = NewSession(a.config.Model)
+		a.mu.Unlock()
+		fmt.Printf("\u001b[93msystem\u001b[0m: started session %s\n", a.session.ID)
+		return nil, nil
+	},
+}
+
+// /model command
+
+var ModelCommand = SlashCommand{
+	Name:        "model",
+	Usage:       "[name]",
+	Description: "Show or switch the model",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		if args == "" {
+			fmt.Printf("\u001b[93msystem\u001b[0m: using %s model %s\n", a.provider.Name(), a.config.Model)
+			return conversation, nil
+		}
+
+		model := anthropic.Model(args)
+		if a.config.Provider == ProviderAnthropic {
+			resolved, err := resolveModel(args)
+			if err != nil {
+				return conversation, err
+			}
+			model = resolved
+		}
+
+		a.config.Model = model
+		a.session.Model = model
+		fmt.Printf("\u001b[93msystem\u001b[0m: switched to %s\n", model)
+		return conversation, nil
+	},
+}
+
+// /tools command
+
+var ToolsCommand = SlashCommand{
+	Name:        "tools",
+	Description: "List the tools available to the model",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		for _, tool := range a.tools {
+			summary, _, _ := strings.Cut(tool.Description, "\n")
+			fmt.Printf("  %-20s %s\n", tool.Name, summary)
+		}
+		return conversation, nil
+	},
+}
+
+// /save command
+
+var SaveCommand = SlashCommand{
+	Name:        "save",
+	Description: "Save the session now and show how to resume it",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		a.setConversation(conversation)
+		err := a.persistSession()
+		if err != nil {
+			return conversation, fmt.Errorf("failed to save session: %w", err)
+		}
+		fmt.Printf("\u001b[93msystem\u001b[0m: saved session %s, resume with --resume %s\n", a.session.ID, a.session.ID)
+		return conversation, nil
+	},
+}
+
+// /sessions command
+
+// maxListedSessions caps how many sessions /sessions lists.
+const maxListedSessions = 20
+
+var SessionsCommand = SlashCommand{
+	Name:        "sessions",
+	Usage:       "[open|delete <id>]",
+	Description: "List saved sessions, switch to one or delete one",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		action, id, _ := strings.Cut(args, " ")
+		id = strings.TrimSpace(id)
+		switch {
+		case action == "" || action == "list":
+			summaries, err := ListSessions()
+			if err != nil {
+				return conversation, err
+			}
+			if len(summaries) == 0 {
+				fmt.Println("\u001b[93msystem\u001b[0m: no saved sessions")
+				return conversation, nil
+			}
+			for i, summary := range summaries {
+				if i == maxListedSessions {
+					fmt.Printf("  ... and %d older sessions, see system3 sessions list\n", len(summaries)-i)
+					break
+				}
+				marker := " "
+				if summary.ID == a.session.ID {
+					marker = "*"
+				}
+				fmt.Printf("%s %s\n", marker, summary)
+			}
+			return conversation, nil
+
+		case action == "open" && id != "":
+			a.setConversation(conversation)
+			err := a.persistSession()
+			if err != nil {
+				return conversation, fmt.Errorf("failed to save session: %w", err)
+			}
+			session, err := LoadSession(id)
+			if err != nil {
+				return conversation, err
+			}
+			a.mu.Lock()
+			a.session = session
+			a.mu.Unlock()
+			a.loop.reset()
+			a.results.clear()
+			fmt.Printf("\u001b[93msystem\u001b[0m: opened session %s (%d messages)\n", session.ID, len(session.Messages))
+			return session.Messages, nil
+
+		case action == "delete" && id != "":
+			if id == a.session.ID {
+				return conversation, fmt.Errorf("cannot delete the current session, /clear or open another one first")
+			}
+			err := DeleteSession(id)
+			if err != nil {
+				return conversation, err
+			}
+			fmt.Printf("\u001b[93msystem\u001b[0m: deleted session %s\n", id)
+			return conversation, nil
+		}
+		return conversation, fmt.Errorf("usage: /sessions [open|delete <id>]")
+	},
+}
+
+// /fork command
+
+var ForkCommand = SlashCommand{
+	Name:        "fork",
+	Usage:       "[name]",
+	Description: "Continue in a copy of the conversation; the original stays saved",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		a.setConversation(conversation)
+		err := a.persistSession()
+		if err != nil {
+			return conversation, fmt.Errorf("failed to save session: %w", err)
+		}
+
+		a.mu.Lock()
+		original := a.session
+		title := args
+		if title == "" && original.Title != "" {
+			title = original.Title + " (fork)"
+		}
+		fork := original.Fork(title)
+		a.session = fork
+		a.mu.Unlock()
+		err = a.persistSession()
+		if err != nil {
+			return conversation, fmt.Errorf("failed to save session: %w", err)
+		}
+
+		fmt.Printf("\u001b[93msystem\u001b[0m: forked session %s into %s, return with /sessions open %s\n", original.ID, fork.ID, original.ID)
+		return fork.Messages, nil
+	},
+}
+
+// /exit command
+
+var ExitCommand = SlashCommand{
+	Name:        "exit",
+	Description: "Quit System 3",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		return conversation, errExit
+	},
+}
@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const (
+	// compactionModel is the cheap Anthropic model used to summarize old
+	// turns.
+	compactionModel = anthropic.ModelClaude3_5HaikuLatest
+	// compactionKeepRecent is how many trailing messages survive compaction
+	// verbatim.
+	compactionKeepRecent = 10
+	// compactionResultLimit caps how much of each tool result is shown to
+	// the summarizer.
+	compactionResultLimit = 2000
+)
+
+// summaryTag opens the text block the summary is folded into the
+// conversation with.
+const summaryTag = "<summary of earlier conversation>"
+
+const compactionPrompt = `Summarize the following conversation between a user and a coding assistant so that the assistant can continue the work without the original messages.
+
+Keep: the user's goals and constraints, decisions made, files read or changed and why, commands run and their outcome, open problems and next steps. Drop pleasantries and raw file contents. Be concise but complete.
+
+<conversation>
+%s
+</conversation>`
+
+// estimateTokens gives a rough token count for a message using the common
+// four-characters-per-token heuristic over its JSON encoding.
+func estimateTokens(message anthropic.MessageParam) int {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return 0
+	}
+	return len(content)/4 + 1
+}
+
+func estimateConversationTokens(conversation []anthropic.MessageParam) int {
+	total := 0
+	for _, message := range conversation {
+		total += estimateTokens(message)
+	}
+	return total
+}
+
+// compactIfNeeded replaces older turns with a model-written summary once the
+// conversation approaches the configured token threshold. Recent messages
+// are kept as-is so the model retains detailed context for the current task.
+func (a *Agent) compactIfNeeded(ctx context.Context, conversation []anthropic.MessageParam) []anthropic.MessageParam {
+	if a.config.CompactThreshold <= 0 || estimateConversationTokens(conversation) < a.config.CompactThreshold {
+		return conversation
+	}
+
+	split := compactionSplit(conversation)
+	if split <= 0 {
+		return conversation
+	}
+
+	summary, err := a.summarize(ctx, conversation[:split])
+	if err != nil {
+		slog.Warn("failed to compact conversation", "error", err)
+		return conversation
+	}
+
+	// The summary is folded into the first kept user message so roles keep
+	// alternating.
+	first := conversation[split]
+	blocks := append([]anthropic.ContentBlockParamUnion{
+		anthropic.NewTextBlock(fmt.Sprintf("%s\n%s\n</summary of earlier conversation>", summaryTag, summary)),
+	}, first.Content...)
+
+	compacted := []anthropic.MessageParam{anthropic.NewUserMessage(blocks...)}
+	compacted = append(compacted, conversation[split+1:]...)
+	// Cached results may refer back to turns that were just summarized.
+	a.results.clear()
+
+	a.notice("compacted %d messages into a summary", split)
+	return compacted
+}
+
+// compactionSplit returns the index of the first message to keep. It must be
+// a user prompt rather than a tool result, otherwise the kept history would
+// reference a tool call that was summarized away.
+func compactionSplit(conversation []anthropic.MessageParam) int {
+	for i := len(conversation) - compactionKeepRecent; i > 0; i-- {
+		if isUserPrompt(conversation[i]) {
+			return i
+		}
+	}
+	return 0
+}
+
+func isUserPrompt(message anthropic.MessageParam) bool {
+	if message.Role != anthropic.MessageParamRoleUser {
+		return false
+	}
+	for _, block := range message.Content {
+		if block.OfRequestToolResultBlock != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Agent) summarize(ctx context.Context, messages []anthropic.MessageParam) (string, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		transcript.WriteString(renderMessageText(message, compactionResultLimit))
+		transcript.WriteString("\n")
+	}
+
+	response, err := a.provider.SendMessage(ctx, anthropic.MessageNewParams{
+		Model:     a.config.SummaryModel,
+		MaxTokens: int64(2048),
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf(compactionPrompt, transcript.String()))),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	a.recordUsage(a.config.SummaryModel, response)
+
+	var summary strings.Builder
+	for _, content := range response.Content {
+		if content.Type == "text" {
+			summary.WriteString(content.Text)
+		}
+	}
+	if summary.Len() == 0 {
+		return "", fmt.Errorf("summarizer returned no text")
+	}
+
+	return summary.String(), nil
+}
+
+// renderMessageText flattens a message into plain text, truncating tool
+// results to limit bytes.
+func renderMessageText(message anthropic.MessageParam, limit int) string {
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("[%s]\n", message.Role))
+	for _, block := range message.Content {
+		switch {
+		case block.OfRequestTextBlock != nil:
+			output.WriteString(block.OfRequestTextBlock.Text)
+			output.WriteString("\n")
+		case block.OfRequestToolUseBlock != nil:
+			input, _ := json.Marshal(block.OfRequestToolUseBlock.Input)
+			output.WriteString(fmt.Sprintf("(tool call) %s(%s)\n", block.OfRequestToolUseBlock.Name, input))
+		case block.OfRequestToolResultBlock != nil:
+			var result strings.Builder
+			for _, content := range block.OfRequestToolResultBlock.Content {
+				if content.OfRequestTextBlock != nil {
+					result.WriteString(content.OfRequestTextBlock.Text)
+				}
+			}
+			text := result.String()
+			if len(text) > limit {
+				text = text[:limit] + "... (truncated)"
+			}
+			output.WriteString(fmt.Sprintf("(tool result) %s\n", text))
+		case block.OfRequestImageBlock != nil:
+			output.WriteString("(image)\n")
+		}
+	}
+	return output.String()
+}
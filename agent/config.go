@@ -0,0 +1,492 @@
+package agent
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Config holds the runtime settings the Agent is started with.
+type Config struct {
+	Provider string
+	Model    anthropic.Model
+	// SummaryModel is the cheaper model used for housekeeping calls such as
+	// compaction.
+	SummaryModel anthropic.Model
+	// BaseURL and APIKey configure non-Anthropic providers. The Anthropic
+	// client reads its own ANTHROPIC_* environment variables.
+	BaseURL      string
+	APIKey       string
+	SystemPrompt string
+	Resume       string
+	// Transcript, when set, is where the session transcript is written on
+	// exit.
+	Transcript string
+	// Workspace is the directory file tools are confined to.
+	Workspace string
+	// Prompt, when set, runs a single task non-interactively instead of
+	// starting the chat.
+	Prompt string
+	// CompactThreshold is the estimated token count at which older turns
+	// are summarized. Zero disables compaction.
+	CompactThreshold int
+	// Permissions are the tool rules from the project permissions file.
+	Permissions Permissions
+	// Hooks are the shell hooks from the project hooks file.
+	Hooks []Hook
+	// Redact removes secrets from tool results before the model sees them.
+	Redact bool
+	// Record, when set, is the fixture file model calls are recorded to,
+	// and Replay the one they are answered from instead of a model.
+	Record string
+	Replay string
+	// ServerTools are the Anthropic-defined tools to offer besides the
+	// built-in ones: web_search, code_execution and text_editor.
+	ServerTools []string
+	// AuditLog, when set, is the file every tool call is appended to as a
+	// line of JSON.
+	AuditLog string
+	// Sandbox runs shell commands in containers when its image is set.
+	Sandbox SandboxSettings
+	// CustomTools are the shell command tools from the project tools file,
+	// added to the built-in ones.
+	CustomTools []ToolDefinition
+	// AutoApprove skips the confirmation prompt for destructive tool calls.
+	AutoApprove bool
+	// Format runs the language's formatter on every file edit_file and
+	// write_file change.
+	Format bool
+	// RepoMap includes a map of the workspace's files and most used symbols
+	// in the system prompt.
+	RepoMap bool
+	// Checkpoints snapshots the working tree onto a git ref before each
+	// batch of mutating tool calls.
+	Checkpoints bool
+	// SyncWrites flushes files the tools write to disk before they are
+	// renamed into place, so a power loss cannot lose a reported change.
+	SyncWrites bool
+	// GitIdentity is the commit identity used when git config and the
+	// environment do not provide one.
+	GitIdentity gitIdentity
+	// Forge overrides which forge the forge tool talks to.
+	Forge ForgeSettings
+	// Embeddings chooses the embeddings API semantic_search uses.
+	Embeddings EmbeddingSettings
+	// Plan starts in plan mode, where mutating tool calls are described
+	// instead of run.
+	Plan bool
+	// ReadOnly offers the model only tools and calls that change nothing,
+	// for production checkouts and code review.
+	ReadOnly bool
+	// Plain disables markdown rendering and prints replies as raw text.
+	Plain bool
+	// Headless starts the agent without printing to the terminal, for
+	// agents shown only through frontends attached with Attach.
+	Headless bool
+	// ServeAddr is the address system3 serve listens on, and ServeToken the
+	// bearer token its clients must send; one is generated when it is
+	// empty.
+	ServeAddr  string
+	ServeToken string
+	// LogLevel is the lowest level printed to the console. The session log
+	// file always records debug messages.
+	LogLevel slog.Level
+	// OTLPEndpoint is the OTLP/HTTP collector that traces and metrics are
+	// exported to. Telemetry is off when it is empty.
+	OTLPEndpoint string
+	MaxTokens    int64
+	// Thinking turns on extended thinking, letting the model reason for up
+	// to ThinkingBudget tokens before it answers. The budget comes on top of
+	// MaxTokens.
+	Thinking       bool
+	ThinkingBudget int64
+	// ShowThinking is how thinking is printed: stream, summary or hidden.
+	ShowThinking string
+	// MaxResultBytes caps each tool result added to the conversation; the
+	// full text of larger ones is saved for read_output. Zero disables it.
+	MaxResultBytes int
+	// MaxIterations caps the tool-use turns in a row before the agent stops
+	// for the user, and MaxRepeats how often the same tool call may be made
+	// while nothing changes. Zero disables either check.
+	MaxIterations int
+	MaxRepeats    int
+	// MaxCost and MaxTurns cap the estimated dollars spent on model calls
+	// and the model turns taken in a run, after which the agent pauses and
+	// asks whether to go on. Zero disables either limit.
+	MaxCost  float64
+	MaxTurns int
+	// ToolTimeout bounds each tool call, and ToolTimeouts overrides it for
+	// single tools. Zero means no limit.
+	ToolTimeout  time.Duration
+	ToolTimeouts map[string]time.Duration
+	// Temperature and TopP are nil when the API default should be used.
+	Temperature *float64
+	TopP        *float64
+}
+
+const (
+	defaultModel            = anthropic.ModelClaude3_5Sonnet20241022
+	defaultOpenAIModel      = "gpt-4o"
+	defaultOllamaModel      = "qwen2.5-coder"
+	defaultCompactThreshold = 150000
+	defaultMaxTokens        = 8192
+	defaultThinkingBudget   = 10000
+	// minThinkingBudget is the smallest budget the API accepts.
+	minThinkingBudget = 1024
+)
+
+// How extended thinking is shown.
+const (
+	ThinkingStream  = "stream"
+	ThinkingSummary = "summary"
+	ThinkingHidden  = "hidden"
+)
+
+// projectSystemPromptFile is the repo-local system prompt, relative to the
+// working directory.
+var projectSystemPromptFile = filepath.Join(".system3", "system.md")
+
+// knownModels lists the model IDs System 3 accepts.
+var knownModels = []anthropic.Model{
+	anthropic.ModelClaude3_7SonnetLatest,
+	anthropic.ModelClaude3_7Sonnet20250219,
+	anthropic.ModelClaude3_5HaikuLatest,
+	anthropic.ModelClaude3_5Haiku20241022,
+	anthropic.ModelClaude3_5SonnetLatest,
+	anthropic.ModelClaude3_5Sonnet20241022,
+	anthropic.ModelClaude_3_5_Sonnet_20240620,
+	anthropic.ModelClaude3OpusLatest,
+	anthropic.ModelClaude_3_Opus_20240229,
+	anthropic.ModelClaude_3_Haiku_20240307,
+}
+
+// modelAliases maps short names to the model they currently resolve to.
+var modelAliases = map[string]anthropic.Model{
+	"sonnet": anthropic.ModelClaude3_7SonnetLatest,
+	"haiku":  anthropic.ModelClaude3_5HaikuLatest,
+	"opus":   anthropic.ModelClaude3OpusLatest,
+}
+
+// LoadConfig builds a Config from command line arguments, falling back to
+// SYSTEM3_* environment variables and then to built-in defaults.
+func LoadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("system3", flag.ContinueOnError)
+
+	provider := fs.String("provider", envOr("SYSTEM3_PROVIDER", ProviderAnthropic), "Model backend: anthropic, openai (any OpenAI-compatible API) or ollama")
+	model := fs.String("model", os.Getenv("SYSTEM3_MODEL"), "Model ID; for Anthropic also an alias (sonnet, haiku, opus)")
+	baseURL := fs.String("base-url", os.Getenv("SYSTEM3_BASE_URL"), "API base URL for OpenAI-compatible and Ollama providers")
+	systemPrompt := fs.String("system-prompt", "", "Extra system prompt instructions")
+	resume := fs.String("resume", "", "Resume a saved session by ID")
+	transcript := fs.String("transcript", "", "Write the session transcript to this file on exit, as JSON if it ends in .json and markdown otherwise")
+	workspace := fs.String("workspace", envOr("SYSTEM3_WORKSPACE", "."), "Directory the file tools are confined to")
+	prompt := fs.String("prompt", "", "Run a single task without the interactive chat, print the answer and exit")
+	fs.StringVar(prompt, "p", "", "Shorthand for --prompt")
+	autoApprove := fs.Bool("auto-approve", false, "Run destructive tool calls without asking for confirmation")
+	format := fs.Bool("format", false, "Run gofmt/goimports, prettier or black on files the agent edits")
+	repoMap := fs.Bool("repo-map", true, "Start the session with a map of the repository's files and most used symbols in the system prompt")
+	checkpoints := fs.Bool("checkpoints", false, "Snapshot the working tree to "+checkpointRef+" before the agent changes anything")
+	fsync := fs.Bool("fsync", false, "Flush every file the agent writes to disk before reporting it written, at some cost in speed")
+	identity := fs.String("git-identity", os.Getenv("SYSTEM3_GIT_IDENTITY"), "Fallback commit identity as \"Name <email>\" when git config has none")
+	forge := fs.String("forge", os.Getenv("SYSTEM3_FORGE"), "Forge hosting the repository: github, gitlab or gitea (default: detected from the remote URL)")
+	forgeURL := fs.String("forge-url", os.Getenv("SYSTEM3_FORGE_URL"), "Forge API base URL, for self-hosted instances (default: derived from the remote URL)")
+	embeddings := fs.String("embeddings", os.Getenv("SYSTEM3_EMBEDDINGS"), "Embeddings API for semantic_search: voyage, openai or ollama (default: voyage or openai, whichever has an API key set)")
+	embeddingModel := fs.String("embedding-model", os.Getenv("SYSTEM3_EMBEDDING_MODEL"), "Embeddings model (default: voyage-code-3, text-embedding-3-small or nomic-embed-text)")
+	embeddingsURL := fs.String("embeddings-url", os.Getenv("SYSTEM3_EMBEDDINGS_URL"), "Embeddings API base URL (default: the provider's)")
+	plan := fs.Bool("plan", false, "Start in plan mode: describe changes instead of making them")
+	readOnly := fs.Bool("read-only", false, "Only let the model read, list and search files and inspect git history; nothing it can call changes anything")
+	plain := fs.Bool("plain", false, "Print replies as raw text instead of rendered markdown")
+	fs.BoolVar(plain, "no-color", false, "Same as --plain")
+	logLevel := fs.String("log-level", envOr("SYSTEM3_LOG_LEVEL", "warn"), "Lowest log level shown on the console: debug, info, warn or error")
+	otlpEndpoint := fs.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector URL to export traces and metrics to (default: telemetry off)")
+	maxTokens := fs.Int64("max-tokens", defaultMaxTokens, "Maximum tokens the model may generate per response")
+	thinking := fs.Bool("thinking", false, "Let the model think before answering (Anthropic only), for hard tasks such as large refactors")
+	thinkingBudget := fs.Int64("thinking-budget", defaultThinkingBudget, fmt.Sprintf("Maximum tokens the model may think for per response, at least %d", minThinkingBudget))
+	showThinking := fs.String("show-thinking", envOr("SYSTEM3_SHOW_THINKING", ThinkingStream), "How thinking is printed: stream, summary or hidden")
+	maxResultBytes := fs.Int("max-result-bytes", defaultMaxResultBytes, "Largest tool result sent to the model; larger ones keep their start and end and are saved whole for paging (0 disables)")
+	maxIterations := fs.Int("max-iterations", defaultMaxIterations, "Tool-use turns in a row before the agent stops and asks the user how to go on (0 disables)")
+	maxRepeats := fs.Int("max-repeats", defaultMaxRepeats, "Times the same tool call may repeat while nothing changes before the agent stops as stuck (0 disables)")
+	redact := fs.Bool("redact", true, "Redact API keys, tokens and private keys from tool results before they reach the model or transcripts; add patterns under redact in the permissions file")
+	record := fs.String("record", "", "Record every model request and response to this fixture file (JSONL)")
+	replay := fs.String("replay", "", "Answer model requests from a fixture recorded with --record instead of calling the model")
+	serverTools := fs.String("server-tools", os.Getenv("SYSTEM3_SERVER_TOOLS"), "Anthropic-defined tools to offer, separated by commas: web_search and code_execution run on Anthropic's servers, text_editor edits files here (Anthropic provider only)")
+	auditLog := fs.String("audit-log", os.Getenv("SYSTEM3_AUDIT_LOG"), "Append every tool call, with its input, result hash and approval decision, to this JSONL file")
+	sandbox := fs.String("sandbox", os.Getenv("SYSTEM3_SANDBOX"), "Container image to run shell commands in, with only the workspace mounted, instead of on the host (default: host)")
+	sandboxNetwork := fs.Bool("sandbox-network", false, "Give sandbox containers network access")
+	sandboxRuntime := fs.String("sandbox-runtime", envOr("SYSTEM3_SANDBOX_RUNTIME", "docker"), "Container CLI for the sandbox, docker or a compatible one such as podman")
+	maxCost := fs.Float64("max-cost", 0, "Dollars the session may spend on model calls, estimated from list prices, before the agent pauses and asks whether to go on (0 disables)")
+	maxTurns := fs.Int("max-turns", 0, "Model turns, including sub-agents', the session may take before the agent pauses and asks whether to go on (0 disables)")
+	toolTimeout := fs.Duration("tool-timeout", defaultToolTimeout, "Longest a tool call may run before it is canceled; fetch_url, git, forge and dispatch_agent have their own defaults (0 disables all limits)")
+	toolTimeouts := fs.String("tool-timeouts", os.Getenv("SYSTEM3_TOOL_TIMEOUTS"), "Timeouts for single tools, as tool=duration pairs separated by commas, e.g. fetch_url=30s,git=10m (0 disables a tool's limit)")
+	serveAddr := fs.String("addr", envOr("SYSTEM3_ADDR", defaultServeAddr), "Address system3 serve listens on; clients must send SYSTEM3_SERVE_TOKEN, or the token printed at start, as a bearer token")
+	temperature := fs.Float64("temperature", -1, "Sampling temperature between 0 and 1 (default: API default)")
+	topP := fs.Float64("top-p", -1, "Nucleus sampling probability between 0 and 1 (default: API default)")
+	compactThreshold := fs.Int("compact-threshold", defaultCompactThreshold, "Estimated token count at which old turns are summarized (0 disables)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{Provider: *provider, BaseURL: *baseURL}
+	switch *provider {
+	case ProviderAnthropic:
+		config.Model, err = resolveModel(orDefault(*model, defaultModel))
+		if err != nil {
+			return Config{}, err
+		}
+		config.SummaryModel = compactionModel
+	case ProviderOpenAI:
+		config.Model = orDefault(*model, defaultOpenAIModel)
+		config.SummaryModel = config.Model
+		config.APIKey = envOr("SYSTEM3_API_KEY", os.Getenv("OPENAI_API_KEY"))
+	case ProviderOllama:
+		config.Model = orDefault(*model, defaultOllamaModel)
+		config.SummaryModel = config.Model
+		config.BaseURL = orDefault(config.BaseURL, os.Getenv("OLLAMA_HOST"))
+	default:
+		return Config{}, fmt.Errorf("unknown provider %q, expected %s, %s or %s", *provider, ProviderAnthropic, ProviderOpenAI, ProviderOllama)
+	}
+
+	if *record != "" && *replay != "" {
+		return Config{}, fmt.Errorf("--record and --replay cannot be used together")
+	}
+	config.Record = *record
+	config.Replay = *replay
+
+	config.ServerTools, err = parseServerTools(*serverTools)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(config.ServerTools) > 0 && config.Provider != ProviderAnthropic {
+		return Config{}, fmt.Errorf("--server-tools needs the %s provider", ProviderAnthropic)
+	}
+
+	systemPromptText, err := loadSystemPrompt(*systemPrompt)
+	if err != nil {
+		return Config{}, err
+	}
+
+	permissions, err := LoadPermissions(projectPermissionsFile)
+	if err != nil {
+		return Config{}, err
+	}
+
+	hooks, err := LoadHooks(projectHooksFile)
+	if err != nil {
+		return Config{}, err
+	}
+
+	customTools, err := LoadCustomTools(projectToolsFile, DefaultTools())
+	if err != nil {
+		return Config{}, err
+	}
+
+	gitIdentity, err := parseGitIdentity(*identity)
+	if err != nil {
+		return Config{}, err
+	}
+
+	switch *forge {
+	case "", ForgeGitHub, ForgeGitLab, ForgeGitea:
+	default:
+		return Config{}, fmt.Errorf("unknown forge %q, expected %s, %s or %s", *forge, ForgeGitHub, ForgeGitLab, ForgeGitea)
+	}
+
+	switch *embeddings {
+	case "", EmbeddingsVoyage, ProviderOpenAI, ProviderOllama:
+	default:
+		return Config{}, fmt.Errorf("unknown embeddings provider %q, expected %s, %s or %s", *embeddings, EmbeddingsVoyage, ProviderOpenAI, ProviderOllama)
+	}
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(*logLevel))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", *logLevel)
+	}
+
+	if *maxTokens <= 0 {
+		return Config{}, fmt.Errorf("max-tokens must be positive, got %d", *maxTokens)
+	}
+
+	if *thinkingBudget < minThinkingBudget {
+		return Config{}, fmt.Errorf("thinking-budget must be at least %d, got %d", minThinkingBudget, *thinkingBudget)
+	}
+	switch *showThinking {
+	case ThinkingStream, ThinkingSummary, ThinkingHidden:
+	default:
+		return Config{}, fmt.Errorf("unknown show-thinking %q, expected %s, %s or %s", *showThinking, ThinkingStream, ThinkingSummary, ThinkingHidden)
+	}
+	if *thinking {
+		err = checkThinking(*provider, optionalFloat(*temperature), optionalFloat(*topP))
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	if *maxCost < 0 || *maxTurns < 0 {
+		return Config{}, fmt.Errorf("max-cost and max-turns must not be negative")
+	}
+
+	if *toolTimeout < 0 {
+		return Config{}, fmt.Errorf("tool-timeout must not be negative, got %s", *toolTimeout)
+	}
+	toolTimeoutOverrides, err := parseToolTimeouts(*toolTimeouts)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, sampling := range []struct {
+		name  string
+		value float64
+	}{{"temperature", *temperature}, {"top-p", *topP}} {
+		if sampling.value != -1 && (sampling.value < 0 || sampling.value > 1) {
+			return Config{}, fmt.Errorf("%s must be between 0 and 1, got %g", sampling.name, sampling.value)
+		}
+	}
+
+	config.SystemPrompt = systemPromptText
+	config.Resume = *resume
+	config.Transcript = *transcript
+	config.Workspace = *workspace
+	config.Prompt = *prompt
+	config.CompactThreshold = *compactThreshold
+	config.Permissions = permissions
+	config.Hooks = hooks
+	config.CustomTools = customTools
+	config.AutoApprove = *autoApprove
+	config.Plain = *plain
+	config.Plan = *plan
+	config.ReadOnly = *readOnly
+	config.Checkpoints = *checkpoints
+	config.SyncWrites = *fsync
+	config.Format = *format
+	config.RepoMap = *repoMap
+	config.GitIdentity = gitIdentity
+	config.Forge = ForgeSettings{Kind: *forge, APIURL: *forgeURL}
+	config.Redact = *redact
+	config.AuditLog = *auditLog
+	config.Sandbox = SandboxSettings{Image: *sandbox, Network: *sandboxNetwork, Runtime: *sandboxRuntime}
+	config.Embeddings = EmbeddingSettings{Provider: *embeddings, Model: *embeddingModel, BaseURL: *embeddingsURL}
+	config.MaxTokens = *maxTokens
+	config.MaxResultBytes = *maxResultBytes
+	config.MaxIterations = *maxIterations
+	config.MaxRepeats = *maxRepeats
+	config.MaxCost = *maxCost
+	config.MaxTurns = *maxTurns
+	config.ToolTimeout = *toolTimeout
+	config.ToolTimeouts = toolTimeoutOverrides
+	config.ServeAddr = *serveAddr
+	config.ServeToken = os.Getenv("SYSTEM3_SERVE_TOKEN")
+	config.Thinking = *thinking
+	config.ThinkingBudget = *thinkingBudget
+	config.ShowThinking = *showThinking
+	config.LogLevel = level
+	config.OTLPEndpoint = *otlpEndpoint
+	config.Temperature = optionalFloat(*temperature)
+	config.TopP = optionalFloat(*topP)
+
+	return config, nil
+}
+
+// checkThinking reports why extended thinking cannot be used with the
+// provider and sampling settings, or returns nil. The API only allows it
+// with the default temperature and a top_p of at least 0.95.
+func checkThinking(provider string, temperature, topP *float64) error {
+	if provider != ProviderAnthropic {
+		return fmt.Errorf("extended thinking needs the %s provider, not %s", ProviderAnthropic, provider)
+	}
+	if temperature != nil {
+		return errors.New("extended thinking cannot be combined with --temperature")
+	}
+	if topP != nil && *topP < 0.95 {
+		return fmt.Errorf("extended thinking needs a top-p of at least 0.95, got %g", *topP)
+	}
+	return nil
+}
+
+// optionalFloat maps the -1 "unset" flag value to nil.
+func optionalFloat(value float64) *float64 {
+	if value == -1 {
+		return nil
+	}
+	return &value
+}
+
+// loadSystemPrompt merges the project file, the SYSTEM3_SYSTEM_PROMPT env var
+// and the --system-prompt flag, in that order, into a single prompt.
+func loadSystemPrompt(flagValue string) (string, error) {
+	var parts []string
+
+	content, err := os.ReadFile(projectSystemPromptFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", projectSystemPromptFile, err)
+	}
+	parts = append(parts, string(content))
+	parts = append(parts, os.Getenv("SYSTEM3_SYSTEM_PROMPT"))
+	parts = append(parts, flagValue)
+
+	var merged []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			merged = append(merged, part)
+		}
+	}
+
+	return strings.Join(merged, "\n\n"), nil
+}
+
+func resolveModel(name string) (anthropic.Model, error) {
+	name = strings.TrimSpace(name)
+	if alias, ok := modelAliases[strings.ToLower(name)]; ok {
+		return alias, nil
+	}
+
+	for _, model := range knownModels {
+		if model == name {
+			return model, nil
+		}
+	}
+
+	var valid []string
+	for alias := range modelAliases {
+		valid = append(valid, alias)
+	}
+	sort.Strings(valid)
+	valid = append(valid, knownModels...)
+
+	return "", fmt.Errorf("unknown model %q, expected one of: %s", name, strings.Join(valid, ", "))
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// userDataDir is where System 3 keeps its own files, such as sessions, logs
+// and plugins: ~/.system3, or system3 in %APPDATA% on Windows.
+func userDataDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate application data directory: %w", err)
+		}
+		return filepath.Join(dir, "system3"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, ".system3"), nil
+}
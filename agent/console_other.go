@@ -0,0 +1,6 @@
+//go:build !windows
+
+package agent
+
+// Other terminals interpret escape sequences already.
+func EnableConsoleColors() {}
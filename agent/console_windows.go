@@ -0,0 +1,23 @@
+//go:build windows
+
+package agent
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// EnableConsoleColors turns on escape sequence processing in the Windows
+// console, which otherwise prints the chat's colors as raw codes. Output
+// that is not a console, such as a pipe, is left alone.
+func EnableConsoleColors() {
+	for _, file := range []*os.File{os.Stdout, os.Stderr} {
+		handle := windows.Handle(file.Fd())
+		var mode uint32
+		if windows.GetConsoleMode(handle, &mode) != nil {
+			continue
+		}
+		_ = windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+}
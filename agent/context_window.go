@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// contextWarning is the share of the context window at which the chat's
+// prompt starts showing how full the context is.
+const contextWarning = 0.7
+
+// contextWindows are the context window sizes, in tokens, of model
+// families. Other models, such as most served by Ollama, have no known
+// window, and the context is shown without a share of it.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"claude-", 200000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"o3", 200000},
+	{"o4-mini", 200000},
+}
+
+// contextWindow returns the context window of model, or 0 when it is not
+// known.
+func contextWindow(model anthropic.Model) int {
+	for _, entry := range contextWindows {
+		if strings.HasPrefix(string(model), entry.prefix) {
+			return entry.tokens
+		}
+	}
+	return 0
+}
+
+// contextUsage is an estimate of what the next request sends the model, by
+// part, with the same heuristic compaction uses.
+type contextUsage struct {
+	system, tools, history int
+}
+
+func (u contextUsage) total() int {
+	return u.system + u.tools + u.history
+}
+
+func (a *Agent) contextUsage(conversation []anthropic.MessageParam) contextUsage {
+	return contextUsage{
+		system:  estimateJSONTokens(a.systemPrompt()),
+		tools:   estimateJSONTokens(a.toolParams()),
+		history: estimateConversationTokens(conversation),
+	}
+}
+
+// estimateJSONTokens estimates the tokens of value's JSON encoding, as
+// estimateTokens does for a message.
+func estimateJSONTokens(value any) int {
+	content, err := json.Marshal(value)
+	if err != nil || len(content) <= len("null") {
+		return 0
+	}
+	return len(content)/4 + 1
+}
+
+// contextIndicator is shown in the chat's prompt once the conversation
+// nears the model's context window, or is "".
+func (a *Agent) contextIndicator() string {
+	window := contextWindow(a.config.Model)
+	if window == 0 {
+		return ""
+	}
+	share := float64(a.contextUsage(a.Conversation()).total()) / float64(window)
+	if share < contextWarning {
+		return ""
+	}
+	return fmt.Sprintf(" \u001b[93m(context %.0f%% full)\u001b[0m", 100*share)
+}
+
+// /context command
+
+var ContextCommand = SlashCommand{
+	Name:        "context",
+	Description: "Show the estimated tokens the conversation takes up in the model's context window",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		usage := a.contextUsage(conversation)
+		window := contextWindow(a.config.Model)
+		if window == 0 {
+			fmt.Printf("\u001b[93msystem\u001b[0m: estimated context for %s (its context window is not known)\n", a.config.Model)
+		} else {
+			fmt.Printf("\u001b[93msystem\u001b[0m: estimated context for %s, of a %d token window\n", a.config.Model, window)
+		}
+		line := func(name string, tokens int) {
+			if window == 0 {
+				fmt.Printf("  %-14s %8d\n", name, tokens)
+				return
+			}
+			fmt.Printf("  %-14s %8d %5.1f%%\n", name, tokens, 100*float64(tokens)/float64(window))
+		}
+		line("system prompt", usage.system)
+		line("tools", usage.tools)
+		line(fmt.Sprintf("history (%d)", len(conversation)), usage.history)
+		line("total", usage.total())
+		if window > 0 {
+			line("free", max(window-usage.total(), 0))
+		}
+
+		a.mu.Lock()
+		last := a.lastUsage
+		a.mu.Unlock()
+		if last.InputTokens+last.CacheReadInputTokens+last.CacheCreationInputTokens > 0 {
+			fmt.Printf("  last request: %d input tokens, %d of them read from the prompt cache and %d written to it\n",
+				last.InputTokens+last.CacheReadInputTokens+last.CacheCreationInputTokens, last.CacheReadInputTokens, last.CacheCreationInputTokens)
+		}
+		if a.config.CompactThreshold > 0 {
+			fmt.Printf("  the history is compacted once it reaches about %d tokens\n", a.config.CompactThreshold)
+		}
+		return conversation, nil
+	},
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"gopkg.in/yaml.v3"
+)
+
+// projectToolsFile holds the repo-local custom tools, relative to the
+// working directory.
+var projectToolsFile = filepath.Join(".system3", "tools.yaml")
+
+// customToolName restricts custom tool names to what the API accepts.
+var customToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// CustomTool is a tool from the tools file that runs a shell command, so
+// project commands become tools without writing Go or a plugin:
+//
+//	tools:
+//	  - name: make
+//	    description: Run a make target in the project root.
+//	    command: make {{target}}
+//	    input_schema:
+//	      properties:
+//	        target:
+//	          type: string
+//	          description: The target to build, such as test or lint.
+//	      required: [target]
+//	  - name: compose_ps
+//	    description: List the docker compose services and their state.
+//	    command: docker compose ps
+//
+// Each {{name}} in the command is replaced by that input, quoted for the
+// shell; inputs that are not strings are passed as JSON. Commands run like
+// run_shell_command, in the workspace root: the permissions file's shell
+// rules apply to them, and they ask for approval unless a rule allows them.
+// timeout defaults to the shell tool's two minutes and may be up to ten.
+//
+// The file comes with the repository, so it is only loaded once the user
+// trusts it, and custom tools are never offered in read-only or plan mode.
+type CustomTool struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Command     string `yaml:"command"`
+	InputSchema struct {
+		Properties map[string]any `yaml:"properties"`
+		Required   []string       `yaml:"required"`
+	} `yaml:"input_schema"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LoadCustomTools reads the tools file at path. A missing file, or one the
+// user does not trust, yields no tools. taken are the names already in use,
+// which custom tools may not replace.
+func LoadCustomTools(path string, taken []ToolDefinition) ([]ToolDefinition, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	trusted, err := trustProjectFile(path, content, "custom tools", "run commands on this machine")
+	if err != nil || !trusted {
+		return nil, err
+	}
+
+	var file struct {
+		Tools []CustomTool `yaml:"tools"`
+	}
+	err = yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, tool := range taken {
+		names[tool.Name] = true
+	}
+	var tools []ToolDefinition
+	for _, custom := range file.Tools {
+		err := custom.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if names[custom.Name] {
+			return nil, fmt.Errorf("%s: tool %s already exists", path, custom.Name)
+		}
+		names[custom.Name] = true
+		tools = append(tools, custom.definition())
+	}
+	return tools, nil
+}
+
+func (c CustomTool) validate() error {
+	if !customToolName.MatchString(c.Name) {
+		return fmt.Errorf("invalid tool name %q, use letters, digits, _ and - only", c.Name)
+	}
+	if strings.TrimSpace(c.Description) == "" || strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("tool %s needs a description and a command", c.Name)
+	}
+	for _, match := range promptVariable.FindAllStringSubmatch(c.Command, -1) {
+		if _, ok := c.InputSchema.Properties[match[1]]; !ok {
+			return fmt.Errorf("tool %s: the command uses {{%s}}, which is not in input_schema.properties", c.Name, match[1])
+		}
+	}
+	for _, name := range c.InputSchema.Required {
+		if _, ok := c.InputSchema.Properties[name]; !ok {
+			return fmt.Errorf("tool %s: required input %s is not in input_schema.properties", c.Name, name)
+		}
+	}
+	if c.Timeout < 0 || c.Timeout > maxShellTimeout {
+		return fmt.Errorf("tool %s: timeout must be between 0 and %s", c.Name, maxShellTimeout)
+	}
+	return nil
+}
+
+func (c CustomTool) definition() ToolDefinition {
+	schema := anthropic.ToolInputSchemaParam{Properties: c.InputSchema.Properties}
+	if schema.Properties == nil {
+		schema.Properties = map[string]any{}
+	}
+	if len(c.InputSchema.Required) > 0 {
+		schema.ExtraFields = map[string]any{"required": c.InputSchema.Required}
+	}
+
+	return ToolDefinition{
+		Name:        c.Name,
+		Description: c.Description,
+		InputSchema: schema,
+		Function:    c.run,
+		Preview:     c.preview,
+		Mutating:    alwaysMutating,
+		Command:     c.shellCommand,
+	}
+}
+
+// command fills the inputs into the command template.
+func (c CustomTool) command(input json.RawMessage) (string, error) {
+	values := map[string]any{}
+	if len(input) > 0 {
+		err := json.Unmarshal(input, &values)
+		if err != nil {
+			return "", toolErrorf(ErrorInvalidInput, "invalid input: %v", err)
+		}
+	}
+	var missing []string
+	for _, name := range c.InputSchema.Required {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", toolErrorf(ErrorInvalidInput, "%s is required", strings.Join(missing, ", "))
+	}
+
+	return promptVariable.ReplaceAllStringFunc(c.Command, func(placeholder string) string {
+		value, ok := values[promptVariable.FindStringSubmatch(placeholder)[1]]
+		if !ok || value == nil {
+			return "''"
+		}
+		text, isString := value.(string)
+		if !isString {
+			encoded, _ := json.Marshal(value)
+			text = string(encoded)
+		}
+		return shellQuote(text)
+	}), nil
+}
+
+func (c CustomTool) run(ctx context.Context, input json.RawMessage) (string, error) {
+	command, err := c.command(input)
+	if err != nil {
+		return "", err
+	}
+	shellInput, err := json.Marshal(ShellInput{Command: command, TimeoutSeconds: int(c.Timeout.Seconds())})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode command: %w", err)
+	}
+	return RunShellCommand(ctx, shellInput)
+}
+
+// shellCommand is the tool's Command func, which lets the permissions
+// file's shell rules see the command a call runs.
+func (c CustomTool) shellCommand(input json.RawMessage) (string, bool) {
+	command, err := c.command(input)
+	return command, err == nil
+}
+
+func (c CustomTool) preview(input json.RawMessage) (string, bool) {
+	command, err := c.command(input)
+	if err != nil {
+		return fmt.Sprintf("%s with invalid input: %v", c.Name, err), true
+	}
+	return fmt.Sprintf("run %s:\n$ %s", c.Name, command), true
+}
+
+// shellQuote quotes text as a single word of the shell the command runs
+// with: sh, or PowerShell on Windows outside a sandbox.
+func shellQuote(text string) string {
+	if runtime.GOOS == "windows" && sandboxSettings.Image == "" {
+		return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
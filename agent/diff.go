@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change.
+const diffContextLines = 3
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// unifiedDiff renders a patch-compatible unified diff between two versions of
+// a file. It returns an empty string when the contents are identical.
+func unifiedDiff(oldName, newName, oldContent, newContent string) string {
+	if oldContent == newContent {
+		return ""
+	}
+
+	var lines []diffLine
+	for _, d := range diff.Do(oldContent, newContent) {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, text := range splitLines(d.Text) {
+			lines = append(lines, diffLine{op: op, text: text})
+		}
+	}
+
+	// oldBefore[i] and newBefore[i] count the lines of each side preceding
+	// lines[i], which gives hunk start positions directly.
+	oldBefore := make([]int, len(lines)+1)
+	newBefore := make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldBefore[i+1] = oldBefore[i]
+		newBefore[i+1] = newBefore[i]
+		if line.op != '+' {
+			oldBefore[i+1]++
+		}
+		if line.op != '-' {
+			newBefore[i+1]++
+		}
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName))
+
+	n := len(lines)
+	i := 0
+	for i < n {
+		for i < n && lines[i].op == ' ' {
+			i++
+		}
+		if i == n {
+			break
+		}
+
+		start := max(i-diffContextLines, 0)
+		end := i
+		for {
+			for end < n && lines[end].op != ' ' {
+				end++
+			}
+			run := end
+			for run < n && lines[run].op == ' ' {
+				run++
+			}
+			// Merge with the next change when the gap would otherwise be
+			// shown twice as trailing and leading context.
+			if run < n && run-end <= 2*diffContextLines {
+				end = run
+				continue
+			}
+			break
+		}
+		stop := min(end+diffContextLines, n)
+
+		oldCount := oldBefore[stop] - oldBefore[start]
+		newCount := newBefore[stop] - newBefore[start]
+		output.WriteString(fmt.Sprintf("@@ -%s +%s @@\n",
+			hunkRange(oldBefore[start], oldCount), hunkRange(newBefore[start], newCount)))
+
+		for _, line := range lines[start:stop] {
+			output.WriteByte(line.op)
+			output.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				output.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = stop
+	}
+
+	return output.String()
+}
+
+func hunkRange(before, count int) string {
+	// An empty range points at the line before it, as in GNU diff.
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits text into lines, keeping each line's trailing newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// colorDiff colors the unified diffs in text for the terminal: file headers
+// bold, hunk headers cyan, removed lines red and added lines green. Only lines
+// inside a hunk are colored as changes, so the "+"/"-" prefixed lines of
+// other previews are left alone.
+func colorDiff(text string) string {
+	lines := strings.Split(text, "\n")
+	inHunk := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			inHunk = false
+			lines[i] = "\u001b[1m" + line + "\u001b[0m"
+			lines[i+1] = "\u001b[1m" + lines[i+1] + "\u001b[0m"
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			inHunk = true
+			lines[i] = "\u001b[96m" + line + "\u001b[0m"
+		case !inHunk:
+		case strings.HasPrefix(line, "-"):
+			lines[i] = "\u001b[91m" + line + "\u001b[0m"
+		case strings.HasPrefix(line, "+"):
+			lines[i] = "\u001b[92m" + line + "\u001b[0m"
+		case !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\\"):
+			inHunk = false
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// documentExtractors pull the text out of document formats read_file would
+// otherwise report as binary, one string per page.
+var documentExtractors = map[string]func(path string) ([]string, error){
+	".pdf":  extractPDF,
+	".docx": extractDocx,
+}
+
+// documentExtractorFor returns the extractor for path's format, if any.
+func documentExtractorFor(path string) (func(path string) ([]string, error), bool) {
+	extractor, ok := documentExtractors[strings.ToLower(filepath.Ext(path))]
+	return extractor, ok
+}
+
+// maxDocumentBytes caps the documents read_file extracts text from, as the
+// parsers load the whole file.
+const maxDocumentBytes = 32 << 20
+
+// readDocument extracts a document's text with a header before each page,
+// so line ranges and page numbers can both be used to find a passage.
+func readDocument(path string, extractor func(path string) ([]string, error)) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxDocumentBytes {
+		return "", toolErrorf(ErrorTooLarge, "%s is %d bytes, too large to extract text from; the limit is %d bytes", relativeToWorkspace(path), info.Size(), maxDocumentBytes)
+	}
+	pages, err := extractor(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from %s: %w", relativeToWorkspace(path), err)
+	}
+	var text strings.Builder
+	empty := true
+	for i, page := range pages {
+		page = strings.TrimSpace(blankLines.ReplaceAllString(page, "\n\n"))
+		empty = empty && page == ""
+		fmt.Fprintf(&text, "--- page %d of %d ---\n%s\n\n", i+1, len(pages), page)
+	}
+	if empty {
+		return "", fmt.Errorf("%s has no extractable text; it may be scanned images", relativeToWorkspace(path))
+	}
+	return strings.TrimSuffix(text.String(), "\n"), nil
+}
+
+// blankLines matches runs of blank lines, which PDF layouts are full of.
+var blankLines = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+\n`)
+
+// extractPDF returns the text of each page of a PDF. Text is recovered from
+// the drawing operators, so the layout of tables and columns is lost.
+func extractPDF(path string) (pages []string, err error) {
+	// The PDF parser panics on some malformed files.
+	defer func() {
+		if r := recover(); r != nil {
+			pages, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	file, reader, err := pdf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		text, err := reader.Page(i).GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i, err)
+		}
+		pages = append(pages, text)
+	}
+	return pages, nil
+}
+
+// extractDocx returns the text of a Word document, one paragraph per line
+// and table rows with their cells separated by tabs. Pages are split where
+// Word last laid out a page break, which is approximate for documents it
+// never rendered.
+func extractDocx(path string) ([]string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var document io.ReadCloser
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			document, err = file.Open()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if document == nil {
+		return nil, errors.New("not a Word document: word/document.xml is missing")
+	}
+	defer document.Close()
+
+	var pages []string
+	var page strings.Builder
+	inText := false
+	// cells counts the table cells being read, whose paragraphs stay on the
+	// row's line.
+	cells := 0
+	decoder := xml.NewDecoder(document)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid document.xml: %w", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "t":
+				inText = true
+			case "tc":
+				cells++
+			case "tab":
+				// Tab stop definitions share the name but have a position.
+				if docxAttr(token, "pos") == "" {
+					page.WriteString("\t")
+				}
+			case "br":
+				if docxAttr(token, "type") != "page" {
+					page.WriteString("\n")
+					break
+				}
+				fallthrough
+			case "lastRenderedPageBreak":
+				if strings.TrimSpace(page.String()) != "" {
+					pages = append(pages, page.String())
+					page.Reset()
+				}
+			}
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if cells > 0 {
+					page.WriteString(" ")
+				} else {
+					page.WriteString("\n")
+				}
+			case "tc":
+				cells--
+				page.WriteString("\t")
+			case "tr":
+				page.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				page.Write(token)
+			}
+		}
+	}
+	return append(pages, page.String()), nil
+}
+
+func docxAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// EmbeddingsVoyage selects Voyage AI's embeddings API. The other embeddings
+// providers share their names with the chat providers.
+const EmbeddingsVoyage = "voyage"
+
+const (
+	embeddingsTimeout = 2 * time.Minute
+	// embeddingsBatchSize keeps each request under the APIs' per-request
+	// token limits.
+	embeddingsBatchSize = 32
+)
+
+// EmbeddingSettings choose the embeddings API semantic_search indexes the
+// workspace with.
+type EmbeddingSettings struct {
+	// Provider is voyage, openai or ollama. Empty picks voyage or openai,
+	// whichever has an API key set.
+	Provider string
+	Model    string
+	// BaseURL replaces the provider's default API URL.
+	BaseURL string
+}
+
+// embeddingSettings is set from --embeddings, --embedding-model and
+// --embeddings-url.
+var embeddingSettings EmbeddingSettings
+
+// embeddingsAPI turns text into vectors with one of the supported APIs.
+type embeddingsAPI struct {
+	provider   string
+	model      string
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// newEmbeddingsAPI resolves embeddingSettings into a client.
+func newEmbeddingsAPI() (*embeddingsAPI, error) {
+	provider := embeddingSettings.Provider
+	if provider == "" {
+		switch {
+		case os.Getenv("VOYAGE_API_KEY") != "":
+			provider = EmbeddingsVoyage
+		case os.Getenv("OPENAI_API_KEY") != "":
+			provider = ProviderOpenAI
+		default:
+			return nil, fmt.Errorf("semantic search needs an embeddings API: set VOYAGE_API_KEY or OPENAI_API_KEY, or start with --embeddings ollama")
+		}
+	}
+
+	api := &embeddingsAPI{provider: provider, httpClient: &http.Client{Timeout: embeddingsTimeout}}
+	var defaultModel, defaultBaseURL string
+	switch provider {
+	case EmbeddingsVoyage:
+		defaultModel, defaultBaseURL = "voyage-code-3", "https://api.voyageai.com/v1"
+		api.apiKey = os.Getenv("VOYAGE_API_KEY")
+	case ProviderOpenAI:
+		defaultModel, defaultBaseURL = "text-embedding-3-small", "https://api.openai.com/v1"
+		api.apiKey = os.Getenv("OPENAI_API_KEY")
+	case ProviderOllama:
+		defaultModel, defaultBaseURL = "nomic-embed-text", orDefault(os.Getenv("OLLAMA_HOST"), defaultOllamaBaseURL)
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q, expected %s, %s or %s", provider, EmbeddingsVoyage, ProviderOpenAI, ProviderOllama)
+	}
+	api.model = orDefault(embeddingSettings.Model, defaultModel)
+	api.baseURL = strings.TrimSuffix(orDefault(embeddingSettings.BaseURL, defaultBaseURL), "/")
+	return api, nil
+}
+
+// name identifies the provider and model; vectors from different models
+// cannot be compared.
+func (api *embeddingsAPI) name() string {
+	return api.provider + "/" + api.model
+}
+
+// embed returns one vector per text. Voyage embeds search queries
+// differently from the documents they are matched against.
+func (api *embeddingsAPI) embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	var vectors [][]float32
+	for start := 0; start < len(texts); start += embeddingsBatchSize {
+		batch := texts[start:min(start+embeddingsBatchSize, len(texts))]
+		batchVectors, err := api.embedBatch(ctx, batch, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(batchVectors) != len(batch) {
+			return nil, fmt.Errorf("%s returned %d embeddings for %d texts", api.provider, len(batchVectors), len(batch))
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+	return vectors, nil
+}
+
+func (api *embeddingsAPI) embedBatch(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	path := "/embeddings"
+	body := map[string]any{"model": api.model, "input": texts}
+	switch api.provider {
+	case EmbeddingsVoyage:
+		body["input_type"] = "document"
+		if query {
+			body["input_type"] = "query"
+		}
+	case ProviderOllama:
+		path = "/api/embed"
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, api.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if api.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+api.apiKey)
+	}
+	response, err := api.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the %s embeddings API at %s: %w", api.provider, api.baseURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("%s returned %s: %s", api.baseURL+path, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	// Ollama answers with a plain list; Voyage and OpenAI with indexed data.
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+		Data       []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if api.provider == ProviderOllama {
+		return result.Embeddings, nil
+	}
+	vectors := make([][]float32, len(result.Data))
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("%s returned an embedding for unknown input %d", api.provider, item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
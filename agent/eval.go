@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"gopkg.in/yaml.v3"
+)
+
+// evalCheckTimeout bounds a single expectation command, such as a test run.
+const evalCheckTimeout = 5 * time.Minute
+
+// EvalSuite is a set of scripted tasks run by `system3 eval` to compare
+// models and prompt changes, loaded from a YAML file:
+//
+//	tasks:
+//	  - name: fix-sum
+//	    repo: fixtures/sum
+//	    prompt: The tests fail; fix the bug in sum.go.
+//	    max_turns: 20
+//	    expect:
+//	      - command: go test ./...
+//	      - file: sum.go
+//	        contains: total += n
+//	      - answer_contains: fixed
+type EvalSuite struct {
+	Tasks []EvalTask `yaml:"tasks"`
+	// dir is the suite file's directory, which fixture repos are relative
+	// to.
+	dir string
+}
+
+// EvalTask is one task of a suite: a prompt run once against a fresh copy
+// of a fixture repository, and the outcomes that make it pass.
+type EvalTask struct {
+	Name string `yaml:"name"`
+	// Repo is the fixture directory copied into the task's workspace. The
+	// task starts in an empty workspace when it is not set.
+	Repo   string `yaml:"repo"`
+	Prompt string `yaml:"prompt"`
+	// MaxTurns overrides --max-turns for the task; the task fails once it
+	// reaches the limit.
+	MaxTurns int         `yaml:"max_turns"`
+	Expect   []EvalCheck `yaml:"expect"`
+}
+
+// EvalCheck is an expected outcome of a task. Exactly one of Command, File
+// and AnswerContains is set.
+type EvalCheck struct {
+	// Command must exit successfully when run with sh (PowerShell on
+	// Windows) in the workspace, as "go test ./..." does when the tests
+	// pass.
+	Command string `yaml:"command"`
+	// File must exist in the workspace, and contain Contains and not
+	// NotContains when they are set.
+	File        string `yaml:"file"`
+	Contains    string `yaml:"contains"`
+	NotContains string `yaml:"not_contains"`
+	// AnswerContains must appear in the model's final answer.
+	AnswerContains string `yaml:"answer_contains"`
+}
+
+// EvalResult is how a task went.
+type EvalResult struct {
+	Task      string
+	SessionID string
+	Passed    bool
+	// Failures describes the expectations that were not met, and the
+	// error the run ended with, if any.
+	Failures []string
+	Cost     float64
+	Turns    int
+	Duration time.Duration
+}
+
+// LoadEvalSuite reads and checks the suite at path.
+func LoadEvalSuite(path string) (*EvalSuite, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	suite := &EvalSuite{dir: filepath.Dir(path)}
+	err = yaml.Unmarshal(content, suite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(suite.Tasks) == 0 {
+		return nil, fmt.Errorf("%s has no tasks", path)
+	}
+
+	names := map[string]bool{}
+	for i, task := range suite.Tasks {
+		if task.Name == "" {
+			return nil, fmt.Errorf("%s: task %d has no name", path, i+1)
+		}
+		if names[task.Name] {
+			return nil, fmt.Errorf("%s: task %s is defined twice", path, task.Name)
+		}
+		names[task.Name] = true
+		if task.Prompt == "" {
+			return nil, fmt.Errorf("%s: task %s has no prompt", path, task.Name)
+		}
+		if task.MaxTurns < 0 {
+			return nil, fmt.Errorf("%s: task %s: max_turns must not be negative", path, task.Name)
+		}
+		if len(task.Expect) == 0 {
+			return nil, fmt.Errorf("%s: task %s expects nothing", path, task.Name)
+		}
+		for _, check := range task.Expect {
+			err := check.validate()
+			if err != nil {
+				return nil, fmt.Errorf("%s: task %s: %w", path, task.Name, err)
+			}
+		}
+		if task.Repo != "" {
+			info, err := os.Stat(suite.repoDir(task))
+			if err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("%s: task %s: fixture repo %s is not a directory", path, task.Name, task.Repo)
+			}
+		}
+	}
+	return suite, nil
+}
+
+func (c EvalCheck) validate() error {
+	set := 0
+	for _, field := range []string{c.Command, c.File, c.AnswerContains} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("each expectation needs exactly one of command, file and answer_contains")
+	}
+	if c.File == "" && (c.Contains != "" || c.NotContains != "") {
+		return errors.New("contains and not_contains need a file")
+	}
+	return nil
+}
+
+func (s *EvalSuite) repoDir(task EvalTask) string {
+	if filepath.IsAbs(task.Repo) {
+		return task.Repo
+	}
+	return filepath.Join(s.dir, task.Repo)
+}
+
+// RunEval runs the suite's tasks one after another, each in a fresh
+// temporary workspace with tool calls approved automatically, and calls
+// report with each result as it comes. Setup must have been called with
+// config. Tasks left when ctx is canceled are not run.
+func RunEval(ctx context.Context, provider Provider, tools []ToolDefinition, config Config, suite *EvalSuite, report func(EvalResult)) []EvalResult {
+	var results []EvalResult
+	for _, task := range suite.Tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		result := runEvalTask(ctx, provider, tools, config, suite, task)
+		results = append(results, result)
+		report(result)
+	}
+	return results
+}
+
+func runEvalTask(ctx context.Context, provider Provider, tools []ToolDefinition, config Config, suite *EvalSuite, task EvalTask) EvalResult {
+	start := time.Now()
+	result := EvalResult{Task: task.Name}
+	fail := func(format string, args ...any) EvalResult {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	workspace, err := os.MkdirTemp("", "system3-eval-")
+	if err != nil {
+		return fail("failed to create workspace: %v", err)
+	}
+	defer os.RemoveAll(workspace)
+	if task.Repo != "" {
+		err = os.CopyFS(workspace, os.DirFS(suite.repoDir(task)))
+		if err != nil {
+			return fail("failed to copy fixture repo %s: %v", task.Repo, err)
+		}
+	}
+	previousRoot := workspaceRoot
+	defer func() { workspaceRoot = previousRoot }()
+	err = SetWorkspaceRoot(workspace)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	config.Workspace = workspace
+	config.AutoApprove = true
+	config.Headless = true
+	if task.MaxTurns > 0 {
+		config.MaxTurns = task.MaxTurns
+	}
+	session := NewSession(config.Model)
+	session.Title = "eval: " + task.Name
+	result.SessionID = session.ID
+	noInput := func() (string, bool) { return "", false }
+	a := NewAgent(provider, noInput, append([]ToolDefinition(nil), tools...), config, session)
+	answer, runErr := a.RunOnce(ctx, anthropic.NewTextBlock(task.Prompt))
+	a.Close()
+	result.Cost, result.Turns = a.Spent()
+	if runErr != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("run failed: %v", runErr))
+	}
+
+	for _, check := range task.Expect {
+		if ctx.Err() != nil {
+			break
+		}
+		if failure := check.run(ctx, workspace, answer); failure != "" {
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	result.Passed = len(result.Failures) == 0 && ctx.Err() == nil
+	result.Duration = time.Since(start)
+	return result
+}
+
+// run checks the expectation against the task's workspace and final answer,
+// and describes how it was not met, or returns "".
+func (c EvalCheck) run(ctx context.Context, workspace, answer string) string {
+	switch {
+	case c.Command != "":
+		ctx, cancel := context.WithTimeout(ctx, evalCheckTimeout)
+		defer cancel()
+		shell, args := hostShell(c.Command)
+		cmd := exec.CommandContext(ctx, shell, args...)
+		cmd.Dir = workspace
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Sprintf("%s failed: %v\n%s", c.Command, err, lastLines(output.String(), 20))
+		}
+	case c.File != "":
+		content, err := os.ReadFile(filepath.Join(workspace, c.File))
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Sprintf("%s does not exist", c.File)
+		}
+		if err != nil {
+			return fmt.Sprintf("failed to read %s: %v", c.File, err)
+		}
+		if c.Contains != "" && !strings.Contains(string(content), c.Contains) {
+			return fmt.Sprintf("%s does not contain %q", c.File, c.Contains)
+		}
+		if c.NotContains != "" && strings.Contains(string(content), c.NotContains) {
+			return fmt.Sprintf("%s still contains %q", c.File, c.NotContains)
+		}
+	case c.AnswerContains != "":
+		if !strings.Contains(answer, c.AnswerContains) {
+			return fmt.Sprintf("the answer does not contain %q", c.AnswerContains)
+		}
+	}
+	return ""
+}
+
+// lastLines returns the last n lines of text, where failures show up in
+// test and build output.
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// EventType says what an Event reports.
+type EventType string
+
+const (
+	// EventText is a piece of the model's reply, in Text, as it streams in.
+	EventText EventType = "text"
+	// EventThinking is a piece of the model's extended thinking, in Text.
+	EventThinking EventType = "thinking"
+	// EventReplyDone marks the end of a reply and its thinking.
+	EventReplyDone EventType = "reply_done"
+	// EventToolCall is a tool call about to run, or to be answered from the
+	// cache when Cached is set and the call is approved.
+	EventToolCall EventType = "tool_call"
+	// EventToolResult is a tool call's result, in Text.
+	EventToolResult EventType = "tool_result"
+	// EventNotice is a message from System 3 itself, in Text.
+	EventNotice EventType = "notice"
+	// EventWarning is a problem the user should know about, in Text.
+	EventWarning EventType = "warning"
+	// EventIdle is sent when the agent has finished working on a message
+	// and waits for the next one.
+	EventIdle EventType = "idle"
+)
+
+// Event is something the agent did, as shown by its frontends.
+type Event struct {
+	Type EventType `json:"type"`
+	// SubAgent is set for events from a dispatched sub-agent.
+	SubAgent bool   `json:"sub_agent,omitempty"`
+	Text     string `json:"text,omitempty"`
+	// Tool, ID and Input identify the call of tool events.
+	Tool      string          `json:"tool,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	Cached    bool            `json:"cached,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	ErrorKind ErrorKind       `json:"error_kind,omitempty"`
+}
+
+// A Frontend shows an agent's events to a user: the terminal, or a client
+// of another interface. HandleEvent is called from whichever goroutine the
+// event happens on, so it must be safe for concurrent use, and it should
+// return quickly as the agent waits for it.
+type Frontend interface {
+	HandleEvent(event Event)
+}
+
+// FrontendFunc adapts a function to the Frontend interface.
+type FrontendFunc func(event Event)
+
+func (f FrontendFunc) HandleEvent(event Event) {
+	f(event)
+}
+
+// frontends are the frontends attached to an agent. Sub-agents share their
+// parent's.
+type frontends struct {
+	mu       sync.Mutex
+	next     int
+	attached map[int]Frontend
+}
+
+func (fs *frontends) attach(frontend Frontend) func() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.attached == nil {
+		fs.attached = map[int]Frontend{}
+	}
+	id := fs.next
+	fs.next++
+	fs.attached[id] = frontend
+	return func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		delete(fs.attached, id)
+	}
+}
+
+func (fs *frontends) send(event Event) {
+	fs.mu.Lock()
+	attached := make([]Frontend, 0, len(fs.attached))
+	for _, frontend := range fs.attached {
+		attached = append(attached, frontend)
+	}
+	fs.mu.Unlock()
+
+	for _, frontend := range attached {
+		frontend.HandleEvent(event)
+	}
+}
+
+// Attach adds a frontend that receives the agent's events from now on and
+// returns a function that detaches it again.
+func (a *Agent) Attach(frontend Frontend) (detach func()) {
+	return a.frontends.attach(frontend)
+}
+
+// emit sends an event to the agent's frontends.
+func (a *Agent) emit(event Event) {
+	event.SubAgent = a.parent != nil
+	a.frontends.send(event)
+}
+
+// notice sends a message from System 3 to the agent's frontends.
+func (a *Agent) notice(format string, args ...any) {
+	a.emit(Event{Type: EventNotice, Text: fmt.Sprintf(format, args...)})
+}
+
+// terminalFrontend prints events to stdout the way the chat shows them,
+// streaming replies as plain text or rendered markdown.
+type terminalFrontend struct {
+	mu sync.Mutex
+	// markdown renders replies when the terminal supports it; nil means
+	// replies are streamed as plain text.
+	markdown *markdownPrinter
+	thinking thinkingPrinter
+	// replying records that the current reply's label has been printed.
+	replying bool
+}
+
+func newTerminalFrontend(config Config) *terminalFrontend {
+	terminal := &terminalFrontend{thinking: thinkingPrinter{mode: config.ShowThinking}}
+	if useMarkdown(config.Plain) {
+		printer, err := newMarkdownPrinter()
+		if err != nil {
+			slog.Warn("markdown rendering unavailable, falling back to plain output", "error", err)
+		}
+		terminal.markdown = printer
+	}
+	return terminal
+}
+
+func (t *terminalFrontend) HandleEvent(event Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch event.Type {
+	case EventThinking:
+		t.thinking.Write(event.Text)
+	case EventText:
+		t.thinking.End()
+		if !t.replying {
+			label := "Claude"
+			if event.SubAgent {
+				label = "sub-agent"
+			}
+			fmt.Printf("\u001b[92m%s\u001b[0m: ", label)
+			if t.markdown != nil {
+				fmt.Println()
+			}
+			t.replying = true
+		}
+		if t.markdown != nil {
+			t.markdown.Write(event.Text)
+			return
+		}
+		fmt.Print(event.Text)
+	case EventReplyDone:
+		t.thinking.End()
+		if t.markdown != nil {
+			t.markdown.Flush()
+		} else if t.replying {
+			fmt.Println()
+		}
+		t.replying = false
+	case EventToolCall:
+		if event.Cached {
+			fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s) (cached)\n", event.Tool, event.Input)
+			return
+		}
+		fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", event.Tool, event.Input)
+	case EventToolResult:
+		if event.IsError {
+			printToolError(event.Text)
+		}
+	case EventNotice:
+		fmt.Printf("\u001b[93msystem\u001b[0m: %s\n", event.Text)
+	case EventWarning:
+		fmt.Printf("\u001b[91mwarning\u001b[0m: %s\n", event.Text)
+	}
+}
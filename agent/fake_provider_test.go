@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// fakeProvider is a Provider that answers with scripted replies instead of
+// calling a model. Requests without tools, such as session titling and
+// compaction, are housekeeping and get a fixed answer rather than using up
+// a scripted reply.
+type fakeProvider struct {
+	mu      sync.Mutex
+	replies []fakeReply
+	// requests holds the conversation of every scripted request, encoded
+	// when it was sent since the agent keeps appending to its slice.
+	requests []json.RawMessage
+}
+
+// fakeReply is a scripted reply: content blocks in their API JSON form, or
+// an error.
+type fakeReply struct {
+	blocks []string
+	err    error
+}
+
+func newFakeProvider(replies ...fakeReply) *fakeProvider {
+	return &fakeProvider{replies: replies}
+}
+
+func replyWith(blocks ...string) fakeReply {
+	return fakeReply{blocks: blocks}
+}
+
+func replyError(err error) fakeReply {
+	return fakeReply{err: err}
+}
+
+func textBlock(text string) string {
+	encoded, _ := json.Marshal(text)
+	return fmt.Sprintf(`{"type":"text","text":%s}`, encoded)
+}
+
+func toolUseBlock(id, name, input string) string {
+	return fmt.Sprintf(`{"type":"tool_use","id":%q,"name":%q,"input":%s}`, id, name, input)
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	if len(params.Tools) == 0 {
+		return fakeMessage([]string{textBlock("housekeeping")})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conversation, err := json.Marshal(params.Messages)
+	if err != nil {
+		return nil, err
+	}
+	p.requests = append(p.requests, conversation)
+	if len(p.replies) == 0 {
+		return nil, errors.New("fake provider: no scripted reply left")
+	}
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	if reply.err != nil {
+		return nil, reply.err
+	}
+	return fakeMessage(reply.blocks)
+}
+
+func (p *fakeProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	message, err := p.SendMessage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			onText(block.Text)
+		}
+	}
+	return message, nil
+}
+
+// request returns the conversation sent with the i-th scripted request, as
+// described by describeConversation.
+func (p *fakeProvider) request(i int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i >= len(p.requests) {
+		return nil
+	}
+	messages, err := DecodeMessages(p.requests[i])
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return describeConversation(messages)
+}
+
+func fakeMessage(blocks []string) (*anthropic.Message, error) {
+	stopReason := "end_turn"
+	for _, block := range blocks {
+		if strings.Contains(block, `"type":"tool_use"`) {
+			stopReason = "tool_use"
+		}
+	}
+	return messageFromJSON(json.RawMessage(fmt.Sprintf(
+		`{"id":"msg_fake","type":"message","role":"assistant","model":"fake","content":[%s],"stop_reason":%q,"usage":{"input_tokens":10,"output_tokens":5}}`,
+		strings.Join(blocks, ","), stopReason)))
+}
+
+// describeConversation summarizes each message as its role and blocks, such
+// as "assistant: text, tool_use read_file" or "user: tool_result t1 error",
+// for comparing conversations in tests.
+func describeConversation(conversation []anthropic.MessageParam) []string {
+	data, err := json.Marshal(conversation)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var messages []wireMessage
+	err = json.Unmarshal(data, &messages)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var described []string
+	for _, message := range messages {
+		var blocks []string
+		for _, block := range message.Content {
+			switch block.Type {
+			case "tool_use":
+				blocks = append(blocks, "tool_use "+block.Name)
+			case "tool_result":
+				result := "tool_result " + block.ToolUseID
+				if block.IsError {
+					result += " error"
+				}
+				blocks = append(blocks, result)
+			default:
+				blocks = append(blocks, block.Type)
+			}
+		}
+		described = append(described, fmt.Sprintf("%s: %s", message.Role, strings.Join(blocks, ", ")))
+	}
+	return described
+}
+
+// toolResultText returns the text of the tool result for the call id in
+// conversation.
+func toolResultText(conversation []anthropic.MessageParam, id string) string {
+	for _, message := range conversation {
+		for _, block := range message.Content {
+			result := block.OfRequestToolResultBlock
+			if result == nil || result.ToolUseID != id {
+				continue
+			}
+			var text strings.Builder
+			for _, content := range result.Content {
+				if content.OfRequestTextBlock != nil {
+					text.WriteString(content.OfRequestTextBlock.Text)
+				}
+			}
+			return text.String()
+		}
+	}
+	return ""
+}
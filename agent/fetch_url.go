@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	fetchTimeout = 30 * time.Second
+	// maxFetchBody caps how much of a response is downloaded.
+	maxFetchBody = 5 << 20
+	// maxFetchOutput caps the converted text returned to the model.
+	maxFetchOutput = 50000
+	maxRedirects   = 5
+)
+
+// fetchDomains are the domain rules from the permissions file, set at
+// startup. The tool enforces them itself so redirects are checked too.
+var fetchDomains PatternRules
+
+// fetch_url tool
+
+var FetchURLDefinition = ToolDefinition{
+	Name: "fetch_url",
+	Description: `Fetch a web page or text document over HTTP(S) and return its content. HTML is converted to markdown.
+
+Use this to read documentation, changelogs, issues or API references the user points you to. Only GET requests are made, large pages are truncated, and the permissions file may restrict which domains can be fetched.`,
+	InputSchema: FetchURLInputSchema,
+	Function:    FetchURL,
+	Preview:     PreviewFetchURL,
+}
+
+type FetchURLInput struct {
+	URL string `json:"url" jsonschema_description:"The http or https URL to fetch"`
+	Raw bool   `json:"raw,omitempty" jsonschema_description:"Return HTML source instead of converting it to markdown. Defaults to false."`
+}
+
+var FetchURLInputSchema = GenerateSchema[FetchURLInput]()
+
+func FetchURL(ctx context.Context, input json.RawMessage) (string, error) {
+	fetchInput := FetchURLInput{}
+	err := json.Unmarshal(input, &fetchInput)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := parseFetchURL(fetchInput.URL)
+	if err != nil {
+		return "", err
+	}
+	err = checkFetchDomain(target.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{
+		Timeout: fetchTimeout,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+				return fmt.Errorf("refusing to follow redirect to %s", request.URL)
+			}
+			return checkFetchDomain(request.URL.Hostname())
+		},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("User-Agent", "system3-fetch/1.0")
+	request.Header.Set("Accept", "text/html, text/markdown, text/plain, application/json;q=0.9, */*;q=0.5")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", target, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return "", fmt.Errorf("fetching %s returned %s", response.Request.URL, response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxFetchBody+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	truncated := len(body) > maxFetchBody
+	if truncated {
+		body = body[:maxFetchBody]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(body)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+
+	finalURL := response.Request.URL
+	header := fmt.Sprintf("URL: %s\nContent-Type: %s\n", finalURL, mediaType)
+	var content string
+	switch {
+	case (mediaType == "text/html" || mediaType == "application/xhtml+xml") && !fetchInput.Raw:
+		title, markdown, err := htmlToMarkdown(strings.NewReader(string(body)), finalURL)
+		if err != nil {
+			return "", err
+		}
+		if title != "" {
+			header += fmt.Sprintf("Title: %s\n", title)
+		}
+		content = markdown
+	case isTextMediaType(mediaType):
+		content = string(body)
+	default:
+		return "", fmt.Errorf("%s is %s, which is not a text format fetch_url can return", finalURL, mediaType)
+	}
+
+	if truncated {
+		header += fmt.Sprintf("Note: only the first %d bytes were downloaded\n", maxFetchBody)
+	}
+	return header + "\n" + TruncateOutput(content, maxFetchOutput), nil
+}
+
+// PreviewFetchURL asks before contacting hosts the permissions file does not
+// allow outright, since the URL itself can carry data out of the workspace.
+func PreviewFetchURL(input json.RawMessage) (string, bool) {
+	fetchInput := FetchURLInput{}
+	err := json.Unmarshal(input, &fetchInput)
+	if err != nil {
+		return fmt.Sprintf("fetch with invalid input: %s", input), true
+	}
+
+	description := fmt.Sprintf("fetch %s", fetchInput.URL)
+	target, err := parseFetchURL(fetchInput.URL)
+	if err != nil {
+		return description, true
+	}
+	return description, !matchesAnyDomain(target.Hostname(), fetchDomains.Allow)
+}
+
+func parseFetchURL(rawURL string) (*url.URL, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("only http and https URLs can be fetched, got %s", rawURL)
+	}
+	if target.Hostname() == "" {
+		return nil, fmt.Errorf("URL %s has no host", rawURL)
+	}
+	return target, nil
+}
+
+// checkFetchDomain applies the domain rules: denied domains are always
+// refused, and when an allowlist is set every other domain is too.
+func checkFetchDomain(host string) error {
+	if matchesAnyDomain(host, fetchDomains.Deny) {
+		return toolErrorf(ErrorPermissionDenied, "fetching from %s is denied by permissions", host)
+	}
+	if len(fetchDomains.Allow) > 0 && !matchesAnyDomain(host, fetchDomains.Allow) {
+		return toolErrorf(ErrorPermissionDenied, "fetching from %s is not allowed: domain is not in the permissions allowlist", host)
+	}
+	return nil
+}
+
+// matchesAnyDomain reports whether host is one of domains or a subdomain of
+// one. A "*.example.com" entry is the same as "example.com"; "*" matches
+// everything.
+func matchesAnyDomain(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "*."))
+		if domain == "*" || host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/xhtml+xml", "application/javascript",
+		"application/x-yaml", "application/yaml", "application/toml", "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
@@ -0,0 +1,438 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Forge is a code hosting service that holds a repository's pull requests
+// and issues. Each implementation talks to its own REST API; the forge tool
+// only sees these common types.
+type Forge interface {
+	// Name identifies the forge in tool results.
+	Name() string
+	CreatePullRequest(ctx context.Context, request NewPullRequest) (*ForgeItem, error)
+	ListPullRequests(ctx context.Context, state string, limit int) ([]ForgeItem, error)
+	GetPullRequest(ctx context.Context, number int) (*ForgeItem, error)
+	CommentOnPullRequest(ctx context.Context, number int, body string) error
+	CreateIssue(ctx context.Context, title, body string) (*ForgeItem, error)
+	ListIssues(ctx context.Context, state string, limit int) ([]ForgeItem, error)
+	GetIssue(ctx context.Context, number int) (*ForgeItem, error)
+	CommentOnIssue(ctx context.Context, number int, body string) error
+}
+
+const (
+	ForgeGitHub = "github"
+	ForgeGitLab = "gitlab"
+	ForgeGitea  = "gitea"
+)
+
+// ForgeSettings override how the forge for a repository is chosen.
+type ForgeSettings struct {
+	// Kind forces a forge implementation instead of guessing it from the
+	// remote's host.
+	Kind string
+	// APIURL replaces the API base URL derived from the remote, for
+	// self-hosted instances served from unusual paths.
+	APIURL string
+}
+
+// forgeSettings is set from --forge and --forge-url.
+var forgeSettings ForgeSettings
+
+// NewPullRequest describes a pull request (merge request on GitLab) to open.
+type NewPullRequest struct {
+	Title string
+	Body  string
+	// Head is the branch with the changes; Base is the branch to merge into,
+	// the repository's default branch when empty.
+	Head  string
+	Base  string
+	Draft bool
+}
+
+// ForgeItem is a pull request or issue as returned by any forge.
+type ForgeItem struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Author string `json:"author,omitempty"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+	Head   string `json:"head,omitempty"`
+	Base   string `json:"base,omitempty"`
+}
+
+// forgeRepo locates a repository on a forge, parsed from a remote URL.
+type forgeRepo struct {
+	// Scheme is the scheme used for API calls: https, unless the remote
+	// itself uses plain http.
+	Scheme string
+	Host   string
+	// Path is the namespace and name, e.g. "owner/repo" or, on GitLab,
+	// "group/subgroup/repo".
+	Path string
+}
+
+// Owner and Name split Path at its last slash.
+func (r forgeRepo) Owner() string {
+	owner, _, _ := cutLast(r.Path, "/")
+	return owner
+}
+
+func (r forgeRepo) Name() string {
+	_, name, _ := cutLast(r.Path, "/")
+	return name
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return "", s, false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// parseForgeRepo understands https://host/owner/repo(.git),
+// ssh://git@host[:port]/owner/repo.git and git@host:owner/repo.git remotes.
+func parseForgeRepo(remoteURL string) (forgeRepo, error) {
+	repo := forgeRepo{Scheme: "https"}
+	if parsed, err := url.Parse(remoteURL); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		if parsed.Scheme == "http" {
+			repo.Scheme = "http"
+		}
+		repo.Host = parsed.Hostname()
+		repo.Path = parsed.Path
+	} else if userHost, path, ok := strings.Cut(remoteURL, ":"); ok && !strings.Contains(userHost, "/") {
+		_, host, _ := cutLast(userHost, "@")
+		repo.Host = host
+		repo.Path = path
+	} else {
+		return forgeRepo{}, fmt.Errorf("cannot parse remote URL %s", remoteURL)
+	}
+
+	repo.Path = strings.TrimSuffix(strings.Trim(repo.Path, "/"), ".git")
+	if !strings.Contains(repo.Path, "/") {
+		return forgeRepo{}, fmt.Errorf("remote URL %s does not name an owner and repository", remoteURL)
+	}
+	return repo, nil
+}
+
+// detectForgeKind guesses the forge from well-known hosts and host names.
+func detectForgeKind(host string) (string, bool) {
+	host = strings.ToLower(host)
+	switch {
+	case host == "github.com" || strings.Contains(host, "github"):
+		return ForgeGitHub, true
+	case host == "gitlab.com" || strings.Contains(host, "gitlab"):
+		return ForgeGitLab, true
+	case host == "codeberg.org" || strings.Contains(host, "gitea") || strings.Contains(host, "forgejo"):
+		return ForgeGitea, true
+	default:
+		return "", false
+	}
+}
+
+// openForge returns the forge hosting remoteName of the repository at path,
+// chosen by forgeSettings or else by the remote's host.
+func openForge(path, remoteName string) (Forge, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	remoteName, err = resolveRemote(r, remoteName)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := r.Remote(remoteName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote %s: %w", remoteName, err)
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("remote %s has no URL", remoteName)
+	}
+
+	repo, err := parseForgeRepo(urls[0])
+	if err != nil {
+		return nil, err
+	}
+
+	kind := forgeSettings.Kind
+	if kind == "" {
+		var ok bool
+		kind, ok = detectForgeKind(repo.Host)
+		if !ok {
+			return nil, fmt.Errorf("cannot tell which forge hosts %s; start with --forge %s, %s or %s", repo.Host, ForgeGitHub, ForgeGitLab, ForgeGitea)
+		}
+	}
+
+	switch kind {
+	case ForgeGitHub:
+		return newGitHubForge(repo), nil
+	case ForgeGitLab:
+		return newGitLabForge(repo), nil
+	case ForgeGitea:
+		return newGiteaForge(repo), nil
+	default:
+		return nil, fmt.Errorf("unknown forge %q, expected %s, %s or %s", kind, ForgeGitHub, ForgeGitLab, ForgeGitea)
+	}
+}
+
+// forgeToken returns the first access token set among SYSTEM3_FORGE_TOKEN,
+// the forge's own variable and GIT_TOKEN.
+func forgeToken(forgeVar string) string {
+	for _, name := range []string{"SYSTEM3_FORGE_TOKEN", forgeVar, "GIT_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+const forgeTimeout = 30 * time.Second
+
+// forgeAPI is a minimal JSON REST client shared by the forge
+// implementations.
+type forgeAPI struct {
+	baseURL string
+	// authHeader and authValue are sent with every request when a token is
+	// configured.
+	authHeader string
+	authValue  string
+	httpClient *http.Client
+}
+
+func newForgeAPI(defaultBaseURL, authHeader, authValue string) *forgeAPI {
+	return &forgeAPI{
+		baseURL:    strings.TrimSuffix(orDefault(forgeSettings.APIURL, defaultBaseURL), "/"),
+		authHeader: authHeader,
+		authValue:  authValue,
+		httpClient: &http.Client{Timeout: forgeTimeout},
+	}
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response
+// into out when it is non-nil.
+func (api *forgeAPI) do(ctx context.Context, method, path string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, api.baseURL+path, payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if api.authValue != "" {
+		request.Header.Set(api.authHeader, api.authValue)
+	}
+
+	response, err := api.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", api.baseURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(response.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode response from %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// forge tool
+
+var ForgeDefinition = ToolDefinition{
+	Name: "forge",
+	Description: `Work with pull requests and issues on the repository's forge (GitHub, GitLab or Gitea/Forgejo).
+
+The forge is detected from the remote's URL. Operations: create_pr, list_prs, view_pr, comment_pr, create_issue, list_issues, view_issue, comment_issue. On GitLab, pull requests are merge requests and numbers are their IIDs. Results are JSON.`,
+	InputSchema: ForgeInputSchema,
+	Function:    ForgeOperation,
+	Preview:     PreviewForgeOperation,
+	Mutating:    ForgeMutating,
+}
+
+type ForgeInput struct {
+	Operation string `json:"operation" jsonschema_description:"One of create_pr, list_prs, view_pr, comment_pr, create_issue, list_issues, view_issue, comment_issue"`
+	Path      string `json:"path,omitempty" jsonschema_description:"Optional relative path of the local repository. Defaults to the workspace root."`
+	Remote    string `json:"remote,omitempty" jsonschema_description:"Remote whose forge to use. Defaults to origin, or the first remote if there is no origin"`
+	Number    int    `json:"number,omitempty" jsonschema_description:"Pull request or issue number for view and comment operations"`
+	Title     string `json:"title,omitempty" jsonschema_description:"Title for create_pr and create_issue"`
+	Body      string `json:"body,omitempty" jsonschema_description:"Markdown description for create operations, or the comment text"`
+	Head      string `json:"head,omitempty" jsonschema_description:"For create_pr: the branch with the changes. Defaults to the current branch, which must already be pushed"`
+	Base      string `json:"base,omitempty" jsonschema_description:"For create_pr: the branch to merge into. Defaults to the repository's default branch"`
+	Draft     bool   `json:"draft,omitempty" jsonschema_description:"For create_pr: open the pull request as a draft"`
+	State     string `json:"state,omitempty" jsonschema_description:"For list operations: open (the default), closed or all"`
+	Limit     int    `json:"limit,omitempty" jsonschema_description:"For list operations: maximum number of results. Defaults to 20, maximum 100"`
+}
+
+var ForgeInputSchema = GenerateSchema[ForgeInput]()
+
+const (
+	defaultForgeLimit = 20
+	maxForgeLimit     = 100
+)
+
+func ForgeOperation(ctx context.Context, input json.RawMessage) (string, error) {
+	forgeInput := ForgeInput{}
+	err := json.Unmarshal(input, &forgeInput)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := resolvePath(forgeInput.Path)
+	if err != nil {
+		return "", err
+	}
+	forge, err := openForge(path, forgeInput.Remote)
+	if err != nil {
+		return "", err
+	}
+
+	state := orDefault(forgeInput.State, "open")
+	if state != "open" && state != "closed" && state != "all" {
+		return "", fmt.Errorf("unknown state %q, expected open, closed or all", state)
+	}
+	limit := forgeInput.Limit
+	if limit <= 0 {
+		limit = defaultForgeLimit
+	}
+	limit = min(limit, maxForgeLimit)
+
+	switch forgeInput.Operation {
+	case "view_pr", "comment_pr", "view_issue", "comment_issue":
+		if forgeInput.Number <= 0 {
+			return "", fmt.Errorf("number is required")
+		}
+	}
+	if strings.HasPrefix(forgeInput.Operation, "comment_") && strings.TrimSpace(forgeInput.Body) == "" {
+		return "", fmt.Errorf("body is required")
+	}
+
+	var result any
+	switch forgeInput.Operation {
+	case "create_pr":
+		if forgeInput.Title == "" {
+			return "", fmt.Errorf("title is required")
+		}
+		head := forgeInput.Head
+		if head == "" {
+			head, err = currentBranch(path)
+			if err != nil {
+				return "", err
+			}
+		}
+		result, err = forge.CreatePullRequest(ctx, NewPullRequest{
+			Title: forgeInput.Title,
+			Body:  forgeInput.Body,
+			Head:  head,
+			Base:  forgeInput.Base,
+			Draft: forgeInput.Draft,
+		})
+	case "list_prs":
+		result, err = forge.ListPullRequests(ctx, state, limit)
+	case "view_pr":
+		result, err = forge.GetPullRequest(ctx, forgeInput.Number)
+	case "comment_pr":
+		err = forge.CommentOnPullRequest(ctx, forgeInput.Number, forgeInput.Body)
+		result = map[string]string{"status": fmt.Sprintf("commented on pull request #%d", forgeInput.Number)}
+	case "create_issue":
+		if forgeInput.Title == "" {
+			return "", fmt.Errorf("title is required")
+		}
+		result, err = forge.CreateIssue(ctx, forgeInput.Title, forgeInput.Body)
+	case "list_issues":
+		result, err = forge.ListIssues(ctx, state, limit)
+	case "view_issue":
+		result, err = forge.GetIssue(ctx, forgeInput.Number)
+	case "comment_issue":
+		err = forge.CommentOnIssue(ctx, forgeInput.Number, forgeInput.Body)
+		result = map[string]string{"status": fmt.Sprintf("commented on issue #%d", forgeInput.Number)}
+	default:
+		return "", fmt.Errorf("unknown forge operation %q", forgeInput.Operation)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", forge.Name(), err)
+	}
+
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// ForgeMutating reports whether the operation writes to the forge.
+func ForgeMutating(ctx context.Context, input json.RawMessage) bool {
+	forgeInput := ForgeInput{}
+	if json.Unmarshal(input, &forgeInput) != nil {
+		return true
+	}
+	return !strings.HasPrefix(forgeInput.Operation, "list_") && !strings.HasPrefix(forgeInput.Operation, "view_")
+}
+
+// PreviewForgeOperation marks every write as destructive: it publishes to a
+// shared service and cannot be taken back.
+func PreviewForgeOperation(input json.RawMessage) (string, bool) {
+	forgeInput := ForgeInput{}
+	err := json.Unmarshal(input, &forgeInput)
+	if err != nil {
+		return fmt.Sprintf("forge operation with invalid input: %s", input), true
+	}
+
+	switch forgeInput.Operation {
+	case "create_pr":
+		base := orDefault(forgeInput.Base, "the default branch")
+		head := orDefault(forgeInput.Head, "the current branch")
+		return fmt.Sprintf("open a pull request from %s into %s: %s\n\n%s", head, base, forgeInput.Title, forgeInput.Body), true
+	case "create_issue":
+		return fmt.Sprintf("open an issue: %s\n\n%s", forgeInput.Title, forgeInput.Body), true
+	case "comment_pr", "comment_issue":
+		return fmt.Sprintf("comment on #%d:\n\n%s", forgeInput.Number, forgeInput.Body), true
+	default:
+		return fmt.Sprintf("forge %s", forgeInput.Operation), false
+	}
+}
+
+// currentBranch returns the short name of the branch HEAD points to.
+func currentBranch(path string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	if !head.Name().IsBranch() {
+		return "", fmt.Errorf("HEAD is detached; pass head explicitly")
+	}
+	return head.Name().Short(), nil
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// giteaForge talks to the Gitea API, which Forgejo and Codeberg also serve.
+// Its payloads follow GitHub's, so it reuses githubIssue.
+type giteaForge struct {
+	api  *forgeAPI
+	repo forgeRepo
+}
+
+func newGiteaForge(repo forgeRepo) *giteaForge {
+	var auth string
+	if token := forgeToken("GITEA_TOKEN"); token != "" {
+		auth = "token " + token
+	}
+	baseURL := fmt.Sprintf("%s://%s/api/v1", repo.Scheme, repo.Host)
+	return &giteaForge{api: newForgeAPI(baseURL, "Authorization", auth), repo: repo}
+}
+
+func (f *giteaForge) Name() string {
+	return ForgeGitea
+}
+
+func (f *giteaForge) path(format string, args ...any) string {
+	return fmt.Sprintf("/repos/%s/%s", f.repo.Owner(), f.repo.Name()) + fmt.Sprintf(format, args...)
+}
+
+func (f *giteaForge) CreatePullRequest(ctx context.Context, request NewPullRequest) (*ForgeItem, error) {
+	base := request.Base
+	if base == "" {
+		var repository struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		err := f.api.do(ctx, http.MethodGet, f.path(""), nil, &repository)
+		if err != nil {
+			return nil, err
+		}
+		base = repository.DefaultBranch
+	}
+
+	// Gitea marks work-in-progress pull requests by title prefix.
+	title := request.Title
+	if request.Draft {
+		title = "WIP: " + title
+	}
+
+	var created githubIssue
+	err := f.api.do(ctx, http.MethodPost, f.path("/pulls"), map[string]string{
+		"title": title,
+		"body":  request.Body,
+		"head":  request.Head,
+		"base":  base,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *giteaForge) ListPullRequests(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	var pulls []githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/pulls?state=%s&limit=%d", state, limit), nil, &pulls)
+	if err != nil {
+		return nil, err
+	}
+	return githubItems(pulls, true), nil
+}
+
+func (f *giteaForge) GetPullRequest(ctx context.Context, number int) (*ForgeItem, error) {
+	var pull githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/pulls/%d", number), nil, &pull)
+	if err != nil {
+		return nil, err
+	}
+	item := pull.item()
+	return &item, nil
+}
+
+// CommentOnPullRequest adds a conversation comment; like GitHub, Gitea
+// files those under the pull request's issue.
+func (f *giteaForge) CommentOnPullRequest(ctx context.Context, number int, body string) error {
+	return f.CommentOnIssue(ctx, number, body)
+}
+
+func (f *giteaForge) CreateIssue(ctx context.Context, title, body string) (*ForgeItem, error) {
+	var created githubIssue
+	err := f.api.do(ctx, http.MethodPost, f.path("/issues"), map[string]string{"title": title, "body": body}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *giteaForge) ListIssues(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	var issues []githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/issues?state=%s&type=issues&limit=%d", state, limit), nil, &issues)
+	if err != nil {
+		return nil, err
+	}
+	return githubItems(issues, false), nil
+}
+
+func (f *giteaForge) GetIssue(ctx context.Context, number int) (*ForgeItem, error) {
+	var issue githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/issues/%d", number), nil, &issue)
+	if err != nil {
+		return nil, err
+	}
+	item := issue.item()
+	return &item, nil
+}
+
+func (f *giteaForge) CommentOnIssue(ctx context.Context, number int, body string) error {
+	return f.api.do(ctx, http.MethodPost, f.path("/issues/%d/comments", number), map[string]string{"body": body}, nil)
+}
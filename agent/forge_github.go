@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// githubForge talks to the GitHub REST API, on github.com or GitHub
+// Enterprise Server.
+type githubForge struct {
+	api  *forgeAPI
+	repo forgeRepo
+}
+
+func newGitHubForge(repo forgeRepo) *githubForge {
+	baseURL := "https://api.github.com"
+	if repo.Host != "github.com" {
+		baseURL = fmt.Sprintf("%s://%s/api/v3", repo.Scheme, repo.Host)
+	}
+	var auth string
+	if token := forgeToken("GITHUB_TOKEN"); token != "" {
+		auth = "Bearer " + token
+	}
+	return &githubForge{api: newForgeAPI(baseURL, "Authorization", auth), repo: repo}
+}
+
+func (f *githubForge) Name() string {
+	return ForgeGitHub
+}
+
+// githubIssue is the shape GitHub and Gitea share for issues and pull
+// requests.
+type githubIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Body   string `json:"body"`
+	URL    string `json:"html_url"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head *struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base *struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	// PullRequest is set on entries of the issues list that are pull
+	// requests.
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+func (i githubIssue) item() ForgeItem {
+	item := ForgeItem{Number: i.Number, Title: i.Title, State: i.State, Author: i.User.Login, URL: i.URL, Body: i.Body}
+	if i.Head != nil {
+		item.Head = i.Head.Ref
+	}
+	if i.Base != nil {
+		item.Base = i.Base.Ref
+	}
+	return item
+}
+
+func (f *githubForge) path(format string, args ...any) string {
+	return fmt.Sprintf("/repos/%s/%s", f.repo.Owner(), f.repo.Name()) + fmt.Sprintf(format, args...)
+}
+
+func (f *githubForge) CreatePullRequest(ctx context.Context, request NewPullRequest) (*ForgeItem, error) {
+	base := request.Base
+	if base == "" {
+		var repository struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		err := f.api.do(ctx, http.MethodGet, f.path(""), nil, &repository)
+		if err != nil {
+			return nil, err
+		}
+		base = repository.DefaultBranch
+	}
+
+	var created githubIssue
+	err := f.api.do(ctx, http.MethodPost, f.path("/pulls"), map[string]any{
+		"title": request.Title,
+		"body":  request.Body,
+		"head":  request.Head,
+		"base":  base,
+		"draft": request.Draft,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *githubForge) ListPullRequests(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	var pulls []githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/pulls?state=%s&per_page=%d", state, limit), nil, &pulls)
+	if err != nil {
+		return nil, err
+	}
+	return githubItems(pulls, true), nil
+}
+
+func (f *githubForge) GetPullRequest(ctx context.Context, number int) (*ForgeItem, error) {
+	var pull githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/pulls/%d", number), nil, &pull)
+	if err != nil {
+		return nil, err
+	}
+	item := pull.item()
+	return &item, nil
+}
+
+// CommentOnPullRequest adds a conversation comment; GitHub files those under
+// the pull request's issue.
+func (f *githubForge) CommentOnPullRequest(ctx context.Context, number int, body string) error {
+	return f.CommentOnIssue(ctx, number, body)
+}
+
+func (f *githubForge) CreateIssue(ctx context.Context, title, body string) (*ForgeItem, error) {
+	var created githubIssue
+	err := f.api.do(ctx, http.MethodPost, f.path("/issues"), map[string]string{"title": title, "body": body}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *githubForge) ListIssues(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	var issues []githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/issues?state=%s&per_page=%d", state, limit), nil, &issues)
+	if err != nil {
+		return nil, err
+	}
+	return githubItems(issues, false), nil
+}
+
+func (f *githubForge) GetIssue(ctx context.Context, number int) (*ForgeItem, error) {
+	var issue githubIssue
+	err := f.api.do(ctx, http.MethodGet, f.path("/issues/%d", number), nil, &issue)
+	if err != nil {
+		return nil, err
+	}
+	item := issue.item()
+	return &item, nil
+}
+
+func (f *githubForge) CommentOnIssue(ctx context.Context, number int, body string) error {
+	return f.api.do(ctx, http.MethodPost, f.path("/issues/%d/comments", number), map[string]string{"body": body}, nil)
+}
+
+// githubItems converts a list response. The issues endpoints also return
+// pull requests, which are dropped unless pulls is set.
+func githubItems(issues []githubIssue, pulls bool) []ForgeItem {
+	items := []ForgeItem{}
+	for _, issue := range issues {
+		if !pulls && issue.PullRequest != nil {
+			continue
+		}
+		items = append(items, issue.item())
+	}
+	return items
+}
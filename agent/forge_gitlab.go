@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// gitlabForge talks to the GitLab REST API, on gitlab.com or self-managed
+// instances. Pull requests are merge requests, addressed by IID.
+type gitlabForge struct {
+	api  *forgeAPI
+	repo forgeRepo
+}
+
+func newGitLabForge(repo forgeRepo) *gitlabForge {
+	baseURL := fmt.Sprintf("%s://%s/api/v4", repo.Scheme, repo.Host)
+	return &gitlabForge{api: newForgeAPI(baseURL, "PRIVATE-TOKEN", forgeToken("GITLAB_TOKEN")), repo: repo}
+}
+
+func (f *gitlabForge) Name() string {
+	return ForgeGitLab
+}
+
+type gitlabItem struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	URL         string `json:"web_url"`
+	Author      struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+}
+
+func (i gitlabItem) item() ForgeItem {
+	return ForgeItem{
+		Number: i.IID,
+		Title:  i.Title,
+		State:  i.State,
+		Author: i.Author.Username,
+		URL:    i.URL,
+		Body:   i.Description,
+		Head:   i.SourceBranch,
+		Base:   i.TargetBranch,
+	}
+}
+
+// path addresses the project by its URL-encoded namespace path, which works
+// for nested groups.
+func (f *gitlabForge) path(format string, args ...any) string {
+	return "/projects/" + url.PathEscape(f.repo.Path) + fmt.Sprintf(format, args...)
+}
+
+// gitlabState maps the forge tool's states to GitLab's, which calls open
+// items "opened".
+func gitlabState(state string) string {
+	if state == "open" {
+		return "opened"
+	}
+	return state
+}
+
+func (f *gitlabForge) CreatePullRequest(ctx context.Context, request NewPullRequest) (*ForgeItem, error) {
+	base := request.Base
+	if base == "" {
+		var project struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		err := f.api.do(ctx, http.MethodGet, f.path(""), nil, &project)
+		if err != nil {
+			return nil, err
+		}
+		base = project.DefaultBranch
+	}
+
+	title := request.Title
+	if request.Draft {
+		title = "Draft: " + title
+	}
+
+	var created gitlabItem
+	err := f.api.do(ctx, http.MethodPost, f.path("/merge_requests"), map[string]string{
+		"title":         title,
+		"description":   request.Body,
+		"source_branch": request.Head,
+		"target_branch": base,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *gitlabForge) ListPullRequests(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	return f.list(ctx, "/merge_requests", state, limit)
+}
+
+func (f *gitlabForge) GetPullRequest(ctx context.Context, number int) (*ForgeItem, error) {
+	return f.get(ctx, "/merge_requests/%d", number)
+}
+
+func (f *gitlabForge) CommentOnPullRequest(ctx context.Context, number int, body string) error {
+	return f.api.do(ctx, http.MethodPost, f.path("/merge_requests/%d/notes", number), map[string]string{"body": body}, nil)
+}
+
+func (f *gitlabForge) CreateIssue(ctx context.Context, title, body string) (*ForgeItem, error) {
+	var created gitlabItem
+	err := f.api.do(ctx, http.MethodPost, f.path("/issues"), map[string]string{"title": title, "description": body}, &created)
+	if err != nil {
+		return nil, err
+	}
+	item := created.item()
+	return &item, nil
+}
+
+func (f *gitlabForge) ListIssues(ctx context.Context, state string, limit int) ([]ForgeItem, error) {
+	return f.list(ctx, "/issues", state, limit)
+}
+
+func (f *gitlabForge) GetIssue(ctx context.Context, number int) (*ForgeItem, error) {
+	return f.get(ctx, "/issues/%d", number)
+}
+
+func (f *gitlabForge) CommentOnIssue(ctx context.Context, number int, body string) error {
+	return f.api.do(ctx, http.MethodPost, f.path("/issues/%d/notes", number), map[string]string{"body": body}, nil)
+}
+
+func (f *gitlabForge) list(ctx context.Context, collection, state string, limit int) ([]ForgeItem, error) {
+	var results []gitlabItem
+	err := f.api.do(ctx, http.MethodGet, f.path("%s?state=%s&per_page=%d", collection, gitlabState(state), limit), nil, &results)
+	if err != nil {
+		return nil, err
+	}
+	items := []ForgeItem{}
+	for _, result := range results {
+		items = append(items, result.item())
+	}
+	return items, nil
+}
+
+func (f *gitlabForge) get(ctx context.Context, format string, number int) (*ForgeItem, error) {
+	var result gitlabItem
+	err := f.api.do(ctx, http.MethodGet, f.path(format, number), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	item := result.item()
+	return &item, nil
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// formatter is a command that rewrites a file in place, run with the file's
+// path appended.
+type formatter struct {
+	Command    []string
+	Extensions []string
+}
+
+// formatters are tried in order; the first one installed that handles a
+// file's extension formats it.
+var formatters = []formatter{
+	{Command: []string{"goimports", "-w"}, Extensions: []string{".go"}},
+	{Command: []string{"gofmt", "-w"}, Extensions: []string{".go"}},
+	{Command: []string{"prettier", "--write"}, Extensions: []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".json", ".css", ".scss", ".html", ".vue", ".yaml", ".yml"}},
+	{Command: []string{"black", "--quiet"}, Extensions: []string{".py", ".pyi"}},
+}
+
+// formatHook is the post_tool hook --format installs. It formats the file
+// each successful edit touched and tells the model when the file changed
+// or the formatter failed, so its picture of the file stays accurate.
+// watched is the agent's, which learns of the formatter's rewrites.
+func formatHook(watched *fileWatcher) Hook {
+	return Hook{
+		Event: HookPostTool,
+		Tools: []string{"edit_file", "write_file"},
+		Func: func(hook *HookContext) error {
+			return formatAfterEdit(hook, watched)
+		},
+	}
+}
+
+func formatAfterEdit(hook *HookContext, watched *fileWatcher) error {
+	if hook.IsError {
+		return nil
+	}
+	var input struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(hook.Input, &input) != nil || input.Path == "" {
+		return nil
+	}
+	path, err := resolvePath(input.Path)
+	if err != nil {
+		return nil
+	}
+
+	name, changed, err := formatFile(path)
+	switch {
+	case err != nil:
+		hook.Output += fmt.Sprintf("\n\nThe change was saved, but %s failed on %s:\n%v", name, input.Path, err)
+	case changed:
+		// The formatter ran on the agent's behalf; its rewrite is not
+		// someone else's change.
+		watched.seen(path)
+		hook.Output += fmt.Sprintf("\n\n%s reformatted %s; read it again before editing nearby lines.", name, input.Path)
+	}
+	return nil
+}
+
+// formatFile runs the first installed formatter for path's extension. It
+// returns the formatter's name, "" when none applies, and whether the file
+// changed.
+func formatFile(path string) (string, bool, error) {
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, candidate := range formatters {
+		if !slices.Contains(candidate.Extensions, extension) {
+			continue
+		}
+		if _, err := exec.LookPath(candidate.Command[0]); err != nil {
+			continue
+		}
+		name := candidate.Command[0]
+
+		before, err := os.ReadFile(path)
+		if err != nil {
+			return name, false, fmt.Errorf("failed to read file: %w", err)
+		}
+		args := append(append([]string(nil), candidate.Command[1:]...), path)
+		cmd := exec.Command(candidate.Command[0], args...)
+		cmd.Dir = workspaceRoot
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err = cmd.Run()
+		if err != nil {
+			return name, false, fmt.Errorf("%w\n%s", err, TruncateOutput(strings.TrimSpace(output.String()), maxShellOutput))
+		}
+		after, err := os.ReadFile(path)
+		if err != nil {
+			return name, false, fmt.Errorf("failed to read file: %w", err)
+		}
+		return name, !bytes.Equal(before, after), nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// tokenEnvVars are checked in order for an HTTP(S) access token. Each is
+// only sent to the hosts it is meant for, by tokenHosts, so a remote URL
+// in a cloned repository cannot collect it.
+var tokenEnvVars = []string{"SYSTEM3_GIT_TOKEN", "GIT_TOKEN", "GITHUB_TOKEN", "GITLAB_TOKEN", "GITEA_TOKEN"}
+
+// defaultSSHKeys are tried, in order, when no SSH agent is available.
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// resolveRemote returns remoteName if given, otherwise origin, otherwise the
+// first configured remote.
+func resolveRemote(r *git.Repository, remoteName string) (string, error) {
+	if remoteName != "" {
+		_, err := r.Remote(remoteName)
+		if err != nil {
+			return "", toolErrorf(ErrorNotFound, "remote %s not found: %w", remoteName, err)
+		}
+		return remoteName, nil
+	}
+
+	// Get the default remote (origin)
+	_, err := r.Remote("origin")
+	if err == nil {
+		return "origin", nil
+	}
+
+	// Try to find any remote if origin doesn't exist
+	remotes, remErr := r.Remotes()
+	if remErr != nil || len(remotes) == 0 {
+		return "", fmt.Errorf("no remotes found: %w", err)
+	}
+	// Use the first available remote
+	return remotes[0].Config().Name, nil
+}
+
+// remoteAuth picks credentials for a remote based on its URL scheme. HTTP(S)
+// remotes use a token from the environment meant for their host; SSH
+// remotes use the SSH agent and fall back to keys in ~/.ssh. A nil
+// AuthMethod means anonymous access.
+func remoteAuth(r *git.Repository, remoteName string) (transport.AuthMethod, error) {
+	remote, err := r.Remote(remoteName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote %s: %w", remoteName, err)
+	}
+
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("remote %s has no URL", remoteName)
+	}
+
+	endpoint, err := transport.NewEndpoint(urls[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL %s: %w", urls[0], err)
+	}
+
+	switch endpoint.Protocol {
+	case "http", "https":
+		return httpAuth(endpoint.Host), nil
+	case "ssh":
+		return sshAuth(endpoint.User)
+	default:
+		return nil, nil
+	}
+}
+
+func httpAuth(host string) transport.AuthMethod {
+	for _, name := range tokenEnvVars {
+		token := os.Getenv(name)
+		if token == "" || !slices.ContainsFunc(tokenHosts(name), func(candidate string) bool {
+			return strings.EqualFold(candidate, host)
+		}) {
+			continue
+		}
+
+		// Forges accept any non-empty username alongside a token.
+		username := envOr("GIT_USERNAME", "x-access-token")
+		return &githttp.BasicAuth{Username: username, Password: token}
+	}
+	return nil
+}
+
+// tokenHosts returns the hosts the token in the environment variable name
+// may be sent to. Forge tokens go to their forge's public host and to the
+// --forge-url host when that forge is configured; the generic tokens only
+// to the --forge-url host and the hosts listed in SYSTEM3_GIT_HOSTS.
+func tokenHosts(name string) []string {
+	switch name {
+	case "GITHUB_TOKEN":
+		return append([]string{"github.com"}, configuredForgeHost(ForgeGitHub)...)
+	case "GITLAB_TOKEN":
+		return append([]string{"gitlab.com"}, configuredForgeHost(ForgeGitLab)...)
+	case "GITEA_TOKEN":
+		return configuredForgeHost(ForgeGitea)
+	}
+	hosts := configuredForgeHost("")
+	for _, host := range strings.Split(os.Getenv("SYSTEM3_GIT_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// configuredForgeHost returns the host of --forge-url when it is set and
+// serves kind, as given by --forge or its host name; an empty kind matches
+// any forge.
+func configuredForgeHost(kind string) []string {
+	if forgeSettings.APIURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(forgeSettings.APIURL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil
+	}
+	configured := forgeSettings.Kind
+	if configured == "" {
+		configured, _ = detectForgeKind(parsed.Hostname())
+	}
+	if kind != "" && configured != kind {
+		return nil
+	}
+	return []string{parsed.Hostname()}
+}
+
+func sshAuth(user string) (transport.AuthMethod, error) {
+	if user == "" {
+		user = "git"
+	}
+
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		auth, err := gitssh.NewSSHAgentAuth(user)
+		if err == nil {
+			return auth, nil
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("no SSH agent and failed to locate home directory: %w", err)
+	}
+
+	keys := defaultSSHKeys
+	if key := os.Getenv("SYSTEM3_SSH_KEY"); key != "" {
+		keys = []string{key}
+	}
+	for _, key := range keys {
+		path := key
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(home, ".ssh", key)
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		auth, err := gitssh.NewPublicKeysFromFile(user, path, os.Getenv("SSH_KEY_PASSWORD"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key %s: %w", path, err)
+		}
+		return auth, nil
+	}
+
+	return nil, fmt.Errorf("no SSH agent running and no SSH key found in %s", filepath.Join(home, ".ssh"))
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitIdentity is the name and email recorded on commits and tags.
+type gitIdentity struct {
+	Name  string
+	Email string
+}
+
+// defaultGitIdentity is used when neither the environment nor any git config
+// scope names an identity, as on fresh CI machines. It is set from
+// --git-identity.
+var defaultGitIdentity gitIdentity
+
+// parseGitIdentity parses an identity written as "Name <email>". An empty
+// string is the zero identity.
+func parseGitIdentity(value string) (gitIdentity, error) {
+	if value == "" {
+		return gitIdentity{}, nil
+	}
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Name == "" {
+		return gitIdentity{}, fmt.Errorf("invalid git identity %q, expected \"Name <email>\"", value)
+	}
+	return gitIdentity{Name: address.Name, Email: address.Address}, nil
+}
+
+// commitSignatures resolves the author and committer for a new commit in r
+// the way git does: GIT_AUTHOR_* and GIT_COMMITTER_* environment variables
+// first, then the author, committer and user sections of the local, global
+// and system config, then defaultGitIdentity.
+func commitSignatures(r *git.Repository) (author, committer *object.Signature, err error) {
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load git config: %w", err)
+	}
+
+	now := time.Now()
+	author, err = resolveSignature("GIT_AUTHOR",
+		gitIdentity{cfg.Author.Name, cfg.Author.Email}, gitIdentity{cfg.User.Name, cfg.User.Email}, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	committer, err = resolveSignature("GIT_COMMITTER",
+		gitIdentity{cfg.Committer.Name, cfg.Committer.Email}, gitIdentity{cfg.User.Name, cfg.User.Email}, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	return author, committer, nil
+}
+
+// resolveSignature picks the name and email separately, so GIT_AUTHOR_NAME
+// alone can override just the name.
+func resolveSignature(envPrefix string, role, user gitIdentity, when time.Time) (*object.Signature, error) {
+	name := firstNonEmpty(os.Getenv(envPrefix+"_NAME"), role.Name, user.Name, defaultGitIdentity.Name)
+	email := firstNonEmpty(os.Getenv(envPrefix+"_EMAIL"), role.Email, user.Email, defaultGitIdentity.Email)
+	if name == "" || email == "" {
+		return nil, fmt.Errorf("no git identity: set user.name and user.email in git config, %s_NAME and %s_EMAIL, or --git-identity", envPrefix, envPrefix)
+	}
+	return &object.Signature{Name: name, Email: email, When: when}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	defaultLogCount = 10
+	maxLogCount     = 200
+)
+
+// logQuery selects the commits gitLog returns.
+type logQuery struct {
+	Revision string
+	Count    int
+	Skip     int
+	// Author matches a case-insensitive substring of the author name or
+	// email.
+	Author string
+	// Files limits the log to commits touching these comma-separated paths.
+	Files string
+	// Since and Until are dates as YYYY-MM-DD or RFC 3339 timestamps.
+	Since string
+	Until string
+}
+
+type logEntry struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body,omitempty"`
+}
+
+type logPage struct {
+	Commits []logEntry `json:"commits"`
+	// More reports whether older matching commits exist; fetch them by
+	// increasing skip.
+	More bool `json:"more"`
+}
+
+// gitLog lists commits reachable from the query's revision, newest first, as
+// JSON.
+func gitLog(path string, query logQuery) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	revision := orDefault(query.Revision, "HEAD")
+	from, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", revision, err)
+	}
+
+	count := query.Count
+	if count <= 0 {
+		count = defaultLogCount
+	}
+	count = min(count, maxLogCount)
+
+	options := &git.LogOptions{From: *from}
+	if paths := splitList(query.Files); len(paths) > 0 {
+		options.PathFilter = func(name string) bool {
+			return pathMatches(name, paths)
+		}
+	}
+	options.Since, err = parseLogDate("since", query.Since)
+	if err != nil {
+		return "", err
+	}
+	options.Until, err = parseLogDate("until", query.Until)
+	if err != nil {
+		return "", err
+	}
+
+	logIter, err := r.Log(options)
+	if err != nil {
+		return "", fmt.Errorf("failed to get log: %w", err)
+	}
+	defer logIter.Close()
+
+	author := strings.ToLower(query.Author)
+	page := logPage{Commits: []logEntry{}}
+	skipped := 0
+	err = logIter.ForEach(func(c *object.Commit) error {
+		if author != "" &&
+			!strings.Contains(strings.ToLower(c.Author.Name), author) &&
+			!strings.Contains(strings.ToLower(c.Author.Email), author) {
+			return nil
+		}
+		if skipped < query.Skip {
+			skipped++
+			return nil
+		}
+		if len(page.Commits) == count {
+			page.More = true
+			return storer.ErrStop
+		}
+
+		subject, body, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+		page.Commits = append(page.Commits, logEntry{
+			Hash:    c.Hash.String(),
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Date:    c.Author.When,
+			Subject: subject,
+			Body:    strings.TrimSpace(body),
+		})
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return "", fmt.Errorf("failed to iterate over commits: %w", err)
+	}
+
+	result, err := json.MarshalIndent(page, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// parseLogDate parses a since or until date, returning nil when it is empty.
+func parseLogDate(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &date, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD or an RFC 3339 timestamp", name, value)
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// pathMatches reports whether name is one of paths or inside one of them.
+func pathMatches(name string, paths []string) bool {
+	for _, path := range paths {
+		if name == path || strings.HasPrefix(name, strings.TrimSuffix(path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
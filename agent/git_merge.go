@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// conflictReport is returned to the model when an operation stops on
+// conflicts, so it can resolve them file by file.
+type conflictReport struct {
+	Operation string         `json:"operation"`
+	Status    string         `json:"status"`
+	Message   string         `json:"message"`
+	Files     []conflictFile `json:"conflicted_files"`
+	Hint      string         `json:"hint"`
+}
+
+type conflictFile struct {
+	Path      string         `json:"path"`
+	Conflicts []conflictHunk `json:"conflicts"`
+}
+
+type conflictHunk struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Ours      string `json:"ours"`
+	Theirs    string `json:"theirs"`
+}
+
+func gitMerge(path, branchName string, abort bool) (string, error) {
+	if abort {
+		_, err := runGit(path, "merge", "--abort")
+		if err != nil {
+			return "", fmt.Errorf("failed to abort merge: %w", err)
+		}
+		return "Merge aborted", nil
+	}
+
+	if branchName == "" {
+		return "", fmt.Errorf("branch name is required for merge operation")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	target, err := r.ResolveRevision(plumbing.Revision(branchName))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", branchName, err)
+	}
+
+	if head.Hash() == *target {
+		return "Already up to date", nil
+	}
+
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	targetCommit, err := r.CommitObject(*target)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit for '%s': %w", branchName, err)
+	}
+
+	merged, err := targetCommit.IsAncestor(headCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to compare history: %w", err)
+	}
+	if merged {
+		return "Already up to date", nil
+	}
+
+	fastForward, err := headCommit.IsAncestor(targetCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to compare history: %w", err)
+	}
+
+	if fastForward {
+		// MergeReset moves the branch and updates files changed between the
+		// two commits while keeping unrelated local modifications.
+		err = w.Reset(&git.ResetOptions{
+			Commit: *target,
+			Mode:   git.MergeReset,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to fast-forward: %w", err)
+		}
+		return fmt.Sprintf("Fast-forwarded %s to %s (%s)", head.Name().Short(), branchName, target.String()[:7]), nil
+	}
+
+	// go-git only supports fast-forward merges, so three-way merges are left
+	// to the git binary.
+	output, err := runGit(path, "merge", "--no-edit", "--end-of-options", branchName)
+	if err != nil {
+		return conflictResult(path, "merge", output, err)
+	}
+
+	return fmt.Sprintf("Merged %s into %s\n%s", branchName, head.Name().Short(), output), nil
+}
+
+// runGit runs the git binary in path and returns its combined output.
+func runGit(path string, args ...string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("this operation needs the git binary, which was not found in PATH")
+	}
+
+	cmd := exec.Command("git", append([]string{"-C", path}, args...)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return strings.TrimSpace(output.String()), err
+}
+
+// conflictResult turns a failed git operation into a conflict report when
+// the failure left unmerged files behind, and into a plain error otherwise.
+func conflictResult(path, operation, output string, runErr error) (string, error) {
+	unmerged, err := runGit(path, "diff", "--name-only", "--diff-filter=U")
+	if err != nil || unmerged == "" {
+		return "", fmt.Errorf("%s failed: %w\n%s", operation, runErr, output)
+	}
+
+	report := conflictReport{
+		Operation: operation,
+		Status:    "conflict",
+		Message:   output,
+	}
+	next := fmt.Sprintf("run %s again with continue", operation)
+	if operation == "merge" {
+		next = "commit"
+	}
+	report.Hint = fmt.Sprintf("Edit each file to resolve the conflict markers, stage the files with git add, then %s. Use abort to cancel the %s instead.", next, operation)
+
+	for _, file := range strings.Split(unmerged, "\n") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		hunks, err := parseConflictMarkers(filepath.Join(path, file))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		report.Files = append(report.Files, conflictFile{Path: file, Conflicts: hunks})
+	}
+
+	result, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// parseConflictMarkers extracts the <<<<<<< / ======= / >>>>>>> regions of a
+// conflicted file.
+func parseConflictMarkers(path string) ([]conflictHunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	const (
+		outside = iota
+		inOurs
+		inBase
+		inTheirs
+	)
+
+	var hunks []conflictHunk
+	var current conflictHunk
+	var ours, theirs strings.Builder
+	section := outside
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "<<<<<<<"):
+			current = conflictHunk{StartLine: lineNumber}
+			ours.Reset()
+			theirs.Reset()
+			section = inOurs
+		case section == inOurs && strings.HasPrefix(line, "|||||||"):
+			// diff3-style conflicts include the merge base, which is skipped.
+			section = inBase
+		case (section == inOurs || section == inBase) && strings.HasPrefix(line, "======="):
+			section = inTheirs
+		case section == inTheirs && strings.HasPrefix(line, ">>>>>>>"):
+			current.EndLine = lineNumber
+			current.Ours = ours.String()
+			current.Theirs = theirs.String()
+			hunks = append(hunks, current)
+			section = outside
+		case section == inOurs:
+			ours.WriteString(line + "\n")
+		case section == inTheirs:
+			theirs.WriteString(line + "\n")
+		}
+	}
+
+	return hunks, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// go-git cannot rebase or cherry-pick, so both are left to the git binary.
+// core.editor=true keeps --continue from waiting on an editor for the
+// commit message. Revisions are resolved to hashes with go-git first, so a
+// value such as --exec=cmd never reaches git as an option.
+
+// commitHash returns the hash of the commit revision names.
+func commitHash(r *git.Repository, revision string) (string, error) {
+	if strings.HasPrefix(revision, "-") {
+		return "", toolErrorf(ErrorInvalidInput, "invalid revision %q", revision)
+	}
+	commit, err := resolveCommit(r, revision)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func gitRebase(path, onto string, abort, cont bool) (string, error) {
+	switch {
+	case abort:
+		_, err := runGit(path, "rebase", "--abort")
+		if err != nil {
+			return "", fmt.Errorf("failed to abort rebase: %w", err)
+		}
+		return "Rebase aborted", nil
+	case cont:
+		output, err := runGit(path, "-c", "core.editor=true", "rebase", "--continue")
+		if err != nil {
+			return conflictResult(path, "rebase", output, err)
+		}
+		return fmt.Sprintf("Rebase continued\n%s", output), nil
+	}
+
+	if onto == "" {
+		return "", fmt.Errorf("branch name is required for rebase operation")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	hash, err := commitHash(r, onto)
+	if err != nil {
+		return "", err
+	}
+	output, err := runGit(path, "rebase", "--end-of-options", hash)
+	if err != nil {
+		return conflictResult(path, "rebase", output, err)
+	}
+	return fmt.Sprintf("Rebased onto %s\n%s", onto, output), nil
+}
+
+// gitCherryPick applies the given commits, a comma-separated list of
+// revisions or ranges such as a..b, on top of HEAD.
+func gitCherryPick(path, revisions string, abort, cont bool) (string, error) {
+	switch {
+	case abort:
+		_, err := runGit(path, "cherry-pick", "--abort")
+		if err != nil {
+			return "", fmt.Errorf("failed to abort cherry-pick: %w", err)
+		}
+		return "Cherry-pick aborted", nil
+	case cont:
+		output, err := runGit(path, "-c", "core.editor=true", "cherry-pick", "--continue")
+		if err != nil {
+			return conflictResult(path, "cherry-pick", output, err)
+		}
+		return fmt.Sprintf("Cherry-pick continued\n%s", output), nil
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	var commits, hashes []string
+	for _, revision := range strings.Split(revisions, ",") {
+		revision = strings.TrimSpace(revision)
+		if revision == "" {
+			continue
+		}
+		// A range a..b is resolved end by end.
+		var ends []string
+		for _, end := range strings.SplitN(revision, "..", 2) {
+			hash, err := commitHash(r, end)
+			if err != nil {
+				return "", err
+			}
+			ends = append(ends, hash)
+		}
+		commits = append(commits, revision)
+		hashes = append(hashes, strings.Join(ends, ".."))
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("revision is required for cherry-pick operation")
+	}
+
+	output, err := runGit(path, append([]string{"cherry-pick", "--end-of-options"}, hashes...)...)
+	if err != nil {
+		return conflictResult(path, "cherry-pick", output, err)
+	}
+	return fmt.Sprintf("Cherry-picked %s\n%s", strings.Join(commits, ", "), output), nil
+}
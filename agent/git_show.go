@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitShow returns the metadata and patch of a commit, like git show.
+// files optionally limits the patch to a comma-separated list of paths.
+func gitShow(path, revision, files string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	commit, err := resolveCommit(r, orDefault(revision, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("commit %s\n", commit.Hash))
+	if commit.NumParents() > 1 {
+		var parents []string
+		for _, parent := range commit.ParentHashes {
+			parents = append(parents, parent.String()[:7])
+		}
+		output.WriteString(fmt.Sprintf("Merge: %s\n", strings.Join(parents, " ")))
+	}
+	output.WriteString(fmt.Sprintf("Author: %s <%s>\nDate:   %s\n\n%s\n",
+		commit.Author.Name,
+		commit.Author.Email,
+		commit.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"),
+		prefixLines(strings.TrimRight(commit.Message, "\n"), "    ")))
+
+	// Like git show, merges are diffed against their first parent and root
+	// commits against the empty tree.
+	var parent *object.Commit
+	if commit.NumParents() > 0 {
+		parent, err = commit.Parent(0)
+		if err != nil {
+			return "", fmt.Errorf("failed to get parent commit: %w", err)
+		}
+	}
+
+	patch, err := diffCommits(parent, commit, files)
+	if err != nil {
+		return "", err
+	}
+	if patch != "" {
+		output.WriteString("\n")
+		output.WriteString(patch)
+	}
+
+	return TruncateOutput(output.String(), maxShellOutput), nil
+}
+
+// gitDiffRefs diffs two revisions given as "base..head". "base...head"
+// diffs head against its merge base with base, and a single revision is
+// compared with HEAD.
+func gitDiffRefs(path, revisions, files string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	baseName, headName, mergeBase := revisions, "HEAD", false
+	if before, after, ok := strings.Cut(revisions, "..."); ok {
+		baseName, headName, mergeBase = before, after, true
+	} else if before, after, ok := strings.Cut(revisions, ".."); ok {
+		baseName, headName = before, after
+	}
+
+	base, err := resolveCommit(r, orDefault(baseName, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	head, err := resolveCommit(r, orDefault(headName, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	if mergeBase {
+		bases, err := base.MergeBase(head)
+		if err != nil {
+			return "", fmt.Errorf("failed to find merge base: %w", err)
+		}
+		if len(bases) == 0 {
+			return "", fmt.Errorf("%s and %s have no common history", baseName, headName)
+		}
+		base = bases[0]
+	}
+
+	patch, err := diffCommits(base, head, files)
+	if err != nil {
+		return "", err
+	}
+	if patch == "" {
+		return "No differences", nil
+	}
+	return TruncateOutput(patch, maxShellOutput), nil
+}
+
+func resolveCommit(r *git.Repository, revision string) (*object.Commit, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve '%s': %w", revision, err)
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit for '%s': %w", revision, err)
+	}
+	return commit, nil
+}
+
+// diffCommits returns the unified diff from one commit to another. A nil
+// from commit stands for the empty tree.
+func diffCommits(from, to *object.Commit, files string) (string, error) {
+	fromTree := &object.Tree{}
+	if from != nil {
+		tree, err := from.Tree()
+		if err != nil {
+			return "", fmt.Errorf("failed to get tree: %w", err)
+		}
+		fromTree = tree
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get tree: %w", err)
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return "", fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	if paths := splitList(files); len(paths) > 0 {
+		var filtered object.Changes
+		for _, change := range changes {
+			if changeTouches(change, paths) {
+				filtered = append(filtered, change)
+			}
+		}
+		changes = filtered
+	}
+
+	patch, err := changes.Patch()
+	if err != nil {
+		return "", fmt.Errorf("failed to create patch: %w", err)
+	}
+	return patch.String(), nil
+}
+
+// changeTouches reports whether a change affects one of paths, or a file
+// inside one of them when a path is a directory.
+func changeTouches(change *object.Change, paths []string) bool {
+	for _, name := range []string{change.From.Name, change.To.Name} {
+		if name != "" && pathMatches(name, paths) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// gitConfigValue looks key up in the local, global and system config in that
+// order, the way git does. ConfigScoped only merges the typed fields, so
+// options such as commit.gpgsign are read from each scope's raw config.
+func gitConfigValue(r *git.Repository, section, subsection, key string) (string, bool, error) {
+	local, err := r.Storer.Config()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load git config: %w", err)
+	}
+	scopes := []*config.Config{local}
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to load git config: %w", err)
+		}
+		scopes = append(scopes, cfg)
+	}
+
+	for _, cfg := range scopes {
+		if cfg.Raw == nil || !cfg.Raw.HasSection(section) {
+			continue
+		}
+		var options format.Options
+		if subsection == "" {
+			options = cfg.Raw.Section(section).Options
+		} else if cfg.Raw.Section(section).HasSubsection(subsection) {
+			options = cfg.Raw.Section(section).Subsection(subsection).Options
+		}
+		if options.Has(key) {
+			return options.Get(key), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// commitSigner returns the signer for new commits when commit.gpgsign is
+// enabled, or nil. Signing shells out to gpg or ssh-keygen like git itself,
+// so keys in agents and smartcards work.
+func commitSigner(r *git.Repository) (git.Signer, error) {
+	value, ok, err := gitConfigValue(r, "commit", "", "gpgsign")
+	if err != nil || !ok {
+		return nil, err
+	}
+	// A bare "gpgsign" key with no value means true.
+	enabled, err := strconv.ParseBool(orDefault(value, "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit.gpgsign value %q", value)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	key, _, err := gitConfigValue(r, "user", "", "signingkey")
+	if err != nil {
+		return nil, err
+	}
+	signingFormat, _, err := gitConfigValue(r, "gpg", "", "format")
+	if err != nil {
+		return nil, err
+	}
+
+	switch orDefault(signingFormat, "openpgp") {
+	case "openpgp":
+		program, _, err := gitConfigValue(r, "gpg", "", "program")
+		if err != nil {
+			return nil, err
+		}
+		return &gpgSigner{program: orDefault(program, "gpg"), key: key}, nil
+	case "ssh":
+		if key == "" {
+			return nil, fmt.Errorf("commit.gpgsign is set with gpg.format ssh but user.signingkey is empty")
+		}
+		program, _, err := gitConfigValue(r, "gpg", "ssh", "program")
+		if err != nil {
+			return nil, err
+		}
+		return &sshSigner{program: orDefault(program, "ssh-keygen"), key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported gpg.format %q, expected openpgp or ssh", signingFormat)
+	}
+}
+
+// gpgSigner makes a detached, armored OpenPGP signature with gpg.
+type gpgSigner struct {
+	program string
+	// key is the user.signingkey; when empty gpg uses its default key.
+	key string
+}
+
+func (s *gpgSigner) Sign(message io.Reader) ([]byte, error) {
+	args := []string{"--status-fd=2", "-bsa"}
+	if s.key != "" {
+		args = append(args, "-u", s.key)
+	}
+	return runSigner(s.program, args, message)
+}
+
+// sshSigner makes an SSH signature in the "git" namespace with ssh-keygen.
+type sshSigner struct {
+	program string
+	// key is a path to a key file or a literal "key::<public key>", whose
+	// private half must then be loaded in ssh-agent.
+	key string
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	keyFile := s.key
+	if literal, ok := strings.CutPrefix(s.key, "key::"); ok {
+		file, err := os.CreateTemp("", "system3-signingkey-*.pub")
+		if err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %w", err)
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(literal + "\n")
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %w", err)
+		}
+		keyFile = file.Name()
+	} else if rest, ok := strings.CutPrefix(keyFile, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand signing key path: %w", err)
+		}
+		keyFile = filepath.Join(home, rest)
+	}
+
+	return runSigner(s.program, []string{"-Y", "sign", "-n", "git", "-f", keyFile}, message)
+}
+
+// runSigner pipes message through program and returns what it prints.
+func runSigner(program string, args []string, message io.Reader) ([]byte, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = message
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign commit with %s: %w\n%s", program, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// gitTag creates a tag when a name is given and lists tags otherwise. A
+// message makes the tag annotated; without one it is lightweight.
+func gitTag(path, tagName, revision, message string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if tagName == "" {
+		return listTags(r)
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(orDefault(revision, "HEAD")))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", orDefault(revision, "HEAD"), err)
+	}
+
+	var options *git.CreateTagOptions
+	if message != "" {
+		_, tagger, err := commitSignatures(r)
+		if err != nil {
+			return "", err
+		}
+		options = &git.CreateTagOptions{
+			Tagger:  tagger,
+			Message: message,
+		}
+	}
+
+	_, err = r.CreateTag(tagName, *hash, options)
+	if err != nil {
+		if errors.Is(err, git.ErrTagExists) {
+			return "", fmt.Errorf("tag '%s' already exists", tagName)
+		}
+		return "", fmt.Errorf("failed to create tag: %w", err)
+	}
+
+	kind := "lightweight"
+	if options != nil {
+		kind = "annotated"
+	}
+	return fmt.Sprintf("Created %s tag '%s' at %s", kind, tagName, hash.String()[:7]), nil
+}
+
+func listTags(r *git.Repository) (string, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return "", fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	var lines []string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		tag, err := r.TagObject(ref.Hash())
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			lines = append(lines, fmt.Sprintf("%s -> %s", name, ref.Hash().String()[:7]))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		subject, _, _ := strings.Cut(strings.TrimSpace(tag.Message), "\n")
+		lines = append(lines, fmt.Sprintf("%s -> %s (annotated: %s)", name, tag.Target.String()[:7], subject))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read tags: %w", err)
+	}
+
+	if len(lines) == 0 {
+		return "No tags", nil
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n"), nil
+}
+
+func gitDeleteTag(path, tagName string) (string, error) {
+	if tagName == "" {
+		return "", fmt.Errorf("tag name is required for delete-tag operation")
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	err = r.DeleteTag(tagName)
+	if err != nil {
+		if errors.Is(err, git.ErrTagNotFound) {
+			return "", toolErrorf(ErrorNotFound, "tag '%s' not found", tagName)
+		}
+		return "", fmt.Errorf("failed to delete tag: %w", err)
+	}
+	return fmt.Sprintf("Deleted tag '%s'", tagName), nil
+}
+
+// gitPushTag pushes one tag, or every tag when no name is given.
+func gitPushTag(ctx context.Context, path, remote, tagName string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	remoteName, err := resolveRemote(r, remote)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := remoteAuth(r, remoteName)
+	if err != nil {
+		return "", err
+	}
+
+	refSpec := config.RefSpec("refs/tags/*:refs/tags/*")
+	if tagName != "" {
+		if _, err := r.Tag(tagName); err != nil {
+			return "", toolErrorf(ErrorNotFound, "tag '%s' not found", tagName)
+		}
+		refSpec = config.RefSpec(fmt.Sprintf("refs/tags/%s:refs/tags/%s", tagName, tagName))
+	}
+
+	err = r.PushContext(ctx, &git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       auth,
+	})
+	if err != nil {
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return fmt.Sprintf("Tags are already up-to-date on '%s'", remoteName), nil
+		} else if isAuthError(err) {
+			return "", authFailure(err)
+		}
+		return "", fmt.Errorf("push failed: %w", err)
+	}
+
+	if tagName == "" {
+		return fmt.Sprintf("Pushed all tags to '%s'", remoteName), nil
+	}
+	return fmt.Sprintf("Pushed tag '%s' to '%s'", tagName, remoteName), nil
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultGlobMaxResults = 200
+
+// glob tool
+
+var GlobDefinition = ToolDefinition{
+	Name: "glob",
+	Description: `Find files whose paths match a glob pattern, most recently modified first.
+
+Patterns are matched against paths relative to path (the workspace root by default): * and ? match within a path segment, [abc] matches a character class and ** matches any number of directories, e.g. "**/*_test.go", "cmd/*/main.go" or "src/**/*.{ts,tsx}". Hidden directories are skipped unless the pattern names them. Prefer this over list_files when you want the files matching a name rather than the whole tree.`,
+	InputSchema: GlobInputSchema,
+	Function:    Glob,
+}
+
+type GlobInput struct {
+	Pattern    string `json:"pattern" jsonschema_description:"The glob pattern, e.g. **/*.go"`
+	Path       string `json:"path,omitempty" jsonschema_description:"Optional relative directory to match from. Defaults to the workspace root."`
+	MaxResults int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of files to return. Defaults to 200."`
+}
+
+var GlobInputSchema = GenerateSchema[GlobInput]()
+
+func Glob(ctx context.Context, input json.RawMessage) (string, error) {
+	globInput := GlobInput{}
+	err := json.Unmarshal(input, &globInput)
+	if err != nil {
+		return "", err
+	}
+	pattern := strings.TrimPrefix(filepath.ToSlash(globInput.Pattern), "./")
+	if pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	patterns := expandBraces(pattern)
+	for _, expanded := range patterns {
+		if _, err := path.Match(strings.ReplaceAll(expanded, "**", "*"), ""); err != nil {
+			return "", fmt.Errorf("invalid pattern %q: %w", globInput.Pattern, err)
+		}
+	}
+
+	dir, err := resolvePath(globInput.Path)
+	if err != nil {
+		return "", err
+	}
+	maxResults := defaultGlobMaxResults
+	if globInput.MaxResults > 0 {
+		maxResults = globInput.MaxResults
+	}
+
+	type match struct {
+		path    string
+		modTime time.Time
+	}
+	var matches []match
+	err = filepath.Walk(dir, func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		relPath, err := slashRel(dir, walked)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && !namesHiddenDir(patterns, relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		for _, expanded := range patterns {
+			if globMatch(expanded, relPath) {
+				matches = append(matches, match{path: walked, modTime: info.ModTime()})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "No files matched", nil
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].modTime.After(matches[j].modTime) })
+
+	var results []string
+	for _, m := range matches[:min(maxResults, len(matches))] {
+		results = append(results, relativeToWorkspace(m.path))
+	}
+	if len(matches) > maxResults {
+		results = append(results, fmt.Sprintf("... and %d more, narrow the pattern or raise max_results", len(matches)-maxResults))
+	}
+	return strings.Join(results, "\n"), nil
+}
+
+// globMatch matches a slash-separated path against pattern, segment by
+// segment; a "**" segment matches any number of segments, including none.
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces turns "src/*.{ts,tsx}" into "src/*.ts" and "src/*.tsx".
+func expandBraces(pattern string) []string {
+	open := strings.Index(pattern, "{")
+	if open < 0 {
+		return []string{pattern}
+	}
+	length := strings.Index(pattern[open:], "}")
+	if length < 0 {
+		return []string{pattern}
+	}
+	var patterns []string
+	for _, option := range strings.Split(pattern[open+1:open+length], ",") {
+		patterns = append(patterns, expandBraces(pattern[:open]+option+pattern[open+length+1:])...)
+	}
+	return patterns
+}
+
+// namesHiddenDir reports whether a pattern spells out the hidden directory
+// dir, as ".github/**" does, so it is searched.
+func namesHiddenDir(patterns []string, dir string) bool {
+	segments := strings.Split(dir, "/")
+	for _, pattern := range patterns {
+		patternSegments := strings.Split(pattern, "/")
+		if len(patternSegments) < len(segments) {
+			continue
+		}
+		named := true
+		for i, segment := range segments {
+			if patternSegments[i] == "**" {
+				named = false
+				break
+			}
+			// A wildcard such as "*" matches ".git" too, but does not name it.
+			matched, _ := path.Match(patternSegments[i], segment)
+			if !matched || strings.HasPrefix(segment, ".") && !strings.HasPrefix(patternSegments[i], ".") {
+				named = false
+				break
+			}
+		}
+		if named {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const goBuildTimeout = 5 * time.Minute
+
+// go_build tool
+
+var GoBuildDefinition = ToolDefinition{
+	Name: "go_build",
+	Description: `Compile Go packages with "go build" and check them with "go vet", returning compiler diagnostics as JSON.
+
+Use this after editing Go code to verify that it compiles. Each diagnostic has the file, line, column and message. Vet only runs once the build succeeds.`,
+	InputSchema: GoBuildInputSchema,
+	Function:    GoBuild,
+	Preview:     PreviewGoBuild,
+}
+
+type GoBuildInput struct {
+	Path     string `json:"path,omitempty" jsonschema_description:"Optional relative directory of the Go module. Defaults to the workspace root."`
+	Packages string `json:"packages,omitempty" jsonschema_description:"Optional space-separated package patterns to build. Defaults to ./..."`
+	SkipVet  bool   `json:"skip_vet,omitempty" jsonschema_description:"Only run go build, not go vet. Defaults to false."`
+}
+
+var GoBuildInputSchema = GenerateSchema[GoBuildInput]()
+
+type buildReport struct {
+	OK          bool              `json:"ok"`
+	Diagnostics []buildDiagnostic `json:"diagnostics,omitempty"`
+	// Output holds the raw output of a failed step when no diagnostics
+	// could be parsed from it.
+	Output string `json:"output,omitempty"`
+}
+
+type buildDiagnostic struct {
+	Tool    string `json:"tool"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func GoBuild(ctx context.Context, input json.RawMessage) (string, error) {
+	goBuildInput := GoBuildInput{}
+	err := json.Unmarshal(input, &goBuildInput)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := resolvePath(goBuildInput.Path)
+	if err != nil {
+		return "", err
+	}
+	packages, err := goPackagePatterns(goBuildInput.Packages)
+	if err != nil {
+		return "", err
+	}
+
+	report := buildReport{OK: true}
+	steps := [][]string{append([]string{"build", "-o", os.DevNull, "--"}, packages...)}
+	if !goBuildInput.SkipVet {
+		steps = append(steps, append([]string{"vet", "--"}, packages...))
+	}
+
+	for _, args := range steps {
+		output, ok, err := runGo(ctx, dir, args...)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			continue
+		}
+
+		report.OK = false
+		report.Diagnostics = parseGoDiagnostics(args[0], output)
+		if len(report.Diagnostics) == 0 {
+			report.Output = TruncateOutput(output, maxShellOutput)
+		}
+		break
+	}
+
+	result, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// goPackagePatterns splits packages into the patterns go build is given,
+// refusing flags such as -toolexec, which would run any command.
+func goPackagePatterns(packages string) ([]string, error) {
+	patterns := strings.Fields(packages)
+	if len(patterns) == 0 {
+		return []string{"./..."}, nil
+	}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "-") {
+			return nil, toolErrorf(ErrorInvalidInput, "invalid package pattern %q; flags are not accepted", pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// PreviewGoBuild asks before building anything but the default ./..., so
+// the user reviews patterns the model chose.
+func PreviewGoBuild(input json.RawMessage) (string, bool) {
+	goBuildInput := GoBuildInput{}
+	err := json.Unmarshal(input, &goBuildInput)
+	if err != nil {
+		return fmt.Sprintf("build with invalid input: %s", input), true
+	}
+	packages := strings.Join(strings.Fields(goBuildInput.Packages), " ")
+	return fmt.Sprintf("go build %s in %s", orDefault(packages, "./..."), orDefault(goBuildInput.Path, ".")), packages != "" && packages != "./..."
+}
+
+// runGo runs the go command in dir and reports whether it succeeded. Only
+// failures to start the command are returned as errors.
+func runGo(ctx context.Context, dir string, args ...string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, goBuildTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", false, toolErrorf(ErrorTimeout, "go %s timed out after %s", args[0], goBuildTimeout)
+	}
+	if ctx.Err() != nil {
+		return "", false, ctx.Err()
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", false, fmt.Errorf("failed to run go %s: %w", args[0], err)
+		}
+		return output.String(), false, nil
+	}
+	return output.String(), true, nil
+}
+
+var goDiagnostic = regexp.MustCompile(`^(?:vet: )?(\S+?\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// parseGoDiagnostics extracts file:line:column diagnostics from compiler or
+// vet output. Indented lines continue the previous diagnostic's message.
+func parseGoDiagnostics(tool, output string) []buildDiagnostic {
+	var diagnostics []buildDiagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			last := &diagnostics[len(diagnostics)-1]
+			last.Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		match := goDiagnostic.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		diagnostic := buildDiagnostic{Tool: tool, File: match[1], Message: match[4]}
+		diagnostic.Line, _ = strconv.Atoi(match[2])
+		diagnostic.Column, _ = strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
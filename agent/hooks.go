@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectHooksFile holds the repo-local hooks, relative to the working
+// directory.
+var projectHooksFile = filepath.Join(".system3", "hooks.yaml")
+
+// hookTimeout bounds a single shell hook.
+const hookTimeout = time.Minute
+
+// HookEvent is the moment a hook fires.
+type HookEvent string
+
+const (
+	// HookPreTool fires before an approved tool call runs. A failing hook
+	// blocks the call and its error is sent to the model instead.
+	HookPreTool HookEvent = "pre_tool"
+	// HookPostTool fires after a tool call ran and may change its result.
+	HookPostTool HookEvent = "post_tool"
+	// HookSessionStart fires when the agent starts working.
+	HookSessionStart HookEvent = "session_start"
+	// HookSessionEnd fires when the agent is closed.
+	HookSessionEnd HookEvent = "session_end"
+)
+
+// HookContext is what a hook receives. Tool, Input, Output and IsError are
+// only set for tool events; post_tool hooks may change Output and IsError.
+type HookContext struct {
+	Event   HookEvent       `json:"event"`
+	Session string          `json:"session"`
+	Tool    string          `json:"tool,omitempty"`
+	Input   json.RawMessage `json:"input,omitempty"`
+	Output  string          `json:"output,omitempty"`
+	IsError bool            `json:"is_error,omitempty"`
+}
+
+// Hook runs Func at Event. Tool hooks fire for the tools matching one of
+// Tools, a list of names or globs, and for every tool when Tools is empty.
+type Hook struct {
+	Event HookEvent
+	Tools []string
+	Func  func(hook *HookContext) error
+}
+
+func (h Hook) matches(event HookEvent, tool string) bool {
+	if h.Event != event {
+		return false
+	}
+	if len(h.Tools) == 0 || tool == "" {
+		return true
+	}
+	for _, pattern := range h.Tools {
+		if matched, _ := path.Match(pattern, tool); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ShellHook is a hook from the hooks file:
+//
+//	post_tool:
+//	  - tools: [edit_file, write_file]
+//	    command: gofmt -w "$SYSTEM3_PATH"
+//	pre_tool:
+//	  - tools: [run_shell_command]
+//	    command: ./scripts/check-command.sh
+//	session_end:
+//	  - command: go vet ./...
+//
+// The command runs with sh (PowerShell on Windows) in the workspace root
+// and receives the HookContext as JSON on stdin, plus SYSTEM3_EVENT,
+// SYSTEM3_SESSION, SYSTEM3_TOOL and, when the tool input has a path,
+// SYSTEM3_PATH. A pre_tool command that fails blocks the call. A post_tool
+// command's output is added to the tool result, or replaces it when replace
+// is set, and failing marks the result as an error.
+type ShellHook struct {
+	Tools   []string `yaml:"tools"`
+	Command string   `yaml:"command"`
+	Replace bool     `yaml:"replace"`
+}
+
+// LoadHooks reads the hooks file at path. A missing file, or one the user
+// does not trust, yields no hooks: the file comes with the repository, and
+// its session_start hooks would run as soon as the agent starts.
+func LoadHooks(path string) ([]Hook, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	trusted, err := trustProjectFile(path, content, "hooks", "run commands on this machine")
+	if err != nil || !trusted {
+		return nil, err
+	}
+
+	file := map[HookEvent][]ShellHook{}
+	err = yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var hooks []Hook
+	for _, event := range []HookEvent{HookSessionStart, HookPreTool, HookPostTool, HookSessionEnd} {
+		for _, shellHook := range file[event] {
+			if strings.TrimSpace(shellHook.Command) == "" {
+				return nil, fmt.Errorf("%s: %s hook without a command", path, event)
+			}
+			hooks = append(hooks, Hook{Event: event, Tools: shellHook.Tools, Func: shellHook.run})
+		}
+		delete(file, event)
+	}
+	for event := range file {
+		return nil, fmt.Errorf("%s: unknown hook event %q, expected %s, %s, %s or %s", path, event, HookSessionStart, HookPreTool, HookPostTool, HookSessionEnd)
+	}
+	return hooks, nil
+}
+
+func (s ShellHook) run(hook *HookContext) error {
+	payload, err := json.Marshal(hook)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook input: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	shell, args := hostShell(s.Command)
+	cmd := exec.CommandContext(ctx, shell, args...)
+	cmd.Dir = workspaceRoot
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"SYSTEM3_EVENT="+string(hook.Event),
+		"SYSTEM3_SESSION="+hook.Session,
+		"SYSTEM3_TOOL="+hook.Tool,
+	)
+	var input struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(hook.Input, &input) == nil && input.Path != "" {
+		if resolved, err := resolvePath(input.Path); err == nil {
+			cmd.Env = append(cmd.Env, "SYSTEM3_PATH="+resolved)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	output := strings.TrimSpace(stdout.String())
+	if err != nil {
+		message := strings.TrimSpace(output + "\n" + stderr.String())
+		if hook.Event == HookPostTool {
+			hook.IsError = true
+			hook.Output = strings.TrimSpace(hook.Output + "\n\nhook " + s.Command + " failed:\n" + message)
+			return nil
+		}
+		return fmt.Errorf("hook %s failed: %w\n%s", s.Command, err, message)
+	}
+
+	if hook.Event == HookPostTool && output != "" {
+		if s.Replace {
+			hook.Output = output
+		} else {
+			hook.Output = strings.TrimSpace(hook.Output + "\n\nhook " + s.Command + ":\n" + output)
+		}
+	}
+	return nil
+}
+
+// RegisterHook adds a hook that fires after the existing ones.
+func (a *Agent) RegisterHook(hook Hook) {
+	a.hooks = append(a.hooks, hook)
+}
+
+// runHooks fires every hook registered for the event and tool in order. It
+// stops at the first error.
+func (a *Agent) runHooks(hook *HookContext) error {
+	hook.Session = a.session.ID
+	for _, registered := range a.hooks {
+		if !registered.matches(hook.Event, hook.Tool) {
+			continue
+		}
+		err := registered.Func(hook)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSessionHook fires the session hooks for event. They only report
+// failures; there is nothing for them to block.
+func (a *Agent) runSessionHook(event HookEvent) {
+	if a.parent != nil {
+		return
+	}
+	err := a.runHooks(&HookContext{Event: event})
+	if err != nil {
+		slog.Warn("session hook failed", "event", event, "error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skippedElements hold scripts, styling and page chrome rather than content.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Iframe: true, atom.Form: true,
+	atom.Button: true, atom.Nav: true, atom.Footer: true,
+}
+
+// blockElements start on a new paragraph.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Table: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Details: true, atom.Summary: true,
+	atom.Hr: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// htmlToMarkdown converts an HTML page to readable markdown, returning the
+// page title separately. Links are resolved against base.
+func htmlToMarkdown(r io.Reader, base *url.URL) (title, markdown string, err error) {
+	document, err := html.Parse(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	w := &markdownWriter{base: base}
+	if titleNode := findElement(document, atom.Title); titleNode != nil {
+		title = strings.TrimSpace(collapseSpace(textContent(titleNode)))
+	}
+	w.render(document)
+	return title, w.String(), nil
+}
+
+type markdownWriter struct {
+	buf  []byte
+	base *url.URL
+	// pre is set inside <pre>, where whitespace is kept.
+	pre bool
+	// lists holds the next item number of each enclosing list, or 0 for
+	// unordered lists.
+	lists []int
+}
+
+func (w *markdownWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		w.children(n)
+		return
+	default:
+		return
+	}
+
+	if skippedElements[n.DataAtom] {
+		return
+	}
+
+	if level, ok := headingLevels[n.DataAtom]; ok {
+		w.block()
+		w.write(strings.Repeat("#", level) + " ")
+		w.children(n)
+		w.block()
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		w.newline()
+	case atom.Pre:
+		w.block()
+		w.write("```\n")
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.newline()
+		w.write("```")
+		w.block()
+	case atom.Code:
+		if w.pre {
+			w.children(n)
+		} else {
+			w.wrap(n, "`", "`")
+		}
+	case atom.Strong, atom.B:
+		w.wrap(n, "**", "**")
+	case atom.Em, atom.I:
+		w.wrap(n, "*", "*")
+	case atom.A:
+		w.link(n)
+	case atom.Img:
+		if alt := htmlAttribute(n, "alt"); alt != "" {
+			w.text("[image: " + alt + "]")
+		}
+	case atom.Ul, atom.Ol:
+		start := 0
+		if n.DataAtom == atom.Ol {
+			start = 1
+		}
+		if len(w.lists) == 0 {
+			w.block()
+		}
+		w.lists = append(w.lists, start)
+		w.children(n)
+		w.lists = w.lists[:len(w.lists)-1]
+		if len(w.lists) == 0 {
+			w.block()
+		}
+	case atom.Li:
+		w.newline()
+		depth := len(w.lists)
+		marker := "- "
+		if depth > 0 && w.lists[depth-1] > 0 {
+			marker = fmt.Sprintf("%d. ", w.lists[depth-1])
+			w.lists[depth-1]++
+		}
+		w.write(strings.Repeat("  ", max(depth-1, 0)) + marker)
+		w.children(n)
+	case atom.Tr:
+		w.newline()
+		w.write("|")
+		w.children(n)
+	case atom.Td, atom.Th:
+		w.write(" ")
+		w.children(n)
+		w.write(" |")
+	default:
+		// Inside list items, paragraphs only break the line so the item
+		// stays together.
+		if blockElements[n.DataAtom] {
+			if len(w.lists) > 0 {
+				w.newline()
+				w.children(n)
+				return
+			}
+			w.block()
+			w.children(n)
+			w.block()
+			return
+		}
+		w.children(n)
+	}
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.render(child)
+	}
+}
+
+// wrap renders n's children between prefix and suffix, dropping the
+// markers when the content is empty.
+func (w *markdownWriter) wrap(n *html.Node, prefix, suffix string) {
+	start := len(w.buf)
+	w.children(n)
+	content := strings.TrimSpace(string(w.buf[start:]))
+	w.buf = w.buf[:start]
+	if content != "" {
+		w.text(prefix + content + suffix)
+	}
+}
+
+func (w *markdownWriter) link(n *html.Node) {
+	start := len(w.buf)
+	w.children(n)
+	content := strings.TrimSpace(string(w.buf[start:]))
+	w.buf = w.buf[:start]
+
+	href := htmlAttribute(n, "href")
+	if target, err := url.Parse(href); err == nil && w.base != nil {
+		href = w.base.ResolveReference(target).String()
+	}
+	switch {
+	case content == "":
+	case href == "" || strings.HasPrefix(href, "javascript:") || strings.HasPrefix(htmlAttribute(n, "href"), "#"):
+		w.text(content)
+	default:
+		w.text("[" + content + "](" + href + ")")
+	}
+}
+
+// text writes inline text, collapsing whitespace outside <pre>.
+func (w *markdownWriter) text(s string) {
+	if w.pre {
+		w.write(s)
+		return
+	}
+	s = collapseSpace(s)
+	if len(w.buf) == 0 || w.buf[len(w.buf)-1] == '\n' || w.buf[len(w.buf)-1] == ' ' {
+		s = strings.TrimLeft(s, " ")
+	}
+	w.write(s)
+}
+
+func (w *markdownWriter) write(s string) {
+	w.buf = append(w.buf, s...)
+}
+
+// newline ends the current line unless it is already empty.
+func (w *markdownWriter) newline() {
+	w.trimSpaces()
+	if len(w.buf) > 0 && w.buf[len(w.buf)-1] != '\n' {
+		w.buf = append(w.buf, '\n')
+	}
+}
+
+// block ends the current paragraph with a blank line.
+func (w *markdownWriter) block() {
+	w.newline()
+	if len(w.buf) > 1 && w.buf[len(w.buf)-2] != '\n' {
+		w.buf = append(w.buf, '\n')
+	}
+}
+
+func (w *markdownWriter) trimSpaces() {
+	for len(w.buf) > 0 && w.buf[len(w.buf)-1] == ' ' {
+		w.buf = w.buf[:len(w.buf)-1]
+	}
+}
+
+func (w *markdownWriter) String() string {
+	return strings.TrimSpace(string(w.buf)) + "\n"
+}
+
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+func collapseSpace(s string) string {
+	return whitespaceRun.ReplaceAllString(s, " ")
+}
+
+func htmlAttribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// interruptHandler turns Ctrl+C into cancellation of the turn in flight, so
+// a long reply or tool run can be stopped without losing the session.
+// Pressing Ctrl+C again, or while waiting at the prompt, exits.
+type interruptHandler struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	signals chan os.Signal
+	// exit is called when Ctrl+C exits, and does not return.
+	exit func()
+}
+
+func handleInterrupts(exit func()) *interruptHandler {
+	h := &interruptHandler{signals: make(chan os.Signal, 1), exit: exit}
+	signal.Notify(h.signals, os.Interrupt)
+	go h.loop()
+	return h
+}
+
+func (h *interruptHandler) loop() {
+	for range h.signals {
+		h.mu.Lock()
+		cancel := h.cancel
+		h.cancel = nil
+		h.mu.Unlock()
+
+		if cancel == nil {
+			fmt.Println()
+			h.exit()
+		}
+		fmt.Printf("\n\u001b[93msystem\u001b[0m: interrupted, press Ctrl+C again to exit\n")
+		cancel()
+	}
+}
+
+// begin returns a context that the next Ctrl+C cancels. The returned
+// function must be called once the turn is over.
+func (h *interruptHandler) begin(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	h.mu.Lock()
+	h.cancel = cancel
+	h.mu.Unlock()
+
+	return ctx, func() {
+		h.mu.Lock()
+		h.cancel = nil
+		h.mu.Unlock()
+		cancel()
+	}
+}
+
+// Stop restores the default Ctrl+C behaviour.
+func (h *interruptHandler) Stop() {
+	signal.Stop(h.signals)
+	close(h.signals)
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Journal records the original content of every file an agent changes, so
+// changes can be undone. Files changed through run_shell_command are not
+// tracked.
+type Journal struct {
+	mu      sync.Mutex
+	entries []journalEntry
+	// watched learns of the files undo restores.
+	watched *fileWatcher
+}
+
+// journalEntry is a snapshot of a file taken just before it was changed.
+type journalEntry struct {
+	Tool    string
+	Path    string
+	Existed bool
+	Content []byte
+	Mode    os.FileMode
+}
+
+// Record snapshots path before tool changes it.
+func (j *Journal) Record(tool, path string) error {
+	entry := journalEntry{Tool: tool, Path: path}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", path, err)
+		}
+		entry.Existed = true
+		entry.Content = content
+		entry.Mode = info.Mode().Perm()
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to snapshot %s: %w", path, err)
+	}
+
+	j.add(entry)
+	return nil
+}
+
+// add records snapshots taken by the caller.
+func (j *Journal) add(entries ...journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entries...)
+}
+
+// lastPath returns the path of the most recent change, if there is one.
+func (j *Journal) lastPath() (string, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) == 0 {
+		return "", false
+	}
+	return j.entries[len(j.entries)-1].Path, true
+}
+
+// Undo reverts the last count changes, most recent first, and describes what
+// was restored. A count of zero or less reverts every recorded change.
+func (j *Journal) Undo(count int) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.entries) == 0 {
+		return "", fmt.Errorf("there are no changes to undo")
+	}
+	if count <= 0 || count > len(j.entries) {
+		count = len(j.entries)
+	}
+
+	var restored []string
+	for i := 0; i < count; i++ {
+		entry := j.entries[len(j.entries)-1]
+		err := entry.restore()
+		if err != nil {
+			return strings.Join(restored, "\n"), fmt.Errorf("failed to undo %s of %s: %w", entry.Tool, entry.Path, err)
+		}
+		j.entries = j.entries[:len(j.entries)-1]
+		j.watched.seen(entry.Path)
+
+		if entry.Existed {
+			restored = append(restored, fmt.Sprintf("restored %s (undid %s)", relativeToWorkspace(entry.Path), entry.Tool))
+		} else {
+			restored = append(restored, fmt.Sprintf("removed %s (undid %s)", relativeToWorkspace(entry.Path), entry.Tool))
+		}
+	}
+	return strings.Join(restored, "\n"), nil
+}
+
+func (e journalEntry) restore() error {
+	if !e.Existed {
+		err := os.Remove(e.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return writeFileAtomic(e.Path, e.Content, e.Mode)
+}
+
+// undo_edit tool
+
+var UndoEditDefinition = ToolDefinition{
+	Name: "undo_edit",
+	Description: `Revert file changes made by edit_file, write_file, multi_edit and rename_symbol, and directories and projects created with mkdir and scaffold, during this session.
+
+Reverts the most recent change by default. Set count to revert several, or all to revert every change made this session. Changes made with run_shell_command cannot be undone.`,
+	InputSchema: UndoEditInputSchema,
+	Function:    UndoEdit,
+	Preview:     PreviewUndoEdit,
+	Mutating:    alwaysMutating,
+}
+
+type UndoEditInput struct {
+	Count int  `json:"count,omitempty" jsonschema_description:"Optional number of changes to revert, most recent first. Defaults to 1."`
+	All   bool `json:"all,omitempty" jsonschema_description:"Revert every change made this session."`
+}
+
+var UndoEditInputSchema = GenerateSchema[UndoEditInput]()
+
+func UndoEdit(ctx context.Context, input json.RawMessage) (string, error) {
+	undoEditInput := UndoEditInput{}
+	err := json.Unmarshal(input, &undoEditInput)
+	if err != nil {
+		return "", err
+	}
+
+	return toolStateFor(ctx).journal.Undo(undoCount(undoEditInput))
+}
+
+func PreviewUndoEdit(input json.RawMessage) (string, bool) {
+	undoEditInput := UndoEditInput{}
+	err := json.Unmarshal(input, &undoEditInput)
+	if err != nil {
+		return fmt.Sprintf("undo with invalid input: %s", input), true
+	}
+
+	if undoEditInput.All {
+		return "revert every file change made this session", true
+	}
+	return fmt.Sprintf("revert the last %d file change(s)", undoCount(undoEditInput)), true
+}
+
+func undoCount(input UndoEditInput) int {
+	if input.All {
+		return 0
+	}
+	if input.Count <= 0 {
+		return 1
+	}
+	return input.Count
+}
+
+// /undo command
+
+var UndoCommand = SlashCommand{
+	Name:        "undo",
+	Usage:       "[all|N]",
+	Description: "Revert the agent's last file change, the last N, or all of them",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		count := 1
+		switch {
+		case args == "all":
+			count = 0
+		case args != "":
+			_, err := fmt.Sscanf(args, "%d", &count)
+			if err != nil || count <= 0 {
+				return conversation, fmt.Errorf("usage: /undo [all|N]")
+			}
+		}
+
+		summary, err := a.state.journal.Undo(count)
+		if summary != "" {
+			fmt.Printf("\u001b[93msystem\u001b[0m: %s\n", strings.ReplaceAll(summary, "\n", "\n        "))
+		}
+		if err != nil {
+			return conversation, err
+		}
+
+		// Tell the model, so it does not keep working from stale file
+		// contents.
+		conversation = append(conversation, anthropic.NewUserMessage(anthropic.NewTextBlock(
+			fmt.Sprintf("<system>The user reverted file changes:\n%s</system>", summary))))
+		a.saveSession(conversation)
+		return conversation, nil
+	},
+}
@@ -0,0 +1,47 @@
+package agent
+
+import "strings"
+
+// lineFormat is how a file ends its lines, which edits keep so that they
+// change only the lines they are about. The model writes "\n" whatever the
+// file uses, and often drops or adds a newline at the end of the file.
+type lineFormat struct {
+	// crlf is set when every line ends with "\r\n". Files that mix line
+	// endings are edited as they are.
+	crlf bool
+	// finalNewline is set when the file ends with a line ending.
+	finalNewline bool
+}
+
+func detectLineFormat(content string) lineFormat {
+	newlines := strings.Count(content, "\n")
+	return lineFormat{
+		crlf:         newlines > 0 && strings.Count(content, "\r\n") == newlines,
+		finalNewline: strings.HasSuffix(content, "\n"),
+	}
+}
+
+// normalize turns the file's "\r\n" line endings in text, its content or an
+// edit of it, into "\n", the form edits are matched and applied in.
+func (f lineFormat) normalize(text string) string {
+	if !f.crlf {
+		return text
+	}
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// restore turns normalized content back into the file's format, with its
+// line endings and with or without a newline at the end, as it had.
+func (f lineFormat) restore(text string) string {
+	if text != "" && strings.HasSuffix(text, "\n") != f.finalNewline {
+		if f.finalNewline {
+			text += "\n"
+		} else {
+			text = strings.TrimSuffix(text, "\n")
+		}
+	}
+	if f.crlf {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
@@ -0,0 +1,398 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+const (
+	defaultLintTimeout = 5 * time.Minute
+	// maxLintDiagnostics caps how many diagnostics are reported.
+	maxLintDiagnostics = 100
+)
+
+// lint tool
+
+var LintDefinition = ToolDefinition{
+	Name: "lint",
+	Description: `Run the project's linters on changed files and return their findings as structured diagnostics.
+
+Linters are picked by file extension from the ones installed: golangci-lint for Go, eslint for JavaScript and TypeScript, ruff for Python. Without files, every file changed or added since the last commit (according to git status) is linted. Run it after editing and fix what it reports before finishing.`,
+	InputSchema: LintInputSchema,
+	Function:    Lint,
+}
+
+type LintInput struct {
+	Files   []string `json:"files,omitempty" jsonschema_description:"Optional relative paths of the files to lint. Defaults to the files changed since the last commit."`
+	Linters []string `json:"linters,omitempty" jsonschema_description:"Optional linters to run: golangci-lint, eslint or ruff. Defaults to every installed linter that applies."`
+}
+
+var LintInputSchema = GenerateSchema[LintInput]()
+
+type lintDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	Message  string `json:"message"`
+	Linter   string `json:"linter"`
+}
+
+// lintReport is the structured result returned to the model.
+type lintReport struct {
+	Files       []string         `json:"files"`
+	Linters     []string         `json:"linters"`
+	Clean       bool             `json:"clean"`
+	Diagnostics []lintDiagnostic `json:"diagnostics"`
+	// Skipped lists linters that apply but are not installed or failed.
+	Skipped []string `json:"skipped,omitempty"`
+	Summary string   `json:"summary,omitempty"`
+}
+
+// linter knows how to run one linter on a set of files and parse its JSON
+// report. Files are relative to the workspace root, where it runs.
+type linter struct {
+	Name       string
+	Extensions []string
+	Command    func(files []string) []string
+	Parse      func(output []byte) ([]lintDiagnostic, error)
+}
+
+var linters = []linter{
+	{
+		Name:       "golangci-lint",
+		Extensions: []string{".go"},
+		Command: func(files []string) []string {
+			// golangci-lint lints whole packages; findings in files that
+			// were not asked for are filtered out afterwards.
+			var dirs []string
+			for _, file := range files {
+				dir := "./" + filepath.ToSlash(filepath.Dir(file))
+				if !slices.Contains(dirs, dir) {
+					dirs = append(dirs, dir)
+				}
+			}
+			args := []string{"golangci-lint", "run", "--output.json.path=stdout", "--output.text.path=", "--show-stats=false"}
+			if golangciLintV1() {
+				args = []string{"golangci-lint", "run", "--out-format=json"}
+			}
+			return append(args, dirs...)
+		},
+		Parse: parseGolangciLint,
+	},
+	{
+		Name:       "eslint",
+		Extensions: []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".vue"},
+		Command: func(files []string) []string {
+			return append([]string{"eslint", "--format", "json"}, files...)
+		},
+		Parse: parseESLint,
+	},
+	{
+		Name:       "ruff",
+		Extensions: []string{".py", ".pyi"},
+		Command: func(files []string) []string {
+			return append([]string{"ruff", "check", "--output-format", "json", "--quiet"}, files...)
+		},
+		Parse: parseRuff,
+	},
+}
+
+func Lint(ctx context.Context, input json.RawMessage) (string, error) {
+	lintInput := LintInput{}
+	err := json.Unmarshal(input, &lintInput)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range lintInput.Linters {
+		if !slices.ContainsFunc(linters, func(l linter) bool { return l.Name == name }) {
+			return "", fmt.Errorf("unknown linter %q, expected golangci-lint, eslint or ruff", name)
+		}
+	}
+
+	files := lintInput.Files
+	if len(files) == 0 {
+		files, err = changedFiles()
+		if err != nil {
+			return "", err
+		}
+	}
+	for i, file := range files {
+		path, err := resolvePath(file)
+		if err != nil {
+			return "", err
+		}
+		files[i] = relativeToWorkspace(path)
+	}
+
+	report := lintReport{Files: files, Linters: []string{}, Diagnostics: []lintDiagnostic{}}
+	if len(files) == 0 {
+		report.Clean = true
+		report.Summary = "no changed files to lint"
+		return marshalLintReport(report)
+	}
+
+	for _, candidate := range linters {
+		if len(lintInput.Linters) > 0 && !slices.Contains(lintInput.Linters, candidate.Name) {
+			continue
+		}
+		var matched []string
+		for _, file := range files {
+			if slices.Contains(candidate.Extensions, strings.ToLower(filepath.Ext(file))) {
+				matched = append(matched, file)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(candidate.Name); err != nil {
+			report.Skipped = append(report.Skipped, candidate.Name+": not installed")
+			continue
+		}
+
+		diagnostics, err := runLinter(ctx, candidate, matched)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", candidate.Name, err))
+			continue
+		}
+		report.Linters = append(report.Linters, candidate.Name)
+		for _, diagnostic := range diagnostics {
+			if slices.Contains(matched, diagnostic.File) {
+				report.Diagnostics = append(report.Diagnostics, diagnostic)
+			}
+		}
+	}
+
+	sort.SliceStable(report.Diagnostics, func(i, j int) bool {
+		a, b := report.Diagnostics[i], report.Diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	report.Clean = len(report.Diagnostics) == 0
+	switch {
+	case len(report.Linters) == 0:
+		report.Summary = "no linter ran"
+	case len(report.Diagnostics) > maxLintDiagnostics:
+		report.Summary = fmt.Sprintf("showing the first %d of %d diagnostics", maxLintDiagnostics, len(report.Diagnostics))
+		report.Diagnostics = report.Diagnostics[:maxLintDiagnostics]
+	default:
+		report.Summary = fmt.Sprintf("diagnostics: %d", len(report.Diagnostics))
+	}
+	return marshalLintReport(report)
+}
+
+func marshalLintReport(report lintReport) (string, error) {
+	result, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode lint report: %w", err)
+	}
+	return string(result), nil
+}
+
+// runLinter runs l on files. Linters exit non-zero when they find problems,
+// so only output that does not parse counts as a failure.
+func runLinter(ctx context.Context, l linter, files []string) ([]lintDiagnostic, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultLintTimeout)
+	defer cancel()
+
+	args := l.Command(files)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = workspaceRoot
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, toolErrorf(ErrorTimeout, "timed out after %s", defaultLintTimeout)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	diagnostics, parseErr := l.Parse(stdout.Bytes())
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("%w\n%s", err, TruncateOutput(strings.TrimSpace(stderr.String()), 2000))
+		}
+		return nil, parseErr
+	}
+	for i := range diagnostics {
+		diagnostics[i].Linter = l.Name
+		diagnostics[i].File = lintRelativePath(diagnostics[i].File)
+	}
+	return diagnostics, nil
+}
+
+// lintRelativePath maps a linter's file name, absolute or relative to the
+// workspace root, onto the workspace-relative form the tools use.
+func lintRelativePath(file string) string {
+	if !filepath.IsAbs(file) {
+		return filepath.Clean(file)
+	}
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+	}
+	return relativeToWorkspace(file)
+}
+
+// changedFiles lists the files in the workspace that are modified, added or
+// untracked according to git, relative to the workspace root.
+func changedFiles() ([]string, error) {
+	r, err := git.PlainOpenWithOptions(workspaceRoot, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository to find changed files, pass files instead: %w", err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+
+	root := w.Filesystem.Root()
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	var files []string
+	for file, fileStatus := range status {
+		if fileStatus.Worktree == git.Deleted || (fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified) {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if !withinRoot(workspaceRoot, path) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, relativeToWorkspace(path))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// golangciLintV1 reports whether the installed golangci-lint predates v2,
+// which changed the output flags.
+func golangciLintV1() bool {
+	output, err := exec.Command("golangci-lint", "--version").Output()
+	return err == nil && strings.Contains(string(output), "version 1.")
+}
+
+func parseGolangciLint(output []byte) ([]lintDiagnostic, error) {
+	var report struct {
+		Issues []struct {
+			FromLinter string
+			Text       string
+			Severity   string
+			Pos        struct {
+				Filename string
+				Line     int
+				Column   int
+			}
+		}
+	}
+	// v2 may print a text summary after the JSON document.
+	err := json.NewDecoder(bytes.NewReader(output)).Decode(&report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse golangci-lint output: %w", err)
+	}
+	var diagnostics []lintDiagnostic
+	for _, issue := range report.Issues {
+		diagnostics = append(diagnostics, lintDiagnostic{
+			File:     issue.Pos.Filename,
+			Line:     issue.Pos.Line,
+			Column:   issue.Pos.Column,
+			Severity: orDefault(issue.Severity, "warning"),
+			Rule:     issue.FromLinter,
+			Message:  issue.Text,
+		})
+	}
+	return diagnostics, nil
+}
+
+func parseESLint(output []byte) ([]lintDiagnostic, error) {
+	var results []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   string `json:"ruleId"`
+			Severity int    `json:"severity"`
+			Message  string `json:"message"`
+			Line     int    `json:"line"`
+			Column   int    `json:"column"`
+		} `json:"messages"`
+	}
+	err := json.Unmarshal(output, &results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse eslint output: %w", err)
+	}
+	var diagnostics []lintDiagnostic
+	for _, result := range results {
+		for _, message := range result.Messages {
+			severity := "warning"
+			if message.Severity == 2 {
+				severity = "error"
+			}
+			diagnostics = append(diagnostics, lintDiagnostic{
+				File:     result.FilePath,
+				Line:     message.Line,
+				Column:   message.Column,
+				Severity: severity,
+				Rule:     message.RuleID,
+				Message:  message.Message,
+			})
+		}
+	}
+	return diagnostics, nil
+}
+
+func parseRuff(output []byte) ([]lintDiagnostic, error) {
+	var results []struct {
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Filename string `json:"filename"`
+		Location struct {
+			Row    int `json:"row"`
+			Column int `json:"column"`
+		} `json:"location"`
+	}
+	err := json.Unmarshal(output, &results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ruff output: %w", err)
+	}
+	var diagnostics []lintDiagnostic
+	for _, result := range results {
+		diagnostics = append(diagnostics, lintDiagnostic{
+			File:     result.Filename,
+			Line:     result.Location.Row,
+			Column:   result.Location.Column,
+			Severity: "error",
+			Rule:     result.Code,
+			Message:  result.Message,
+		})
+	}
+	return diagnostics, nil
+}
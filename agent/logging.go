@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxLoggedOutput caps how much of each tool result goes into the log file.
+const maxLoggedOutput = 4000
+
+// SetupLogging installs the default logger: readable console output at
+// level, plus a JSON log file per session that records everything down to
+// debug level, such as every API call and tool execution. The returned
+// function closes the log file.
+func SetupLogging(level slog.Level, sessionID string) func() {
+	console := &consoleHandler{level: level, mu: &sync.Mutex{}}
+	slog.SetDefault(slog.New(console))
+
+	path, err := logFilePath(sessionID)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err != nil {
+		slog.Warn("failed to create log directory", "error", err)
+		return func() {}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Warn("failed to open log file", "error", err)
+		return func() {}
+	}
+
+	fileHandler := slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})
+	slog.SetDefault(slog.New(fanoutHandler{console, fileHandler}).With("session", sessionID))
+	return func() { file.Close() }
+}
+
+func logFilePath(sessionID string) (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "logs", sessionID+".jsonl"), nil
+}
+
+// consoleHandler prints records the way the chat prints everything else:
+// a colored level label, the message, then any error and attributes.
+type consoleHandler struct {
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	switch {
+	case record.Level >= slog.LevelError:
+		line.WriteString("\u001b[91merror\u001b[0m: ")
+	case record.Level >= slog.LevelWarn:
+		line.WriteString("\u001b[91mwarning\u001b[0m: ")
+	case record.Level >= slog.LevelInfo:
+		line.WriteString("\u001b[93msystem\u001b[0m: ")
+	default:
+		line.WriteString("\u001b[90mdebug\u001b[0m: ")
+	}
+	line.WriteString(record.Message)
+
+	var errText string
+	var fields []string
+	addAttr := func(attr slog.Attr) bool {
+		// The session is in every record; on the console it is noise.
+		if attr.Key == "session" {
+			return true
+		}
+		if attr.Key == "error" {
+			errText = attr.Value.String()
+			return true
+		}
+		fields = append(fields, attr.Key+"="+formatLogValue(attr.Value))
+		return true
+	}
+	for _, attr := range h.attrs {
+		addAttr(attr)
+	}
+	record.Attrs(addAttr)
+
+	if errText != "" {
+		line.WriteString(": " + errText)
+	}
+	if len(fields) > 0 {
+		line.WriteString(" \u001b[90m" + strings.Join(fields, " ") + "\u001b[0m")
+	}
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// os.Stdout is read at print time because one-shot mode points it at
+	// stderr.
+	_, err := io.WriteString(os.Stdout, line.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &consoleHandler{level: h.level, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...), mu: h.mu}
+}
+
+// WithGroup is not needed by System 3's log calls, so groups are flattened.
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// formatLogValue renders JSON values such as tool inputs as JSON rather than
+// as Go byte slices.
+func formatLogValue(value slog.Value) string {
+	if marshaler, ok := value.Any().(json.Marshaler); ok {
+		if data, err := marshaler.MarshalJSON(); err == nil {
+			return string(data)
+		}
+	}
+	text := value.String()
+	if strings.ContainsAny(text, " \n\"") {
+		return fmt.Sprintf("%q", text)
+	}
+	return text
+}
+
+// fanoutHandler sends each record to every handler that accepts its level.
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range f {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range f {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, handler := range f {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, handler := range f {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
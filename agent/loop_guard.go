@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const (
+	defaultMaxIterations = 50
+	defaultMaxRepeats    = 4
+)
+
+// ErrLoopStopped is returned when the agent stops calling tools because it
+// looks stuck: it took more tool-use turns in a row than allowed, or kept
+// making the same call without anything changing.
+var ErrLoopStopped = errors.New("agent loop stopped")
+
+// loopGuard follows the tool calls made since the user's last message.
+type loopGuard struct {
+	// iterations counts the turns that called tools.
+	iterations int
+	// repeats counts identical calls since the last successful change, so
+	// running the tests again after an edit is not mistaken for a loop.
+	repeats map[string]int
+}
+
+func (g *loopGuard) reset() {
+	g.iterations = 0
+	g.repeats = nil
+}
+
+// checkLoop records a turn's tool calls and their results, returning an
+// ErrLoopStopped error when the run should stop for the user to step in.
+func (a *Agent) checkLoop(message *anthropic.Message, results []anthropic.ContentBlockParamUnion) error {
+	g := &a.loop
+	g.iterations++
+	if a.config.MaxIterations > 0 && g.iterations >= a.config.MaxIterations {
+		return fmt.Errorf("%w after %d tool-use turns in a row (--max-iterations)", ErrLoopStopped, g.iterations)
+	}
+
+	failed := map[string]bool{}
+	for _, result := range results {
+		if block := result.OfRequestToolResultBlock; block != nil {
+			failed[block.ToolUseID] = block.IsError.Value
+		}
+	}
+	if g.repeats == nil {
+		g.repeats = map[string]int{}
+	}
+	var repeated string
+	var repeatedCount int
+	for _, content := range message.Content {
+		if content.Type != "tool_use" {
+			continue
+		}
+		var input bytes.Buffer
+		if json.Compact(&input, content.Input) != nil {
+			input.Write(content.Input)
+		}
+		call := content.Name + "(" + input.String() + ")"
+		g.repeats[call]++
+		if a.config.MaxRepeats > 0 && g.repeats[call] >= a.config.MaxRepeats && repeated == "" {
+			repeated, repeatedCount = call, g.repeats[call]
+		}
+		if tool, ok := a.findTool(content.Name); ok && a.mutating(tool, content.Input) && !failed[content.ID] {
+			// The workspace changed, so calls that repeat from here on may
+			// see something new. The change itself still counts.
+			g.repeats = map[string]int{call: g.repeats[call]}
+		}
+	}
+	if repeated != "" {
+		return fmt.Errorf("%w: the model made the same call %d times without making progress (--max-repeats): %s",
+			ErrLoopStopped, repeatedCount, TruncateOutput(repeated, 200))
+	}
+	return nil
+}
+
+// loopStoppedNotice tells the model why its run of tool calls was cut off,
+// so it can change course when the user replies.
+func loopStoppedNotice(err error) anthropic.ContentBlockParamUnion {
+	return anthropic.NewTextBlock(fmt.Sprintf("<system>%s. Wait for the user before calling more tools; explain where you are stuck or try a different approach.</system>", err))
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// LoopState is the step the agent loop stands at in a session. It is saved
+// with the session after every transition, together with the tool results
+// gathered so far, so a session whose process stopped mid-turn resumes at
+// that step instead of losing the turn or running its tools again.
+type LoopState string
+
+const (
+	// StateAwaitingUser is a conversation the model has answered; the next
+	// step is a message from the user.
+	StateAwaitingUser LoopState = "awaiting_user"
+	// StateAwaitingModel is a conversation ending on a prompt, complete
+	// tool results or a paused reply, which the model has yet to answer.
+	StateAwaitingModel LoopState = "awaiting_model"
+	// StateExecutingTools is a reply whose tool calls have not all run. The
+	// results of those that have follow it as the last message.
+	StateExecutingTools LoopState = "executing_tools"
+)
+
+// deriveLoopState works out the state of a conversation saved without one,
+// or changed by a command such as /retry.
+func deriveLoopState(conversation []anthropic.MessageParam) LoopState {
+	if len(conversation) == 0 {
+		return StateAwaitingUser
+	}
+	last := conversation[len(conversation)-1]
+	if last.Role == anthropic.MessageParamRoleAssistant {
+		if len(toolCallIDs(last)) > 0 {
+			return StateExecutingTools
+		}
+		return StateAwaitingUser
+	}
+	if len(conversation) > 1 && len(unansweredCalls(conversation[len(conversation)-2], last)) > 0 {
+		return StateExecutingTools
+	}
+	return StateAwaitingModel
+}
+
+// toolCallIDs returns the IDs of the tool calls in a reply, leaving out
+// server tool calls, which the API runs itself.
+func toolCallIDs(message anthropic.MessageParam) []string {
+	var ids []string
+	for _, block := range wireBlocks(message) {
+		if block.Type == "tool_use" {
+			ids = append(ids, block.ID)
+		}
+	}
+	return ids
+}
+
+// unansweredCalls returns the IDs of reply's tool calls that results, the
+// message after it, has no result for.
+func unansweredCalls(reply, results anthropic.MessageParam) []string {
+	if reply.Role != anthropic.MessageParamRoleAssistant {
+		return nil
+	}
+	answered := map[string]bool{}
+	for _, block := range results.Content {
+		if result := block.OfRequestToolResultBlock; result != nil {
+			answered[result.ToolUseID] = true
+		}
+	}
+	var unanswered []string
+	for _, id := range toolCallIDs(reply) {
+		if !answered[id] {
+			unanswered = append(unanswered, id)
+		}
+	}
+	return unanswered
+}
+
+func wireBlocks(message anthropic.MessageParam) []wireBlock {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil
+	}
+	var wire wireMessage
+	if json.Unmarshal(data, &wire) != nil {
+		return nil
+	}
+	return wire.Content
+}
+
+// loopState returns the session's state.
+func (a *Agent) loopState() LoopState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.session.State
+}
+
+// saveState moves the session to state and saves it with conversation.
+// runningCall is the ID of the tool call about to run, while executing
+// tools.
+func (a *Agent) saveState(conversation []anthropic.MessageParam, state LoopState, runningCall string) {
+	a.mu.Lock()
+	a.session.State = state
+	a.session.RunningCall = runningCall
+	a.mu.Unlock()
+	a.saveSession(conversation)
+}
+
+// pendingToolCalls returns the reply whose tool calls a session saved in
+// the executing_tools state was running, the conversation up to it and the
+// results gathered before it stopped.
+func pendingToolCalls(conversation []anthropic.MessageParam) ([]anthropic.MessageParam, *anthropic.Message, []anthropic.ContentBlockParamUnion, error) {
+	var results []anthropic.ContentBlockParamUnion
+	if last := len(conversation) - 1; last > 0 && conversation[last].Role == anthropic.MessageParamRoleUser {
+		results = append([]anthropic.ContentBlockParamUnion(nil), conversation[last].Content...)
+		conversation = conversation[:last]
+	}
+	message, err := messageFromJSON(conversation[len(conversation)-1])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return conversation, message, results, nil
+}
+
+// rerunnable reports whether a tool call cut short when the process stopped
+// can run again when the session resumes, because it changes nothing, as
+// read-only mode would allow it.
+func (a *Agent) rerunnable(name string, input json.RawMessage) bool {
+	for _, tool := range a.tools {
+		if tool.Name != name {
+			continue
+		}
+		return readOnlyToolNames[name] && !a.mutating(tool, input)
+	}
+	return false
+}
+
+// stoppedCallResult answers a call that was running when the process
+// stopped and may have partly happened.
+func stoppedCallResult(id, name string) anthropic.ContentBlockParamUnion {
+	slog.Warn("not running a tool call again after the session stopped during it", "tool", name, "id", id)
+	return anthropic.NewToolResultBlock(id, markToolError("system3 stopped while this "+name+" call was running, so it may have partly run; check its effects before calling it again"), true)
+}
@@ -0,0 +1,503 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// lspRequestTimeout bounds a single request. The first request of a
+	// session waits for the server to load the workspace, which can take a
+	// while in large repositories.
+	lspRequestTimeout = 2 * time.Minute
+	// lspShutdownTimeout bounds how long exiting waits for servers to stop.
+	lspShutdownTimeout = 5 * time.Second
+)
+
+// languageServer is a server System 3 knows how to start.
+type languageServer struct {
+	Name       string
+	Command    []string
+	Extensions []string
+	LanguageID string
+}
+
+var languageServers = []languageServer{
+	{Name: "gopls", Command: []string{"gopls", "serve"}, Extensions: []string{".go"}, LanguageID: "go"},
+}
+
+// serverFor returns the language server for path's extension.
+func serverFor(path string) (languageServer, error) {
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, server := range languageServers {
+		if slices.Contains(server.Extensions, extension) {
+			if _, err := exec.LookPath(server.Command[0]); err != nil {
+				return server, fmt.Errorf("%s files need %s, which was not found in PATH", extension, server.Command[0])
+			}
+			return server, nil
+		}
+	}
+	return languageServer{}, fmt.Errorf("no language server for %s files", orDefault(extension, filepath.Base(path)))
+}
+
+// lspClients holds the running language servers, started on first use and
+// kept for the rest of the session.
+var lspClients = &lspClientTable{clients: map[string]*lspClient{}}
+
+type lspClientTable struct {
+	mu      sync.Mutex
+	clients map[string]*lspClient
+}
+
+// get returns the running client for server, starting it when needed.
+func (t *lspClientTable) get(ctx context.Context, server languageServer) (*lspClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, ok := t.clients[server.Name]
+	if ok && !client.exited() {
+		return client, nil
+	}
+	client, err := startLSPClient(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	t.clients[server.Name] = client
+	return client, nil
+}
+
+// StopAll shuts every language server down.
+func (t *lspClientTable) StopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, client := range t.clients {
+		client.shutdown()
+		delete(t.clients, name)
+	}
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return fmt.Sprintf("language server error %d: %s", e.Code, e.Message)
+}
+
+type lspMessage struct {
+	JSONRPC string `json:"jsonrpc"`
+	// ID is a number or a string; servers may use either for their own
+	// requests.
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *lspError       `json:"error,omitempty"`
+}
+
+// lspDocument is the text the server was last sent for an open file.
+type lspDocument struct {
+	Version int
+	Text    string
+}
+
+// lspClient speaks JSON-RPC with one language server over its stdio.
+type lspClient struct {
+	server languageServer
+	cmd    *exec.Cmd
+	// encoding is the negotiated position encoding, "utf-8" or "utf-16".
+	encoding string
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan lspMessage
+	opened  map[string]lspDocument
+
+	done chan struct{}
+}
+
+func startLSPClient(ctx context.Context, server languageServer) (*lspClient, error) {
+	cmd := exec.Command(server.Command[0], server.Command[1:]...)
+	cmd.Dir = workspaceRoot
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", server.Name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", server.Name, err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", server.Name, err)
+	}
+
+	client := &lspClient{
+		server:   server,
+		cmd:      cmd,
+		encoding: "utf-16",
+		stdin:    stdin,
+		pending:  map[int]chan lspMessage{},
+		opened:   map[string]lspDocument{},
+		done:     make(chan struct{}),
+	}
+	go client.readLoop(bufio.NewReader(stdout))
+
+	var initialized struct {
+		Capabilities struct {
+			PositionEncoding string `json:"positionEncoding"`
+		} `json:"capabilities"`
+	}
+	err = client.call(ctx, "initialize", map[string]any{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(workspaceRoot),
+		"workspaceFolders": []map[string]string{
+			{"uri": fileURI(workspaceRoot), "name": filepath.Base(workspaceRoot)},
+		},
+		"capabilities": map[string]any{
+			"general": map[string]any{"positionEncodings": []string{"utf-8", "utf-16"}},
+			"textDocument": map[string]any{
+				"hover":      map[string]any{"contentFormat": []string{"markdown", "plaintext"}},
+				"definition": map[string]any{"linkSupport": false},
+				"rename":     map[string]any{"prepareSupport": false},
+			},
+			"workspace": map[string]any{"workspaceFolders": true, "configuration": true},
+		},
+	}, &initialized)
+	if err != nil {
+		client.kill()
+		return nil, fmt.Errorf("failed to initialize %s: %w", server.Name, err)
+	}
+	if initialized.Capabilities.PositionEncoding == "utf-8" {
+		client.encoding = "utf-8"
+	}
+	err = client.notify("initialized", map[string]any{})
+	if err != nil {
+		client.kill()
+		return nil, err
+	}
+	slog.Debug("started language server", "server", server.Name)
+	return client, nil
+}
+
+func (c *lspClient) exited() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// readLoop delivers responses to their callers and answers the requests
+// servers send to clients, until the server's stdout closes.
+func (c *lspClient) readLoop(reader *bufio.Reader) {
+	defer func() {
+		close(c.done)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+	}()
+
+	for {
+		message, err := readLSPMessage(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Debug("language server connection failed", "server", c.server.Name, "error", err)
+			}
+			return
+		}
+
+		switch {
+		case message.Method != "" && len(message.ID) > 0:
+			c.answerServerRequest(message)
+		case message.Method != "":
+			// Notifications such as diagnostics and progress are not used.
+		case len(message.ID) > 0:
+			id, err := strconv.Atoi(string(message.ID))
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ok {
+				ch <- message
+			}
+		}
+	}
+}
+
+// answerServerRequest gives the minimal answers that keep servers going:
+// default configuration and acceptance of everything else.
+func (c *lspClient) answerServerRequest(request lspMessage) {
+	var result any
+	if request.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		_ = json.Unmarshal(request.Params, &params)
+		result = make([]any, len(params.Items))
+	}
+	resultJSON, _ := json.Marshal(result)
+	_ = c.write(lspMessage{JSONRPC: "2.0", ID: request.ID, Result: resultJSON})
+}
+
+func readLSPMessage(reader *bufio.Reader) (lspMessage, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return lspMessage{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return lspMessage{}, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return lspMessage{}, fmt.Errorf("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	_, err := io.ReadFull(reader, body)
+	if err != nil {
+		return lspMessage{}, err
+	}
+	message := lspMessage{}
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		return lspMessage{}, fmt.Errorf("invalid message: %w", err)
+	}
+	return message, nil
+}
+
+func (c *lspClient) write(message lspMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", message.Method, err)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = fmt.Fprintf(c.stdin, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", c.server.Name, err)
+	}
+	return nil
+}
+
+// call sends a request and decodes its result into result, which may be nil.
+func (c *lspClient) call(ctx context.Context, method string, params, result any) error {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", method, err)
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan lspMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	err = c.write(lspMessage{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: paramsJSON})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lspRequestTimeout)
+	defer cancel()
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("%s exited", c.server.Name)
+		}
+		if response.Error != nil {
+			return response.Error
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(response.Result, result)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%s did not answer %s: %w", c.server.Name, method, ctx.Err())
+	}
+}
+
+func (c *lspClient) notify(method string, params any) error {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", method, err)
+	}
+	return c.write(lspMessage{JSONRPC: "2.0", Method: method, Params: paramsJSON})
+}
+
+// sync sends the server the current content of path, opening it the first
+// time and sending the full text again whenever it changed on disk since.
+// It returns that content.
+func (c *lspClient) sync(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", relativeToWorkspace(path), err)
+	}
+	text := string(content)
+	uri := fileURI(path)
+
+	c.mu.Lock()
+	document, open := c.opened[uri]
+	if open && document.Text == text {
+		c.mu.Unlock()
+		return text, nil
+	}
+	document = lspDocument{Version: document.Version + 1, Text: text}
+	c.opened[uri] = document
+	c.mu.Unlock()
+
+	if !open {
+		err = c.notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": c.server.LanguageID, "version": document.Version, "text": text},
+		})
+	} else {
+		err = c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": document.Version},
+			"contentChanges": []map[string]string{{"text": text}},
+		})
+	}
+	return text, err
+}
+
+func (c *lspClient) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), lspShutdownTimeout)
+	defer cancel()
+	if err := c.call(ctx, "shutdown", nil, nil); err == nil {
+		_ = c.notify("exit", nil)
+	}
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+	c.kill()
+}
+
+func (c *lspClient) kill() {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	_ = c.cmd.Wait()
+}
+
+// position converts a byte offset within line of text into an LSP
+// position in the client's encoding.
+func (c *lspClient) position(line int, lineText string, offset int) lspPosition {
+	if c.encoding == "utf-8" {
+		return lspPosition{Line: line, Character: offset}
+	}
+	return lspPosition{Line: line, Character: len(utf16.Encode([]rune(lineText[:offset])))}
+}
+
+// offset converts an LSP position into a byte offset within text.
+func (c *lspClient) offset(text string, position lspPosition) int {
+	start := 0
+	for i := 0; i < position.Line; i++ {
+		next := strings.IndexByte(text[start:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		start += next + 1
+	}
+	lineText := text[start:]
+	if end := strings.IndexByte(lineText, '\n'); end >= 0 {
+		lineText = lineText[:end]
+	}
+
+	if c.encoding == "utf-8" {
+		return start + min(position.Character, len(lineText))
+	}
+	units := 0
+	for i, r := range lineText {
+		if units >= position.Character {
+			return start + i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return start + len(lineText)
+}
+
+func fileURI(path string) string {
+	slashed := filepath.ToSlash(path)
+	// Windows paths start with a drive letter, which URIs put after a slash.
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+func uriPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return uri
+	}
+	path := parsed.Path
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
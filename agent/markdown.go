@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"golang.org/x/term"
+)
+
+// useMarkdown reports whether replies can be rendered as styled markdown.
+// Dumb terminals, NO_COLOR and redirected output fall back to plain text.
+func useMarkdown(plain bool) bool {
+	if plain || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// markdownPrinter renders streamed markdown one block at a time. Text is
+// buffered until a blank line outside a code fence completes a block, so
+// replies still appear progressively without breaking fences or lists
+// across renders.
+type markdownPrinter struct {
+	renderer *glamour.TermRenderer
+	pending  strings.Builder
+}
+
+func newMarkdownPrinter() (*markdownPrinter, error) {
+	width := 80
+	if columns, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && columns > 0 {
+		width = columns
+	}
+
+	renderer, err := glamour.NewTermRenderer(glamour.WithAutoStyle(), glamour.WithWordWrap(width))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create markdown renderer: %w", err)
+	}
+	return &markdownPrinter{renderer: renderer}, nil
+}
+
+func (p *markdownPrinter) Write(text string) {
+	p.pending.WriteString(text)
+
+	buffered := p.pending.String()
+	end := completeBlocksEnd(buffered)
+	if end == 0 {
+		return
+	}
+
+	if strings.TrimSpace(buffered[:end]) != "" {
+		p.render(buffered[:end])
+	}
+	p.pending.Reset()
+	p.pending.WriteString(buffered[end:])
+}
+
+// Flush renders whatever is left once the reply is complete.
+func (p *markdownPrinter) Flush() {
+	if strings.TrimSpace(p.pending.String()) != "" {
+		p.render(p.pending.String())
+	}
+	p.pending.Reset()
+}
+
+func (p *markdownPrinter) render(markdown string) {
+	rendered, err := p.renderer.Render(markdown)
+	if err != nil {
+		fmt.Print(markdown)
+		return
+	}
+	fmt.Print(strings.Trim(rendered, "\n") + "\n\n")
+}
+
+// completeBlocksEnd returns the length of the longest prefix of text that
+// ends on a blank line outside any code fence, or 0 when there is none.
+func completeBlocksEnd(text string) int {
+	end := 0
+	inFence := false
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		offset += len(line)
+		if !strings.HasSuffix(line, "\n") {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			end = offset
+		}
+	}
+	return end
+}
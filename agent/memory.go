@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// memoryFiles are the project memory files looked for in the workspace
+// root, in order. AGENTS.md is the name other coding agents share.
+var memoryFiles = []string{"SYSTEM3.md", "AGENTS.md"}
+
+// maxMemorySize caps how much project memory goes into the system prompt.
+const maxMemorySize = 40000
+
+// ProjectMemory is the content of the workspace's memory files, included in
+// the system prompt so project conventions carry over between sessions.
+type ProjectMemory struct {
+	// Files lists the memory files found, relative to the workspace.
+	Files   []string
+	Content string
+}
+
+// loadProjectMemory reads every memory file present in the workspace root.
+func loadProjectMemory() (ProjectMemory, error) {
+	memory := ProjectMemory{}
+	var parts []string
+	for _, name := range memoryFiles {
+		content, err := os.ReadFile(filepath.Join(workspaceRoot, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return ProjectMemory{}, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		text := strings.TrimSpace(string(content))
+		if text == "" {
+			continue
+		}
+		memory.Files = append(memory.Files, name)
+		parts = append(parts, fmt.Sprintf("<project_memory file=%q>\n%s\n</project_memory>", name, text))
+	}
+	memory.Content = TruncateOutput(strings.Join(parts, "\n\n"), maxMemorySize)
+	return memory, nil
+}
+
+// prompt returns the system prompt block for the memory, or "" when there is
+// none.
+func (m ProjectMemory) prompt() string {
+	if m.Content == "" {
+		return ""
+	}
+	return "The project keeps notes for you in memory files. Follow the conventions they describe.\n\n" + m.Content
+}
+
+// appendMemory adds entry as a list item to the first memory file that
+// exists, creating SYSTEM3.md when there is none.
+func appendMemory(entry string) (string, error) {
+	name := memoryFiles[0]
+	for _, candidate := range memoryFiles {
+		if _, err := os.Stat(filepath.Join(workspaceRoot, candidate)); err == nil {
+			name = candidate
+			break
+		}
+	}
+	path := filepath.Join(workspaceRoot, name)
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	var addition strings.Builder
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		addition.WriteString("\n")
+	}
+	addition.WriteString("- " + strings.ReplaceAll(strings.TrimSpace(entry), "\n", "\n  ") + "\n")
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(addition.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// /memory command
+
+var MemoryCommand = SlashCommand{
+	Name:        "memory",
+	Usage:       "[add <note>]",
+	Description: "Show the project memory, or add a note to it",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		action, note, _ := strings.Cut(args, " ")
+		switch action {
+		case "":
+			if len(a.memory.Files) == 0 {
+				fmt.Printf("\u001b[93msystem\u001b[0m: no project memory, add notes with /memory add <note> or create %s\n", memoryFiles[0])
+				return conversation, nil
+			}
+			fmt.Printf("\u001b[93msystem\u001b[0m: project memory from %s\n%s\n", strings.Join(a.memory.Files, ", "), a.memory.Content)
+			return conversation, nil
+		case "add":
+			if strings.TrimSpace(note) == "" {
+				return conversation, fmt.Errorf("usage: /memory add <note>")
+			}
+			name, err := appendMemory(note)
+			if err != nil {
+				return conversation, err
+			}
+			memory, err := loadProjectMemory()
+			if err != nil {
+				return conversation, err
+			}
+			a.memory = memory
+			fmt.Printf("\u001b[93msystem\u001b[0m: added to %s\n", name)
+			return conversation, nil
+		default:
+			return conversation, fmt.Errorf("usage: /memory [add <note>]")
+		}
+	},
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mkdir tool
+
+var MkdirDefinition = ToolDefinition{
+	Name: "mkdir",
+	Description: `Create a directory. Set recursive to create missing parent directories too, like mkdir -p.
+
+Use this to lay out a project's structure, including directories that stay empty for now. write_file already creates the parent directories of the files it writes, so there is no need to create them first.`,
+	InputSchema: MkdirInputSchema,
+	Function:    Mkdir,
+	Preview:     PreviewMkdir,
+	Mutating:    alwaysMutating,
+}
+
+type MkdirInput struct {
+	Path      string `json:"path" jsonschema_description:"The relative path of the directory to create."`
+	Recursive bool   `json:"recursive,omitempty" jsonschema_description:"Create missing parent directories too, and succeed if the directory already exists. Defaults to false."`
+}
+
+var MkdirInputSchema = GenerateSchema[MkdirInput]()
+
+func Mkdir(ctx context.Context, input json.RawMessage) (string, error) {
+	mkdirInput := MkdirInput{}
+	err := json.Unmarshal(input, &mkdirInput)
+	if err != nil {
+		return "", err
+	}
+	if mkdirInput.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	path, err := resolvePath(mkdirInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	missing, err := missingDirs(path)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(missing) == 0 && mkdirInput.Recursive:
+		return fmt.Sprintf("%s already exists", mkdirInput.Path), nil
+	case len(missing) == 0:
+		return "", toolErrorf(ErrorInvalidInput, "directory %s already exists", mkdirInput.Path)
+	case len(missing) > 1 && !mkdirInput.Recursive:
+		return "", toolErrorf(ErrorNotFound, "parent directory %s does not exist, set recursive to create it", relativeToWorkspace(filepath.Dir(path)))
+	}
+
+	// Each directory is recorded before it is created, so undo_edit removes
+	// them again, innermost first.
+	var created []string
+	for _, dir := range missing {
+		err = toolStateFor(ctx).journal.Record("mkdir", dir)
+		if err != nil {
+			return "", err
+		}
+		err = os.Mkdir(dir, 0755)
+		if err != nil {
+			return "", fmt.Errorf("failed to create directory %s: %w", relativeToWorkspace(dir), err)
+		}
+		created = append(created, relativeToWorkspace(dir))
+	}
+	return "Created " + strings.Join(created, ", "), nil
+}
+
+// missingDirs returns the directories that creating path takes, outermost
+// first: path itself and any of its parents that do not exist yet. It is
+// empty when path is already a directory.
+func missingDirs(path string) ([]string, error) {
+	var missing []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return nil, toolErrorf(ErrorInvalidInput, "%s exists and is not a directory", relativeToWorkspace(dir))
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		missing = append([]string{dir}, missing...)
+	}
+	return missing, nil
+}
+
+// PreviewMkdir describes the directories a call creates. Creating
+// directories loses nothing, so it is not asked about.
+func PreviewMkdir(input json.RawMessage) (string, bool) {
+	mkdirInput := MkdirInput{}
+	err := json.Unmarshal(input, &mkdirInput)
+	if err != nil {
+		return fmt.Sprintf("mkdir with invalid input: %s", input), true
+	}
+	if mkdirInput.Recursive {
+		return fmt.Sprintf("create directory %s and its missing parents", mkdirInput.Path), false
+	}
+	return fmt.Sprintf("create directory %s", mkdirInput.Path), false
+}
@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// multi_edit tool
+
+var MultiEditDefinition = ToolDefinition{
+	Name: "multi_edit",
+	Description: `Apply several edits across one or more files as a single all-or-nothing change.
+
+Each edit replaces old_str with new_str in its file, like edit_file: old_str must match exactly once unless replace_all is set, and an empty old_str creates a file that does not exist yet. Edits to the same file apply in order, each to the result of the previous one.
+
+Every edit is checked before anything is written; if any of them fails, no file is changed. Use this for refactors that touch several places, so the code is never left half-changed.`,
+	InputSchema: MultiEditInputSchema,
+	Function:    MultiEdit,
+	Preview:     PreviewMultiEdit,
+	Mutating:    alwaysMutating,
+}
+
+type MultiEditInput struct {
+	Edits []MultiEditOperation `json:"edits" jsonschema_description:"The edits to apply, in order."`
+	Force bool                 `json:"force,omitempty" jsonschema_description:"Edit even if files changed since you last read them. Defaults to false; prefer reading them again."`
+}
+
+type MultiEditOperation struct {
+	Path       string `json:"path" jsonschema_description:"The relative path of the file to edit."`
+	OldStr     string `json:"old_str" jsonschema_description:"Text to replace; must match exactly once unless replace_all is set. Empty to create a new file."`
+	NewStr     string `json:"new_str" jsonschema_description:"Text to replace old_str with."`
+	ReplaceAll bool   `json:"replace_all,omitempty" jsonschema_description:"Replace every occurrence of old_str. Defaults to false."`
+}
+
+var MultiEditInputSchema = GenerateSchema[MultiEditInput]()
+
+// pendingFile is a file's new content, staged before any file is written.
+type pendingFile struct {
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+	// edited is the new content with "\n" line endings; it gets the file's
+	// format back when written.
+	edited string
+	format lineFormat
+	edits  int
+	// temporary holds the new content next to the file until it is renamed
+	// into place.
+	temporary string
+}
+
+func MultiEdit(ctx context.Context, input json.RawMessage) (string, error) {
+	multiEditInput := MultiEditInput{}
+	err := json.Unmarshal(input, &multiEditInput)
+	if err != nil {
+		return "", err
+	}
+	if len(multiEditInput.Edits) == 0 {
+		return "", fmt.Errorf("edits is required")
+	}
+
+	// Apply every edit in memory first, so a bad edit fails before anything
+	// is written.
+	var files []*pendingFile
+	byPath := map[string]*pendingFile{}
+	for i, edit := range multiEditInput.Edits {
+		if edit.Path == "" || edit.OldStr == edit.NewStr {
+			return "", fmt.Errorf("edit %d: invalid input parameters", i+1)
+		}
+		path, err := resolvePath(edit.Path)
+		if err != nil {
+			return "", fmt.Errorf("edit %d: %w", i+1, err)
+		}
+
+		file, ok := byPath[path]
+		if !ok {
+			file, err = stageFile(ctx, path, multiEditInput.Force)
+			if err != nil {
+				return "", fmt.Errorf("edit %d: %w", i+1, err)
+			}
+			byPath[path] = file
+			files = append(files, file)
+		}
+
+		switch {
+		case edit.OldStr == "" && (file.existed || file.edits > 0):
+			return "", fmt.Errorf("edit %d: old_str is empty but %s already exists", i+1, edit.Path)
+		case edit.OldStr == "":
+			file.edited = edit.NewStr
+		default:
+			if !file.existed && file.edits == 0 {
+				return "", toolErrorf(ErrorNotFound, "edit %d: %s does not exist", i+1, edit.Path)
+			}
+			oldStr, newStr := file.format.normalize(edit.OldStr), file.format.normalize(edit.NewStr)
+			occurrences := strings.Count(file.edited, oldStr)
+			if occurrences == 0 {
+				return "", toolErrorf(ErrorNotFound, "edit %d: old_str not found in %s", i+1, edit.Path)
+			}
+			if occurrences > 1 && !edit.ReplaceAll {
+				return "", fmt.Errorf("edit %d: old_str found %d times in %s, add surrounding context to make it unique or set replace_all", i+1, occurrences, edit.Path)
+			}
+			file.edited = strings.Replace(file.edited, oldStr, newStr, -1)
+		}
+		file.edits++
+	}
+
+	err = commitFiles(ctx, "multi_edit", files)
+	if err != nil {
+		return "", err
+	}
+
+	var summary []string
+	edits := 0
+	for _, file := range files {
+		edits += file.edits
+		action := "edited"
+		if !file.existed {
+			action = "created"
+		}
+		summary = append(summary, fmt.Sprintf("%s %s (%d edits)", action, relativeToWorkspace(file.path), file.edits))
+	}
+	return fmt.Sprintf("Applied %d edits to %d files:\n%s", edits, len(files), strings.Join(summary, "\n")), nil
+}
+
+// stageFile reads the current content of a file about to be edited.
+func stageFile(ctx context.Context, path string, force bool) (*pendingFile, error) {
+	if !force && toolStateFor(ctx).watched.check(path) {
+		return nil, fmt.Errorf("%w, or set force to edit it anyway", errChangedSinceRead(path))
+	}
+	file := &pendingFile{path: path, mode: newFileMode}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return nil, fmt.Errorf("%s is a directory", relativeToWorkspace(path))
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file.existed = true
+		file.content = content
+		file.mode = info.Mode().Perm()
+		file.format = detectLineFormat(string(content))
+		file.edited = file.format.normalize(string(content))
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	return file, nil
+}
+
+// commitFiles writes every file's new content to a temporary file beside
+// it, then renames them into place. If a rename fails, the files already
+// replaced are restored, so either every file changes or none does. The
+// change is journaled as tool's. Files the permissions file's path rules
+// keep tool from are refused before anything is written.
+func commitFiles(ctx context.Context, tool string, files []*pendingFile) error {
+	for _, file := range files {
+		err := checkWritePath(tool, file.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	removeTemporaries := func() {
+		for _, file := range files {
+			if file.temporary != "" {
+				os.Remove(file.temporary)
+			}
+		}
+	}
+
+	for _, file := range files {
+		dir := filepath.Dir(file.path)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			removeTemporaries()
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		content := file.edited
+		if file.existed {
+			content = file.format.restore(content)
+		}
+		temporary, err := stageFileContent(file.path, []byte(content), file.mode)
+		if err != nil {
+			removeTemporaries()
+			return fmt.Errorf("failed to stage %s: %w", relativeToWorkspace(file.path), err)
+		}
+		file.temporary = temporary
+	}
+
+	for i, file := range files {
+		err := os.Rename(file.temporary, file.path)
+		if err == nil {
+			file.temporary = ""
+			continue
+		}
+		renameErr := fmt.Errorf("failed to write %s: %w", relativeToWorkspace(file.path), err)
+		for _, done := range files[:i] {
+			restoreErr := restorePending(done)
+			if restoreErr != nil {
+				renameErr = errors.Join(renameErr, fmt.Errorf("failed to roll back %s: %w", relativeToWorkspace(done.path), restoreErr))
+			}
+		}
+		removeTemporaries()
+		return renameErr
+	}
+
+	// Only a completed change is recorded, so undo reverts it as a whole.
+	state := toolStateFor(ctx)
+	for _, file := range files {
+		state.journal.add(journalEntry{Tool: tool, Path: file.path, Existed: file.existed, Content: file.content, Mode: file.mode})
+		state.watched.seen(file.path)
+	}
+	return nil
+}
+
+// restorePending puts back a file's original content after its new content
+// was renamed into place.
+func restorePending(file *pendingFile) error {
+	if !file.existed {
+		return os.Remove(file.path)
+	}
+	return writeFileAtomic(file.path, file.content, file.mode)
+}
+
+func PreviewMultiEdit(input json.RawMessage) (string, bool) {
+	multiEditInput := MultiEditInput{}
+	err := json.Unmarshal(input, &multiEditInput)
+	if err != nil {
+		return fmt.Sprintf("multi_edit with invalid input: %s", input), true
+	}
+
+	var previews []string
+	for _, edit := range multiEditInput.Edits {
+		if edit.OldStr == "" {
+			previews = append(previews, fmt.Sprintf("create %s:\n%s", edit.Path, prefixLines(edit.NewStr, "+ ")))
+			continue
+		}
+		previews = append(previews, fmt.Sprintf("edit %s:\n%s\n%s", edit.Path,
+			prefixLines(edit.OldStr, "- "), prefixLines(edit.NewStr, "+ ")))
+	}
+	return strings.Join(previews, "\n\n"), true
+}
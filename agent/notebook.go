@@ -0,0 +1,333 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxNotebookOutput caps each cell's outputs in read_notebook, since a
+// single printed dataframe can be larger than the rest of the notebook.
+const maxNotebookOutput = 2000
+
+// notebook is a Jupyter notebook. Only the cells are interpreted; every
+// other field is kept as it was, so editing a cell leaves the rest of the
+// file untouched.
+type notebook struct {
+	fields map[string]json.RawMessage
+	cells  []notebookCell
+}
+
+// notebookCell is one cell, with its fields kept raw for the same reason.
+type notebookCell map[string]json.RawMessage
+
+func loadNotebook(path string) (*notebook, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	nb := &notebook{}
+	err = json.Unmarshal(content, &nb.fields)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid notebook: %w", relativeToWorkspace(path), err)
+	}
+	if cells, ok := nb.fields["cells"]; ok {
+		err = json.Unmarshal(cells, &nb.cells)
+		if err != nil {
+			return nil, fmt.Errorf("%s has invalid cells: %w", relativeToWorkspace(path), err)
+		}
+	}
+	return nb, nil
+}
+
+// encode serializes the notebook the way Jupyter saves it, with sorted keys,
+// indented by one space and with a trailing newline, so diffs only show the
+// edited cell.
+func (nb *notebook) encode() ([]byte, error) {
+	nb.fields["cells"] = notebookJSON(nb.cells)
+	var content bytes.Buffer
+	err := json.Indent(&content, notebookJSON(nb.fields), "", " ")
+	if err != nil {
+		return nil, err
+	}
+	content.WriteString("\n")
+	return content.Bytes(), nil
+}
+
+// notebookJSON marshals value without escaping HTML characters, as Jupyter
+// does, so markup in cells is not rewritten as \u003c escapes.
+func notebookJSON(value any) json.RawMessage {
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return bytes.TrimSuffix(content.Bytes(), []byte("\n"))
+}
+
+// string decodes a string field of the cell, returning "" when it is absent.
+func (cell notebookCell) string(field string) string {
+	var value string
+	json.Unmarshal(cell[field], &value)
+	return value
+}
+
+// source returns the cell's source, which notebooks store either as one
+// string or as a list of lines.
+func (cell notebookCell) source() string {
+	return multilineString(cell["source"])
+}
+
+// setSource stores source as a list of lines, as Jupyter does.
+func (cell notebookCell) setSource(source string) {
+	lines := []string{}
+	for _, line := range strings.SplitAfter(source, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	cell["source"] = notebookJSON(lines)
+}
+
+func multilineString(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var lines []string
+	json.Unmarshal(raw, &lines)
+	return strings.Join(lines, "")
+}
+
+// outputs renders the cell's outputs as text, naming the rich ones that
+// cannot be shown.
+func (cell notebookCell) outputs() string {
+	var outputs []map[string]json.RawMessage
+	json.Unmarshal(cell["outputs"], &outputs)
+
+	var rendered []string
+	for _, output := range outputs {
+		outputCell := notebookCell(output)
+		switch outputCell.string("output_type") {
+		case "stream":
+			rendered = append(rendered, strings.TrimSuffix(multilineString(output["text"]), "\n"))
+		case "error":
+			rendered = append(rendered, fmt.Sprintf("%s: %s", outputCell.string("ename"), outputCell.string("evalue")))
+		case "execute_result", "display_data":
+			var data map[string]json.RawMessage
+			json.Unmarshal(output["data"], &data)
+			if text, ok := data["text/plain"]; ok {
+				rendered = append(rendered, strings.TrimSuffix(multilineString(text), "\n"))
+				continue
+			}
+			var types []string
+			for mimeType := range data {
+				types = append(types, mimeType)
+			}
+			sort.Strings(types)
+			rendered = append(rendered, fmt.Sprintf("[%s output]", strings.Join(types, ", ")))
+		}
+	}
+	return TruncateOutput(strings.Join(rendered, "\n"), maxNotebookOutput)
+}
+
+// read_notebook tool
+
+var ReadNotebookDefinition = ToolDefinition{
+	Name: "read_notebook",
+	Description: `Read a Jupyter notebook (.ipynb) as a list of numbered cells with their type, source and outputs, rather than its raw JSON.
+
+Use the cell numbers with edit_notebook to change a cell.`,
+	InputSchema: ReadNotebookInputSchema,
+	Function:    ReadNotebook,
+}
+
+type ReadNotebookInput struct {
+	Path           string `json:"path" jsonschema_description:"The relative path of the notebook."`
+	IncludeOutputs *bool  `json:"include_outputs,omitempty" jsonschema_description:"Show the outputs of code cells. Defaults to true."`
+}
+
+var ReadNotebookInputSchema = GenerateSchema[ReadNotebookInput]()
+
+func ReadNotebook(ctx context.Context, input json.RawMessage) (string, error) {
+	readInput := ReadNotebookInput{}
+	err := json.Unmarshal(input, &readInput)
+	if err != nil {
+		return "", err
+	}
+	path, err := resolvePath(readInput.Path)
+	if err != nil {
+		return "", err
+	}
+	nb, err := loadNotebook(path)
+	if err != nil {
+		return "", err
+	}
+	toolStateFor(ctx).watched.seen(path)
+	if len(nb.cells) == 0 {
+		return "The notebook has no cells", nil
+	}
+
+	var cells []string
+	for i, cell := range nb.cells {
+		cellType := cell.string("cell_type")
+		header := fmt.Sprintf("--- cell %d [%s] ---", i, cellType)
+		if count := string(cell["execution_count"]); cellType == "code" && count != "" && count != "null" {
+			header = fmt.Sprintf("--- cell %d [%s, run %s] ---", i, cellType, count)
+		}
+		text := header + "\n" + cell.source()
+		if readInput.IncludeOutputs == nil || *readInput.IncludeOutputs {
+			if outputs := cell.outputs(); outputs != "" {
+				text += "\n--- output ---\n" + outputs
+			}
+		}
+		cells = append(cells, text)
+	}
+	return strings.Join(cells, "\n\n"), nil
+}
+
+// edit_notebook tool
+
+var EditNotebookDefinition = ToolDefinition{
+	Name: "edit_notebook",
+	Description: `Change one cell of a Jupyter notebook (.ipynb) by its number from read_notebook, keeping the rest of the notebook intact.
+
+Modes:
+- replace (default): set the source of cell; its outputs are cleared, as they no longer match
+- insert: add a new cell of cell_type before cell, or at the end when cell equals the number of cells
+- delete: remove cell
+
+Use this instead of edit_file on notebooks, whose JSON is easy to corrupt by hand.`,
+	InputSchema: EditNotebookInputSchema,
+	Function:    EditNotebook,
+	Preview:     PreviewEditNotebook,
+	Mutating:    alwaysMutating,
+}
+
+type EditNotebookInput struct {
+	Path     string `json:"path" jsonschema_description:"The relative path of the notebook."`
+	Cell     int    `json:"cell" jsonschema_description:"The 0-based number of the cell, as shown by read_notebook."`
+	Mode     string `json:"mode,omitempty" jsonschema_description:"replace, insert or delete. Defaults to replace."`
+	Source   string `json:"source,omitempty" jsonschema_description:"The new source of the cell, for replace and insert."`
+	CellType string `json:"cell_type,omitempty" jsonschema_description:"code, markdown or raw. Defaults to code for insert and to the cell's current type for replace."`
+	Force    bool   `json:"force,omitempty" jsonschema_description:"Edit even if the notebook changed since you last read it. Defaults to false; prefer reading it again."`
+}
+
+var EditNotebookInputSchema = GenerateSchema[EditNotebookInput]()
+
+func EditNotebook(ctx context.Context, input json.RawMessage) (string, error) {
+	editInput := EditNotebookInput{}
+	err := json.Unmarshal(input, &editInput)
+	if err != nil {
+		return "", err
+	}
+	path, err := resolvePath(editInput.Path)
+	if err != nil {
+		return "", err
+	}
+	if !editInput.Force && toolStateFor(ctx).watched.check(path) {
+		return "", fmt.Errorf("%w, or set force to edit it anyway", errChangedSinceRead(path))
+	}
+	nb, err := loadNotebook(path)
+	if err != nil {
+		return "", err
+	}
+	switch editInput.CellType {
+	case "", "code", "markdown", "raw":
+	default:
+		return "", toolErrorf(ErrorInvalidInput, "unknown cell_type %q, expected code, markdown or raw", editInput.CellType)
+	}
+
+	mode := orDefault(editInput.Mode, "replace")
+	last := len(nb.cells) - 1
+	if mode == "insert" {
+		last++
+	}
+	if editInput.Cell < 0 || editInput.Cell > last {
+		return "", toolErrorf(ErrorInvalidInput, "cell %d does not exist, the notebook has %d cells", editInput.Cell, len(nb.cells))
+	}
+
+	var result string
+	switch mode {
+	case "replace":
+		cell := nb.cells[editInput.Cell]
+		if editInput.CellType != "" {
+			cell["cell_type"] = notebookJSON(editInput.CellType)
+		}
+		cell.setSource(editInput.Source)
+		shapeCell(cell)
+		result = fmt.Sprintf("Replaced cell %d of %s", editInput.Cell, editInput.Path)
+	case "insert":
+		cell := newNotebookCell(nb, orDefault(editInput.CellType, "code"))
+		cell.setSource(editInput.Source)
+		nb.cells = slices.Insert(nb.cells, editInput.Cell, cell)
+		result = fmt.Sprintf("Inserted cell %d into %s, the cells after it moved down by one", editInput.Cell, editInput.Path)
+	case "delete":
+		nb.cells = slices.Delete(nb.cells, editInput.Cell, editInput.Cell+1)
+		result = fmt.Sprintf("Deleted cell %d of %s, the cells after it moved up by one", editInput.Cell, editInput.Path)
+	default:
+		return "", toolErrorf(ErrorInvalidInput, "unknown mode %q, expected replace, insert or delete", mode)
+	}
+
+	content, err := nb.encode()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode notebook: %w", err)
+	}
+	err = toolStateFor(ctx).journal.Record("edit_notebook", path)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(path, content, fileMode(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to write notebook: %w", err)
+	}
+	toolStateFor(ctx).watched.seen(path)
+	return result, nil
+}
+
+// newNotebookCell returns an empty cell of cellType. Notebooks from format
+// 4.5 on give every cell an id.
+func newNotebookCell(nb *notebook, cellType string) notebookCell {
+	cell := notebookCell{"metadata": json.RawMessage("{}")}
+	cell["cell_type"] = notebookJSON(cellType)
+	var minor int
+	json.Unmarshal(nb.fields["nbformat_minor"], &minor)
+	if minor >= 5 {
+		id := make([]byte, 4)
+		rand.Read(id)
+		cell["id"] = notebookJSON(hex.EncodeToString(id))
+	}
+	shapeCell(cell)
+	return cell
+}
+
+// shapeCell gives a cell the fields its type requires, clearing the outputs
+// of code cells, and drops those it may not have.
+func shapeCell(cell notebookCell) {
+	if cell.string("cell_type") == "code" {
+		cell["outputs"] = json.RawMessage("[]")
+		cell["execution_count"] = json.RawMessage("null")
+		return
+	}
+	delete(cell, "outputs")
+	delete(cell, "execution_count")
+}
+
+func PreviewEditNotebook(input json.RawMessage) (string, bool) {
+	editInput := EditNotebookInput{}
+	err := json.Unmarshal(input, &editInput)
+	if err != nil {
+		return fmt.Sprintf("edit_notebook with invalid input: %s", input), true
+	}
+	mode := orDefault(editInput.Mode, "replace")
+	if mode == "delete" {
+		return fmt.Sprintf("delete cell %d of %s", editInput.Cell, editInput.Path), true
+	}
+	return fmt.Sprintf("%s cell %d of %s:\n%s", mode, editInput.Cell, editInput.Path, prefixLines(editInput.Source, "+ ")), true
+}
@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxOutlineSymbols caps the outline of generated or enormous files.
+	maxOutlineSymbols = 500
+	// maxSignatureLength keeps long parameter lists readable.
+	maxSignatureLength = 160
+)
+
+// outlineLanguage describes which syntax nodes of a language are symbols.
+// Files are parsed with tree-sitter, which needs cgo; builds without it
+// know the languages but cannot parse them.
+type outlineLanguage struct {
+	Name       string
+	Extensions []string
+	// Symbols maps node types to the keyword their signature is prefixed
+	// with, when the node's own text does not start with one.
+	Symbols map[string]string
+}
+
+var javascriptSymbols = map[string]string{
+	"function_declaration":           "",
+	"generator_function_declaration": "",
+	"class_declaration":              "",
+	"method_definition":              "",
+	"variable_declarator":            "",
+}
+
+var typescriptSymbols = map[string]string{
+	"function_declaration":           "",
+	"generator_function_declaration": "",
+	"class_declaration":              "",
+	"abstract_class_declaration":     "",
+	"method_definition":              "",
+	"method_signature":               "",
+	"variable_declarator":            "",
+	"interface_declaration":          "",
+	"type_alias_declaration":         "",
+	"enum_declaration":               "",
+}
+
+var outlineLanguages = []outlineLanguage{
+	{Name: "Go", Extensions: []string{".go"}, Symbols: map[string]string{
+		"function_declaration": "",
+		"method_declaration":   "",
+		"type_spec":            "type ",
+		"method_elem":          "",
+		"method_spec":          "",
+	}},
+	{Name: "Python", Extensions: []string{".py", ".pyi"}, Symbols: map[string]string{
+		"function_definition": "",
+		"class_definition":    "",
+	}},
+	{Name: "JavaScript", Extensions: []string{".js", ".jsx", ".mjs", ".cjs"}, Symbols: javascriptSymbols},
+	{Name: "TypeScript", Extensions: []string{".ts", ".mts", ".cts"}, Symbols: typescriptSymbols},
+	{Name: "TSX", Extensions: []string{".tsx"}, Symbols: typescriptSymbols},
+	{Name: "Rust", Extensions: []string{".rs"}, Symbols: map[string]string{
+		"function_item":           "",
+		"function_signature_item": "",
+		"struct_item":             "",
+		"enum_item":               "",
+		"union_item":              "",
+		"trait_item":              "",
+		"impl_item":               "",
+		"mod_item":                "",
+		"type_item":               "",
+		"macro_definition":        "",
+	}},
+}
+
+// outlineLanguageFor returns the language for path's extension.
+func outlineLanguageFor(path string) (outlineLanguage, bool) {
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, language := range outlineLanguages {
+		for _, candidate := range language.Extensions {
+			if candidate == extension {
+				return language, true
+			}
+		}
+	}
+	return outlineLanguage{}, false
+}
+
+// get_outline tool
+
+var GetOutlineDefinition = ToolDefinition{
+	Name: "get_outline",
+	Description: `List the symbols defined in a source file (functions, methods, types, classes and interfaces) with their line ranges, without reading the whole file.
+
+Each line is "start-end signature", indented under the symbol it is nested in. Use it to find your way around a large file, then read_file with start_line and end_line to read just the parts you need. Supports Go, Python, JavaScript, TypeScript and Rust.`,
+	InputSchema: GetOutlineInputSchema,
+	Function:    GetOutline,
+}
+
+type GetOutlineInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of the source file to outline."`
+}
+
+var GetOutlineInputSchema = GenerateSchema[GetOutlineInput]()
+
+func GetOutline(ctx context.Context, input json.RawMessage) (string, error) {
+	outlineInput := GetOutlineInput{}
+	err := json.Unmarshal(input, &outlineInput)
+	if err != nil {
+		return "", err
+	}
+	if outlineInput.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+
+	path, err := resolvePath(outlineInput.Path)
+	if err != nil {
+		return "", err
+	}
+	outline, err := parseOutline(path)
+	if err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf("%s (%s, %d lines)", outlineInput.Path, outline.Language, outline.Lines)
+	if outline.HasError {
+		header += "\nThe file has syntax errors; the outline may be incomplete."
+	}
+	if len(outline.Symbols) == 0 {
+		return header + "\nNo symbols found.", nil
+	}
+	var symbols []string
+	for i, symbol := range outline.Symbols {
+		if i == maxOutlineSymbols {
+			symbols = append(symbols, fmt.Sprintf("... and %d more", len(outline.Symbols)-i))
+			break
+		}
+		symbols = append(symbols, strings.Repeat("  ", symbol.Depth)+symbol.String())
+	}
+	return header + "\n" + strings.Join(symbols, "\n"), nil
+}
+
+// outlineSymbol is a definition found in a file.
+type outlineSymbol struct {
+	Name      string
+	Signature string
+	// Depth counts the symbols this one is nested in.
+	Depth     int
+	StartLine int
+	EndLine   int
+}
+
+// String formats the symbol as its 1-based line range and signature.
+func (s outlineSymbol) String() string {
+	if s.StartLine == s.EndLine {
+		return fmt.Sprintf("%d %s", s.StartLine, s.Signature)
+	}
+	return fmt.Sprintf("%d-%d %s", s.StartLine, s.EndLine, s.Signature)
+}
+
+type fileOutline struct {
+	Language string
+	Lines    int
+	HasError bool
+	Symbols  []outlineSymbol
+	// Identifiers counts the names the file uses, definitions included.
+	Identifiers map[string]int
+}
@@ -0,0 +1,175 @@
+//go:build cgo
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/rust"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+// grammars are the tree-sitter grammars of the outline languages, by name.
+var grammars = map[string]func() *sitter.Language{
+	"Go":         golang.GetLanguage,
+	"Python":     python.GetLanguage,
+	"JavaScript": javascript.GetLanguage,
+	"TypeScript": typescript.GetLanguage,
+	"TSX":        tsx.GetLanguage,
+	"Rust":       rust.GetLanguage,
+}
+
+// parseOutline parses path with tree-sitter and collects its symbols and
+// the identifiers it uses.
+func parseOutline(path string) (fileOutline, error) {
+	language, ok := outlineLanguageFor(path)
+	if !ok {
+		return fileOutline{}, fmt.Errorf("cannot outline %s files; supported languages are Go, Python, JavaScript, TypeScript and Rust", orDefault(filepath.Ext(path), filepath.Base(path)))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileOutline{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	root, err := sitter.ParseCtx(context.Background(), content, grammars[language.Name]())
+	if err != nil {
+		return fileOutline{}, fmt.Errorf("failed to parse %s: %w", relativeToWorkspace(path), err)
+	}
+
+	outline := fileOutline{
+		Language:    language.Name,
+		Lines:       strings.Count(string(content), "\n"),
+		HasError:    root.HasError(),
+		Identifiers: map[string]int{},
+	}
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		outline.Lines++
+	}
+
+	var visit func(node *sitter.Node, depth int)
+	visit = func(node *sitter.Node, depth int) {
+		if strings.HasSuffix(node.Type(), "identifier") && node.NamedChildCount() == 0 {
+			outline.Identifiers[node.Content(content)]++
+		}
+		childDepth := depth
+		if prefix, ok := language.Symbols[node.Type()]; ok && isOutlineSymbol(node) {
+			outline.Symbols = append(outline.Symbols, outlineSymbol{
+				Name:      symbolName(node, content),
+				Signature: prefix + symbolSignature(node, content),
+				Depth:     depth,
+				StartLine: int(node.StartPoint().Row) + 1,
+				EndLine:   int(node.EndPoint().Row) + 1,
+			})
+			childDepth++
+		}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			visit(node.NamedChild(i), childDepth)
+		}
+	}
+	visit(root, 0)
+	return outline, nil
+}
+
+// isOutlineSymbol filters variable declarations down to the ones that bind
+// a function, such as const handler = () => {}.
+func isOutlineSymbol(node *sitter.Node) bool {
+	if node.Type() != "variable_declarator" {
+		return true
+	}
+	value := node.ChildByFieldName("value")
+	if value == nil {
+		return false
+	}
+	switch value.Type() {
+	case "arrow_function", "function", "function_expression", "generator_function", "class":
+		return true
+	}
+	return false
+}
+
+// symbolName is the name a symbol is referred to by, or "" for symbols
+// such as Rust impl blocks that have none.
+func symbolName(node *sitter.Node, content []byte) string {
+	for _, field := range []string{"name", "type"} {
+		if name := node.ChildByFieldName(field); name != nil && name.NamedChildCount() == 0 {
+			return name.Content(content)
+		}
+	}
+	return ""
+}
+
+// symbolSignature is the text of node up to its body, on one line. A
+// declarator starts at its declaration, so the const or let is kept.
+func symbolSignature(node *sitter.Node, content []byte) string {
+	start, end := node.StartByte(), node.EndByte()
+	if node.Type() == "variable_declarator" {
+		if parent := node.Parent(); parent != nil {
+			start = parent.StartByte()
+		}
+		node = node.ChildByFieldName("value")
+	}
+	if body := node.ChildByFieldName("body"); body != nil {
+		end = body.StartByte()
+	}
+	signature := string(content[start:end])
+	if node.ChildByFieldName("body") == nil {
+		// Types and the like have no body field; keep their first line.
+		signature, _, _ = strings.Cut(signature, "\n")
+	}
+	signature = strings.Join(strings.Fields(signature), " ")
+	signature = strings.TrimSpace(strings.TrimRight(signature, "{:=; "))
+	if len(signature) > maxSignatureLength {
+		signature = signature[:maxSignatureLength] + "..."
+	}
+	return signature
+}
+
+// memberExpressions are the node types that select a member of a value,
+// such as Go's x.Field or Python's obj.attribute, by language.
+var memberExpressions = map[string]bool{
+	"selector_expression": true,
+	"attribute":           true,
+	"member_expression":   true,
+	"field_expression":    true,
+}
+
+// fileIdentifiers parses content and returns where each identifier is used,
+// by name. Strings and comments hold no identifier nodes, so they are left
+// out.
+func fileIdentifiers(content []byte, language outlineLanguage) (map[string][]syntaxIdentifier, error) {
+	root, err := sitter.ParseCtx(context.Background(), content, grammars[language.Name]())
+	if err != nil {
+		return nil, err
+	}
+	identifiers := map[string][]syntaxIdentifier{}
+	var visit func(node *sitter.Node, member bool)
+	visit = func(node *sitter.Node, member bool) {
+		if strings.HasSuffix(node.Type(), "identifier") && node.NamedChildCount() == 0 {
+			name := node.Content(content)
+			identifiers[name] = append(identifiers[name], syntaxIdentifier{
+				start:  node.StartByte(),
+				end:    node.EndByte(),
+				line:   int(node.StartPoint().Row) + 1,
+				column: int(node.StartPoint().Column) + 1,
+				kind:   node.Type(),
+				member: member,
+			})
+		}
+		count := int(node.NamedChildCount())
+		for i := 0; i < count; i++ {
+			// The member is the last child, after the value it is selected
+			// from.
+			visit(node.NamedChild(i), memberExpressions[node.Type()] && i > 0 && i == count-1)
+		}
+	}
+	visit(root, false)
+	return identifiers, nil
+}
@@ -0,0 +1,24 @@
+//go:build !cgo
+
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// errNoSyntaxParser is returned where tree-sitter would parse a file: its
+// grammars are C, so builds without cgo cannot outline files or rename by
+// syntax.
+var errNoSyntaxParser = toolErrorf(ErrorFailed, "parsing source files is not available in this build of system3, which was built without cgo")
+
+func parseOutline(path string) (fileOutline, error) {
+	if _, ok := outlineLanguageFor(path); !ok {
+		return fileOutline{}, fmt.Errorf("cannot outline %s files; supported languages are Go, Python, JavaScript, TypeScript and Rust", orDefault(filepath.Ext(path), filepath.Base(path)))
+	}
+	return fileOutline{}, errNoSyntaxParser
+}
+
+func fileIdentifiers(content []byte, language outlineLanguage) (map[string][]syntaxIdentifier, error) {
+	return nil, errNoSyntaxParser
+}
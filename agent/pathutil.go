@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Paths in tool inputs, results and rules are relative to the workspace and
+// separated by slashes on every platform, as the model writes them. The
+// helpers in this file are where they are converted to and from the
+// operating system's own paths, so tools agree on both forms on Windows too.
+
+// normalizeToolPath cleans a path from a tool input into a slash-separated
+// path. Backslashes count as separators as well, so Windows-style paths
+// work everywhere; "" and "." are the workspace root.
+func normalizeToolPath(toolPath string) string {
+	toolPath = strings.ReplaceAll(strings.TrimSpace(toolPath), `\`, "/")
+	return path.Clean(toolPath)
+}
+
+// resolvePath maps a path given to a tool onto an absolute path inside the
+// workspace. Absolute paths, ".." traversal and symlinks pointing outside
+// the workspace are rejected. An empty path is the workspace root itself.
+func resolvePath(toolPath string) (string, error) {
+	root := workspaceRoot
+	if root == "" {
+		err := SetWorkspaceRoot(".")
+		if err != nil {
+			return "", err
+		}
+		root = workspaceRoot
+	}
+
+	normalized := normalizeToolPath(toolPath)
+	native := filepath.FromSlash(normalized)
+	if path.IsAbs(normalized) || hasDriveLetter(normalized) || filepath.VolumeName(native) != "" {
+		return "", toolErrorf(ErrorInvalidInput, "path %s must be relative to the workspace", toolPath)
+	}
+
+	joined := filepath.Join(root, native)
+	if !withinRoot(root, joined) {
+		return "", toolErrorf(ErrorPermissionDenied, "path %s is outside the workspace", toolPath)
+	}
+
+	// Resolve symlinks in the longest existing prefix; the rest of the path
+	// does not exist yet and so cannot be a link.
+	existing, rest := joined, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			existing = resolved
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to resolve %s: %w", toolPath, err)
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	if !withinRoot(root, existing) {
+		return "", toolErrorf(ErrorPermissionDenied, "path %s resolves outside the workspace", toolPath)
+	}
+
+	return filepath.Join(existing, rest), nil
+}
+
+// hasDriveLetter reports whether a slash-separated path starts with a
+// Windows drive such as C:, which makes it absolute, or relative to another
+// directory than the workspace, on any platform the model might assume.
+func hasDriveLetter(toolPath string) bool {
+	return len(toolPath) >= 2 && toolPath[1] == ':' &&
+		('a' <= toolPath[0] && toolPath[0] <= 'z' || 'A' <= toolPath[0] && toolPath[0] <= 'Z')
+}
+
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// slashRel returns target relative to base, separated by slashes.
+func slashRel(base, target string) (string, error) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// relativeToWorkspace shortens an absolute path inside the workspace for
+// display, as the slash-separated path a tool would be given. Paths outside
+// the workspace are left as they are.
+func relativeToWorkspace(path string) string {
+	if workspaceRoot == "" || !withinRoot(workspaceRoot, path) {
+		return path
+	}
+	rel, err := slashRel(workspaceRoot, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// matchesAnyGlob reports whether relPath matches one of the patterns. A
+// pattern without a slash is matched against the base name, and a trailing
+// "/**" matches everything under a directory. Matching is on slashes, as
+// filepath.Match would let * cross "/" on Windows.
+func matchesAnyGlob(relPath string, patterns []string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if relPath == prefix || strings.HasPrefix(relPath, prefix+"/") {
+				return true
+			}
+			continue
+		}
+
+		target := relPath
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(relPath)
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// workspacePathsInError names the file an error from the os package is
+// about by its workspace path, as the model gave it, rather than by its
+// absolute path.
+func workspacePathsInError(err error) string {
+	message := err.Error()
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && filepath.IsAbs(pathErr.Path) {
+		message = strings.ReplaceAll(message, pathErr.Path, relativeToWorkspace(pathErr.Path))
+	}
+	return message
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectPermissionsFile holds the repo-local tool permissions, relative to
+// the working directory.
+var projectPermissionsFile = filepath.Join(".system3", "permissions.yaml")
+
+// Permissions is the parsed permissions file:
+//
+//	tools:
+//	  git: ask
+//	  run_shell_command: deny
+//	paths:
+//	  allow: ["src/**", "docs/**"]
+//	  deny: [".env", "secrets/**"]
+//	shell:
+//	  allow: ["go test *", "go build *"]
+//	  deny: ["sudo *"]
+//	domains:
+//	  allow: ["pkg.go.dev", "*.github.com"]
+//	redact:
+//	  - 'internal-token-[a-z0-9]{32}'
+//	secret_files:
+//	  allow: [".env.test"]
+//	  deny: ["config/master.key"]
+type Permissions struct {
+	// Tools sets a policy per tool name.
+	Tools map[string]Policy `yaml:"tools"`
+	// Paths restricts the paths tools may touch. When Allow is set, paths
+	// must match one of its globs; paths matching Deny are always refused.
+	Paths PatternRules `yaml:"paths"`
+	// Shell lists commands the shell tool runs without asking (Allow) or
+	// refuses outright (Deny). A * matches any run of characters.
+	Shell PatternRules `yaml:"shell"`
+	// Domains limits the hosts fetch_url may contact. When Allow is set,
+	// hosts must be one of its domains or a subdomain; Deny always refuses.
+	Domains PatternRules `yaml:"domains"`
+	// Redact adds regular expressions for secrets to remove from tool
+	// results, besides the built-in ones for keys and tokens.
+	Redact []string `yaml:"redact"`
+	// SecretFiles adjusts the files whose contents are never read: Deny
+	// adds globs to the built-in list of .env files, keys and credentials,
+	// and Allow exempts files from it.
+	SecretFiles PatternRules `yaml:"secret_files"`
+}
+
+type PatternRules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// LoadPermissions reads the permissions file at path. A missing file yields
+// empty permissions, which leave every tool on the default ask policy. The
+// file comes with the repository, so rules that let calls run without
+// asking or read secret files only apply once the user trusts it; the rules
+// that restrict always do.
+func LoadPermissions(path string) (Permissions, error) {
+	permissions := Permissions{}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return permissions, nil
+	}
+	if err != nil {
+		return permissions, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	err = yaml.Unmarshal(content, &permissions)
+	if err != nil {
+		return permissions, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for tool, policy := range permissions.Tools {
+		switch policy {
+		case PolicyAllow, PolicyAsk, PolicyDeny:
+		default:
+			return permissions, fmt.Errorf("%s: invalid policy %q for tool %s, expected allow, ask or deny", path, policy, tool)
+		}
+	}
+	_, err = newRedactor(permissions.Redact)
+	if err != nil {
+		return permissions, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if permissions.loosens() {
+		trusted, err := trustProjectFile(path, content, "allow rules", "let tools and commands run and secret files be read without asking")
+		if err != nil {
+			return permissions, err
+		}
+		if !trusted {
+			permissions.tighten()
+		}
+	}
+	return permissions, nil
+}
+
+// loosens reports whether the permissions allow anything the defaults ask
+// about or refuse.
+func (p Permissions) loosens() bool {
+	for _, policy := range p.Tools {
+		if policy == PolicyAllow {
+			return true
+		}
+	}
+	return len(p.Shell.Allow) > 0 || len(p.SecretFiles.Allow) > 0
+}
+
+// tighten drops the rules that allow more than the defaults, keeping those
+// that restrict.
+func (p *Permissions) tighten() {
+	for tool, policy := range p.Tools {
+		if policy == PolicyAllow {
+			delete(p.Tools, tool)
+		}
+	}
+	p.Shell.Allow = nil
+	p.SecretFiles.Allow = nil
+}
+
+// pathRules are the path rules from the permissions file, set at setup.
+// The approval gate checks the paths named in a call's input; tools that
+// find the files they write while running, such as rename_symbol, check
+// each of those with checkWritePath.
+var pathRules PatternRules
+
+// checkPaths refuses calls whose path arguments fall outside the configured
+// path rules.
+func (p Permissions) checkPaths(tool string, input json.RawMessage) error {
+	if len(p.Paths.Allow) == 0 && len(p.Paths.Deny) == 0 {
+		return nil
+	}
+
+	for _, path := range toolPaths(input) {
+		err := checkPath(p.Paths, tool, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWritePath refuses path, an absolute path inside the workspace, when
+// the permissions file's path rules do not let tool access it.
+func checkWritePath(tool, path string) error {
+	if len(pathRules.Allow) == 0 && len(pathRules.Deny) == 0 {
+		return nil
+	}
+	relPath, err := slashRel(workspaceRoot, path)
+	if err != nil {
+		return err
+	}
+	return checkPath(pathRules, tool, relPath)
+}
+
+// checkPath refuses path, relative to the workspace, when rules do not let
+// tool access it.
+func checkPath(rules PatternRules, tool, path string) error {
+	if matchesAnyGlob(path, rules.Deny) {
+		return toolErrorf(ErrorPermissionDenied, "%s may not access %s: path is denied by permissions", tool, path)
+	}
+	if len(rules.Allow) > 0 && !matchesAnyGlob(path, rules.Allow) {
+		return toolErrorf(ErrorPermissionDenied, "%s may not access %s: path is not in the permissions allowlist", tool, path)
+	}
+	return nil
+}
+
+// toolPaths extracts the workspace paths a tool call refers to. Tools name
+// their path arguments "path" or "working_dir", at the top level or, as in
+// multi_edit, in each of their edits.
+func toolPaths(input json.RawMessage) []string {
+	var fields map[string]any
+	if json.Unmarshal(input, &fields) != nil {
+		return nil
+	}
+
+	objects := []map[string]any{fields}
+	if edits, ok := fields["edits"].([]any); ok {
+		for _, edit := range edits {
+			if object, ok := edit.(map[string]any); ok {
+				objects = append(objects, object)
+			}
+		}
+	}
+	var paths []string
+	for _, object := range objects {
+		for _, key := range []string{"path", "working_dir"} {
+			value, ok := object[key].(string)
+			if !ok || value == "" {
+				continue
+			}
+			path := normalizeToolPath(value)
+			if path != "." {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// shellOperators are the characters that chain, substitute or redirect
+// commands. A command containing any of them never matches an allow rule, so
+// "go test *" cannot be stretched into "go test ./... && rm -rf ~".
+const shellOperators = ";&|`$<>\n"
+
+// commandPolicy returns the policy the shell rules give command, or "" when
+// no rule matches.
+func (p Permissions) commandPolicy(command string) Policy {
+	command = strings.TrimSpace(command)
+	for _, pattern := range p.Shell.Deny {
+		if matchCommand(pattern, command) {
+			return PolicyDeny
+		}
+	}
+	if strings.ContainsAny(command, shellOperators) {
+		return ""
+	}
+	for _, pattern := range p.Shell.Allow {
+		if matchCommand(pattern, command) {
+			return PolicyAllow
+		}
+	}
+	return ""
+}
+
+// matchCommand reports whether the whole command matches pattern, where *
+// stands for any run of characters.
+func matchCommand(pattern, command string) bool {
+	parts := strings.Split(strings.TrimSpace(pattern), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(command)
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const planModePrompt = `Plan mode is on. Tools that would change files, repositories or run commands are not executed; they return a description of what they would have done instead. Read and search freely, then lay out the complete set of changes as a step-by-step plan using those tool calls. The user will review the plan and turn plan mode off before anything is executed.`
+
+// planResult answers a mutating call made in plan mode with what it would
+// have done.
+func planResult(tool ToolDefinition, input json.RawMessage) string {
+	description := fmt.Sprintf("%s(%s)", tool.Name, input)
+	if tool.Preview != nil {
+		description, _ = tool.Preview(input)
+	}
+	return fmt.Sprintf("Plan mode: this call was not executed and nothing was changed. It would:\n%s", description)
+}
+
+// /plan command
+
+var PlanCommand = SlashCommand{
+	Name:        "plan",
+	Usage:       "[on|off]",
+	Description: "Toggle plan mode, where changes are described instead of made",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		switch strings.ToLower(args) {
+		case "":
+			a.planMode = !a.planMode
+		case "on":
+			a.planMode = true
+		case "off":
+			a.planMode = false
+		default:
+			return conversation, fmt.Errorf("usage: /plan [on|off]")
+		}
+
+		if a.planMode {
+			fmt.Println("\u001b[93msystem\u001b[0m: plan mode on, changes will be described instead of made")
+		} else {
+			fmt.Println("\u001b[93msystem\u001b[0m: plan mode off, ask the model to carry out the plan")
+		}
+		return conversation, nil
+	},
+}
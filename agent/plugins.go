@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Plugins add tools written in any language. Every executable in
+// ~/.system3/plugins (%APPDATA%\system3\plugins on Windows) is a plugin;
+// it is started once per request, reads a single JSON request from stdin
+// and writes a single JSON response to stdout, with the workspace root as
+// its working directory.
+//
+// At startup System 3 sends {"method":"describe"} and expects
+//
+//	{"name": "...", "description": "...", "input_schema": {"properties": {...}}, "mutating": false}
+//
+// For each call it sends {"method":"invoke","input":{...}} and expects
+// {"result": "..."} or {"error": "..."}. Mutating plugins are described in
+// plan mode instead of run and ask for approval like other changes.
+
+const (
+	// pluginDescribeTimeout bounds how long a plugin may take to describe
+	// itself at startup.
+	pluginDescribeTimeout = 10 * time.Second
+	// pluginInvokeTimeout bounds a single plugin call.
+	pluginInvokeTimeout = 5 * time.Minute
+)
+
+type pluginRequest struct {
+	Method string          `json:"method"`
+	Input  json.RawMessage `json:"input,omitempty"`
+}
+
+type pluginDescription struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema struct {
+		Properties map[string]any `json:"properties"`
+	} `json:"input_schema"`
+	Mutating bool `json:"mutating"`
+}
+
+type pluginResponse struct {
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+func pluginsDir() (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// LoadPlugins describes every plugin in the plugins directory and returns
+// their tools. Plugins that fail to describe themselves or whose name is
+// already taken are skipped with a warning.
+func LoadPlugins(existing []ToolDefinition) []ToolDefinition {
+	dir, err := pluginsDir()
+	if err != nil {
+		slog.Warn("failed to load plugins", "error", err)
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		slog.Warn("failed to load plugins", "error", err)
+		return nil
+	}
+
+	taken := map[string]bool{}
+	for _, tool := range existing {
+		taken[tool.Name] = true
+	}
+
+	var tools []ToolDefinition
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || !isExecutable(info) {
+			continue
+		}
+
+		tool, err := loadPlugin(path)
+		if err != nil {
+			slog.Warn("skipping plugin", "plugin", entry.Name(), "error", err)
+			continue
+		}
+		if taken[tool.Name] {
+			slog.Warn("skipping plugin", "plugin", entry.Name(), "error", fmt.Errorf("tool %s already exists", tool.Name))
+			continue
+		}
+		taken[tool.Name] = true
+		tools = append(tools, tool)
+		slog.Info("loaded plugin", "plugin", entry.Name(), "tool", tool.Name)
+	}
+	return tools
+}
+
+// loadPlugin asks the plugin at path to describe itself and builds its tool.
+func loadPlugin(path string) (ToolDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+	defer cancel()
+
+	output, err := callPlugin(ctx, path, pluginRequest{Method: "describe"})
+	if err != nil {
+		return ToolDefinition{}, err
+	}
+	description := pluginDescription{}
+	err = json.Unmarshal(output, &description)
+	if err != nil {
+		return ToolDefinition{}, fmt.Errorf("invalid describe response: %w", err)
+	}
+	if description.Name == "" || description.Description == "" {
+		return ToolDefinition{}, fmt.Errorf("describe response needs a name and a description")
+	}
+
+	tool := ToolDefinition{
+		Name:        description.Name,
+		Description: description.Description,
+		InputSchema: anthropic.ToolInputSchemaParam{Properties: description.InputSchema.Properties},
+		Function: func(ctx context.Context, input json.RawMessage) (string, error) {
+			return invokePlugin(ctx, path, input)
+		},
+	}
+	if description.Mutating {
+		tool.Mutating = alwaysMutating
+		tool.Preview = func(input json.RawMessage) (string, bool) {
+			return fmt.Sprintf("Run plugin %s with %s", filepath.Base(path), input), true
+		}
+	}
+	return tool, nil
+}
+
+func invokePlugin(ctx context.Context, path string, input json.RawMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pluginInvokeTimeout)
+	defer cancel()
+
+	output, err := callPlugin(ctx, path, pluginRequest{Method: "invoke", Input: input})
+	if err != nil {
+		return "", err
+	}
+	response := pluginResponse{}
+	err = json.Unmarshal(output, &response)
+	if err != nil {
+		return "", fmt.Errorf("invalid plugin response: %w", err)
+	}
+	if response.Error != "" {
+		return "", errors.New(response.Error)
+	}
+	return TruncateOutput(response.Result, maxShellOutput), nil
+}
+
+// callPlugin runs the plugin with request on stdin and returns its stdout.
+func callPlugin(ctx context.Context, path string, request pluginRequest) ([]byte, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode plugin request: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = workspaceRoot
+	cmd.Stdin = bytes.NewReader(append(payload, '\n'))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, toolErrorf(ErrorTimeout, "plugin %s timed out", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %w\n%s", filepath.Base(path), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// isExecutable reports whether a plugins directory entry can be run. Windows
+// has no execute bit, so the extension decides there.
+func isExecutable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode().Perm()&0111 != 0
+}
@@ -0,0 +1,388 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxProcesses caps how many background processes may run at once.
+	maxProcesses = 10
+	// processLogSize is how many bytes of recent output each process keeps.
+	processLogSize = 256 * 1024
+	// startupWait is how long start_process waits to catch commands that
+	// fail immediately.
+	startupWait    = time.Second
+	stopGrace      = 5 * time.Second
+	defaultLogTail = 100
+)
+
+// backgroundProcess is a long-running command started by start_process.
+type backgroundProcess struct {
+	ID      int
+	Command string
+	Dir     string
+	Started time.Time
+
+	cmd *exec.Cmd
+	// container is the sandbox container the command runs in, if any.
+	container string
+	logs      *ringBuffer
+	done      chan struct{}
+	// exitCode and waitErr are set once done is closed.
+	exitCode int
+	waitErr  error
+}
+
+func (p *backgroundProcess) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *backgroundProcess) status() string {
+	if p.running() {
+		return fmt.Sprintf("running for %s", time.Since(p.Started).Round(time.Second))
+	}
+	if p.waitErr != nil {
+		return fmt.Sprintf("exited: %v", p.waitErr)
+	}
+	return fmt.Sprintf("exited with code %d", p.exitCode)
+}
+
+// processTable tracks the background processes of an agent.
+type processTable struct {
+	mu        sync.Mutex
+	nextID    int
+	processes map[int]*backgroundProcess
+}
+
+func (t *processTable) start(command, dir string) (*backgroundProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	running := 0
+	for _, p := range t.processes {
+		if p.running() {
+			running++
+		}
+	}
+	if running >= maxProcesses {
+		return nil, fmt.Errorf("%d background processes are already running; stop one first", running)
+	}
+
+	logs := newRingBuffer(processLogSize)
+	cmd, container := shellCommand(context.Background(), command, dir)
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	t.nextID++
+	p := &backgroundProcess{
+		ID:        t.nextID,
+		Command:   command,
+		Dir:       dir,
+		Started:   time.Now(),
+		cmd:       cmd,
+		container: container,
+		logs:      logs,
+		done:      make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+			p.exitCode = exitErr.ExitCode()
+		default:
+			p.waitErr = err
+		}
+		close(p.done)
+	}()
+
+	t.processes[p.ID] = p
+	return p, nil
+}
+
+func (t *processTable) get(id int) (*backgroundProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.processes[id]
+	if !ok {
+		return nil, fmt.Errorf("no background process with id %d", id)
+	}
+	return p, nil
+}
+
+func (t *processTable) list() []*backgroundProcess {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var list []*backgroundProcess
+	for _, p := range t.processes {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// stop asks the process and its children to terminate, killing them if
+// they are still running after stopGrace.
+func (t *processTable) stop(p *backgroundProcess) {
+	if !p.running() {
+		return
+	}
+	terminateProcess(p.cmd.Process)
+	select {
+	case <-p.done:
+	case <-time.After(stopGrace):
+		killProcess(p.cmd.Process)
+		<-p.done
+		removeContainer(p.container)
+	}
+}
+
+// StopAll stops every running process. It is called on exit so servers do
+// not outlive the session.
+func (t *processTable) StopAll() {
+	var wg sync.WaitGroup
+	for _, p := range t.list() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.stop(p)
+		}()
+	}
+	wg.Wait()
+}
+
+// ringBuffer keeps the most recent output of a process. Offsets count every
+// byte ever written, so readers can ask for output since their last read.
+type ringBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	size    int
+	written int64
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	b.written += int64(len(p))
+	// Compact only once the buffer holds twice its size, to avoid copying
+	// on every write.
+	if len(b.data) > 2*b.size {
+		b.data = append([]byte(nil), b.data[len(b.data)-b.size:]...)
+	}
+	return len(p), nil
+}
+
+// since returns the output written after offset, the offset to read from
+// next time, and whether older output had already been dropped.
+func (b *ringBuffer) since(offset int64) (string, int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := b.data
+	if len(data) > b.size {
+		data = data[len(data)-b.size:]
+	}
+	start := b.written - int64(len(data))
+	dropped := offset < start
+	if dropped {
+		offset = start
+	}
+	if offset > b.written {
+		offset = b.written
+	}
+	return string(data[offset-start:]), b.written, dropped
+}
+
+// tail returns the last lines of output and the current offset.
+func (b *ringBuffer) tail(lines int) (string, int64) {
+	output, offset, _ := b.since(0)
+	output = strings.TrimRight(output, "\n")
+	all := strings.Split(output, "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n"), offset
+}
+
+// start_process tool
+
+var StartProcessDefinition = ToolDefinition{
+	Name: "start_process",
+	Description: `Start a long-running command, such as a dev server or file watcher, in the background.
+
+Returns the process id and any output from its first second. Use process_logs to read its output later and stop_process to stop it. Processes are stopped when System 3 exits. Use run_shell_command instead for commands that finish on their own.`,
+	InputSchema: StartProcessInputSchema,
+	Function:    StartProcess,
+	Preview:     PreviewStartProcess,
+	Mutating:    alwaysMutating,
+	Command:     ShellToolCommand,
+}
+
+type StartProcessInput struct {
+	Command    string `json:"command" jsonschema_description:"The shell command to run in the background"`
+	WorkingDir string `json:"working_dir,omitempty" jsonschema_description:"Optional relative directory to run the command in. Defaults to the workspace root."`
+}
+
+var StartProcessInputSchema = GenerateSchema[StartProcessInput]()
+
+func StartProcess(ctx context.Context, input json.RawMessage) (string, error) {
+	startInput := StartProcessInput{}
+	err := json.Unmarshal(input, &startInput)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(startInput.Command) == "" {
+		return "", fmt.Errorf("command is required")
+	}
+	dir, err := resolvePath(startInput.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := toolStateFor(ctx).processes.start(startInput.Command, dir)
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(startupWait):
+	}
+	output, offset := p.logs.tail(defaultLogTail)
+	return fmt.Sprintf("process %d (pid %d) %s, output offset %d\n%s",
+		p.ID, p.cmd.Process.Pid, p.status(), offset, output), nil
+}
+
+func PreviewStartProcess(input json.RawMessage) (string, bool) {
+	startInput := StartProcessInput{}
+	err := json.Unmarshal(input, &startInput)
+	if err != nil {
+		return fmt.Sprintf("start a process with invalid input: %s", input), true
+	}
+	return fmt.Sprintf("start in the background in %s:\n$ %s", orDefault(startInput.WorkingDir, "."), startInput.Command), true
+}
+
+// stop_process tool
+
+var StopProcessDefinition = ToolDefinition{
+	Name:        "stop_process",
+	Description: `Stop a background process started with start_process, along with any processes it spawned, and return its last output.`,
+	InputSchema: StopProcessInputSchema,
+	Function:    StopProcess,
+	Mutating:    alwaysMutating,
+}
+
+type StopProcessInput struct {
+	ID int `json:"id" jsonschema_description:"The id start_process returned"`
+}
+
+var StopProcessInputSchema = GenerateSchema[StopProcessInput]()
+
+func StopProcess(ctx context.Context, input json.RawMessage) (string, error) {
+	stopInput := StopProcessInput{}
+	err := json.Unmarshal(input, &stopInput)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := toolStateFor(ctx).processes.get(stopInput.ID)
+	if err != nil {
+		return "", err
+	}
+	toolStateFor(ctx).processes.stop(p)
+
+	output, _ := p.logs.tail(defaultLogTail)
+	return fmt.Sprintf("process %d %s\n%s", p.ID, p.status(), output), nil
+}
+
+// process_logs tool
+
+var ProcessLogsDefinition = ToolDefinition{
+	Name: "process_logs",
+	Description: `Read the output of a background process, or list all background processes when no id is given.
+
+Each result reports an output offset; pass it back as since to read only the output produced after that point.`,
+	InputSchema: ProcessLogsInputSchema,
+	Function:    ProcessLogs,
+}
+
+type ProcessLogsInput struct {
+	ID    int   `json:"id,omitempty" jsonschema_description:"The id start_process returned. Omit to list all background processes."`
+	Lines int   `json:"lines,omitempty" jsonschema_description:"Number of most recent lines to return. Defaults to 100. Ignored when since is set."`
+	Since int64 `json:"since,omitempty" jsonschema_description:"Return only output after this offset, as reported by an earlier call"`
+}
+
+var ProcessLogsInputSchema = GenerateSchema[ProcessLogsInput]()
+
+func ProcessLogs(ctx context.Context, input json.RawMessage) (string, error) {
+	logsInput := ProcessLogsInput{}
+	err := json.Unmarshal(input, &logsInput)
+	if err != nil {
+		return "", err
+	}
+
+	if logsInput.ID == 0 {
+		processes := toolStateFor(ctx).processes.list()
+		if len(processes) == 0 {
+			return "No background processes", nil
+		}
+		var lines []string
+		for _, p := range processes {
+			lines = append(lines, fmt.Sprintf("%d: %s (%s) in %s", p.ID, p.Command, p.status(), relativeToWorkspace(p.Dir)))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	p, err := toolStateFor(ctx).processes.get(logsInput.ID)
+	if err != nil {
+		return "", err
+	}
+
+	var output, note string
+	var offset int64
+	if logsInput.Since > 0 {
+		var dropped bool
+		output, offset, dropped = p.logs.since(logsInput.Since)
+		if dropped {
+			note = " (older output was dropped)"
+		}
+	} else {
+		lines := logsInput.Lines
+		if lines <= 0 {
+			lines = defaultLogTail
+		}
+		output, offset = p.logs.tail(lines)
+	}
+
+	return fmt.Sprintf("process %d %s, output offset %d%s\n%s",
+		p.ID, p.status(), offset, note, TruncateOutput(output, maxShellOutput)), nil
+}
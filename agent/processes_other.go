@@ -0,0 +1,19 @@
+//go:build !unix
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Without process groups only the command itself can be stopped.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcess(process *os.Process) {
+	_ = process.Kill()
+}
+
+func killProcess(process *os.Process) {
+	_ = process.Kill()
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so stopping
+// it also stops whatever it spawned, like the server behind "npm run dev".
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminateProcess(process *os.Process) {
+	_ = syscall.Kill(-process.Pid, syscall.SIGTERM)
+}
+
+func killProcess(process *os.Process) {
+	_ = syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Prompt templates are reusable prompts for recurring tasks, one markdown
+// file each in .system3/prompts of the workspace, such as fix-tests.md:
+//
+//	Fix the failing tests in {{FILE}}. Run them first, then change the
+//	code rather than the tests unless a test is clearly wrong.
+//
+// `/prompt fix-tests FILE=main.go` fills in the variables and sends the
+// result as the next message. The first line of a template describes it in
+// `/prompt` listings.
+
+// projectPromptsDir holds the prompt templates, relative to the workspace.
+var projectPromptsDir = filepath.Join(".system3", "prompts")
+
+// promptVariable matches a {{NAME}} placeholder, spaces inside the braces
+// allowed.
+var promptVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_-]*)\s*\}\}`)
+
+type promptTemplate struct {
+	name string
+	text string
+}
+
+func promptsDir() string {
+	return filepath.Join(workspaceRoot, projectPromptsDir)
+}
+
+// promptTemplates returns the workspace's templates by name.
+func promptTemplates() ([]promptTemplate, error) {
+	paths, err := filepath.Glob(filepath.Join(promptsDir(), "*.md"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompt templates: %w", err)
+	}
+	sort.Strings(paths)
+
+	var templates []promptTemplate
+	for _, path := range paths {
+		template, err := loadPromptTemplate(strings.TrimSuffix(filepath.Base(path), ".md"))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func loadPromptTemplate(name string) (promptTemplate, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return promptTemplate{}, fmt.Errorf("invalid prompt template name %q", name)
+	}
+	content, err := os.ReadFile(filepath.Join(promptsDir(), name+".md"))
+	if os.IsNotExist(err) {
+		return promptTemplate{}, fmt.Errorf("prompt template %s not found in %s, see /prompt for the available ones", name, projectPromptsDir)
+	}
+	if err != nil {
+		return promptTemplate{}, fmt.Errorf("failed to read prompt template %s: %w", name, err)
+	}
+	return promptTemplate{name: name, text: strings.TrimSpace(string(content))}, nil
+}
+
+// description is the template's first line, without a markdown heading
+// marker.
+func (t promptTemplate) description() string {
+	line, _, _ := strings.Cut(t.text, "\n")
+	return strings.TrimSpace(strings.TrimLeft(line, "#"))
+}
+
+// variables returns the names of the template's variables in the order
+// they first appear.
+func (t promptTemplate) variables() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, match := range promptVariable.FindAllStringSubmatch(t.text, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// render fills in the template's variables. Every variable needs a value,
+// and every value a variable, so typos are caught before anything is sent.
+func (t promptTemplate) render(values map[string]string) (string, error) {
+	variables := t.variables()
+	var missing []string
+	used := map[string]bool{}
+	for _, name := range variables {
+		used[name] = true
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name+"=...")
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("prompt template %s needs %s", t.name, strings.Join(missing, " "))
+	}
+	for name := range values {
+		if !used[name] {
+			return "", fmt.Errorf("prompt template %s has no variable %s, it uses: %s", t.name, name, orDefault(strings.Join(variables, ", "), "none"))
+		}
+	}
+
+	return promptVariable.ReplaceAllStringFunc(t.text, func(placeholder string) string {
+		return values[promptVariable.FindStringSubmatch(placeholder)[1]]
+	}), nil
+}
+
+// parsePromptArgs splits `/prompt` arguments into the template name and its
+// NAME=value pairs. Values with spaces are quoted as in a shell.
+func parsePromptArgs(args string) (string, map[string]string, error) {
+	words := splitWords(args)
+	values := map[string]string{}
+	for _, word := range words[1:] {
+		name, value, ok := strings.Cut(word, "=")
+		if !ok || name == "" {
+			return "", nil, fmt.Errorf("invalid variable %q, expected NAME=value", word)
+		}
+		values[name] = value
+	}
+	return words[0], values, nil
+}
+
+// /prompt command
+
+var PromptCommand = SlashCommand{
+	Name:        "prompt",
+	Usage:       "[name] [NAME=value...]",
+	Description: "Send a prompt template from .system3/prompts with its variables filled in, or list the templates",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		if args == "" {
+			templates, err := promptTemplates()
+			if err != nil {
+				return conversation, err
+			}
+			if len(templates) == 0 {
+				fmt.Printf("\u001b[93msystem\u001b[0m: no prompt templates, add markdown files to %s\n", projectPromptsDir)
+				return conversation, nil
+			}
+			for _, template := range templates {
+				usage := template.name
+				for _, name := range template.variables() {
+					usage += " " + name + "=..."
+				}
+				fmt.Printf("  %-30s %s\n", usage, template.description())
+			}
+			return conversation, nil
+		}
+
+		name, values, err := parsePromptArgs(args)
+		if err != nil {
+			return conversation, err
+		}
+		template, err := loadPromptTemplate(name)
+		if err != nil {
+			return conversation, err
+		}
+		prompt, err := template.render(values)
+		if err != nil {
+			return conversation, err
+		}
+
+		fmt.Printf("\u001b[94mYou\u001b[0m (%s): %s\n", template.name, prompt)
+		// The chat answers the conversation's new prompt once the command
+		// returns.
+		return append(conversation, a.newPrompt(prompt)), nil
+	},
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// Provider sends a conversation to a language model backend.
+//
+// Anthropic's request and response types are the lingua franca: providers
+// for other APIs translate messages, tool schemas and responses to and from
+// them, so the rest of the agent never deals with backend-specific formats.
+type Provider interface {
+	// Name identifies the backend in the startup banner.
+	Name() string
+	// SendMessage returns the model's complete reply.
+	SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error)
+	// StreamMessage returns the model's complete reply like SendMessage,
+	// calling onText with each piece of text as it is generated and
+	// onThinking with each piece of extended thinking. Backends without
+	// extended thinking never call onThinking.
+	StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error)
+}
+
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+)
+
+// NewProvider builds the provider selected in config, or the one replaying
+// or recording the fixture it names.
+func NewProvider(config Config) (Provider, error) {
+	if config.Replay != "" {
+		return NewReplayProvider(config.Replay)
+	}
+	provider, err := newBackendProvider(config)
+	if err != nil || config.Record == "" {
+		return provider, err
+	}
+	return NewRecordingProvider(provider, config.Record)
+}
+
+func newBackendProvider(config Config) (Provider, error) {
+	switch config.Provider {
+	case ProviderAnthropic:
+		var options []option.RequestOption
+		if slices.Contains(config.ServerTools, ServerToolCodeExecution) {
+			options = append(options, option.WithHeaderAdd("anthropic-beta", codeExecutionBeta))
+		}
+		client := anthropic.NewClient(options...)
+		return &AnthropicProvider{client: &client}, nil
+	case ProviderOpenAI:
+		return NewOpenAIProvider(config.BaseURL, config.APIKey), nil
+	case ProviderOllama:
+		return NewOllamaProvider(config.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, expected %s, %s or %s", config.Provider, ProviderAnthropic, ProviderOpenAI, ProviderOllama)
+	}
+}
+
+// AnthropicProvider talks to the Anthropic Messages API.
+type AnthropicProvider struct {
+	client *anthropic.Client
+}
+
+func (p *AnthropicProvider) Name() string {
+	return ProviderAnthropic
+}
+
+func (p *AnthropicProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	return p.client.Messages.New(ctx, withPromptCaching(params))
+}
+
+func (p *AnthropicProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	stream := p.client.Messages.NewStreaming(ctx, withPromptCaching(params))
+	defer stream.Close()
+
+	message := anthropic.Message{}
+	// Accumulate rebuilds each block from the fields the SDK knows when it
+	// ends, which drops the content of server tool results, so they are
+	// kept as they arrived.
+	serverResults := map[int]string{}
+	for stream.Next() {
+		event := stream.Current()
+		err := message.Accumulate(event)
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := event.AsAny().(anthropic.ContentBlockStartEvent); ok && isServerToolResult(start.ContentBlock.Type) {
+			serverResults[len(message.Content)-1] = start.ContentBlock.RawJSON()
+		}
+
+		if delta, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+			if delta.Delta.Text != "" {
+				onText(delta.Delta.Text)
+			}
+			if delta.Delta.Thinking != "" {
+				onThinking(delta.Delta.Thinking)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	for i, raw := range serverResults {
+		err := message.Content[i].UnmarshalJSON([]byte(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s block: %w", message.Content[i].Type, err)
+		}
+	}
+
+	return &message, nil
+}
+
+// messageFromJSON builds an anthropic.Message from its API JSON form. Going
+// through JSON is the only way to construct response types that behave
+// correctly with the SDK's ToParam conversions.
+func messageFromJSON(value any) (*anthropic.Message, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &anthropic.Message{}
+	err = json.Unmarshal(content, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const defaultOllamaBaseURL = "http://localhost:11434"
+
+// OllamaProvider talks to a local Ollama server through its native chat API,
+// so the agent can run fully offline.
+type OllamaProvider struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewOllamaProvider(baseURL string) *OllamaProvider {
+	if baseURL == "" {
+		baseURL = defaultOllamaBaseURL
+	}
+	return &OllamaProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (p *OllamaProvider) Name() string {
+	return ProviderOllama
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int64    `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// ollamaToolCall differs from OpenAI's format: there is no call ID and the
+// arguments are a JSON object rather than an encoded string.
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (p *OllamaProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	return p.StreamMessage(ctx, params, nil, nil)
+}
+
+func (p *OllamaProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	request, err := toOllamaRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	request.Stream = onText != nil
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	response, err := p.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s (is `ollama serve` running?): %w", p.baseURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("%s returned %s: %s", p.baseURL, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	// Streaming and non-streaming responses share a shape; a non-streaming
+	// response is simply a single final chunk.
+	var text strings.Builder
+	var toolCalls []ollamaToolCall
+	final := ollamaResponse{}
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		chunk := ollamaResponse{}
+		err := json.Unmarshal(line, &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			if onText != nil {
+				onText(chunk.Message.Content)
+			}
+		}
+		toolCalls = append(toolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			final = chunk
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var calls []openAIToolCall
+	for _, call := range toolCalls {
+		calls = append(calls, openAIToolCall{
+			Function: openAIFunctionCall{Name: call.Function.Name, Arguments: string(call.Function.Arguments)},
+		})
+	}
+
+	finishReason := "stop"
+	if final.DoneReason == "length" {
+		finishReason = "length"
+	}
+	return fromOpenAIResponse("", final.Model, text.String(), calls, finishReason, final.PromptEvalCount, final.EvalCount)
+}
+
+// toOllamaRequest translates Anthropic request params into an Ollama chat
+// request.
+func toOllamaRequest(params anthropic.MessageNewParams) (ollamaRequest, error) {
+	request := ollamaRequest{
+		Model: params.Model,
+		Options: ollamaOptions{
+			NumPredict: params.MaxTokens,
+		},
+	}
+	if params.Temperature.IsPresent() {
+		request.Options.Temperature = &params.Temperature.Value
+	}
+	if params.TopP.IsPresent() {
+		request.Options.TopP = &params.TopP.Value
+	}
+
+	var system []string
+	for _, block := range params.System {
+		system = append(system, block.Text)
+	}
+	if len(system) > 0 {
+		request.Messages = append(request.Messages, ollamaMessage{Role: "system", Content: strings.Join(system, "\n\n")})
+	}
+
+	for _, message := range params.Messages {
+		var text []string
+		var images []string
+		var toolCalls []ollamaToolCall
+		for _, block := range message.Content {
+			switch {
+			case block.OfRequestTextBlock != nil:
+				text = append(text, block.OfRequestTextBlock.Text)
+			case block.OfRequestImageBlock != nil:
+				if source := block.OfRequestImageBlock.Source.OfBase64ImageSource; source != nil {
+					images = append(images, source.Data)
+				}
+			case block.OfRequestToolUseBlock != nil:
+				arguments, err := json.Marshal(block.OfRequestToolUseBlock.Input)
+				if err != nil {
+					return ollamaRequest{}, err
+				}
+				call := ollamaToolCall{}
+				call.Function.Name = block.OfRequestToolUseBlock.Name
+				call.Function.Arguments = arguments
+				toolCalls = append(toolCalls, call)
+			case block.OfRequestToolResultBlock != nil:
+				var result []string
+				for _, content := range block.OfRequestToolResultBlock.Content {
+					if content.OfRequestTextBlock != nil {
+						result = append(result, content.OfRequestTextBlock.Text)
+					}
+				}
+				request.Messages = append(request.Messages, ollamaMessage{Role: "tool", Content: strings.Join(result, "\n")})
+			}
+		}
+
+		if len(text) == 0 && len(images) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		request.Messages = append(request.Messages, ollamaMessage{
+			Role:      string(message.Role),
+			Content:   strings.Join(text, "\n"),
+			Images:    images,
+			ToolCalls: toolCalls,
+		})
+	}
+
+	for _, tool := range params.Tools {
+		if tool.OfTool != nil {
+			request.Tools = append(request.Tools, toOpenAITool(tool.OfTool))
+		}
+	}
+
+	return request, nil
+}
@@ -0,0 +1,418 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIProvider talks to any server implementing the OpenAI chat
+// completions API, such as OpenAI itself, OpenRouter or vLLM.
+type OpenAIProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewOpenAIProvider(baseURL, apiKey string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	return &OpenAIProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return ProviderOpenAI
+}
+
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Tools         []openAITool         `json:"tools,omitempty"`
+	MaxTokens     int64                `json:"max_tokens,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role string `json:"role"`
+	// Content is a string or a list of openAIContentPart.
+	Content    any              `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	// Index identifies the call a streamed delta belongs to.
+	Index    int                `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters"`
+}
+
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIResponseMessage `json:"message"`
+		Delta        openAIResponseMessage `json:"delta"`
+		FinishReason string                `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type openAIResponseMessage struct {
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls"`
+}
+
+func (p *OpenAIProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	request, err := toOpenAIRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	response := openAIResponse{}
+	err = json.NewDecoder(body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("response contained no choices")
+	}
+
+	choice := response.Choices[0]
+	var inputTokens, outputTokens int64
+	if response.Usage != nil {
+		inputTokens, outputTokens = response.Usage.PromptTokens, response.Usage.CompletionTokens
+	}
+	return fromOpenAIResponse(response.ID, response.Model, choice.Message.Content, choice.Message.ToolCalls, choice.FinishReason, inputTokens, outputTokens)
+}
+
+func (p *OpenAIProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	request, err := toOpenAIRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	request.Stream = true
+	request.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+
+	body, err := p.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var id, model, finishReason string
+	var text strings.Builder
+	var toolCalls []openAIToolCall
+	var inputTokens, outputTokens int64
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		chunk := openAIResponse{}
+		err := json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+
+		id, model = chunk.ID, chunk.Model
+		if chunk.Usage != nil {
+			inputTokens, outputTokens = chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		if choice.Delta.Content != "" {
+			text.WriteString(choice.Delta.Content)
+			onText(choice.Delta.Content)
+		}
+		for _, delta := range choice.Delta.ToolCalls {
+			for len(toolCalls) <= delta.Index {
+				toolCalls = append(toolCalls, openAIToolCall{})
+			}
+			call := &toolCalls[delta.Index]
+			if delta.ID != "" {
+				call.ID = delta.ID
+			}
+			if delta.Function.Name != "" {
+				call.Function.Name = delta.Function.Name
+			}
+			call.Function.Arguments += delta.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return fromOpenAIResponse(id, model, text.String(), toolCalls, finishReason, inputTokens, outputTokens)
+}
+
+func (p *OpenAIProvider) post(ctx context.Context, request openAIRequest) (io.ReadCloser, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	response, err := p.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("%s returned %s: %s", p.baseURL, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return response.Body, nil
+}
+
+// toOpenAIRequest translates Anthropic request params, including tool
+// definitions and tool call history, into a chat completions request.
+func toOpenAIRequest(params anthropic.MessageNewParams) (openAIRequest, error) {
+	request := openAIRequest{
+		Model:     params.Model,
+		MaxTokens: params.MaxTokens,
+	}
+	if params.Temperature.IsPresent() {
+		request.Temperature = &params.Temperature.Value
+	}
+	if params.TopP.IsPresent() {
+		request.TopP = &params.TopP.Value
+	}
+
+	var system []string
+	for _, block := range params.System {
+		system = append(system, block.Text)
+	}
+	if len(system) > 0 {
+		request.Messages = append(request.Messages, openAIMessage{Role: "system", Content: strings.Join(system, "\n\n")})
+	}
+
+	for _, message := range params.Messages {
+		translated, err := toOpenAIMessages(message)
+		if err != nil {
+			return openAIRequest{}, err
+		}
+		request.Messages = append(request.Messages, translated...)
+	}
+
+	for _, tool := range params.Tools {
+		if tool.OfTool != nil {
+			request.Tools = append(request.Tools, toOpenAITool(tool.OfTool))
+		}
+	}
+
+	return request, nil
+}
+
+// toOpenAITool translates a tool definition into the function-calling
+// format shared by OpenAI-compatible servers and Ollama.
+func toOpenAITool(tool *anthropic.ToolParam) openAITool {
+	return openAITool{
+		Type: "function",
+		Function: openAIFunction{
+			Name:        tool.Name,
+			Description: tool.Description.Value,
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": tool.InputSchema.Properties,
+			},
+		},
+	}
+}
+
+// toOpenAIMessages translates one Anthropic message. Tool results become
+// separate "tool" role messages, which must directly follow the assistant
+// message that made the calls.
+func toOpenAIMessages(message anthropic.MessageParam) ([]openAIMessage, error) {
+	var messages []openAIMessage
+	var parts []openAIContentPart
+	var text []string
+	var toolCalls []openAIToolCall
+
+	for _, block := range message.Content {
+		switch {
+		case block.OfRequestTextBlock != nil:
+			text = append(text, block.OfRequestTextBlock.Text)
+			parts = append(parts, openAIContentPart{Type: "text", Text: block.OfRequestTextBlock.Text})
+		case block.OfRequestImageBlock != nil:
+			source := block.OfRequestImageBlock.Source.OfBase64ImageSource
+			if source == nil {
+				continue
+			}
+			parts = append(parts, openAIContentPart{
+				Type:     "image_url",
+				ImageURL: &openAIImageURL{URL: fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)},
+			})
+		case block.OfRequestToolUseBlock != nil:
+			arguments, err := json.Marshal(block.OfRequestToolUseBlock.Input)
+			if err != nil {
+				return nil, err
+			}
+			toolCalls = append(toolCalls, openAIToolCall{
+				ID:       block.OfRequestToolUseBlock.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.OfRequestToolUseBlock.Name, Arguments: string(arguments)},
+			})
+		case block.OfRequestToolResultBlock != nil:
+			var result []string
+			for _, content := range block.OfRequestToolResultBlock.Content {
+				if content.OfRequestTextBlock != nil {
+					result = append(result, content.OfRequestTextBlock.Text)
+				}
+			}
+			messages = append(messages, openAIMessage{
+				Role:       "tool",
+				ToolCallID: block.OfRequestToolResultBlock.ToolUseID,
+				Content:    strings.Join(result, "\n"),
+			})
+		}
+	}
+
+	if message.Role == anthropic.MessageParamRoleAssistant {
+		assistant := openAIMessage{Role: "assistant", ToolCalls: toolCalls}
+		if len(text) > 0 {
+			assistant.Content = strings.Join(text, "\n")
+		}
+		return append(messages, assistant), nil
+	}
+
+	if len(parts) == 0 {
+		return messages, nil
+	}
+	user := openAIMessage{Role: "user", Content: parts}
+	if len(parts) == len(text) {
+		user.Content = strings.Join(text, "\n")
+	}
+	return append(messages, user), nil
+}
+
+// fromOpenAIResponse assembles an Anthropic message from a completion.
+func fromOpenAIResponse(id, model, text string, toolCalls []openAIToolCall, finishReason string, inputTokens, outputTokens int64) (*anthropic.Message, error) {
+	var content []map[string]any
+	if text != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+	for i, call := range toolCalls {
+		arguments := call.Function.Arguments
+		if strings.TrimSpace(arguments) == "" {
+			arguments = "{}"
+		}
+		if !json.Valid([]byte(arguments)) {
+			return nil, fmt.Errorf("model returned invalid arguments for tool %s: %s", call.Function.Name, arguments)
+		}
+
+		// Some compatible servers leave call IDs empty.
+		callID := call.ID
+		if callID == "" {
+			callID = fmt.Sprintf("call_%d", i)
+		}
+		content = append(content, map[string]any{
+			"type":  "tool_use",
+			"id":    callID,
+			"name":  call.Function.Name,
+			"input": json.RawMessage(arguments),
+		})
+	}
+
+	stopReason := "end_turn"
+	switch {
+	case len(toolCalls) > 0 || finishReason == "tool_calls":
+		stopReason = "tool_use"
+	case finishReason == "length":
+		stopReason = "max_tokens"
+	}
+
+	return messageFromJSON(map[string]any{
+		"id":          id,
+		"type":        "message",
+		"role":        "assistant",
+		"model":       model,
+		"content":     content,
+		"stop_reason": stopReason,
+		"usage": map[string]int64{
+			"input_tokens":  inputTokens,
+			"output_tokens": outputTokens,
+		},
+	})
+}
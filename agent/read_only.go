@@ -0,0 +1,56 @@
+package agent
+
+import "encoding/json"
+
+const readOnlyPrompt = `Read-only mode is on. You can read, list and search files and inspect the repository with git status, log, diff and show, but nothing that changes files, repositories or other state: such tools are not available, and calls that would change something are refused. Answer with findings, explanations or review comments, and describe any changes you would suggest instead of making them.`
+
+// readOnlyToolNames are the built-in tools read-only mode offers. It lists
+// them rather than trusting each tool's Mutating func, which custom and
+// plugin tools set from their own config, so those are never offered. Tools
+// that run the project's code or build tooling, such as run_tests, are left
+// out too: that code can do anything, although it is not meant to change
+// files.
+var readOnlyToolNames = map[string]bool{
+	"read_file":       true,
+	"list_files":      true,
+	"search_files":    true,
+	"glob":            true,
+	"stat":            true,
+	"get_outline":     true,
+	"semantic_search": true,
+	"read_notebook":   true,
+	"read_output":     true,
+	"process_logs":    true,
+	"fetch_url":       true,
+	// These change something only for some inputs, such as git commit but
+	// not git log, and those calls are refused.
+	"git":             true,
+	"forge":           true,
+	"code_intel":      true,
+	dispatchAgentName: true,
+	textEditorName:    true,
+}
+
+// readOnlyTools keeps the tools read-only mode can offer.
+func readOnlyTools(tools []ToolDefinition) []ToolDefinition {
+	var kept []ToolDefinition
+	for _, tool := range tools {
+		if readOnlyToolNames[tool.Name] {
+			kept = append(kept, tool)
+		}
+	}
+	return kept
+}
+
+// refuseInReadOnly returns an error for a call that would change something
+// while read-only mode is on, including calls to tools registered after
+// the agent was created.
+func (a *Agent) refuseInReadOnly(tool ToolDefinition, input json.RawMessage) error {
+	if !a.config.ReadOnly {
+		return nil
+	}
+	if readOnlyToolNames[tool.Name] && !a.mutating(tool, input) {
+		return nil
+	}
+	return toolErrorf(ErrorPermissionDenied, "%s would make changes, which read-only mode (--read-only) does not allow", tool.Name)
+}
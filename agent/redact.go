@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// redactedSecret replaces every secret found in a tool result.
+const redactedSecret = "[REDACTED]"
+
+// defaultSecretPatterns match the credentials most often found in
+// workspaces: private keys, cloud and forge tokens and API keys. A pattern
+// with a capture group redacts only the group.
+var defaultSecretPatterns = []string{
+	`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`,
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,
+	`\bglpat-[A-Za-z0-9_-]{20,}`,
+	`\bsk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}`,
+	`\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+	`\bAIza[0-9A-Za-z_-]{35}\b`,
+	`\b[rs]k_live_[0-9A-Za-z]{20,}`,
+	`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`,
+}
+
+// assignedSecret matches secrets assigned in config files and code, and
+// redacts only the value, so a password keeps its "password: " key. Values
+// must be long and end the assignment, so calls such as
+// token = loadToken(ctx) are left alone, and they are only redacted when
+// looksLikeSecret.
+var assignedSecret = regexp.MustCompile(`(?im)(?:api[_-]?key|secret|token|password|passwd|credentials?)[A-Za-z0-9_.-]*["']?\s*[:=]\s*["']?([A-Za-z0-9_\-+/=.]{16,})(?:["'\s,;]|$)`)
+
+// dottedIdentifier matches field and package selectors such as
+// a.config.MaxTokens.
+var dottedIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+
+// constantName matches the names of environment variables and constants,
+// such as SYSTEM3_SERVE_TOKEN.
+var constantName = regexp.MustCompile(`^[A-Z][A-Z0-9]*(?:_[A-Z0-9]+)+$`)
+
+// looksLikeSecret tells an assigned secret from code that assigns a name,
+// such as secretFiles = config.Permissions.SecretFiles: secrets mix letters
+// and digits, and are neither selectors nor constant names.
+func looksLikeSecret(value string) bool {
+	if dottedIdentifier.MatchString(value) || constantName.MatchString(value) {
+		return false
+	}
+	return strings.ContainsAny(value, "0123456789") && strings.IndexFunc(value, unicode.IsLetter) >= 0
+}
+
+// secretPattern is a pattern secrets are redacted by.
+type secretPattern struct {
+	re *regexp.Regexp
+	// valid, when set, confirms that the matched secret is one.
+	valid func(secret string) bool
+}
+
+// redactor removes secrets from tool results before the model, transcripts
+// and logs see them.
+type redactor struct {
+	patterns []secretPattern
+}
+
+// newRedactor compiles the default patterns and extra ones from the
+// permissions file.
+func newRedactor(extra []string) (*redactor, error) {
+	r := &redactor{}
+	for _, pattern := range append(append([]string(nil), defaultSecretPatterns...), extra...) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, secretPattern{re: compiled})
+	}
+	r.patterns = append(r.patterns, secretPattern{re: assignedSecret, valid: looksLikeSecret})
+	return r, nil
+}
+
+// redact returns text with its secrets replaced, and how many there were.
+func (r *redactor) redact(text string) (string, int) {
+	if r == nil {
+		return text, 0
+	}
+	count := 0
+	for _, pattern := range r.patterns {
+		text = pattern.re.ReplaceAllStringFunc(text, func(match string) string {
+			groups := pattern.re.FindStringSubmatchIndex(match)
+			if len(groups) < 4 || groups[2] < 0 {
+				if pattern.valid != nil && !pattern.valid(match) {
+					return match
+				}
+				count++
+				return redactedSecret
+			}
+			if pattern.valid != nil && !pattern.valid(match[groups[2]:groups[3]]) {
+				return match
+			}
+			count++
+			return match[:groups[2]] + redactedSecret + match[groups[3]:]
+		})
+	}
+	return text, count
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxRenameConflicts caps how many uses of a taken name are listed.
+const maxRenameConflicts = 5
+
+// identifierPattern matches the names rename_symbol accepts.
+var identifierPattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{Nd}_]*$`)
+
+// rename_symbol tool
+
+var RenameSymbolDefinition = ToolDefinition{
+	Name: "rename_symbol",
+	Description: `Rename an identifier, such as a function, type, method, field or variable, everywhere in the project, and list the files it changed.
+
+Point at one occurrence with path, line and symbol, as for code_intel. Go is renamed with gopls, which renames exactly that symbol and refuses renames that would conflict. Without a language server, or when it fails to start, Go, Python, JavaScript, TypeScript and Rust are renamed by syntax instead: the identifiers with that name and the same kind as the one pointed at, such as variables, fields or types, are renamed in the language's files, but not strings or comments, and the new name must not be in use yet. That matches names only, so unrelated symbols sharing the name are renamed too and uses of another kind, such as pkg.Name for a function, are missed; check the changes.
+
+Every file changes together or none does; undo_edit with count set to the number of files changed reverts the rename.`,
+	InputSchema: RenameSymbolInputSchema,
+	Function:    RenameSymbol,
+	Preview:     PreviewRenameSymbol,
+	Mutating:    alwaysMutating,
+}
+
+type RenameSymbolInput struct {
+	Path    string `json:"path" jsonschema_description:"The relative path of a file using the symbol."`
+	Line    int    `json:"line" jsonschema_description:"The 1-based line the symbol is on."`
+	Symbol  string `json:"symbol" jsonschema_description:"The symbol's current name as written on the line."`
+	Column  int    `json:"column,omitempty" jsonschema_description:"Optional 1-based column of the symbol, when its name appears more than once on the line."`
+	NewName string `json:"new_name" jsonschema_description:"The new name."`
+}
+
+var RenameSymbolInputSchema = GenerateSchema[RenameSymbolInput]()
+
+func RenameSymbol(ctx context.Context, input json.RawMessage) (string, error) {
+	renameInput := RenameSymbolInput{}
+	err := json.Unmarshal(input, &renameInput)
+	if err != nil {
+		return "", err
+	}
+	if renameInput.Symbol == "" || renameInput.NewName == "" {
+		return "", toolErrorf(ErrorInvalidInput, "symbol and new_name are required")
+	}
+	if !identifierPattern.MatchString(renameInput.NewName) {
+		return "", toolErrorf(ErrorInvalidInput, "%q is not a valid identifier", renameInput.NewName)
+	}
+	if renameInput.NewName == renameInput.Symbol {
+		return "", toolErrorf(ErrorInvalidInput, "new_name is the symbol's current name")
+	}
+	path, err := resolvePath(renameInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	server, err := serverFor(path)
+	if err != nil {
+		language, _ := outlineLanguageFor(path)
+		return renameBySyntax(ctx, path, renameInput, fmt.Sprintf("there is no language server for %s", language.Name))
+	}
+	client, err := lspClients.get(ctx, server)
+	if err != nil {
+		if _, ok := outlineLanguageFor(path); !ok {
+			return "", err
+		}
+		slog.Debug("renaming by syntax", "server", server.Name, "error", err)
+		return renameBySyntax(ctx, path, renameInput, fmt.Sprintf("%s failed to start", server.Name))
+	}
+	text, err := client.sync(path)
+	if err != nil {
+		return "", err
+	}
+	position, err := symbolPosition(client, text, CodeIntelInput{Path: renameInput.Path, Line: renameInput.Line, Symbol: renameInput.Symbol, Column: renameInput.Column})
+	if err != nil {
+		return "", err
+	}
+	var edit lspWorkspaceEdit
+	err = client.call(ctx, "textDocument/rename", map[string]any{
+		"textDocument": map[string]string{"uri": fileURI(path)},
+		"position":     position,
+		"newName":      renameInput.NewName,
+	}, &edit)
+	if err != nil {
+		return "", err
+	}
+	summary, err := applyWorkspaceEdit(ctx, client, edit, "rename_symbol")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Renamed %s to %s with %s. %s", renameInput.Symbol, renameInput.NewName, server.Name, summary), nil
+}
+
+// syntaxIdentifier is an identifier in a file's syntax tree.
+type syntaxIdentifier struct {
+	start, end uint32
+	// line and column are 1-based; column counts bytes.
+	line, column int
+	// kind is the node type, such as identifier or field_identifier.
+	kind string
+	// member is set for the member in a selector such as x.Field.
+	member bool
+}
+
+// renameFamily groups languages whose files use each other's names, as
+// TypeScript imports from JavaScript.
+func renameFamily(language outlineLanguage) string {
+	switch language.Name {
+	case "TypeScript", "TSX":
+		return "JavaScript"
+	}
+	return language.Name
+}
+
+// renameBySyntax renames the identifiers named input.Symbol in the
+// workspace's files of path's language, for languages without a language
+// server; reason says why there is none. Only identifiers of the same kind
+// as the one pointed at are renamed, so renaming a variable leaves fields
+// and types of that name alone. Refusing names already in use keeps the
+// rename from merging two symbols into one.
+func renameBySyntax(ctx context.Context, path string, input RenameSymbolInput, reason string) (string, error) {
+	language, ok := outlineLanguageFor(path)
+	if !ok {
+		return "", toolErrorf(ErrorInvalidInput, "cannot rename in %s files, which have no language server; renaming by syntax supports Go, Python, JavaScript, TypeScript and Rust", orDefault(filepath.Ext(path), filepath.Base(path)))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	identifiers, err := fileIdentifiers(content, language)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", relativeToWorkspace(path), err)
+	}
+	var pointed []syntaxIdentifier
+	for _, use := range identifiers[input.Symbol] {
+		if use.line == input.Line && (input.Column <= 0 || use.column <= input.Column && input.Column <= use.column+int(use.end-use.start)) {
+			pointed = append(pointed, use)
+		}
+	}
+	if len(pointed) == 0 {
+		return "", toolErrorf(ErrorNotFound, "%s is not used as an identifier on line %d of %s", input.Symbol, input.Line, input.Path)
+	}
+	for _, use := range pointed[1:] {
+		if !use.sameKind(pointed[0]) {
+			return "", toolErrorf(ErrorInvalidInput, "%s is used as different kinds of identifier on line %d of %s; set column to point at one", input.Symbol, input.Line, input.Path)
+		}
+	}
+
+	rels, complete, err := walkRepository(workspaceRoot)
+	if err != nil {
+		return "", err
+	}
+	if !complete {
+		return "", toolErrorf(ErrorTooLarge, "the workspace has more than %d files, too many to rename %s across by syntax", maxRepoMapFiles, input.Symbol)
+	}
+	paths := []string{path}
+	for _, rel := range rels {
+		candidate := filepath.Join(workspaceRoot, filepath.FromSlash(rel))
+		if other, ok := outlineLanguageFor(candidate); ok && candidate != path && renameFamily(other) == renameFamily(language) {
+			paths = append(paths, candidate)
+		}
+	}
+	sort.Strings(paths)
+
+	var files []*pendingFile
+	var summary, conflicts []string
+	total, skipped := 0, 0
+	for _, candidate := range paths {
+		content, err := os.ReadFile(candidate)
+		if err != nil {
+			return "", err
+		}
+		language, _ := outlineLanguageFor(candidate)
+		identifiers, err := fileIdentifiers(content, language)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", relativeToWorkspace(candidate), err)
+		}
+		for _, use := range identifiers[input.NewName] {
+			conflicts = append(conflicts, fmt.Sprintf("%s:%d", relativeToWorkspace(candidate), use.line))
+		}
+		var uses []syntaxIdentifier
+		for _, use := range identifiers[input.Symbol] {
+			if use.sameKind(pointed[0]) {
+				uses = append(uses, use)
+			} else {
+				skipped++
+			}
+		}
+		if len(uses) == 0 {
+			continue
+		}
+
+		file, err := stageFile(ctx, candidate, false)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(file.content, content) {
+			return "", fmt.Errorf("%s changed during the rename, try again", relativeToWorkspace(candidate))
+		}
+		// Later uses first, so earlier offsets stay valid.
+		text := string(content)
+		for i := len(uses) - 1; i >= 0; i-- {
+			text = text[:uses[i].start] + input.NewName + text[uses[i].end:]
+		}
+		file.edited = file.format.normalize(text)
+		file.edits = len(uses)
+		files = append(files, file)
+		total += len(uses)
+		summary = append(summary, fmt.Sprintf("%s (%d edits)", relativeToWorkspace(candidate), len(uses)))
+	}
+	if len(conflicts) > 0 {
+		if len(conflicts) > maxRenameConflicts {
+			conflicts = append(conflicts[:maxRenameConflicts], fmt.Sprintf("and %d more", len(conflicts)-maxRenameConflicts))
+		}
+		return "", toolErrorf(ErrorInvalidInput, "%s is already used in %s; renaming by syntax cannot tell those uses apart, so choose another name", input.NewName, strings.Join(conflicts, ", "))
+	}
+
+	err = commitFiles(ctx, "rename_symbol", files)
+	if err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("Renamed %s to %s by syntax, as %s. Every %s with that name in %s files was renamed, including any unrelated ones; check the changes.",
+		input.Symbol, input.NewName, reason, pointed[0].describe(), renameFamily(language))
+	if skipped > 0 {
+		result += fmt.Sprintf(" Uses of the name as another kind of identifier, such as a field, type or member, were left unchanged (%d); rename them separately if they are the same symbol.", skipped)
+	}
+	return fmt.Sprintf("%s Applied %d edits in %d files:\n%s", result, total, len(files), strings.Join(summary, "\n")), nil
+}
+
+// sameKind reports whether two identifiers are the same kind of name: the
+// same node type and, for grammars such as Python's that use plain
+// identifiers for members too, both or neither members of a selector.
+func (i syntaxIdentifier) sameKind(other syntaxIdentifier) bool {
+	if i.kind != other.kind {
+		return false
+	}
+	return i.kind != "identifier" || i.member == other.member
+}
+
+// describe names the identifier's kind for the rename summary.
+func (i syntaxIdentifier) describe() string {
+	if i.kind == "identifier" && i.member {
+		return "identifier selected as a member"
+	}
+	return strings.ReplaceAll(i.kind, "_", " ")
+}
+
+func PreviewRenameSymbol(input json.RawMessage) (string, bool) {
+	renameInput := RenameSymbolInput{}
+	err := json.Unmarshal(input, &renameInput)
+	if err != nil {
+		return fmt.Sprintf("rename with invalid input: %s", input), true
+	}
+	return fmt.Sprintf("rename %s to %s across the project (at %s:%d)", renameInput.Symbol, renameInput.NewName, renameInput.Path, renameInput.Line), true
+}
@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// ProviderReplay is the name replayed sessions report in place of the
+// recorded provider.
+const ProviderReplay = "replay"
+
+// maxFixtureLine bounds one recorded exchange, which holds a whole request.
+const maxFixtureLine = 64 * 1024 * 1024
+
+// fixtureExchange is one model call in a fixture file, a JSONL file with one
+// exchange per line. Model, MaxTokens and Messages pick the exchange that
+// answers a replayed request; Request is kept for reading the fixture.
+type fixtureExchange struct {
+	Model     anthropic.Model `json:"model"`
+	MaxTokens int64           `json:"max_tokens"`
+	Messages  int             `json:"messages"`
+	Request   json.RawMessage `json:"request"`
+	Response  json.RawMessage `json:"response"`
+}
+
+func newFixtureExchange(params anthropic.MessageNewParams) fixtureExchange {
+	return fixtureExchange{Model: params.Model, MaxTokens: params.MaxTokens, Messages: len(params.Messages)}
+}
+
+// matches reports whether the exchange was recorded for a request like
+// params. Only the shape of the request is compared, as prompts hold paths,
+// dates and tool output that differ between machines.
+func (e fixtureExchange) matches(params anthropic.MessageNewParams) bool {
+	return e.Model == params.Model && e.MaxTokens == params.MaxTokens && e.Messages == len(params.Messages)
+}
+
+// RecordingProvider passes calls through to another provider and appends
+// each request with its response to a fixture file, for ReplayProvider to
+// serve later.
+type RecordingProvider struct {
+	provider Provider
+	mu       sync.Mutex
+	file     *os.File
+}
+
+// NewRecordingProvider records provider's calls to the fixture at path,
+// replacing any earlier recording.
+func NewRecordingProvider(provider Provider, path string) (*RecordingProvider, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fixture: %w", err)
+	}
+	return &RecordingProvider{provider: provider, file: file}, nil
+}
+
+func (p *RecordingProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *RecordingProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	message, err := p.provider.SendMessage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return message, p.record(params, message)
+}
+
+func (p *RecordingProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	message, err := p.provider.StreamMessage(ctx, params, onText, onThinking)
+	if err != nil {
+		return nil, err
+	}
+	return message, p.record(params, message)
+}
+
+func (p *RecordingProvider) record(params anthropic.MessageNewParams, message *anthropic.Message) error {
+	exchange := newFixtureExchange(params)
+	var err error
+	exchange.Request, err = json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode request for the fixture: %w", err)
+	}
+	exchange.Response, err = encodeMessage(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode response for the fixture: %w", err)
+	}
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// encodeMessage encodes a reply as the API sent it. Content blocks are taken
+// from their raw JSON where there is one, since the SDK's types drop the
+// fields of blocks they do not know, such as server tool results.
+func encodeMessage(message *anthropic.Message) (json.RawMessage, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]json.RawMessage, 0, len(message.Content))
+	for _, block := range message.Content {
+		if raw := block.RawJSON(); raw != "" {
+			content = append(content, json.RawMessage(raw))
+			continue
+		}
+		encoded, err := json.Marshal(block)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, encoded)
+	}
+	fields["content"], err = json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// ReplayProvider answers calls from a fixture recorded by RecordingProvider
+// instead of calling a model, so runs can be repeated offline and in CI.
+// Each call gets the first unused exchange recorded for a request of the
+// same shape; background calls such as session titling find their own
+// exchanges even when they happen in a different order.
+type ReplayProvider struct {
+	path      string
+	mu        sync.Mutex
+	exchanges []fixtureExchange
+	used      []bool
+}
+
+// NewReplayProvider loads the fixture at path.
+func NewReplayProvider(path string) (*ReplayProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fixture: %w", err)
+	}
+	defer file.Close()
+
+	var exchanges []fixtureExchange
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxFixtureLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		exchange := fixtureExchange{}
+		err := json.Unmarshal(scanner.Bytes(), &exchange)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s:%d: %w", path, line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("fixture %s has no recorded exchanges", path)
+	}
+	return &ReplayProvider{path: path, exchanges: exchanges, used: make([]bool, len(exchanges))}, nil
+}
+
+func (p *ReplayProvider) Name() string {
+	return ProviderReplay
+}
+
+func (p *ReplayProvider) SendMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, exchange := range p.exchanges {
+		if p.used[i] || !exchange.matches(params) {
+			continue
+		}
+		p.used[i] = true
+		message, err := messageFromJSON(exchange.Response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode exchange %d of %s: %w", i+1, p.path, err)
+		}
+		return message, nil
+	}
+	return nil, fmt.Errorf("%s has no unused exchange for a %s request with %d messages; record the fixture again", p.path, params.Model, len(params.Messages))
+}
+
+// StreamMessage replays the reply's text and thinking whole, before
+// returning it.
+func (p *ReplayProvider) StreamMessage(ctx context.Context, params anthropic.MessageNewParams, onText, onThinking func(string)) (*anthropic.Message, error) {
+	message, err := p.SendMessage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range message.Content {
+		switch block.Type {
+		case "text":
+			onText(block.Text)
+		case "thinking":
+			onThinking(block.Thinking)
+		}
+	}
+	return message, nil
+}
@@ -0,0 +1,353 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+const (
+	// maxRepoMapSize caps the map in bytes, about 2,500 tokens of the system
+	// prompt.
+	maxRepoMapSize = 10000
+	// maxRepoMapFiles stops the walk in very large workspaces.
+	maxRepoMapFiles = 5000
+	// maxRepoMapParseSize skips generated and vendored blobs.
+	maxRepoMapParseSize = 256 * 1024
+
+	// maxRepoMapDefiners ignores names such as String or New that so many
+	// files define that a use says little about which one is meant.
+	maxRepoMapDefiners = 5
+
+	pageRankIterations = 30
+	pageRankDamping    = 0.85
+)
+
+// repoFile is a file in the map, relative to the workspace with forward
+// slashes.
+type repoFile struct {
+	Path    string
+	Outline fileOutline
+}
+
+// buildRepoMap summarizes the workspace: its file tree, pruned by
+// .gitignore, with the top-level symbols the rest of the code refers to most
+// listed under their files. Files are ranked with PageRank over the graph of
+// which files use names other files define.
+func buildRepoMap(root string) (string, error) {
+	paths, complete, err := walkRepository(root)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", nil
+	}
+
+	files := make([]repoFile, len(paths))
+	for i, path := range paths {
+		files[i].Path = path
+		if _, ok := outlineLanguageFor(path); !ok {
+			continue
+		}
+		fullPath := filepath.Join(root, filepath.FromSlash(path))
+		if info, err := os.Stat(fullPath); err != nil || info.Size() > maxRepoMapParseSize {
+			continue
+		}
+		outline, err := parseOutline(fullPath)
+		if err != nil {
+			slog.Debug("skipping file in repository map", "path", path, "error", err)
+			continue
+		}
+		files[i].Outline = outline
+	}
+	fileRanks, symbolRanks := rankRepository(files)
+
+	type rankedSymbol struct {
+		file   int
+		symbol outlineSymbol
+		rank   float64
+	}
+	var candidates []rankedSymbol
+	for i, file := range files {
+		for _, symbol := range file.Outline.Symbols {
+			if symbol.Depth == 0 {
+				candidates = append(candidates, rankedSymbol{file: i, symbol: symbol, rank: symbolRanks[i][symbol.Name]})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.rank != b.rank {
+			return a.rank > b.rank
+		}
+		if fileRanks[a.file] != fileRanks[b.file] {
+			return fileRanks[a.file] > fileRanks[b.file]
+		}
+		return a.file < b.file
+	})
+
+	// List every file when the tree is small; otherwise list directories
+	// with their file counts and only the files that have symbols shown.
+	tree := renderRepoTree(files, nil, true)
+	listFiles := len(tree) <= maxRepoMapSize/2
+	selected := map[int][]outlineSymbol{}
+	size := len(renderRepoTree(files, selected, listFiles))
+	for _, candidate := range candidates {
+		cost := len(candidate.symbol.String()) + 2*strings.Count(files[candidate.file].Path, "/") + 3
+		if !listFiles && len(selected[candidate.file]) == 0 {
+			cost += len(files[candidate.file].Path) + 1
+		}
+		if size+cost > maxRepoMapSize {
+			continue
+		}
+		selected[candidate.file] = append(selected[candidate.file], candidate.symbol)
+		size += cost
+	}
+	for _, symbols := range selected {
+		sort.Slice(symbols, func(i, j int) bool { return symbols[i].StartLine < symbols[j].StartLine })
+	}
+
+	repoMap := renderRepoTree(files, selected, listFiles)
+	if !complete {
+		repoMap += fmt.Sprintf("... the workspace has more than %d files; only the first %d are mapped\n", maxRepoMapFiles, maxRepoMapFiles)
+	}
+	return strings.TrimRight(repoMap, "\n"), nil
+}
+
+// walkRepository lists the workspace's files in lexical order, skipping
+// hidden directories and anything .gitignore or .git/info/exclude ignores.
+// It reports false when it stopped at maxRepoMapFiles.
+func walkRepository(root string) ([]string, bool, error) {
+	patterns := readIgnorePatterns(filepath.Join(root, ".git", "info", "exclude"), nil)
+	var paths []string
+	complete := true
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		rel, err := slashRel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			patterns = append(patterns, readIgnorePatterns(filepath.Join(path, ".gitignore"), nil)...)
+			return nil
+		}
+		parts := strings.Split(rel, "/")
+
+		if entry.IsDir() {
+			if strings.HasPrefix(entry.Name(), ".") || gitignore.NewMatcher(patterns).Match(parts, true) {
+				return filepath.SkipDir
+			}
+			patterns = append(patterns, readIgnorePatterns(filepath.Join(path, ".gitignore"), parts)...)
+			return nil
+		}
+		// Symlinks are skipped so a link cannot pull in files outside the
+		// workspace.
+		if !entry.Type().IsRegular() || gitignore.NewMatcher(patterns).Match(parts, false) {
+			return nil
+		}
+		if len(paths) == maxRepoMapFiles {
+			complete = false
+			return filepath.SkipAll
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list the workspace: %w", err)
+	}
+	return paths, complete, nil
+}
+
+// readIgnorePatterns parses an ignore file whose patterns apply below
+// domain. A missing or unreadable file has no patterns.
+func readIgnorePatterns(path string, domain []string) []gitignore.Pattern {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+	return patterns
+}
+
+// rankRepository runs PageRank over the files, with an edge from each file
+// to the files defining the names it uses, and shares each file's rank out
+// among the symbols its references point at. It returns the rank of every
+// file and of every top-level symbol by file and name.
+func rankRepository(files []repoFile) ([]float64, []map[string]float64) {
+	definers := map[string][]int{}
+	for i, file := range files {
+		seen := map[string]bool{}
+		for _, symbol := range file.Outline.Symbols {
+			if symbol.Depth == 0 && symbol.Name != "" && !seen[symbol.Name] {
+				seen[symbol.Name] = true
+				definers[symbol.Name] = append(definers[symbol.Name], i)
+			}
+		}
+	}
+
+	type edge struct {
+		to     int
+		name   string
+		weight float64
+	}
+	edges := make([][]edge, len(files))
+	outWeights := make([]float64, len(files))
+	for i, file := range files {
+		for name, count := range file.Outline.Identifiers {
+			defining := definers[name]
+			if len(defining) > maxRepoMapDefiners {
+				continue
+			}
+			for _, j := range defining {
+				if j == i {
+					continue
+				}
+				// Repeated uses count for less than distinct ones, and a name
+				// defined in several files is split between them.
+				weight := math.Sqrt(float64(count)) / float64(len(defining))
+				edges[i] = append(edges[i], edge{to: j, name: name, weight: weight})
+				outWeights[i] += weight
+			}
+		}
+	}
+
+	n := float64(len(files))
+	ranks := make([]float64, len(files))
+	for i := range ranks {
+		ranks[i] = 1 / n
+	}
+	for iteration := 0; iteration < pageRankIterations; iteration++ {
+		next := make([]float64, len(files))
+		dangling := 0.0
+		for i := range files {
+			if outWeights[i] == 0 {
+				dangling += ranks[i]
+				continue
+			}
+			for _, e := range edges[i] {
+				next[e.to] += ranks[i] * e.weight / outWeights[i]
+			}
+		}
+		for i := range next {
+			next[i] = (1-pageRankDamping)/n + pageRankDamping*(next[i]+dangling/n)
+		}
+		ranks = next
+	}
+
+	symbolRanks := make([]map[string]float64, len(files))
+	for i := range symbolRanks {
+		symbolRanks[i] = map[string]float64{}
+	}
+	for i := range files {
+		for _, e := range edges[i] {
+			symbolRanks[e.to][e.name] += ranks[i] * e.weight / outWeights[i]
+		}
+	}
+	return ranks, symbolRanks
+}
+
+// renderRepoTree draws files as an indented tree with the selected symbols
+// under their files. With listFiles false, directories show how many files
+// they hold and only files with symbols are drawn.
+func renderRepoTree(files []repoFile, symbols map[int][]outlineSymbol, listFiles bool) string {
+	counts := map[string]int{}
+	for _, file := range files {
+		for dir := filepath.ToSlash(filepath.Dir(file.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			counts[dir]++
+		}
+	}
+
+	var out strings.Builder
+	var printedDirs []string
+	printDirs := func(dirs []string) {
+		common := 0
+		for common < len(dirs) && common < len(printedDirs) && dirs[common] == printedDirs[common] {
+			common++
+		}
+		for depth := common; depth < len(dirs); depth++ {
+			line := strings.Repeat("  ", depth) + dirs[depth] + "/"
+			if !listFiles {
+				line += fmt.Sprintf(" (%d files)", counts[strings.Join(dirs[:depth+1], "/")])
+			}
+			out.WriteString(line + "\n")
+		}
+		printedDirs = dirs
+	}
+
+	for i, file := range files {
+		parts := strings.Split(file.Path, "/")
+		dirs := parts[:len(parts)-1]
+		// Directories are drawn even when none of their files are, so the
+		// collapsed tree still shows every directory.
+		printDirs(dirs)
+		if !listFiles && len(symbols[i]) == 0 {
+			continue
+		}
+		out.WriteString(strings.Repeat("  ", len(dirs)) + parts[len(parts)-1] + "\n")
+		for _, symbol := range symbols[i] {
+			out.WriteString(strings.Repeat("  ", len(dirs)+1) + symbol.String() + "\n")
+		}
+	}
+	return out.String()
+}
+
+// repoMapPrompt wraps a repository map for the system prompt.
+func repoMapPrompt(repoMap string) string {
+	return "This map of the repository shows its files and the top-level symbols the rest of the code uses most, with their line ranges. Use it to decide where to look; read files or use get_outline for anything it leaves out.\n\n<repository_map>\n" + repoMap + "\n</repository_map>"
+}
+
+// refreshRepoMap rebuilds the map the system prompt includes.
+func (a *Agent) refreshRepoMap() error {
+	start := time.Now()
+	repoMap, err := buildRepoMap(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	a.repoMap = repoMap
+	slog.Debug("built repository map", "bytes", len(repoMap), "duration", time.Since(start))
+	return nil
+}
+
+// /map command
+
+var MapCommand = SlashCommand{
+	Name:        "map",
+	Description: "Rebuild the repository map the model sees and show it",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		err := a.refreshRepoMap()
+		if err != nil {
+			return conversation, err
+		}
+		if a.repoMap == "" {
+			fmt.Println("\u001b[93msystem\u001b[0m: the workspace has no files to map")
+			return conversation, nil
+		}
+		fmt.Printf("\u001b[93msystem\u001b[0m: repository map\n%s\n", a.repoMap)
+		return conversation, nil
+	},
+}
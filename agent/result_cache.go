@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	// resultCacheTurns is how many model turns a cached result is reused
+	// for. Older results may have been compacted away or scrolled out of
+	// the model's attention.
+	resultCacheTurns = 10
+	// minElidedResult is the smallest cached result that is replaced by a
+	// reference to the earlier one instead of being sent again.
+	minElidedResult = 2000
+)
+
+// cachedTools are the read-only tools whose results are reused when they
+// are called again with the same input and nothing has changed.
+var cachedTools = map[string]bool{
+	"read_file":     true,
+	"list_files":    true,
+	"search_files":  true,
+	"glob":          true,
+	"stat":          true,
+	"get_outline":   true,
+	"read_notebook": true,
+}
+
+// resultCache remembers recent read-only tool results. Entries are dropped
+// when a tool changes something, when the user speaks (they may have
+// edited files) and when the conversation is compacted; a read of a single
+// file is also dropped when the file's size or modification time changes.
+type resultCache struct {
+	mu      sync.Mutex
+	turn    int
+	entries map[string]cachedResult
+}
+
+type cachedResult struct {
+	output string
+	turn   int
+	path   string
+	stamp  fileStamp
+}
+
+// nextTurn advances the turn window, once per model call.
+func (c *resultCache) nextTurn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.turn++
+}
+
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// lookup returns what to send for a repeated call, if it can be answered
+// from the cache. Large results are not repeated but referred back to.
+func (c *resultCache) lookup(name string, input json.RawMessage) (string, bool) {
+	if !cachedTools[name] {
+		return "", false
+	}
+	key := resultCacheKey(name, input)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if c.turn-entry.turn > resultCacheTurns || (entry.path != "" && !stampFile(entry.path, false).sameMetadata(entry.stamp)) {
+		delete(c.entries, key)
+		return "", false
+	}
+	if len(entry.output) < minElidedResult {
+		return entry.output, true
+	}
+	when := "earlier in this turn"
+	if age := c.turn - entry.turn; age > 0 {
+		when = fmt.Sprintf("%d turn(s) ago", age)
+	}
+	return fmt.Sprintf("Unchanged since your identical %s call %s; that result (%d bytes) still applies and is not repeated here.",
+		name, when, len(entry.output)), true
+}
+
+// store caches a successful result.
+func (c *resultCache) store(name string, input json.RawMessage, output string) {
+	if !cachedTools[name] {
+		return
+	}
+	entry := cachedResult{output: output}
+	var target struct {
+		Path string `json:"path"`
+	}
+	if name == "read_file" && json.Unmarshal(input, &target) == nil {
+		path, err := resolvePath(target.Path)
+		if err != nil {
+			return
+		}
+		entry.path, entry.stamp = path, stampFile(path, false)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.turn = c.turn
+	if c.entries == nil {
+		c.entries = map[string]cachedResult{}
+	}
+	c.entries[resultCacheKey(name, input)] = entry
+}
+
+// resultCacheKey identifies a call by its tool and compacted input, so
+// formatting differences do not matter.
+func resultCacheKey(name string, input json.RawMessage) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, input) != nil {
+		return name + string(input)
+	}
+	return name + compact.String()
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// lastPrompt returns the index of the last message the user typed, skipping
+// the tool results that are also sent as user messages, or -1 when there is
+// none.
+func lastPrompt(conversation []anthropic.MessageParam) int {
+	for i := len(conversation) - 1; i >= 0; i-- {
+		if isUserPrompt(conversation[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// promptText returns the index of the block holding the text the user typed
+// in a prompt, or -1 when it has none. The images and notices sent with the
+// prompt follow that block, and a compaction summary may precede it.
+func promptText(message anthropic.MessageParam) int {
+	for i, block := range message.Content {
+		if block.OfRequestTextBlock != nil && !strings.HasPrefix(block.OfRequestTextBlock.Text, summaryTag) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rewindNotice reminds the user that going back in the conversation leaves
+// the files as they are.
+const rewindNotice = "files the model changed since then are left as they are; /undo or /checkpoint restore them"
+
+// /retry command
+
+var RetryCommand = SlashCommand{
+	Name:        "retry",
+	Description: "Drop the last response and have the model answer the last prompt again",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		prompt := lastPrompt(conversation)
+		if prompt < 0 {
+			return conversation, fmt.Errorf("there is no prompt to retry")
+		}
+		a.loop.reset()
+		a.results.clear()
+		fmt.Printf("\u001b[93msystem\u001b[0m: retrying the last prompt, dropped %d messages; %s\n", len(conversation)-prompt-1, rewindNotice)
+		return conversation[:prompt+1], nil
+	},
+}
+
+// /edit command
+
+var EditCommand = SlashCommand{
+	Name:        "edit",
+	Usage:       "[new prompt]",
+	Description: "Replace the last prompt and drop everything after it, or show it",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		prompt := lastPrompt(conversation)
+		if prompt < 0 {
+			return conversation, fmt.Errorf("there is no prompt to edit")
+		}
+		message := conversation[prompt]
+		typed := promptText(message)
+		if typed < 0 {
+			return conversation, fmt.Errorf("the last prompt has no text to edit")
+		}
+		text := message.Content[typed].OfRequestTextBlock
+		if args == "" {
+			fmt.Printf("\u001b[93msystem\u001b[0m: the last prompt was:\n%s\n", text.Text)
+			fmt.Println("\u001b[93msystem\u001b[0m: type /edit followed by the new prompt to replace it")
+			return conversation, nil
+		}
+
+		blocks := append([]anthropic.ContentBlockParamUnion(nil), message.Content...)
+		blocks[typed] = anthropic.NewTextBlock(args)
+		edited := append(conversation[:prompt:prompt], anthropic.NewUserMessage(blocks...))
+		a.loop.reset()
+		a.results.clear()
+		fmt.Printf("\u001b[93msystem\u001b[0m: replaced the last prompt, dropped %d messages; %s\n", len(conversation)-prompt-1, rewindNotice)
+		return edited, nil
+	},
+}
@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTestTimeout = 5 * time.Minute
+	// maxTestFailures caps how many failures are reported in detail.
+	maxTestFailures = 20
+	// maxFailureMessage caps the message kept for each failure.
+	maxFailureMessage = 2000
+)
+
+// run_tests tool
+
+var RunTestsDefinition = ToolDefinition{
+	Name: "run_tests",
+	Description: `Run the project's tests and return a structured summary of the failures.
+
+The test framework is detected from the project files: go.mod (go test), package.json (npm test) or pyproject.toml/setup.py/pytest.ini (pytest). Each failure is reported with the test name, its message and the file:line it points at, when known. Use filter to run only matching tests.`,
+	InputSchema: RunTestsInputSchema,
+	Function:    RunTests,
+	Preview:     PreviewRunTests,
+}
+
+type RunTestsInput struct {
+	Path           string `json:"path,omitempty" jsonschema_description:"Optional relative directory of the project to test. Defaults to the workspace root."`
+	Filter         string `json:"filter,omitempty" jsonschema_description:"Optional test name pattern (go test -run, pytest -k, jest -t)."`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema_description:"Optional timeout in seconds. Defaults to 300, maximum 600."`
+}
+
+var RunTestsInputSchema = GenerateSchema[RunTestsInput]()
+
+// testReport is the structured result returned to the model.
+type testReport struct {
+	Framework string        `json:"framework"`
+	Command   string        `json:"command"`
+	Passed    bool          `json:"passed"`
+	ExitCode  int           `json:"exit_code"`
+	Summary   string        `json:"summary,omitempty"`
+	Failures  []testFailure `json:"failures,omitempty"`
+	// Output holds the tail of the raw log when failures could not be
+	// parsed from it.
+	Output string `json:"output,omitempty"`
+}
+
+type testFailure struct {
+	Name    string `json:"name"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// testFramework knows how to run and parse one kind of test suite.
+type testFramework struct {
+	Name    string
+	Markers []string
+	Command func(filter string) []string
+	Parse   func(output string, report *testReport)
+}
+
+var testFrameworks = []testFramework{
+	{
+		Name:    "go",
+		Markers: []string{"go.mod"},
+		Command: func(filter string) []string {
+			args := []string{"go", "test", "-json"}
+			if filter != "" {
+				args = append(args, "-run", filter)
+			}
+			return append(args, "./...")
+		},
+		Parse: parseGoTestOutput,
+	},
+	{
+		Name:    "npm",
+		Markers: []string{"package.json"},
+		Command: func(filter string) []string {
+			args := []string{"npm", "test", "--silent"}
+			if filter != "" {
+				args = append(args, "--", "-t", filter)
+			}
+			return args
+		},
+		Parse: parseJestOutput,
+	},
+	{
+		Name:    "pytest",
+		Markers: []string{"pyproject.toml", "pytest.ini", "setup.py", "setup.cfg", "tox.ini"},
+		Command: func(filter string) []string {
+			args := []string{"python", "-m", "pytest", "-q", "-rf", "--tb=short"}
+			if filter != "" {
+				args = append(args, "-k", filter)
+			}
+			return args
+		},
+		Parse: parsePytestOutput,
+	},
+}
+
+func RunTests(ctx context.Context, input json.RawMessage) (string, error) {
+	runTestsInput := RunTestsInput{}
+	err := json.Unmarshal(input, &runTestsInput)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := resolvePath(runTestsInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	framework, err := detectTestFramework(dir)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := defaultTestTimeout
+	if runTestsInput.TimeoutSeconds > 0 {
+		timeout = time.Duration(runTestsInput.TimeoutSeconds) * time.Second
+	}
+	if timeout > maxShellTimeout {
+		timeout = maxShellTimeout
+	}
+
+	args := framework.Command(runTestsInput.Filter)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.WaitDelay = commandWaitDelay
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", toolErrorf(ErrorTimeout, "tests timed out after %s\n%s", timeout, TruncateOutput(output.String(), maxShellOutput))
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("tests canceled: %w\n%s", ctx.Err(), TruncateOutput(output.String(), maxShellOutput))
+	}
+
+	report := testReport{
+		Framework: framework.Name,
+		Command:   strings.Join(args, " "),
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to run %s: %w", report.Command, err)
+		}
+		report.ExitCode = exitErr.ExitCode()
+	}
+	report.Passed = report.ExitCode == 0
+
+	framework.Parse(output.String(), &report)
+	if len(report.Failures) > maxTestFailures {
+		report.Summary += fmt.Sprintf(" (showing the first %d of %d failures)", maxTestFailures, len(report.Failures))
+		report.Failures = report.Failures[:maxTestFailures]
+	}
+	if !report.Passed && len(report.Failures) == 0 {
+		report.Output = lastBytes(output.String(), maxShellOutput)
+	}
+
+	result, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+func PreviewRunTests(input json.RawMessage) (string, bool) {
+	runTestsInput := RunTestsInput{}
+	err := json.Unmarshal(input, &runTestsInput)
+	if err != nil {
+		return fmt.Sprintf("run tests with invalid input: %s", input), false
+	}
+	return fmt.Sprintf("run tests in %s", orDefault(runTestsInput.Path, ".")), false
+}
+
+func detectTestFramework(dir string) (testFramework, error) {
+	for _, framework := range testFrameworks {
+		for _, marker := range framework.Markers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return framework, nil
+			}
+		}
+	}
+	return testFramework{}, fmt.Errorf("could not detect a test framework in %s, expected go.mod, package.json or a Python project file; use run_shell_command instead", dir)
+}
+
+// goTestEvent is one line of `go test -json` output.
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+var goFileLine = regexp.MustCompile(`([\w./-]+\.go):(\d+)`)
+
+func parseGoTestOutput(output string, report *testReport) {
+	type key struct{ pkg, test string }
+	logs := map[key][]string{}
+	var failed []key
+	passed := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		event := goTestEvent{}
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			// Build errors are printed as plain text outside the event stream.
+			if line := scanner.Text(); strings.TrimSpace(line) != "" {
+				logs[key{}] = append(logs[key{}], line)
+			}
+			continue
+		}
+
+		k := key{event.Package, event.Test}
+		switch event.Action {
+		case "build-output":
+			logs[key{}] = append(logs[key{}], strings.TrimRight(event.Output, "\n"))
+		case "output":
+			logs[k] = append(logs[k], strings.TrimRight(event.Output, "\n"))
+		case "pass":
+			if event.Test != "" {
+				passed++
+			}
+		case "fail":
+			failed = append(failed, k)
+		}
+	}
+
+	failedTests := 0
+	for _, k := range failed {
+		if k.test == "" {
+			continue
+		}
+		failedTests++
+		report.Failures = append(report.Failures, goFailure(k.pkg+"."+k.test, logs[k]))
+	}
+
+	// A package that failed without a failing test did not build or
+	// crashed outside a test.
+	for _, k := range failed {
+		if k.test != "" || hasFailedTest(report.Failures, k.pkg) {
+			continue
+		}
+		lines := append(logs[key{}], logs[k]...)
+		report.Failures = append(report.Failures, goFailure(orDefault(k.pkg, "build"), lines))
+	}
+	if len(failed) == 0 && len(logs[key{}]) > 0 && !report.Passed {
+		report.Failures = append(report.Failures, goFailure("build", logs[key{}]))
+	}
+
+	report.Summary = fmt.Sprintf("%d passed, %d failed", passed, failedTests)
+}
+
+func hasFailedTest(failures []testFailure, pkg string) bool {
+	for _, failure := range failures {
+		if strings.HasPrefix(failure.Name, pkg+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func goFailure(name string, lines []string) testFailure {
+	failure := testFailure{Name: name}
+	var message []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "=== ") || strings.HasPrefix(trimmed, "--- FAIL") ||
+			trimmed == "FAIL" || strings.HasPrefix(trimmed, "FAIL\t") {
+			continue
+		}
+		if failure.File == "" {
+			if match := goFileLine.FindStringSubmatch(trimmed); match != nil {
+				failure.File = match[1]
+				failure.Line, _ = strconv.Atoi(match[2])
+			}
+		}
+		message = append(message, trimmed)
+	}
+	failure.Message = TruncateOutput(strings.Join(message, "\n"), maxFailureMessage)
+	return failure
+}
+
+var (
+	pytestFailed  = regexp.MustCompile(`^FAILED (\S+?)(?: - (.*))?$`)
+	pytestSummary = regexp.MustCompile(`^=+ (.*(?:passed|failed|error).*) =+$`)
+)
+
+func parsePytestOutput(output string, report *testReport) {
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if match := pytestSummary.FindStringSubmatch(line); match != nil {
+			report.Summary = match[1]
+		} else if strings.Contains(line, " passed") || strings.Contains(line, " failed") {
+			// -q prints the summary without the ===== frame.
+			if report.Summary == "" && strings.Contains(line, " in ") {
+				report.Summary = line
+			}
+		}
+
+		match := pytestFailed.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		failure := testFailure{Name: match[1], Message: TruncateOutput(match[2], maxFailureMessage)}
+		file, _, _ := strings.Cut(match[1], "::")
+		failure.File = file
+		failure.Line = pytestFailureLine(lines, file)
+		report.Failures = append(report.Failures, failure)
+	}
+}
+
+// pytestFailureLine finds the "file:line:" location --tb=short prints for
+// a failure in file.
+func pytestFailureLine(lines []string, file string) int {
+	prefix := file + ":"
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), prefix)
+		if !ok {
+			continue
+		}
+		number, _, _ := strings.Cut(rest, ":")
+		if n, err := strconv.Atoi(number); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+var (
+	jestLocation = regexp.MustCompile(`\(?([^\s()]+\.[cm]?[jt]sx?):(\d+):\d+\)?`)
+	jestSummary  = regexp.MustCompile(`^Tests:\s+(.*)$`)
+)
+
+func parseJestOutput(output string, report *testReport) {
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if match := jestSummary.FindStringSubmatch(line); match != nil {
+			report.Summary = match[1]
+			continue
+		}
+
+		name, ok := strings.CutPrefix(line, "● ")
+		if !ok || strings.HasPrefix(name, "Test suite failed to run") {
+			continue
+		}
+
+		failure := testFailure{Name: name}
+		var message []string
+		for i+1 < len(lines) {
+			next := strings.TrimSpace(lines[i+1])
+			if strings.HasPrefix(next, "● ") || jestSummary.MatchString(next) {
+				break
+			}
+			i++
+			if match := jestLocation.FindStringSubmatch(next); match != nil && failure.File == "" && !strings.Contains(match[1], "node_modules") {
+				failure.File = match[1]
+				failure.Line, _ = strconv.Atoi(match[2])
+			}
+			if next != "" && !strings.HasPrefix(next, "at ") {
+				message = append(message, next)
+			}
+		}
+		failure.Message = TruncateOutput(strings.Join(message, "\n"), maxFailureMessage)
+		report.Failures = append(report.Failures, failure)
+	}
+}
+
+// lastBytes keeps the end of output, where test runners print their
+// summary.
+func lastBytes(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	return "... (truncated)\n" + output[len(output)-limit:]
+}
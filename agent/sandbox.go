@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"time"
+)
+
+// containerRemoveTimeout bounds removing a sandbox container whose command
+// was canceled.
+const containerRemoveTimeout = 10 * time.Second
+
+// SandboxSettings run shell commands in containers instead of on the host,
+// so an autonomous run cannot damage the machine. Each command of
+// run_shell_command, start_process and the custom tools gets a new container
+// of Image with only the workspace mounted, read-write at the same path so
+// paths in output match (at /workspace on Windows), and no network unless
+// Network is set. Other tools, hooks and plugins still run on the host.
+type SandboxSettings struct {
+	// Image is the container image; empty runs commands on the host.
+	Image string
+	// Network gives containers network access.
+	Network bool
+	// Runtime is the container CLI, docker or a compatible one such as
+	// podman.
+	Runtime string
+}
+
+// sandboxSettings is set from --sandbox, --sandbox-network and
+// --sandbox-runtime.
+var sandboxSettings SandboxSettings
+
+// checkSandbox makes sure the container CLI of a configured sandbox can be
+// found.
+func checkSandbox(settings SandboxSettings) error {
+	if settings.Image == "" {
+		return nil
+	}
+	_, err := exec.LookPath(settings.Runtime)
+	if err != nil {
+		return fmt.Errorf("the sandbox needs %s: %w", settings.Runtime, err)
+	}
+	slog.Info("running shell commands in a sandbox", "image", settings.Image, "network", settings.Network)
+	return nil
+}
+
+// shellCommand returns the command that runs command with the host's shell
+// in dir, or with sh inside a new sandbox container when one is configured.
+// container is the container's name, for removeContainer, and empty on the
+// host.
+func shellCommand(ctx context.Context, command, dir string) (cmd *exec.Cmd, container string) {
+	settings := sandboxSettings
+	if settings.Image == "" {
+		shell, args := hostShell(command)
+		cmd = exec.CommandContext(ctx, shell, args...)
+		cmd.Dir = dir
+		return cmd, ""
+	}
+
+	suffix := make([]byte, 6)
+	_, _ = rand.Read(suffix)
+	container = "system3-" + hex.EncodeToString(suffix)
+	args := []string{"run", "--rm", "--init", "--name", container,
+		"--volume", workspaceRoot + ":" + containerPath(workspaceRoot), "--workdir", containerPath(dir)}
+	if !settings.Network {
+		args = append(args, "--network", "none")
+	}
+	// Files the command creates in the workspace belong to the user rather
+	// than root.
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid), "--env", "HOME=/tmp")
+	}
+	args = append(args, settings.Image, "sh", "-c", command)
+
+	cmd = exec.CommandContext(ctx, settings.Runtime, args...)
+	cmd.Dir = dir
+	// Killing the container CLI leaves the container running, so a
+	// canceled command removes it too.
+	cmd.Cancel = func() error {
+		removeContainer(container)
+		return cmd.Process.Kill()
+	}
+	return cmd, container
+}
+
+// containerPath is where a path in the workspace is inside a sandbox
+// container. The workspace is mounted at its own path, except on Windows,
+// whose paths Linux containers cannot use; it is /workspace there.
+func containerPath(hostPath string) string {
+	if runtime.GOOS != "windows" {
+		return hostPath
+	}
+	rel, err := slashRel(workspaceRoot, hostPath)
+	if err != nil {
+		return "/workspace"
+	}
+	return path.Join("/workspace", rel)
+}
+
+// removeContainer stops and removes a sandbox container, if it still runs.
+func removeContainer(container string) {
+	if container == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), containerRemoveTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, sandboxSettings.Runtime, "rm", "--force", container).CombinedOutput()
+	if err != nil {
+		slog.Debug("failed to remove sandbox container", "container", container, "error", err, "output", string(output))
+	}
+}
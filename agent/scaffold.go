@@ -0,0 +1,372 @@
+package agent
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project templates are directories of files the scaffold tool copies into
+// the workspace, with {{NAME}} placeholders in file contents and names
+// filled in from its variables, as in prompt templates. A ".tmpl" suffix is
+// dropped from file names, which keeps Go tooling away from template
+// sources. An optional template.yaml describes the template:
+//
+//	description: Go HTTP JSON API with a health check
+//	variables:
+//	  MODULE: Module path, such as github.com/acme/orders
+//
+// Templates are looked up in .system3/templates of the workspace, then in
+// templates in the user data directory, then among the built-in ones, so a
+// template can replace a built-in one of the same name.
+
+//go:embed all:templates
+var builtinTemplates embed.FS
+
+// projectTemplatesDir holds the project's templates, relative to the
+// workspace.
+var projectTemplatesDir = filepath.Join(".system3", "templates")
+
+// templateManifest is the template.yaml file of a template, which is not
+// copied.
+const templateManifest = "template.yaml"
+
+type scaffoldTemplate struct {
+	name   string
+	source string
+	files  fs.FS
+	// Description and Variables come from template.yaml; Variables
+	// describes each variable for the model.
+	Description string            `yaml:"description"`
+	Variables   map[string]string `yaml:"variables"`
+}
+
+// scaffoldFile is a file of a template, with its variables filled in.
+type scaffoldFile struct {
+	path    string
+	content []byte
+	mode    os.FileMode
+}
+
+// templateSource is a directory of templates; source is how templates
+// from it are described.
+type templateSource struct {
+	source string
+	dir    string
+}
+
+// templateSources returns the directories templates are looked up in, in
+// order.
+func templateSources() []templateSource {
+	sources := []templateSource{{projectTemplatesDir, filepath.Join(workspaceRoot, projectTemplatesDir)}}
+	if dir, err := userDataDir(); err == nil {
+		sources = append(sources, templateSource{filepath.Join(dir, "templates"), filepath.Join(dir, "templates")})
+	}
+	return sources
+}
+
+// scaffoldTemplates returns every available template by name, sorted.
+func scaffoldTemplates() ([]*scaffoldTemplate, error) {
+	names := map[string]bool{}
+	for _, source := range templateSources() {
+		entries, err := os.ReadDir(source.dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to list templates in %s: %w", source.source, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				names[entry.Name()] = true
+			}
+		}
+	}
+	entries, err := builtinTemplates.ReadDir("templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list built-in templates: %w", err)
+	}
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	var templates []*scaffoldTemplate
+	for name := range names {
+		template, err := loadScaffoldTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].name < templates[j].name })
+	return templates, nil
+}
+
+func loadScaffoldTemplate(name string) (*scaffoldTemplate, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, toolErrorf(ErrorInvalidInput, "invalid template name %q", name)
+	}
+
+	template := &scaffoldTemplate{name: name}
+	for _, source := range templateSources() {
+		info, err := os.Stat(filepath.Join(source.dir, name))
+		if err == nil && info.IsDir() {
+			template.source = source.source
+			template.files = os.DirFS(filepath.Join(source.dir, name))
+			break
+		}
+	}
+	if template.files == nil {
+		files, err := fs.Sub(builtinTemplates, path.Join("templates", name))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fs.Stat(files, "."); err != nil {
+			return nil, toolErrorf(ErrorNotFound, "template %s not found, call scaffold without a template to list them", name)
+		}
+		template.source = "built-in"
+		template.files = files
+	}
+
+	manifest, err := fs.ReadFile(template.files, templateManifest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s of template %s: %w", templateManifest, name, err)
+	}
+	err = yaml.Unmarshal(manifest, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s of template %s: %w", templateManifest, name, err)
+	}
+	return template, nil
+}
+
+// render fills in the template's variables in every file's name and text
+// content. As with prompt templates, every variable needs a value and every
+// value a variable.
+func (t *scaffoldTemplate) render(values map[string]string) ([]scaffoldFile, error) {
+	var files []scaffoldFile
+	used := map[string]bool{}
+	var missing []string
+	fill := func(text string) string {
+		return promptVariable.ReplaceAllStringFunc(text, func(placeholder string) string {
+			name := promptVariable.FindStringSubmatch(placeholder)[1]
+			value, ok := values[name]
+			if !ok && !used[name] {
+				missing = append(missing, name)
+			}
+			used[name] = true
+			return value
+		})
+	}
+
+	err := fs.WalkDir(t.files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || name == templateManifest {
+			return nil
+		}
+		content, err := fs.ReadFile(t.files, name)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !isBinary(content) {
+			content = []byte(fill(string(content)))
+		}
+		mode := newFileMode
+		if info.Mode().Perm()&0111 != 0 {
+			mode |= 0111
+		}
+		files = append(files, scaffoldFile{path: strings.TrimSuffix(fill(name), ".tmpl"), content: content, mode: mode})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", t.name, err)
+	}
+
+	for name := range t.Variables {
+		if !used[name] {
+			used[name] = true
+			if _, ok := values[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, toolErrorf(ErrorInvalidInput, "template %s needs the variables %s", t.name, strings.Join(missing, ", "))
+	}
+	for name := range values {
+		if !used[name] {
+			return nil, toolErrorf(ErrorInvalidInput, "template %s has no variable %s, it uses: %s", t.name, name, orDefault(strings.Join(slices.Sorted(maps.Keys(used)), ", "), "none"))
+		}
+	}
+	return files, nil
+}
+
+// describe lists the template for the model, with its variables.
+func (t *scaffoldTemplate) describe() string {
+	line := fmt.Sprintf("- %s (%s)", t.name, t.source)
+	if t.Description != "" {
+		line += ": " + t.Description
+	}
+	for _, name := range slices.Sorted(maps.Keys(t.Variables)) {
+		line += fmt.Sprintf("\n    %s: %s", name, t.Variables[name])
+	}
+	return line
+}
+
+// scaffold tool
+
+var ScaffoldDefinition = ToolDefinition{
+	Name: "scaffold",
+	Description: `Create a new project from a template, such as a Go module, command-line program or web API, with its variables filled in.
+
+Call it without a template to list the templates and the variables each needs. Templates come from directories in .system3/templates of the workspace, then from templates in the user data directory, then built in. Files are written one at a time, after checking that none of them exists yet; nothing is written if any does. Adapt the generated files with edit_file afterwards.`,
+	InputSchema: ScaffoldInputSchema,
+	Function:    Scaffold,
+	Preview:     PreviewScaffold,
+	Mutating:    ScaffoldMutating,
+}
+
+type ScaffoldInput struct {
+	Template  string            `json:"template,omitempty" jsonschema_description:"The template to create the project from. Omit to list the templates."`
+	Path      string            `json:"path,omitempty" jsonschema_description:"The relative directory to create the project in. Defaults to the workspace root."`
+	Variables map[string]string `json:"variables,omitempty" jsonschema_description:"The template's variables by name, such as {\"MODULE\": \"github.com/acme/orders\"}."`
+}
+
+var ScaffoldInputSchema = GenerateSchema[ScaffoldInput]()
+
+func Scaffold(ctx context.Context, input json.RawMessage) (string, error) {
+	scaffoldInput := ScaffoldInput{}
+	err := json.Unmarshal(input, &scaffoldInput)
+	if err != nil {
+		return "", err
+	}
+	if scaffoldInput.Template == "" {
+		return listScaffoldTemplates()
+	}
+
+	targets, files, err := planScaffold(scaffoldInput)
+	if err != nil {
+		return "", err
+	}
+
+	state := toolStateFor(ctx)
+	// Every created directory and file is recorded, so undo_edit removes
+	// the whole project again.
+	var created []string
+	for i, file := range files {
+		dirs, err := missingDirs(filepath.Dir(targets[i]))
+		if err != nil {
+			return "", err
+		}
+		for _, dir := range dirs {
+			err = state.journal.Record("scaffold", dir)
+			if err != nil {
+				return "", err
+			}
+			err = os.Mkdir(dir, 0755)
+			if err != nil {
+				return "", fmt.Errorf("failed to create directory %s: %w", relativeToWorkspace(dir), err)
+			}
+		}
+		err = state.journal.Record("scaffold", targets[i])
+		if err != nil {
+			return "", err
+		}
+		err = writeFileAtomic(targets[i], file.content, file.mode)
+		if err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", relativeToWorkspace(targets[i]), err)
+		}
+		state.watched.seen(targets[i])
+		created = append(created, relativeToWorkspace(targets[i]))
+	}
+	return fmt.Sprintf("Created %d files from template %s:\n%s", len(created), scaffoldInput.Template, strings.Join(created, "\n")), nil
+}
+
+// planScaffold renders the template and resolves where each file goes,
+// refusing before anything is written when one of them exists.
+func planScaffold(scaffoldInput ScaffoldInput) ([]string, []scaffoldFile, error) {
+	template, err := loadScaffoldTemplate(scaffoldInput.Template)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := template.render(scaffoldInput.Variables)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets := make([]string, len(files))
+	var existing []string
+	for i, file := range files {
+		// Variables could name paths outside the project, so every file is
+		// resolved like a path the model gave.
+		targets[i], err = resolvePath(path.Join(normalizeToolPath(scaffoldInput.Path), file.path))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = checkWritePath("scaffold", targets[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := os.Lstat(targets[i]); err == nil {
+			existing = append(existing, relativeToWorkspace(targets[i]))
+		}
+	}
+	if len(existing) > 0 {
+		return nil, nil, toolErrorf(ErrorInvalidInput, "not creating the project, these files already exist: %s", strings.Join(existing, ", "))
+	}
+	return targets, files, nil
+}
+
+func listScaffoldTemplates() (string, error) {
+	templates, err := scaffoldTemplates()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{"Templates, with the variables each needs:"}
+	for _, template := range templates {
+		lines = append(lines, template.describe())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func PreviewScaffold(input json.RawMessage) (string, bool) {
+	scaffoldInput := ScaffoldInput{}
+	err := json.Unmarshal(input, &scaffoldInput)
+	if err != nil {
+		return fmt.Sprintf("scaffold with invalid input: %s", input), true
+	}
+	if scaffoldInput.Template == "" {
+		return "list project templates", false
+	}
+	targets, _, err := planScaffold(scaffoldInput)
+	if err != nil {
+		return fmt.Sprintf("scaffold %s: %v", scaffoldInput.Template, err), false
+	}
+	created := make([]string, len(targets))
+	for i, target := range targets {
+		created[i] = "+ " + relativeToWorkspace(target)
+	}
+	return fmt.Sprintf("create a project from template %s:\n%s", scaffoldInput.Template, strings.Join(created, "\n")), true
+}
+
+// ScaffoldMutating reports whether a call creates files; listing the
+// templates changes nothing.
+func ScaffoldMutating(ctx context.Context, input json.RawMessage) bool {
+	scaffoldInput := ScaffoldInput{}
+	return json.Unmarshal(input, &scaffoldInput) != nil || scaffoldInput.Template != ""
+}
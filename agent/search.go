@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultSearchMaxResults = 100
+	// maxSearchLineLength keeps minified files from flooding the results.
+	maxSearchLineLength = 300
+)
+
+// search_files tool
+
+var SearchFilesDefinition = ToolDefinition{
+	Name: "search_files",
+	Description: `Search file contents in the workspace with a regular expression (Go RE2 syntax).
+
+Returns matches as "path:line: text". Use include/exclude globs (e.g. "*.go", "vendor/**") to narrow the search. Hidden directories such as .git and files that may hold secrets, such as .env, are skipped.`,
+	InputSchema: SearchFilesInputSchema,
+	Function:    SearchFiles,
+}
+
+type SearchFilesInput struct {
+	Pattern         string `json:"pattern" jsonschema_description:"Regular expression to search for"`
+	Path            string `json:"path,omitempty" jsonschema_description:"Optional relative directory to search in. Defaults to the current directory."`
+	Include         string `json:"include,omitempty" jsonschema_description:"Optional comma-separated globs; only matching files are searched"`
+	Exclude         string `json:"exclude,omitempty" jsonschema_description:"Optional comma-separated globs; matching files and directories are skipped"`
+	CaseInsensitive bool   `json:"case_insensitive,omitempty" jsonschema_description:"Match without regard to case"`
+	MaxResults      int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of matches to return. Defaults to 100."`
+}
+
+var SearchFilesInputSchema = GenerateSchema[SearchFilesInput]()
+
+func SearchFiles(ctx context.Context, input json.RawMessage) (string, error) {
+	searchInput := SearchFilesInput{}
+	err := json.Unmarshal(input, &searchInput)
+	if err != nil {
+		return "", err
+	}
+
+	if searchInput.Pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+
+	pattern := searchInput.Pattern
+	if searchInput.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	dir, err := resolvePath(searchInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	maxResults := defaultSearchMaxResults
+	if searchInput.MaxResults > 0 {
+		maxResults = searchInput.MaxResults
+	}
+
+	includes := splitGlobs(searchInput.Include)
+	excludes := splitGlobs(searchInput.Exclude)
+
+	var results []string
+	truncated := false
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := slashRel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if relPath != "." && (strings.HasPrefix(info.Name(), ".") || matchesAnyGlob(relPath, excludes)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks are skipped so a link cannot expose files outside the
+		// workspace.
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if matchesAnyGlob(relPath, excludes) || (len(includes) > 0 && !matchesAnyGlob(relPath, includes)) {
+			return nil
+		}
+		if isSecretFile(path) {
+			return nil
+		}
+
+		matches, err := searchFile(path, relPath, re, maxResults-len(results))
+		if err != nil {
+			return nil
+		}
+		results = append(results, matches...)
+
+		if len(results) >= maxResults {
+			truncated = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(results) == 0 {
+		return "No matches found", nil
+	}
+
+	output := strings.Join(results, "\n")
+	if truncated {
+		output += fmt.Sprintf("\n(results capped at %d matches)", maxResults)
+	}
+	return output, nil
+}
+
+// searchFile returns up to limit matching lines from a single file. Binary
+// files are skipped.
+func searchFile(path, relPath string, re *regexp.Regexp, limit int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matches []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() && len(matches) < limit {
+		lineNumber++
+		line := scanner.Text()
+		if strings.ContainsRune(line, 0) {
+			return nil, nil
+		}
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(line) > maxSearchLineLength {
+			line = line[:maxSearchLineLength] + "..."
+		}
+		matches = append(matches, fmt.Sprintf("%s:%d: %s", relPath, lineNumber, line))
+	}
+
+	return matches, scanner.Err()
+}
+
+func splitGlobs(globs string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(globs, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"path/filepath"
+)
+
+// defaultSecretFiles are the files that usually hold credentials. Tools that
+// read file contents refuse them, so asking carelessly for "all the config"
+// cannot send keys to the model.
+var defaultSecretFiles = []string{
+	".env", ".env.*", "*.pem", "*.key", "*.p12", "*.pfx", "*.jks", "*.keystore",
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	"credentials.json", "service-account*.json", ".git-credentials",
+	".netrc", ".npmrc", ".pypirc", ".pgpass", "*.tfstate",
+}
+
+// defaultSecretFileExceptions are the templates that match a secret file
+// pattern but hold no secrets by convention.
+var defaultSecretFileExceptions = []string{".env.example", ".env.sample", ".env.template"}
+
+// secretFiles are the secret file rules from the permissions file, set at
+// setup: Deny adds patterns to the defaults and Allow exempts files from
+// them, so allow: ["*"] turns the check off.
+var secretFiles PatternRules
+
+// isSecretFile reports whether path, an absolute path inside the workspace,
+// is a file whose contents must not be read.
+func isSecretFile(path string) bool {
+	relPath, err := slashRel(workspaceRoot, path)
+	if err != nil {
+		relPath = filepath.Base(path)
+	}
+	if matchesAnyGlob(relPath, defaultSecretFileExceptions) || matchesAnyGlob(relPath, secretFiles.Allow) {
+		return false
+	}
+	return matchesAnyGlob(relPath, defaultSecretFiles) || matchesAnyGlob(relPath, secretFiles.Deny)
+}
+
+// refuseSecretFile explains to the model why a secret file cannot be read.
+func refuseSecretFile(name string) error {
+	return toolErrorf(ErrorPermissionDenied, "%s may hold secrets and is not read; allow it under secret_files in the permissions file if it is safe", name)
+}
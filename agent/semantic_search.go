@@ -0,0 +1,371 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// chunkLines is the most lines a chunk covers. Chunks follow top-level
+	// declarations where the file's language is known.
+	chunkLines = 60
+	// maxChunkBytes keeps a chunk of very long lines within the models'
+	// input limits.
+	maxChunkBytes = 8000
+	// maxIndexFileSize skips generated and vendored blobs.
+	maxIndexFileSize = 256 * 1024
+
+	defaultSemanticResults = 10
+	maxSemanticResults     = 50
+	// snippetLines is how much of each matching chunk is shown.
+	snippetLines = 8
+)
+
+// semanticIndex holds the embeddings of every chunk of the workspace's
+// text files. It is kept on disk between sessions and brought up to date
+// before each search, so only files that changed are embedded again.
+type semanticIndex struct {
+	// Model is the provider and model the vectors come from.
+	Model string
+	Files map[string]indexedFile
+}
+
+type indexedFile struct {
+	ModTime time.Time
+	Size    int64
+	Hash    string
+	Chunks  []indexedChunk
+}
+
+type indexedChunk struct {
+	StartLine int
+	EndLine   int
+	Vector    []float32
+}
+
+// semanticIndexMu serializes index updates, for sub-agents searching in
+// parallel.
+var semanticIndexMu sync.Mutex
+
+// semanticIndexPath is where the workspace's index is kept,
+// index/<hash of the workspace path>.gob in the user data directory.
+func semanticIndexPath() (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(workspaceRoot))
+	return filepath.Join(dir, "index", hex.EncodeToString(sum[:8])+".gob"), nil
+}
+
+// loadSemanticIndex reads the saved index, starting a new one when there is
+// none or it was built with a different model.
+func loadSemanticIndex(path, model string) (*semanticIndex, error) {
+	index := &semanticIndex{Model: model, Files: map[string]indexedFile{}}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer file.Close()
+
+	saved := &semanticIndex{}
+	err = gob.NewDecoder(file).Decode(saved)
+	if err != nil {
+		slog.Warn("rebuilding unreadable semantic search index", "path", path, "error", err)
+		return index, nil
+	}
+	if saved.Model != model {
+		slog.Info("rebuilding semantic search index for a new embeddings model", "old", saved.Model, "new", model)
+		return index, nil
+	}
+	return saved, nil
+}
+
+func (index *semanticIndex) save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	var data bytes.Buffer
+	err = gob.NewEncoder(&data).Encode(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	// Write then rename, so an interrupted save leaves the old index.
+	temporary := path + ".tmp"
+	err = os.WriteFile(temporary, data.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	err = os.Rename(temporary, path)
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// update embeds the files that are new or changed since the last update and
+// forgets deleted ones. It reports whether anything changed.
+func (index *semanticIndex) update(ctx context.Context, api *embeddingsAPI) (bool, error) {
+	paths, _, err := walkRepository(workspaceRoot)
+	if err != nil {
+		return false, err
+	}
+
+	type pendingChunk struct {
+		path  string
+		chunk int
+		text  string
+	}
+	var pending []pendingChunk
+	files := map[string]indexedFile{}
+	changed := false
+	for _, path := range paths {
+		fullPath := filepath.Join(workspaceRoot, filepath.FromSlash(path))
+		info, err := os.Stat(fullPath)
+		if err != nil || info.Size() > maxIndexFileSize || isSecretFile(fullPath) {
+			continue
+		}
+		existing, ok := index.Files[path]
+		if ok && existing.Size == info.Size() && existing.ModTime.Equal(info.ModTime()) {
+			files[path] = existing
+			continue
+		}
+
+		content, err := os.ReadFile(fullPath)
+		if err != nil || !isText(content) {
+			continue
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		if ok && existing.Hash == hash {
+			// Touched but not changed.
+			existing.ModTime, existing.Size = info.ModTime(), info.Size()
+			files[path] = existing
+			changed = true
+			continue
+		}
+
+		file := indexedFile{ModTime: info.ModTime(), Size: info.Size(), Hash: hash}
+		lines := strings.Split(string(content), "\n")
+		for _, lineRange := range chunkFile(fullPath, lines) {
+			text := strings.Join(lines[lineRange[0]-1:lineRange[1]], "\n")
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			file.Chunks = append(file.Chunks, indexedChunk{StartLine: lineRange[0], EndLine: lineRange[1]})
+			// The path tells the model what the code is as much as the code
+			// itself does.
+			text = fmt.Sprintf("%s:%d-%d\n%s", path, lineRange[0], lineRange[1], text)
+			pending = append(pending, pendingChunk{path: path, chunk: len(file.Chunks) - 1, text: TruncateOutput(text, maxChunkBytes)})
+		}
+		files[path] = file
+		changed = true
+	}
+	if len(files) != len(index.Files) {
+		changed = true
+	}
+
+	if len(pending) > 0 {
+		slog.Info("embedding files for semantic search", "chunks", len(pending), "model", index.Model)
+		texts := make([]string, len(pending))
+		for i, chunk := range pending {
+			texts[i] = chunk.text
+		}
+		vectors, err := api.embed(ctx, texts, false)
+		if err != nil {
+			return false, err
+		}
+		for i, chunk := range pending {
+			files[chunk.path].Chunks[chunk.chunk].Vector = normalize(vectors[i])
+		}
+	}
+	index.Files = files
+	return changed, nil
+}
+
+// isText reports whether content looks like a text file: valid UTF-8 with
+// no NUL bytes.
+func isText(content []byte) bool {
+	return bytes.IndexByte(content, 0) < 0 && utf8.Valid(content)
+}
+
+// chunkFile splits a file's lines into 1-based inclusive line ranges of at
+// most chunkLines. Source files are split between top-level declarations,
+// merging small neighbours; other files into even windows.
+func chunkFile(path string, lines []string) [][2]int {
+	var segments [][2]int
+	if _, ok := outlineLanguageFor(path); ok {
+		outline, err := parseOutline(path)
+		if err == nil {
+			start := 1
+			for _, symbol := range outline.Symbols {
+				if symbol.Depth != 0 || symbol.EndLine < start {
+					continue
+				}
+				segments = append(segments, [2]int{start, symbol.EndLine})
+				start = symbol.EndLine + 1
+			}
+			if start <= len(lines) {
+				segments = append(segments, [2]int{start, len(lines)})
+			}
+		}
+	}
+	if len(segments) == 0 {
+		segments = [][2]int{{1, len(lines)}}
+	}
+
+	var chunks [][2]int
+	for _, segment := range segments {
+		for start := segment[0]; start <= segment[1]; start += chunkLines {
+			end := min(start+chunkLines-1, segment[1])
+			last := len(chunks) - 1
+			if last >= 0 && end-chunks[last][0] < chunkLines {
+				chunks[last][1] = end
+				continue
+			}
+			chunks = append(chunks, [2]int{start, end})
+		}
+	}
+	return chunks
+}
+
+// normalize scales a vector to unit length, so a dot product is the cosine
+// similarity.
+func normalize(vector []float32) []float32 {
+	var sum float64
+	for _, value := range vector {
+		sum += float64(value) * float64(value)
+	}
+	if sum == 0 {
+		return vector
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return vector
+}
+
+// semantic_search tool
+
+var SemanticSearchDefinition = ToolDefinition{
+	Name: "semantic_search",
+	Description: `Search the workspace by meaning rather than exact text, using embeddings of its files.
+
+Describe what you are looking for in natural language, for example "where retries are configured for HTTP requests" or "code that parses the config file". Returns the best matching sections as "path:start-end (score)" with their first lines. Use search_files instead for exact names or patterns.
+
+The index is kept between sessions and updated before each search, so only changed files are embedded again; the first search in a large workspace can take a while.`,
+	InputSchema: SemanticSearchInputSchema,
+	Function:    SemanticSearch,
+}
+
+type SemanticSearchInput struct {
+	Query      string `json:"query" jsonschema_description:"What to look for, in natural language."`
+	MaxResults int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of sections to return. Defaults to 10."`
+}
+
+var SemanticSearchInputSchema = GenerateSchema[SemanticSearchInput]()
+
+func SemanticSearch(ctx context.Context, input json.RawMessage) (string, error) {
+	searchInput := SemanticSearchInput{}
+	err := json.Unmarshal(input, &searchInput)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(searchInput.Query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	maxResults := defaultSemanticResults
+	if searchInput.MaxResults > 0 {
+		maxResults = min(searchInput.MaxResults, maxSemanticResults)
+	}
+
+	api, err := newEmbeddingsAPI()
+	if err != nil {
+		return "", err
+	}
+	indexPath, err := semanticIndexPath()
+	if err != nil {
+		return "", err
+	}
+
+	semanticIndexMu.Lock()
+	defer semanticIndexMu.Unlock()
+
+	index, err := loadSemanticIndex(indexPath, api.name())
+	if err != nil {
+		return "", err
+	}
+	changed, err := index.update(ctx, api)
+	if err != nil {
+		return "", fmt.Errorf("failed to index the workspace: %w", err)
+	}
+	if changed {
+		err = index.save(indexPath)
+		if err != nil {
+			slog.Warn("failed to save semantic search index", "error", err)
+		}
+	}
+
+	queryVectors, err := api.embed(ctx, []string{searchInput.Query}, true)
+	if err != nil {
+		return "", err
+	}
+	query := normalize(queryVectors[0])
+
+	type match struct {
+		path  string
+		chunk indexedChunk
+		score float64
+	}
+	var matches []match
+	for path, file := range index.Files {
+		for _, chunk := range file.Chunks {
+			if len(chunk.Vector) != len(query) {
+				continue
+			}
+			var score float64
+			for i, value := range chunk.Vector {
+				score += float64(value) * float64(query[i])
+			}
+			matches = append(matches, match{path: path, chunk: chunk, score: score})
+		}
+	}
+	if len(matches) == 0 {
+		return "No indexed files to search", nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	matches = matches[:min(maxResults, len(matches))]
+
+	var results []string
+	for _, m := range matches {
+		content, err := os.ReadFile(filepath.Join(workspaceRoot, filepath.FromSlash(m.path)))
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(content), "\n")
+		end := min(m.chunk.EndLine, m.chunk.StartLine+snippetLines-1, len(lines))
+		snippet := strings.Join(lines[min(m.chunk.StartLine, end)-1:end], "\n")
+		results = append(results, fmt.Sprintf("%s:%d-%d (%.2f)\n%s", m.path, m.chunk.StartLine, m.chunk.EndLine, m.score, prefixLines(snippet, "    ")))
+	}
+	return strings.Join(results, "\n\n"), nil
+}
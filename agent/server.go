@@ -0,0 +1,416 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const (
+	defaultServeAddr = "127.0.0.1:8421"
+	// eventStreamBuffer is how many events a slow event stream may fall
+	// behind before it is closed, rather than hold up the agent.
+	eventStreamBuffer = 1024
+	// eventStreamKeepAlive is how often an idle event stream sends a comment,
+	// so proxies do not close it.
+	eventStreamKeepAlive  = 30 * time.Second
+	serverShutdownTimeout = 10 * time.Second
+	// serveTokenBytes is the length of generated tokens.
+	serveTokenBytes = 32
+)
+
+// Server exposes agents over HTTP, one per session, so web UIs and other
+// services can drive them remotely. Requests and responses are JSON, and a
+// session's events are streamed as server-sent events:
+//
+//	GET  /sessions                list the open sessions
+//	POST /sessions                open a new session, or resume a saved one with {"resume": "<id>"}
+//	GET  /sessions/{id}           a session and its messages
+//	POST /sessions/{id}/messages  send {"text": "..."} and wait for the answer
+//	GET  /sessions/{id}/events    stream the session's events
+//	DELETE /sessions/{id}         close the session, stopping its background processes
+//
+// Every request needs the token as a bearer token: SYSTEM3_SERVE_TOKEN, or
+// one generated at start. Requests must name the server by an IP address,
+// localhost or the host it listens on, come from no other web origin, and
+// send their bodies as application/json, so web pages cannot drive the
+// agent through the user's browser.
+//
+// Nobody is at a terminal to approve tool calls, so calls that need approval
+// are refused unless the permissions file allows them or auto-approve is on.
+type Server struct {
+	provider Provider
+	tools    []ToolDefinition
+	config   Config
+	token    string
+
+	mu     sync.Mutex
+	agents map[string]*Agent
+}
+
+// NewServer creates a server for config, generating a token when
+// config.ServeToken is empty.
+func NewServer(provider Provider, tools []ToolDefinition, config Config) (*Server, error) {
+	config.Headless = true
+	// Sessions would overwrite each other's transcript.
+	config.Transcript = ""
+	token := config.ServeToken
+	if token == "" {
+		random := make([]byte, serveTokenBytes)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate a token: %w", err)
+		}
+		token = hex.EncodeToString(random)
+	}
+	return &Server{provider: provider, tools: tools, config: config, token: token, agents: map[string]*Agent{}}, nil
+}
+
+// Token returns the bearer token requests need.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// ListenAndServe serves the API on addr until ctx is done, then shuts down
+// and closes the sessions' agents.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	// Requests end with ctx, so event streams and messages in flight do
+	// not hold up the shutdown.
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     s.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	slog.Info("serving the agent API", "addr", addr)
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		err = server.Shutdown(shutdownCtx)
+	}
+	s.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the API's HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.listSessions)
+	mux.HandleFunc("POST /sessions", s.createSession)
+	mux.HandleFunc("GET /sessions/{id}", s.getSession)
+	mux.HandleFunc("POST /sessions/{id}/messages", s.sendMessage)
+	mux.HandleFunc("GET /sessions/{id}/events", s.streamEvents)
+	mux.HandleFunc("DELETE /sessions/{id}", s.closeSession)
+	return s.authenticate(mux)
+}
+
+// Close closes every session's agent.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, a := range s.agents {
+		a.Close()
+		delete(s.agents, id)
+	}
+}
+
+// authenticate requires the token as a bearer token, and refuses requests
+// a web page could have sent on the user's behalf.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := s.checkOrigin(r)
+		if err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		if r.ContentLength != 0 && r.Method != http.MethodGet {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeJSONError(w, http.StatusUnsupportedMediaType, errors.New("request bodies must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin refuses requests sent to another name than the server's, as
+// after DNS rebinding, and requests from web pages of other origins.
+func (s *Server) checkOrigin(r *http.Request) error {
+	if !s.allowedHost(r.Host) {
+		return fmt.Errorf("unexpected Host %q", r.Host)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host != r.Host {
+		return fmt.Errorf("unexpected Origin %q", origin)
+	}
+	return nil
+}
+
+// allowedHost reports whether host, a Host header, names this server: an IP
+// address, which DNS rebinding cannot produce, localhost, or the host the
+// server listens on.
+func (s *Server) allowedHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.Trim(host, "[]")
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	listening, _, err := net.SplitHostPort(s.config.ServeAddr)
+	return err == nil && listening != "" && strings.EqualFold(host, listening)
+}
+
+// sessionInfo describes a session in API responses.
+type sessionInfo struct {
+	ID        string          `json:"id"`
+	Title     string          `json:"title,omitempty"`
+	Model     anthropic.Model `json:"model"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Messages  int             `json:"messages"`
+}
+
+func describeSession(a *Agent) sessionInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return sessionInfo{
+		ID:        a.session.ID,
+		Title:     a.session.Title,
+		Model:     a.session.Model,
+		CreatedAt: a.session.CreatedAt,
+		UpdatedAt: a.session.UpdatedAt,
+		Messages:  len(a.session.Messages),
+	}
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	sessions := []sessionInfo{}
+	for _, a := range s.agents {
+		sessions = append(sessions, describeSession(a))
+	}
+	s.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Resume string `json:"resume"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+	}
+
+	// The lookup and the insert happen under one lock, so concurrent
+	// resumes of a session share one agent instead of two writing the same
+	// session file.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := NewSession(s.config.Model)
+	if request.Resume != "" {
+		if a, ok := s.agents[request.Resume]; ok {
+			writeJSON(w, http.StatusOK, describeSession(a))
+			return
+		}
+		var err error
+		session, err = LoadSession(request.Resume)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+	}
+
+	tools := append([]ToolDefinition(nil), s.tools...)
+	a := NewAgent(s.provider, nil, tools, s.config, session)
+	s.agents[session.ID] = a
+	writeJSON(w, http.StatusCreated, describeSession(a))
+}
+
+// closeSession closes the session's agent, which stops its background
+// processes. The session stays saved and can be resumed.
+func (s *Server) closeSession(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.sessionAgent(w, r)
+	if !ok {
+		return
+	}
+	// The agent is never unlocked again, so requests that found it before
+	// it was removed get ErrBusy instead of running a closed agent.
+	if !a.running.TryLock() {
+		writeJSONError(w, http.StatusConflict, ErrBusy)
+		return
+	}
+	s.mu.Lock()
+	delete(s.agents, r.PathValue("id"))
+	s.mu.Unlock()
+	a.closeSession()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) agent(id string) (*Agent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.agents[id]
+	return a, ok
+}
+
+// sessionAgent returns the agent of the session named in the request path,
+// answering with 404 when there is none.
+func (s *Server) sessionAgent(w http.ResponseWriter, r *http.Request) (*Agent, bool) {
+	a, ok := s.agent(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("session %s is not open", r.PathValue("id")))
+	}
+	return a, ok
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.sessionAgent(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session":  describeSession(a),
+		"messages": a.Conversation(),
+	})
+}
+
+// sendMessage runs a message to its answer. Closing the request interrupts
+// the agent.
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.sessionAgent(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		Text string `json:"text"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if request.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("text is required"))
+		return
+	}
+
+	answer, err := a.Send(r.Context(), anthropic.NewTextBlock(request.Text))
+	switch {
+	case errors.Is(err, ErrBusy):
+		writeJSONError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrLoopStopped) || errors.Is(err, ErrBudgetExceeded):
+		writeJSON(w, http.StatusOK, map[string]string{"answer": answer, "stopped": err.Error()})
+	case err != nil:
+		writeJSONError(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"answer": answer})
+	}
+}
+
+// streamEvents sends the session's events as server-sent events, each named
+// by its type with the event as JSON data, until the client disconnects.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.sessionAgent(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	events := make(chan Event, eventStreamBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	detach := a.Attach(FrontendFunc(func(event Event) {
+		select {
+		case events <- event:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	}))
+	defer detach()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-overflow:
+			fmt.Fprint(w, "event: error\ndata: {\"error\":\"the event stream fell too far behind and was closed\"}\n\n")
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Warn("failed to encode event", "type", event.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		slog.Debug("failed to write response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,343 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Anthropic-defined tools that --server-tools enables. Web search and code
+// execution run on Anthropic's servers, which send back both the call and
+// its result in the reply. The text editor is a schema the model is trained
+// on; its calls run here, through the file tools.
+const (
+	ServerToolWebSearch     = "web_search"
+	ServerToolCodeExecution = "code_execution"
+	ServerToolTextEditor    = "text_editor"
+)
+
+var serverToolNames = []string{ServerToolWebSearch, ServerToolCodeExecution, ServerToolTextEditor}
+
+const (
+	// maxWebSearches caps the searches the model may run in one reply.
+	maxWebSearches = 5
+	// codeExecutionBeta is the beta header code execution needs.
+	codeExecutionBeta = "code-execution-2025-05-22"
+	// textEditorName is the name the model calls the text editor by.
+	textEditorName = "str_replace_editor"
+)
+
+// parseServerTools splits a comma-separated list of server tool names.
+func parseServerTools(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(serverToolNames, name) {
+			return nil, fmt.Errorf("unknown server tool %q, expected %s", name, strings.Join(serverToolNames, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// serverToolParams returns the request entries of the enabled tools that run
+// on Anthropic's servers. The SDK has no types for them, so each is sent as
+// a bash tool entry with every field replaced.
+func serverToolParams(names []string) []anthropic.ToolUnionParam {
+	var tools []anthropic.ToolUnionParam
+	for _, name := range names {
+		var definition map[string]any
+		switch name {
+		case ServerToolWebSearch:
+			definition = map[string]any{"type": "web_search_20250305", "name": "web_search", "max_uses": maxWebSearches}
+		case ServerToolCodeExecution:
+			definition = map[string]any{"type": "code_execution_20250522", "name": "code_execution"}
+		default:
+			continue
+		}
+		tool := anthropic.ToolBash20250124Param{}
+		tool.WithExtraFields(definition)
+		tools = append(tools, anthropic.ToolUnionParam{OfBashTool20250124: &tool})
+	}
+	return tools
+}
+
+// isServerToolResult reports whether a content block type is the result of
+// a call that ran on Anthropic's servers, such as web_search_tool_result.
+func isServerToolResult(blockType string) bool {
+	return strings.HasSuffix(blockType, "_tool_result")
+}
+
+// messageParam turns a reply into the message sent back in the next
+// request. Unlike the SDK's ToParam, it keeps the server tool blocks it has
+// no types for, which the API needs to see again.
+func messageParam(message *anthropic.Message) anthropic.MessageParam {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, block := range message.Content {
+		switch {
+		case block.AsAny() != nil:
+			blocks = append(blocks, block.ToParam())
+		case block.Type == "server_tool_use":
+			blocks = append(blocks, serverToolUseBlock(block.ID, block.Name, block.Input))
+		case isServerToolResult(block.Type):
+			result, err := serverToolResultBlock(json.RawMessage(block.RawJSON()))
+			if err != nil {
+				slog.Warn("dropped a server tool result from the conversation", "type", block.Type, "error", err)
+				continue
+			}
+			blocks = append(blocks, result)
+		}
+	}
+	return anthropic.MessageParam{Role: anthropic.MessageParamRoleAssistant, Content: blocks}
+}
+
+// serverToolUseBlock builds a server tool call block. The SDK has no type for
+// it, so it is sent as a tool_use block, which has the same fields, with its
+// type replaced.
+func serverToolUseBlock(id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	block := anthropic.ToolUseBlockParam{ID: id, Name: name, Input: input}
+	block.WithExtraFields(map[string]any{"type": "server_tool_use"})
+	return anthropic.ContentBlockParamUnion{OfRequestToolUseBlock: &block}
+}
+
+// serverToolResultBlock builds a server tool result block from its JSON,
+// sent like serverToolUseBlock as a tool_result block with its type and
+// content replaced.
+func serverToolResultBlock(raw json.RawMessage) (anthropic.ContentBlockParamUnion, error) {
+	var fields struct {
+		Type      string          `json:"type"`
+		ToolUseID string          `json:"tool_use_id"`
+		Content   json.RawMessage `json:"content"`
+	}
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return anthropic.ContentBlockParamUnion{}, err
+	}
+	if fields.ToolUseID == "" || len(fields.Content) == 0 {
+		return anthropic.ContentBlockParamUnion{}, fmt.Errorf("%s block without tool_use_id or content", fields.Type)
+	}
+	block := anthropic.ToolResultBlockParam{ToolUseID: fields.ToolUseID}
+	block.WithExtraFields(map[string]any{"type": fields.Type, "content": fields.Content})
+	return anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &block}, nil
+}
+
+// serverToolResult reads a server tool result block for transcripts: the
+// call it answers, the pages a search found or what code printed, and
+// whether the call failed.
+func serverToolResult(raw json.RawMessage) (toolUseID, text string, isError bool) {
+	var block struct {
+		ToolUseID string          `json:"tool_use_id"`
+		Content   json.RawMessage `json:"content"`
+	}
+	if json.Unmarshal(raw, &block) != nil {
+		return "", "", false
+	}
+
+	type resultContent struct {
+		Type       string `json:"type"`
+		Title      string `json:"title"`
+		URL        string `json:"url"`
+		ErrorCode  string `json:"error_code"`
+		Stdout     string `json:"stdout"`
+		Stderr     string `json:"stderr"`
+		ReturnCode int    `json:"return_code"`
+	}
+	var pages []resultContent
+	if json.Unmarshal(block.Content, &pages) == nil {
+		var lines []string
+		for _, page := range pages {
+			lines = append(lines, fmt.Sprintf("- %s (%s)", page.Title, page.URL))
+		}
+		return block.ToolUseID, strings.Join(lines, "\n"), false
+	}
+	var result resultContent
+	if json.Unmarshal(block.Content, &result) != nil {
+		return block.ToolUseID, string(block.Content), false
+	}
+	if result.ErrorCode != "" {
+		return block.ToolUseID, "error: " + result.ErrorCode, true
+	}
+	text = strings.TrimSpace(result.Stdout + "\n" + result.Stderr)
+	return block.ToolUseID, fmt.Sprintf("%s\n(exit code %d)", text, result.ReturnCode), result.ReturnCode != 0
+}
+
+// reportServerTools tells the frontends about the server tool calls in a
+// reply, which ran before it arrived.
+func (a *Agent) reportServerTools(message *anthropic.Message) {
+	for _, block := range message.Content {
+		if block.Type == "server_tool_use" {
+			a.notice("%s ran on Anthropic's servers with %s", block.Name, block.Input)
+		}
+	}
+}
+
+// text_editor tool
+
+// TextEditorDefinition runs the calls of Anthropic's text editor tool, which
+// is sent to the model by its type rather than this schema, through the file
+// tools, so they get the same checks, approvals and undo journal.
+var TextEditorDefinition = ToolDefinition{
+	Name:        textEditorName,
+	Description: "Views, creates and edits files.",
+	InputSchema: GenerateSchema[TextEditorInput](),
+	Function:    TextEditor,
+	Preview:     PreviewTextEditor,
+	Mutating:    TextEditorMutating,
+}
+
+type TextEditorInput struct {
+	Command    string `json:"command"`
+	Path       string `json:"path"`
+	ViewRange  []int  `json:"view_range,omitempty"`
+	FileText   string `json:"file_text,omitempty"`
+	OldStr     string `json:"old_str,omitempty"`
+	NewStr     string `json:"new_str,omitempty"`
+	InsertLine *int   `json:"insert_line,omitempty"`
+}
+
+// textEditorParam is the text editor's entry in the request.
+func textEditorParam() anthropic.ToolUnionParam {
+	return anthropic.ToolUnionParam{OfTextEditor20250124: &anthropic.ToolTextEditor20250124Param{}}
+}
+
+func TextEditor(ctx context.Context, input json.RawMessage) (string, error) {
+	tool, toolInput, err := translateTextEditor(input)
+	if err != nil {
+		return "", err
+	}
+	if tool.Name == UndoEditDefinition.Name {
+		err = checkTextEditorUndo(ctx, input)
+		if err != nil {
+			return "", err
+		}
+	}
+	return tool.Function(ctx, toolInput)
+}
+
+// checkTextEditorUndo refuses a text editor undo_edit unless the file it
+// names is the one the agent changed last, as the text editor undoes a
+// file's last change and undo_edit the agent's.
+func checkTextEditorUndo(ctx context.Context, input json.RawMessage) error {
+	editorInput := TextEditorInput{}
+	err := json.Unmarshal(input, &editorInput)
+	if err != nil {
+		return fmt.Errorf("invalid input: %w", err)
+	}
+	path, err := resolvePath(editorInput.Path)
+	if err != nil {
+		return err
+	}
+	last, ok := toolStateFor(ctx).journal.lastPath()
+	if !ok || last != path {
+		return toolErrorf(ErrorInvalidInput, "only the most recent file change can be undone, and it was not to %s", editorInput.Path)
+	}
+	return nil
+}
+
+func PreviewTextEditor(input json.RawMessage) (string, bool) {
+	tool, toolInput, err := translateTextEditor(input)
+	if err != nil {
+		return fmt.Sprintf("%s with invalid input: %v", textEditorName, err), false
+	}
+	if tool.Preview == nil {
+		return fmt.Sprintf("%s(%s)", tool.Name, toolInput), false
+	}
+	return tool.Preview(toolInput)
+}
+
+func TextEditorMutating(ctx context.Context, input json.RawMessage) bool {
+	tool, toolInput, err := translateTextEditor(input)
+	return err == nil && tool.Mutating != nil && tool.Mutating(ctx, toolInput)
+}
+
+// translateTextEditor maps a text editor call onto the file tool that does
+// the same, with that tool's input.
+func translateTextEditor(input json.RawMessage) (ToolDefinition, json.RawMessage, error) {
+	editorInput := TextEditorInput{}
+	err := json.Unmarshal(input, &editorInput)
+	if err != nil {
+		return ToolDefinition{}, nil, fmt.Errorf("invalid input: %w", err)
+	}
+	if editorInput.Path == "" {
+		return ToolDefinition{}, nil, toolErrorf(ErrorInvalidInput, "path is required")
+	}
+
+	var tool ToolDefinition
+	var toolInput any
+	switch editorInput.Command {
+	case "view":
+		path, err := resolvePath(editorInput.Path)
+		if err != nil {
+			return ToolDefinition{}, nil, err
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			tool, toolInput = ListFilesDefinition, ListFilesInput{Path: editorInput.Path, MaxDepth: 2}
+			break
+		}
+		readInput := ReadFileInput{Path: editorInput.Path}
+		if len(editorInput.ViewRange) == 2 {
+			// An end of -1 reads to the end of the file.
+			readInput.StartLine, readInput.EndLine = editorInput.ViewRange[0], max(editorInput.ViewRange[1], 0)
+		}
+		tool, toolInput = ReadFileToolDefinition, readInput
+	case "create":
+		tool, toolInput = WriteFileDefinition, WriteFileInput{Path: editorInput.Path, Content: editorInput.FileText, Overwrite: true}
+	case "str_replace":
+		tool, toolInput = EditFileDefinition, EditFileInput{Path: editorInput.Path, OldStr: editorInput.OldStr, NewStr: editorInput.NewStr}
+	case "insert":
+		if editorInput.InsertLine == nil {
+			return ToolDefinition{}, nil, toolErrorf(ErrorInvalidInput, "insert_line is required")
+		}
+		content, err := insertLines(editorInput.Path, *editorInput.InsertLine, editorInput.NewStr)
+		if err != nil {
+			return ToolDefinition{}, nil, err
+		}
+		tool, toolInput = WriteFileDefinition, WriteFileInput{Path: editorInput.Path, Content: content, Overwrite: true}
+	case "undo_edit":
+		tool, toolInput = UndoEditDefinition, UndoEditInput{}
+	default:
+		return ToolDefinition{}, nil, toolErrorf(ErrorInvalidInput, "unknown command %q, expected view, create, str_replace, insert or undo_edit", editorInput.Command)
+	}
+
+	encoded, err := json.Marshal(toolInput)
+	if err != nil {
+		return ToolDefinition{}, nil, err
+	}
+	return tool, encoded, nil
+}
+
+// insertLines returns the content of the file at path with text inserted
+// after line after, where 0 inserts at the start.
+func insertLines(path string, after int, text string) (string, error) {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(resolved)
+	if err != nil {
+		return "", err
+	}
+	lines := splitLines(string(content))
+	if after < 0 || after > len(lines) {
+		return "", toolErrorf(ErrorInvalidInput, "insert_line %d is outside the file (%d lines)", after, len(lines))
+	}
+	if after > 0 && !strings.HasSuffix(lines[after-1], "\n") {
+		lines[after-1] += "\n"
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return strings.Join(lines[:after], "") + text + strings.Join(lines[after:], ""), nil
+}
@@ -0,0 +1,403 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Session is a persisted conversation that can be resumed later.
+type Session struct {
+	ID string `json:"id"`
+	// Title is a short description of the conversation, written by the
+	// model after the first exchange.
+	Title string `json:"title,omitempty"`
+	// ForkedFrom is the ID of the session this one was forked from with
+	// /fork.
+	ForkedFrom string                   `json:"forked_from,omitempty"`
+	Model      anthropic.Model          `json:"model"`
+	CreatedAt  time.Time                `json:"created_at"`
+	UpdatedAt  time.Time                `json:"updated_at"`
+	Messages   []anthropic.MessageParam `json:"messages"`
+	// State is the step the agent loop stood at when the session was
+	// saved, and RunningCall the ID of the tool call that was running, if
+	// any.
+	State       LoopState `json:"state,omitempty"`
+	RunningCall string    `json:"running_call,omitempty"`
+}
+
+func NewSession(model anthropic.Model) *Session {
+	now := time.Now()
+	return &Session{
+		ID:        newSessionID(now),
+		Model:     model,
+		CreatedAt: now,
+		UpdatedAt: now,
+		State:     StateAwaitingUser,
+	}
+}
+
+// LoadSession reads a saved session by ID from the sessions directory.
+func LoadSession(id string) (*Session, error) {
+	path, err := sessionPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("session %s not found", id)
+		}
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	session := &Session{}
+	err = json.Unmarshal(content, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	if session.State == "" {
+		session.State = deriveLoopState(session.Messages)
+	}
+
+	return session, nil
+}
+
+// Fork returns a new session that continues from a copy of this one's
+// conversation, named title. Both sessions share the messages so far, which
+// are never modified in place, and grow apart from there.
+func (s *Session) Fork(title string) *Session {
+	fork := NewSession(s.Model)
+	fork.Title = title
+	fork.ForkedFrom = s.ID
+	fork.Messages = append([]anthropic.MessageParam(nil), s.Messages...)
+	fork.State = s.State
+	fork.RunningCall = s.RunningCall
+	return fork
+}
+
+// Save writes the session to disk, replacing any previous copy atomically.
+func (s *Session) Save() error {
+	dir, err := sessionsDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+
+	s.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	path, err := sessionPath(s.ID)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *Session) UnmarshalJSON(data []byte) error {
+	type shadow Session
+	var raw struct {
+		shadow
+		Messages json.RawMessage `json:"messages"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	*s = Session(raw.shadow)
+	if len(raw.Messages) == 0 {
+		return nil
+	}
+
+	s.Messages, err = DecodeMessages(raw.Messages)
+	return err
+}
+
+// maxUntitledLength caps how much of an untitled session's first prompt is
+// shown in its place.
+const maxUntitledLength = 60
+
+// SessionSummary describes a saved session without its messages.
+type SessionSummary struct {
+	ID string
+	// Title is the session's title, or the start of its first prompt when
+	// it has none yet.
+	Title     string
+	Model     anthropic.Model
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Messages  int
+}
+
+// String formats the summary as one line of a session list.
+func (s SessionSummary) String() string {
+	return fmt.Sprintf("%s  %s  %3d messages  %s", s.ID, s.UpdatedAt.Local().Format("2006-01-02 15:04"), s.Messages, s.Title)
+}
+
+// ListSessions returns the saved sessions, most recently updated first.
+// Files that cannot be read are skipped with a warning.
+func ListSessions() ([]SessionSummary, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var summaries []SessionSummary
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		summary, err := summarizeSession(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Warn("skipping unreadable session", "session", id, "error", err)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt) })
+	return summaries, nil
+}
+
+// summarizeSession reads a session file's metadata, decoding only as much
+// of its messages as the summary needs.
+func summarizeSession(path string) (SessionSummary, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return SessionSummary{}, err
+	}
+	var raw struct {
+		ID        string          `json:"id"`
+		Title     string          `json:"title"`
+		Model     anthropic.Model `json:"model"`
+		CreatedAt time.Time       `json:"created_at"`
+		UpdatedAt time.Time       `json:"updated_at"`
+		Messages  []wireMessage   `json:"messages"`
+	}
+	err = json.Unmarshal(content, &raw)
+	if err != nil {
+		return SessionSummary{}, err
+	}
+
+	title := raw.Title
+	if title == "" && len(raw.Messages) > 0 {
+		for _, block := range raw.Messages[0].Content {
+			if block.Type == "text" {
+				title, _, _ = strings.Cut(strings.TrimSpace(block.Text), "\n")
+				break
+			}
+		}
+		if runes := []rune(title); len(runes) > maxUntitledLength {
+			title = strings.TrimSpace(string(runes[:maxUntitledLength])) + "..."
+		}
+	}
+	return SessionSummary{
+		ID:        raw.ID,
+		Title:     title,
+		Model:     raw.Model,
+		CreatedAt: raw.CreatedAt,
+		UpdatedAt: raw.UpdatedAt,
+		Messages:  len(raw.Messages),
+	}, nil
+}
+
+// DeleteSession removes a saved session.
+func DeleteSession(id string) error {
+	path, err := sessionPath(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("session %s not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// sessionPath returns the file a session is saved in. IDs come from users
+// and API clients, so they may not reach outside the sessions directory.
+func sessionPath(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+func sessionsDir() (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "sessions"), nil
+}
+
+func newSessionID(now time.Time) string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// wireBlock mirrors the API's JSON shape for every content block type a
+// conversation can contain. The SDK's param unions only marshal, so saved
+// conversations are decoded through this type and rebuilt with SDK helpers.
+type wireBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   []wireBlock     `json:"content"`
+	IsError   bool            `json:"is_error"`
+	Signature string          `json:"signature"`
+	Thinking  string          `json:"thinking"`
+	Data      string          `json:"data"`
+	Source    struct {
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"source"`
+	// Raw holds the server tool blocks, which are kept as they are.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (b *wireBlock) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(data, &head)
+	if err != nil {
+		return err
+	}
+	// Server tool results differ in shape from the blocks above, such as
+	// code execution's content being an object.
+	if head.Type == "server_tool_use" || isServerToolResult(head.Type) {
+		type plain struct {
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}
+		var fields plain
+		err := json.Unmarshal(data, &fields)
+		if err != nil {
+			return err
+		}
+		*b = wireBlock{Type: head.Type, ID: fields.ID, Name: fields.Name, Input: fields.Input, Raw: append(json.RawMessage(nil), data...)}
+		return nil
+	}
+	type plain wireBlock
+	return json.Unmarshal(data, (*plain)(b))
+}
+
+type wireMessage struct {
+	Role    anthropic.MessageParamRole `json:"role"`
+	Content []wireBlock                `json:"content"`
+}
+
+// DecodeMessages turns JSON produced by marshaling []anthropic.MessageParam
+// back into message params.
+func DecodeMessages(data []byte) ([]anthropic.MessageParam, error) {
+	var wire []wireMessage
+	err := json.Unmarshal(data, &wire)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]anthropic.MessageParam, 0, len(wire))
+	for _, msg := range wire {
+		var blocks []anthropic.ContentBlockParamUnion
+		for _, block := range msg.Content {
+			param, err := block.toParam()
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, param)
+		}
+		messages = append(messages, anthropic.MessageParam{Role: msg.Role, Content: blocks})
+	}
+
+	return messages, nil
+}
+
+func (b wireBlock) toParam() (anthropic.ContentBlockParamUnion, error) {
+	switch b.Type {
+	case "text":
+		return anthropic.NewTextBlock(b.Text), nil
+	case "image":
+		return anthropic.NewImageBlockBase64(b.Source.MediaType, b.Source.Data), nil
+	case "tool_use":
+		input := b.Input
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		return anthropic.ContentBlockParamOfRequestToolUseBlock(b.ID, input, b.Name), nil
+	case "tool_result":
+		result := anthropic.ToolResultBlockParam{
+			ToolUseID: b.ToolUseID,
+			IsError:   anthropic.Bool(b.IsError),
+		}
+		for _, inner := range b.Content {
+			switch inner.Type {
+			case "text":
+				result.Content = append(result.Content, anthropic.ToolResultBlockParamContentUnion{
+					OfRequestTextBlock: &anthropic.TextBlockParam{Text: inner.Text},
+				})
+			case "image":
+				image := anthropic.NewImageBlockBase64(inner.Source.MediaType, inner.Source.Data)
+				result.Content = append(result.Content, anthropic.ToolResultBlockParamContentUnion{
+					OfRequestImageBlock: image.OfRequestImageBlock,
+				})
+			}
+		}
+		return anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &result}, nil
+	case "thinking":
+		return anthropic.ContentBlockParamOfRequestThinkingBlock(b.Signature, b.Thinking), nil
+	case "redacted_thinking":
+		return anthropic.ContentBlockParamOfRequestRedactedThinkingBlock(b.Data), nil
+	case "server_tool_use":
+		return serverToolUseBlock(b.ID, b.Name, b.Input), nil
+	default:
+		if isServerToolResult(b.Type) {
+			return serverToolResultBlock(b.Raw)
+		}
+		return anthropic.ContentBlockParamUnion{}, fmt.Errorf("unsupported content block type %q", b.Type)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const (
+	// sessionTitleTimeout bounds the call that names a session.
+	sessionTitleTimeout = 30 * time.Second
+	// sessionTitleInputLimit caps how much of each message of the first
+	// exchange is shown to the model.
+	sessionTitleInputLimit = 2000
+	// maxSessionTitleLength caps the title, in case the model rambles.
+	maxSessionTitleLength = 80
+)
+
+const sessionTitlePrompt = `Write a title of at most six words for the conversation below between a user and a coding assistant, naming the task rather than the assistant. Reply with the title only, without quotes or a trailing period.
+
+<conversation>
+%s
+</conversation>`
+
+// titleSession names the session after its first exchange, with a call to
+// the summary model. The call runs in the background so the chat does not
+// wait for it; a session it fails for is named when it is next resumed.
+func (a *Agent) titleSession(conversation []anthropic.MessageParam) {
+	exchange := firstExchange(conversation)
+	if exchange == nil {
+		return
+	}
+	a.mu.Lock()
+	session := a.session
+	needed := session.Title == "" && a.titling != session
+	if needed {
+		a.titling = session
+	}
+	a.mu.Unlock()
+	if !needed {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sessionTitleTimeout)
+		defer cancel()
+		title, err := a.generateTitle(ctx, exchange)
+		if err != nil {
+			slog.Debug("failed to title session", "session", session.ID, "error", err)
+			return
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		session.Title = title
+		err = session.Save()
+		if err != nil {
+			slog.Warn("failed to save session", "error", err)
+		}
+	}()
+}
+
+// firstExchange returns the conversation up to the model's first reply, or
+// nil when it has not replied yet.
+func firstExchange(conversation []anthropic.MessageParam) []anthropic.MessageParam {
+	for i, message := range conversation {
+		if message.Role == anthropic.MessageParamRoleAssistant {
+			return conversation[:i+1]
+		}
+	}
+	return nil
+}
+
+func (a *Agent) generateTitle(ctx context.Context, exchange []anthropic.MessageParam) (string, error) {
+	var transcript strings.Builder
+	for _, message := range exchange {
+		transcript.WriteString(renderMessageText(message, sessionTitleInputLimit))
+		transcript.WriteString("\n")
+	}
+
+	response, err := a.provider.SendMessage(ctx, anthropic.MessageNewParams{
+		Model:     a.config.SummaryModel,
+		MaxTokens: int64(32),
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf(sessionTitlePrompt, transcript.String()))),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	a.recordUsage(a.config.SummaryModel, response)
+
+	var title strings.Builder
+	for _, content := range response.Content {
+		if content.Type == "text" {
+			title.WriteString(content.Text)
+		}
+	}
+	return cleanSessionTitle(title.String())
+}
+
+// cleanSessionTitle keeps the first line of the model's reply, without the
+// quotes, markdown and punctuation models tend to add anyway.
+func cleanSessionTitle(reply string) (string, error) {
+	title, _, _ := strings.Cut(strings.TrimSpace(reply), "\n")
+	title = strings.TrimPrefix(strings.TrimSpace(title), "#")
+	title = strings.TrimPrefix(strings.TrimSpace(title), "Title:")
+	title = strings.Trim(strings.TrimSpace(title), "\"'`*.")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", fmt.Errorf("the model returned no title")
+	}
+	if runes := []rune(title); len(runes) > maxSessionTitleLength {
+		title = strings.TrimSpace(string(runes[:maxSessionTitleLength])) + "..."
+	}
+	return title, nil
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShellTimeout = 2 * time.Minute
+	maxShellTimeout     = 10 * time.Minute
+	// maxShellOutput caps each captured stream before it is returned to the
+	// model.
+	maxShellOutput = 30000
+	// commandWaitDelay is how long a killed command's output is still read
+	// for once it has exited.
+	commandWaitDelay = time.Second
+)
+
+// run_shell_command tool
+
+var ShellToolDefinition = ToolDefinition{
+	Name: "run_shell_command",
+	Description: `Run a shell command and return its exit code, stdout and stderr.
+
+Use this to build, test, format or otherwise inspect the project. Commands run with ` + hostShellName() + ` in the given working directory (defaults to the current directory). Long-running commands are killed after the timeout. Very large output is truncated.`,
+	InputSchema: ShellInputSchema,
+	Function:    RunShellCommand,
+	Preview:     PreviewShellCommand,
+	Mutating:    alwaysMutating,
+	Command:     ShellToolCommand,
+}
+
+type ShellInput struct {
+	Command        string `json:"command" jsonschema_description:"The shell command to run"`
+	WorkingDir     string `json:"working_dir,omitempty" jsonschema_description:"Optional relative directory to run the command in. Defaults to the current directory."`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema_description:"Optional timeout in seconds. Defaults to 120, maximum 600."`
+}
+
+var ShellInputSchema = GenerateSchema[ShellInput]()
+
+// ShellToolCommand is the Command func of tools that take their command in
+// the shell tool's command field.
+func ShellToolCommand(input json.RawMessage) (string, bool) {
+	shellInput := ShellInput{}
+	if json.Unmarshal(input, &shellInput) != nil {
+		return "", false
+	}
+	return shellInput.Command, true
+}
+
+func RunShellCommand(ctx context.Context, input json.RawMessage) (string, error) {
+	shellInput := ShellInput{}
+	err := json.Unmarshal(input, &shellInput)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(shellInput.Command) == "" {
+		return "", fmt.Errorf("command is required")
+	}
+
+	timeout := defaultShellTimeout
+	if shellInput.TimeoutSeconds > 0 {
+		timeout = time.Duration(shellInput.TimeoutSeconds) * time.Second
+	}
+	if timeout > maxShellTimeout {
+		timeout = maxShellTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// The call's own timeout may end it sooner.
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline).Round(time.Second)
+	}
+
+	dir, err := resolvePath(shellInput.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+
+	cmd, _ := shellCommand(ctx, shellInput.Command, dir)
+	// Background children of a killed shell can keep its output open; stop
+	// waiting for them shortly after it exits.
+	cmd.WaitDelay = commandWaitDelay
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	duration := time.Since(start).Round(time.Millisecond)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", toolErrorf(ErrorTimeout, "command timed out after %s\nstdout:\n%s\nstderr:\n%s",
+			timeout, TruncateOutput(stdout.String(), maxShellOutput), TruncateOutput(stderr.String(), maxShellOutput))
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command canceled: %w\nstdout:\n%s\nstderr:\n%s",
+			ctx.Err(), TruncateOutput(stdout.String(), maxShellOutput), TruncateOutput(stderr.String(), maxShellOutput))
+	}
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to run command: %w", err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	return fmt.Sprintf("exit code: %d (took %s)\nstdout:\n%s\nstderr:\n%s",
+		exitCode, duration, TruncateOutput(stdout.String(), maxShellOutput), TruncateOutput(stderr.String(), maxShellOutput)), nil
+}
+
+func PreviewShellCommand(input json.RawMessage) (string, bool) {
+	shellInput := ShellInput{}
+	err := json.Unmarshal(input, &shellInput)
+	if err != nil {
+		return fmt.Sprintf("run a command with invalid input: %s", input), true
+	}
+
+	dir := "."
+	if shellInput.WorkingDir != "" {
+		dir = shellInput.WorkingDir
+	}
+	if sandboxSettings.Image != "" {
+		dir += " (in the " + sandboxSettings.Image + " sandbox)"
+	}
+	return fmt.Sprintf("run in %s:\n$ %s", dir, shellInput.Command), true
+}
+
+// hostShell returns the program and arguments that run command on this
+// machine: sh, or PowerShell on Windows, preferring PowerShell 7 when it is
+// installed.
+func hostShell(command string) (string, []string) {
+	if runtime.GOOS != "windows" {
+		return "sh", []string{"-c", command}
+	}
+	shell := "powershell.exe"
+	if _, err := exec.LookPath("pwsh.exe"); err == nil {
+		shell = "pwsh.exe"
+	}
+	return shell, []string{"-NoProfile", "-NonInteractive", "-Command", command}
+}
+
+// hostShellName names the shell commands run with, for the model.
+func hostShellName() string {
+	if runtime.GOOS == "windows" {
+		return "PowerShell on Windows"
+	}
+	return `"sh -c"`
+}
+
+// TruncateOutput keeps the beginning and end of output longer than limit,
+// since both the command's first errors and its final summary matter.
+func TruncateOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+
+	half := limit / 2
+	omitted := len(output) - 2*half
+	return fmt.Sprintf("%s\n... (%d bytes truncated) ...\n%s", output[:half], omitted, output[len(output)-half:])
+}
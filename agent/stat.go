@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// languagesByExtension names the language of common source and text files
+// for stat. Files get_outline can parse are named by outlineLanguages.
+var languagesByExtension = map[string]string{
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".cxx": "C++", ".hpp": "C++",
+	".cs": "C#", ".java": "Java", ".kt": "Kotlin", ".kts": "Kotlin", ".scala": "Scala",
+	".swift": "Swift", ".m": "Objective-C", ".rb": "Ruby", ".php": "PHP", ".lua": "Lua",
+	".pl": "Perl", ".r": "R", ".dart": "Dart", ".ex": "Elixir", ".exs": "Elixir",
+	".erl": "Erlang", ".hs": "Haskell", ".ml": "OCaml", ".clj": "Clojure", ".zig": "Zig",
+	".sh": "Shell", ".bash": "Shell", ".zsh": "Shell", ".fish": "Shell", ".ps1": "PowerShell",
+	".sql": "SQL", ".proto": "Protocol Buffers", ".graphql": "GraphQL",
+	".html": "HTML", ".htm": "HTML", ".css": "CSS", ".scss": "SCSS", ".vue": "Vue", ".svelte": "Svelte",
+	".json": "JSON", ".yaml": "YAML", ".yml": "YAML", ".toml": "TOML", ".xml": "XML",
+	".ini": "INI", ".md": "Markdown", ".rst": "reStructuredText", ".txt": "Text",
+	".tf": "Terraform", ".nix": "Nix", ".mod": "Go module", ".sum": "Go checksums",
+}
+
+// languagesByName covers files known by name rather than extension.
+var languagesByName = map[string]string{
+	"Makefile": "Makefile", "GNUmakefile": "Makefile", "Dockerfile": "Dockerfile",
+	"Containerfile": "Dockerfile", "Jenkinsfile": "Groovy", "Rakefile": "Ruby", "Gemfile": "Ruby",
+	"CMakeLists.txt": "CMake", "BUILD": "Starlark", "BUILD.bazel": "Starlark", "WORKSPACE": "Starlark",
+}
+
+// detectLanguage names the language of a file from its name, or returns "".
+func detectLanguage(path string) string {
+	if language, ok := outlineLanguageFor(path); ok {
+		return language.Name
+	}
+	base := filepath.Base(path)
+	if language, ok := languagesByName[base]; ok {
+		return language
+	}
+	if strings.HasPrefix(base, "Dockerfile.") {
+		return "Dockerfile"
+	}
+	return languagesByExtension[strings.ToLower(filepath.Ext(base))]
+}
+
+// stat tool
+
+var StatDefinition = ToolDefinition{
+	Name: "stat",
+	Description: `Show a file's metadata without reading it: size, permissions, modification time, line count and language, or the number of entries for a directory.
+
+Use this to decide whether to read a file whole, read it in ranges with start_line and end_line, or skip it, for example for large, generated or binary files.`,
+	InputSchema: StatInputSchema,
+	Function:    Stat,
+}
+
+type StatInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of a file or directory in the working directory."`
+}
+
+var StatInputSchema = GenerateSchema[StatInput]()
+
+func Stat(ctx context.Context, input json.RawMessage) (string, error) {
+	statInput := StatInput{}
+	err := json.Unmarshal(input, &statInput)
+	if err != nil {
+		return "", err
+	}
+	if statInput.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	path, err := resolvePath(statInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	modified := info.ModTime()
+	details := []string{
+		// Symlinks are resolved, so this is the path of the file itself.
+		"path: " + relativeToWorkspace(path),
+		"mode: " + info.Mode().String(),
+		fmt.Sprintf("modified: %s (%s ago)", modified.Format(time.RFC3339), formatAge(time.Since(modified))),
+	}
+
+	switch {
+	case info.IsDir():
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return "", err
+		}
+		details = append(details, "type: directory", fmt.Sprintf("entries: %d", len(entries)))
+	case !info.Mode().IsRegular():
+		details = append(details, "type: "+info.Mode().Type().String())
+	default:
+		fileDetails, err := statFile(path, info.Size())
+		if err != nil {
+			return "", err
+		}
+		details = append(details, fileDetails...)
+	}
+	return strings.Join(details, "\n"), nil
+}
+
+// statFile describes a regular file's content, counting its lines unless it
+// is binary.
+func statFile(path string, size int64) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	details := []string{fmt.Sprintf("size: %d bytes", size)}
+	buffer := make([]byte, 32*1024)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if isBinary(buffer[:n]) {
+		mimeType := mime.TypeByExtension(filepath.Ext(path))
+		if mimeType == "" {
+			mimeType = http.DetectContentType(buffer[:n])
+		}
+		return append(details, "type: binary ("+mimeType+")"), nil
+	}
+
+	lines := 0
+	last := byte('\n')
+	for n > 0 {
+		lines += bytes.Count(buffer[:n], []byte{'\n'})
+		last = buffer[n-1]
+		n, err = file.Read(buffer)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if last != '\n' {
+		// The last line has no newline.
+		lines++
+	}
+
+	details = append(details, "type: text", fmt.Sprintf("lines: %d", lines))
+	if language := detectLanguage(path); language != "" {
+		details = append(details, "language: "+language)
+	}
+	if size > maxReadFileBytes {
+		details = append(details, fmt.Sprintf("note: larger than read_file returns at once (%d bytes), read it in ranges with start_line and end_line", maxReadFileBytes))
+	}
+	return details, nil
+}
+
+// formatAge rounds a duration to its largest unit, such as "3 days".
+func formatAge(age time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{{"year", 365 * 24 * time.Hour}, {"day", 24 * time.Hour}, {"hour", time.Hour}, {"minute", time.Minute}}
+	for _, unit := range units {
+		if count := int(age / unit.size); count > 0 {
+			if count == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %ss", count, unit.name)
+		}
+	}
+	return "under a minute"
+}
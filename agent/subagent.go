@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// dispatchAgentName is the dispatch_agent tool's name. Sub-agents never get
+// the tool themselves, so delegation is one level deep.
+const dispatchAgentName = "dispatch_agent"
+
+// maxSubAgentResult caps the report a sub-agent hands back to its parent.
+const maxSubAgentResult = 20000
+
+// maxParallelSubAgents bounds how many sub-agents run at once when several
+// tasks are dispatched together.
+const maxParallelSubAgents = 4
+
+const subAgentPrompt = `You are a sub-agent working on a task delegated by another assistant. Work on your own: nobody will answer questions while you work. When you are done, reply with a concise report of what you found or changed, with the file paths, line numbers and names that matter. Only that final reply is passed back; your tool calls and intermediate messages are not.`
+
+// subAgentParent is the agent whose tools, provider and settings sub-agents
+// inherit when the calling agent is not known: the most recently created one.
+var subAgentParent *Agent
+
+// callingAgentKey is the context key under which a tool call carries the
+// agent that made it.
+type callingAgentKey struct{}
+
+// callingAgent returns the agent that made the tool call ctx belongs to, so
+// that with several agents in one process, as in serve mode, sub-agents
+// inherit from the right one.
+func callingAgent(ctx context.Context) *Agent {
+	if a, ok := ctx.Value(callingAgentKey{}).(*Agent); ok {
+		return a
+	}
+	return subAgentParent
+}
+
+// newSubAgent creates a child of a with its own empty conversation and the
+// named tools, or a's read-only tools when names is empty. The child shares
+// a's approver, so tool calls are gated exactly as in the parent.
+func (a *Agent) newSubAgent(names []string) (*Agent, error) {
+	var tools []ToolDefinition
+	if len(names) == 0 {
+		for _, tool := range a.tools {
+			if readOnlyToolNames[tool.Name] && tool.Mutating == nil {
+				tools = append(tools, tool)
+			}
+		}
+	}
+	for _, name := range names {
+		if name == dispatchAgentName {
+			return nil, fmt.Errorf("sub-agents cannot dispatch further sub-agents")
+		}
+		tool, ok := a.findTool(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		tools = append(tools, tool)
+	}
+
+	config := a.config
+	config.SystemPrompt = strings.TrimSpace(config.SystemPrompt + "\n\n" + subAgentPrompt)
+	config.Transcript = ""
+
+	return &Agent{
+		provider:       a.provider,
+		getUserMessage: a.getUserMessage,
+		tools:          tools,
+		config:         config,
+		session:        NewSession(config.Model),
+		approver:       a.approver,
+		frontends:      a.frontends,
+		budget:         a.budget,
+		redactor:       a.redactor,
+		planMode:       a.planMode,
+		memory:         a.memory,
+		repoMap:        a.repoMap,
+		hooks:          a.hooks,
+		state:          a.state,
+		parent:         a,
+	}, nil
+}
+
+func (a *Agent) findTool(name string) (ToolDefinition, bool) {
+	for _, tool := range a.tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return ToolDefinition{}, false
+}
+
+// dispatch_agent tool
+
+var DispatchAgentDefinition = ToolDefinition{
+	Name:        dispatchAgentName,
+	Description: "Delegates a self-contained task, such as searching the codebase or analysing how something works, to a sub-agent with its own conversation. Only the sub-agent's final report comes back, so exploration does not fill up this conversation. Pass tasks instead of task to run several independent tasks in parallel, one sub-agent each, and get their reports together. By default sub-agents can only use read-only tools; list tools to give them others. Describe each task fully: sub-agents cannot see this conversation.",
+	InputSchema: DispatchAgentInputSchema,
+	Function:    DispatchAgent,
+	Preview:     PreviewDispatchAgent,
+	Mutating:    DispatchAgentMutating,
+}
+
+type DispatchAgentInput struct {
+	Task  string   `json:"task,omitempty" jsonschema_description:"The complete task for the sub-agent, including any context it needs and what its report should contain."`
+	Tasks []string `json:"tasks,omitempty" jsonschema_description:"Several independent tasks to run in parallel instead of task, each written like task."`
+	Tools []string `json:"tools,omitempty" jsonschema_description:"Optional names of the tools the sub-agents may use. Defaults to every read-only tool."`
+}
+
+var DispatchAgentInputSchema = GenerateSchema[DispatchAgentInput]()
+
+func DispatchAgent(ctx context.Context, input json.RawMessage) (string, error) {
+	dispatchInput := DispatchAgentInput{}
+	err := json.Unmarshal(input, &dispatchInput)
+	if err != nil {
+		return "", fmt.Errorf("invalid input: %w", err)
+	}
+	parent := callingAgent(ctx)
+	if parent == nil {
+		return "", fmt.Errorf("sub-agents are not available")
+	}
+	if len(dispatchInput.Tasks) > 0 {
+		if dispatchInput.Task != "" {
+			return "", fmt.Errorf("pass either task or tasks, not both")
+		}
+		return dispatchParallel(ctx, parent, dispatchInput.Tasks, dispatchInput.Tools)
+	}
+	if strings.TrimSpace(dispatchInput.Task) == "" {
+		return "", fmt.Errorf("task is required")
+	}
+
+	child, err := parent.newSubAgent(dispatchInput.Tools)
+	if err != nil {
+		return "", err
+	}
+	parent.notice("started sub-agent with %d tools", len(child.tools))
+	answer, err := child.RunOnce(ctx, anthropic.NewTextBlock(dispatchInput.Task))
+	if err != nil {
+		return "", fmt.Errorf("sub-agent failed: %w", err)
+	}
+	parent.notice("sub-agent finished")
+	if strings.TrimSpace(answer) == "" {
+		return "The sub-agent finished without a report.", nil
+	}
+	return TruncateOutput(answer, maxSubAgentResult), nil
+}
+
+// dispatchParallel runs each task in its own sub-agent, at most
+// maxParallelSubAgents at a time, and merges their reports in task order. A
+// failed task is reported alongside the others rather than failing the call.
+func dispatchParallel(ctx context.Context, parent *Agent, tasks, tools []string) (string, error) {
+	children := make([]*Agent, len(tasks))
+	for i, task := range tasks {
+		if strings.TrimSpace(task) == "" {
+			return "", fmt.Errorf("task %d is empty", i+1)
+		}
+		child, err := parent.newSubAgent(tools)
+		if err != nil {
+			return "", err
+		}
+		// Replies streaming from several agents at once would interleave.
+		child.quiet = true
+		children[i] = child
+	}
+
+	parent.notice("started %d sub-agents", len(tasks))
+	reports := make([]string, len(tasks))
+	slots := make(chan struct{}, maxParallelSubAgents)
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			answer, err := children[i].RunOnce(ctx, anthropic.NewTextBlock(task))
+			switch {
+			case err != nil:
+				reports[i] = fmt.Sprintf("sub-agent failed: %v", err)
+			case strings.TrimSpace(answer) == "":
+				reports[i] = "The sub-agent finished without a report."
+			default:
+				reports[i] = TruncateOutput(answer, maxSubAgentResult/len(tasks))
+			}
+			parent.notice("sub-agent %d of %d finished", i+1, len(tasks))
+		}()
+	}
+	wg.Wait()
+
+	var merged strings.Builder
+	for i, task := range tasks {
+		title, _, _ := strings.Cut(strings.TrimSpace(task), "\n")
+		fmt.Fprintf(&merged, "## Task %d: %s\n\n%s\n\n", i+1, TruncateOutput(title, 200), strings.TrimSpace(reports[i]))
+	}
+	return strings.TrimSpace(merged.String()), nil
+}
+
+// DispatchAgentMutating reports whether the sub-agent would get any of the
+// calling agent's tools that change state.
+func DispatchAgentMutating(ctx context.Context, input json.RawMessage) bool {
+	dispatchInput := DispatchAgentInput{}
+	parent := callingAgent(ctx)
+	if json.Unmarshal(input, &dispatchInput) != nil || parent == nil {
+		return false
+	}
+	for _, name := range dispatchInput.Tools {
+		tool, ok := parent.findTool(name)
+		if ok && tool.Mutating != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewDispatchAgent never asks by itself: the sub-agent's own tool calls
+// go through the approval gate.
+func PreviewDispatchAgent(input json.RawMessage) (string, bool) {
+	dispatchInput := DispatchAgentInput{}
+	if err := json.Unmarshal(input, &dispatchInput); err != nil {
+		return fmt.Sprintf("dispatch_agent with invalid input: %v", err), false
+	}
+	tools := "read-only tools"
+	if len(dispatchInput.Tools) > 0 {
+		tools = strings.Join(dispatchInput.Tools, ", ")
+	}
+	if len(dispatchInput.Tasks) > 0 {
+		return fmt.Sprintf("Start %d sub-agents in parallel with %s on:\n- %s", len(dispatchInput.Tasks), tools, strings.Join(dispatchInput.Tasks, "\n- ")), false
+	}
+	return fmt.Sprintf("Start a sub-agent with %s on:\n%s", tools, dispatchInput.Task), false
+}
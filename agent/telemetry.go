@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// telemetryShutdownTimeout bounds how long exiting waits to flush spans and
+// metrics to the collector.
+const telemetryShutdownTimeout = 5 * time.Second
+
+// tracer and meter come from the global providers, which do nothing until
+// SetupTelemetry installs exporting ones, so instrumentation is free when
+// telemetry is off.
+var (
+	tracer = otel.Tracer("system3")
+	meter  = otel.Meter("system3")
+
+	modelDuration, _ = meter.Float64Histogram("system3.model.duration",
+		metric.WithDescription("Time to get a complete reply from the model"), metric.WithUnit("s"))
+	tokenUsage, _ = meter.Int64Counter("system3.model.tokens",
+		metric.WithDescription("Tokens used by model calls, by type"), metric.WithUnit("{token}"))
+	toolDuration, _ = meter.Float64Histogram("system3.tool.duration",
+		metric.WithDescription("Time to run a tool call, including approval"), metric.WithUnit("s"))
+)
+
+// flushTelemetry flushes telemetry once SetupTelemetry has enabled it. Exit
+// paths that skip deferred calls use it.
+var flushTelemetry = func() {}
+
+// SetupTelemetry exports traces and metrics over OTLP/HTTP to endpoint. The
+// standard OTEL_EXPORTER_OTLP_* variables (headers, timeouts) still apply.
+// The returned function flushes and stops the exporters; calls after the
+// first do nothing.
+func SetupTelemetry(endpoint, sessionID string) (func(), error) {
+	ctx := context.Background()
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "system3"),
+		attribute.String("service.version", Version),
+		attribute.String("system3.session", sessionID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build telemetry resource: %w", err)
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/metrics"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+
+	var once sync.Once
+	flushTelemetry = func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+			defer cancel()
+			err := errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+			if err != nil {
+				slog.Warn("failed to flush telemetry", "error", err)
+			}
+		})
+	}
+	return flushTelemetry, nil
+}
+
+// recordToolCall records a tool call's duration and outcome.
+func recordToolCall(ctx context.Context, span trace.Span, tool string, duration time.Duration, output string, isError bool) {
+	toolDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("tool", tool),
+		attribute.Bool("error", isError),
+	))
+	if isError {
+		span.SetStatus(codes.Error, TruncateOutput(output, 200))
+	}
+}
+
+// recordModelCall adds the reply's token counts to span and the metrics.
+func recordModelCall(ctx context.Context, span trace.Span, provider string, model anthropic.Model, duration time.Duration, message *anthropic.Message) {
+	attrs := []attribute.KeyValue{
+		attribute.String("gen_ai.system", provider),
+		attribute.String("gen_ai.request.model", string(model)),
+	}
+	modelDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attrs...))
+	if message == nil {
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("gen_ai.response.finish_reason", string(message.StopReason)),
+		attribute.Int64("gen_ai.usage.input_tokens", message.Usage.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", message.Usage.OutputTokens),
+		attribute.Int64("gen_ai.usage.cache_read_tokens", message.Usage.CacheReadInputTokens),
+		attribute.Int64("gen_ai.usage.cache_write_tokens", message.Usage.CacheCreationInputTokens),
+	)
+	for tokenType, count := range map[string]int64{
+		"input":       message.Usage.InputTokens,
+		"output":      message.Usage.OutputTokens,
+		"cache_read":  message.Usage.CacheReadInputTokens,
+		"cache_write": message.Usage.CacheCreationInputTokens,
+	} {
+		tokenUsage.Add(ctx, count, metric.WithAttributes(append(attrs, attribute.String("type", tokenType))...))
+	}
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// maxThinkingSummary caps the line summary mode prints for a thought.
+const maxThinkingSummary = 160
+
+// thinkingPrinter shows the model's extended thinking, dimmed so it is not
+// mistaken for the answer. In stream mode thinking is printed as it
+// arrives; in summary mode it is collected and summed up in one line once
+// the answer starts.
+type thinkingPrinter struct {
+	mode      string
+	streaming bool
+	thought   strings.Builder
+}
+
+func (p *thinkingPrinter) Write(text string) {
+	switch p.mode {
+	case ThinkingStream:
+		if !p.streaming {
+			fmt.Print("\u001b[90mthinking: ")
+			p.streaming = true
+		}
+		fmt.Print(text)
+	case ThinkingSummary:
+		p.thought.WriteString(text)
+	}
+}
+
+// End finishes the thinking shown so far, before the answer is printed.
+func (p *thinkingPrinter) End() {
+	if p.streaming {
+		fmt.Print("\u001b[0m\n")
+		p.streaming = false
+	}
+	if p.thought.Len() > 0 {
+		fmt.Printf("\u001b[90m%s\u001b[0m\n", summarizeThinking(p.thought.String()))
+		p.thought.Reset()
+	}
+}
+
+// summarizeThinking sums a thought up by its length and its last paragraph,
+// where the model usually settles on what to do.
+func summarizeThinking(thought string) string {
+	paragraphs := strings.Split(strings.TrimSpace(thought), "\n\n")
+	conclusion := strings.Join(strings.Fields(paragraphs[len(paragraphs)-1]), " ")
+	if runes := []rune(conclusion); len(runes) > maxThinkingSummary {
+		conclusion = strings.TrimSpace(string(runes[:maxThinkingSummary])) + "..."
+	}
+	return fmt.Sprintf("thought for %d words: %s", len(strings.Fields(thought)), conclusion)
+}
+
+// thinkingParams turns on extended thinking for a request when it is
+// enabled. The API counts the budget against max_tokens, so it is added to
+// the reply's own allowance.
+func (a *Agent) thinkingParams(params *anthropic.MessageNewParams) {
+	if !a.config.Thinking {
+		return
+	}
+	params.Thinking = anthropic.ThinkingConfigParamOfThinkingConfigEnabled(a.config.ThinkingBudget)
+	params.MaxTokens += a.config.ThinkingBudget
+}
+
+// /think command
+
+var ThinkCommand = SlashCommand{
+	Name:        "think",
+	Usage:       "[on|off|budget]",
+	Description: "Toggle extended thinking, or set its token budget",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		switch args {
+		case "":
+			a.config.Thinking = !a.config.Thinking
+		case "on":
+			a.config.Thinking = true
+		case "off":
+			a.config.Thinking = false
+		default:
+			budget, err := strconv.ParseInt(args, 10, 64)
+			if err != nil || budget < minThinkingBudget {
+				return conversation, fmt.Errorf("expected on, off or a budget of at least %d tokens, got %q", minThinkingBudget, args)
+			}
+			a.config.Thinking = true
+			a.config.ThinkingBudget = budget
+		}
+
+		if a.config.Thinking {
+			err := checkThinking(a.config.Provider, a.config.Temperature, a.config.TopP)
+			if err != nil {
+				a.config.Thinking = false
+				return conversation, err
+			}
+			fmt.Printf("\u001b[93msystem\u001b[0m: extended thinking on, up to %d tokens per reply\n", a.config.ThinkingBudget)
+			return conversation, nil
+		}
+		fmt.Println("\u001b[93msystem\u001b[0m: extended thinking off")
+		return conversation, nil
+	},
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrorKind says why a tool call failed, so the model can decide what to do
+// next without parsing the message: look elsewhere after not_found, fix its
+// arguments after invalid_input, ask for less after too_large, and so on.
+type ErrorKind string
+
+const (
+	ErrorNotFound         ErrorKind = "not_found"
+	ErrorPermissionDenied ErrorKind = "permission_denied"
+	ErrorInvalidInput     ErrorKind = "invalid_input"
+	ErrorTimeout          ErrorKind = "timeout"
+	ErrorTooLarge         ErrorKind = "too_large"
+	// ErrorFailed is every other failure.
+	ErrorFailed ErrorKind = "failed"
+)
+
+// ToolError is a tool failure of a known kind.
+type ToolError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *ToolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// toolErrorf returns a ToolError of kind, formatted like fmt.Errorf.
+func toolErrorf(kind ErrorKind, format string, args ...any) error {
+	return &ToolError{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorKindOf classifies err. Errors not marked with a kind are recognized
+// by what they wrap: missing files, permission errors, deadlines, network
+// timeouts and arguments that do not decode.
+func ErrorKindOf(err error) ErrorKind {
+	var toolErr *ToolError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr interface{ Timeout() bool }
+	switch {
+	case errors.As(err, &toolErr):
+		return toolErr.Kind
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorPermissionDenied
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ErrorInvalidInput
+	}
+	return ErrorFailed
+}
+
+// formatToolError serializes a failure as a tool result: its kind in
+// brackets, then the message, as in "[not_found] open main.go: no such file
+// or directory".
+func formatToolError(err error) string {
+	return fmt.Sprintf("[%s] %s", ErrorKindOf(err), workspacePathsInError(err))
+}
+
+// parseToolError splits a result written by formatToolError into its kind
+// and message. Results without a kind are ErrorFailed.
+func parseToolError(output string) (ErrorKind, string) {
+	if rest, ok := strings.CutPrefix(output, "["); ok {
+		if kind, message, ok := strings.Cut(rest, "] "); ok && kind != "" && !strings.ContainsAny(kind, " \n") {
+			return ErrorKind(kind), message
+		}
+	}
+	return ErrorFailed, output
+}
+
+// markToolError gives an error result that was not written by
+// formatToolError, such as one a post_tool hook failed, the failed kind, so
+// every error result has one.
+func markToolError(output string) string {
+	if _, message := parseToolError(output); message != output {
+		return output
+	}
+	return fmt.Sprintf("[%s] %s", ErrorFailed, output)
+}
+
+// printToolError shows a failed call as its kind and the first line of its
+// message.
+func printToolError(output string) {
+	kind, message := parseToolError(output)
+	summary, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	fmt.Printf("\u001b[91m%s\u001b[0m: %s\n", kind, TruncateOutput(summary, 200))
+}
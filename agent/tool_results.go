@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultMaxResultBytes is the most a single tool result may add to the
+// conversation. Larger results are cut down and kept whole in a file.
+const defaultMaxResultBytes = 100000
+
+// resultStore keeps the full text of an agent's tool results that were
+// truncated, in a temporary directory removed when the agent closes.
+type resultStore struct {
+	mu    sync.Mutex
+	dir   string
+	paths map[string]string
+}
+
+// save writes output to a new file and returns its ID.
+func (s *resultStore) save(output string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "system3-results-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create results directory: %w", err)
+		}
+		s.dir = dir
+	}
+	id := fmt.Sprintf("result-%d", len(s.paths)+1)
+	path := filepath.Join(s.dir, id+".txt")
+	err := os.WriteFile(path, []byte(output), 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to save result: %w", err)
+	}
+	s.paths[id] = path
+	return id, nil
+}
+
+func (s *resultStore) read(id string) (string, error) {
+	s.mu.Lock()
+	path, ok := s.paths[id]
+	s.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no saved result %q", id)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read saved result: %w", err)
+	}
+	return string(content), nil
+}
+
+// Clear removes the saved results.
+func (s *resultStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+	s.dir = ""
+	s.paths = map[string]string{}
+}
+
+// limitResult holds a tool result to the per-result budget. An oversized
+// result keeps its first and last lines, where commands put their first
+// errors and their summary, and the whole of it is saved for read_output.
+func (a *Agent) limitResult(name, output string) string {
+	limit := a.config.MaxResultBytes
+	if limit <= 0 || len(output) <= limit {
+		return output
+	}
+	if name == ReadOutputDefinition.Name {
+		// Paging through a saved result must not save it again.
+		return TruncateOutput(output, limit) + "\n(request fewer lines at a time)"
+	}
+
+	id, err := a.state.results.save(output)
+	note := fmt.Sprintf("the full %d bytes are saved as %s, page through them with read_output", len(output), id)
+	if err != nil {
+		note = fmt.Sprintf("%d bytes in total, could not be saved: %s", len(output), err)
+	}
+	head, tail := lineBoundary(output[:limit/2], false), lineBoundary(output[len(output)-limit/2:], true)
+	omitted := len(output) - len(head) - len(tail)
+	return fmt.Sprintf("%s\n... (%d bytes truncated; %s) ...\n%s", head, omitted, note, tail)
+}
+
+// lineBoundary trims a cut-off partial line from the end of a head, or from
+// the start of a tail, unless that would leave nothing.
+func lineBoundary(part string, tail bool) string {
+	if tail {
+		if i := strings.IndexByte(part, '\n'); i >= 0 && i < len(part)-1 {
+			return part[i+1:]
+		}
+		return part
+	}
+	if i := strings.LastIndexByte(part, '\n'); i > 0 {
+		return part[:i]
+	}
+	return part
+}
+
+// read_output tool
+
+var ReadOutputDefinition = ToolDefinition{
+	Name: "read_output",
+	Description: `Page through a tool result that was too large to return whole and was saved instead.
+
+Truncated results name their saved ID, such as result-3. Pass start_line and end_line to read part of it, with numbered lines; search it for a pattern with search to find the lines to read.`,
+	InputSchema: ReadOutputInputSchema,
+	Function:    ReadOutput,
+}
+
+type ReadOutputInput struct {
+	ID        string `json:"id" jsonschema_description:"The ID of the saved result, e.g. result-3."`
+	StartLine int    `json:"start_line,omitempty" jsonschema_description:"Optional 1-based line to start reading from."`
+	EndLine   int    `json:"end_line,omitempty" jsonschema_description:"Optional 1-based line to stop reading at, inclusive."`
+	Search    string `json:"search,omitempty" jsonschema_description:"Optional text to look for; returns the numbered lines containing it instead."`
+}
+
+var ReadOutputInputSchema = GenerateSchema[ReadOutputInput]()
+
+func ReadOutput(ctx context.Context, input json.RawMessage) (string, error) {
+	readInput := ReadOutputInput{}
+	err := json.Unmarshal(input, &readInput)
+	if err != nil {
+		return "", err
+	}
+	output, err := toolStateFor(ctx).results.read(readInput.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if readInput.Search == "" {
+		return numberedLines(output, readInput.StartLine, readInput.EndLine)
+	}
+	var matches []string
+	for i, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, readInput.Search) {
+			matches = append(matches, fmt.Sprintf("%6d\t%s", i+1, line))
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Sprintf("%q does not occur in %s", readInput.Search, readInput.ID), nil
+	}
+	return strings.Join(matches, "\n"), nil
+}
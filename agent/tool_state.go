@@ -0,0 +1,46 @@
+package agent
+
+import "context"
+
+// toolState is what tools keep between the calls of one agent: the undo
+// journal, the files the model has seen, the background processes it
+// started and the tool results saved whole. Each agent has its own, so
+// sessions served side by side cannot undo each other's edits or stop each
+// other's processes. Sub-agents share their parent's.
+type toolState struct {
+	journal   *Journal
+	watched   *fileWatcher
+	processes *processTable
+	results   *resultStore
+}
+
+func newToolState() *toolState {
+	watched := newFileWatcher()
+	return &toolState{
+		journal:   &Journal{watched: watched},
+		watched:   watched,
+		processes: &processTable{processes: map[int]*backgroundProcess{}},
+		results:   &resultStore{paths: map[string]string{}},
+	}
+}
+
+// detachedToolState serves tool calls made outside any agent, such as by a
+// program calling a tool's Function directly.
+var detachedToolState = newToolState()
+
+// toolStateFor returns the state of the agent making the tool call ctx
+// belongs to.
+func toolStateFor(ctx context.Context) *toolState {
+	if a := callingAgent(ctx); a != nil && a.state != nil {
+		return a.state
+	}
+	return detachedToolState
+}
+
+// close stops the background processes and the file watcher, and removes
+// the saved results.
+func (s *toolState) close() {
+	s.processes.StopAll()
+	s.watched.Close()
+	s.results.Clear()
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultToolTimeout bounds every tool call without a timeout of its own. It
+// is longer than the longest command timeout the shell tools accept, so
+// those keep working as documented.
+const defaultToolTimeout = 15 * time.Minute
+
+// defaultToolTimeouts are the tools whose calls are bounded differently:
+// fetches give up sooner, and sub-agents make many calls of their own.
+var defaultToolTimeouts = map[string]time.Duration{
+	"fetch_url":       time.Minute,
+	"git":             5 * time.Minute,
+	"forge":           2 * time.Minute,
+	dispatchAgentName: time.Hour,
+}
+
+// toolTimeout returns how long a call to the tool may run, or zero when it
+// is not limited.
+func (a *Agent) toolTimeout(name string) time.Duration {
+	if timeout, ok := a.config.ToolTimeouts[name]; ok {
+		return timeout
+	}
+	if timeout, ok := defaultToolTimeouts[name]; ok && a.config.ToolTimeout != 0 {
+		return timeout
+	}
+	return a.config.ToolTimeout
+}
+
+// toolContext derives the context a call to the tool runs with, which ends
+// at the tool's timeout.
+func (a *Agent) toolContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	timeout := a.toolTimeout(name)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// toolContextError explains a tool's error when its context ended first, as
+// the error the tool returns is usually just that its process was killed
+// or its request canceled.
+func (a *Agent) toolContextError(ctx context.Context, name string, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && ErrorKindOf(err) != ErrorTimeout:
+		return toolErrorf(ErrorTimeout, "%s timed out after %s (--tool-timeout): %w", name, a.toolTimeout(name), err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%s was interrupted by the user: %w", name, err)
+	}
+	return err
+}
+
+// parseToolTimeouts parses the --tool-timeouts flag, a comma-separated list
+// of tool=duration pairs such as "fetch_url=30s,run_tests=20m". A duration
+// of 0 removes the tool's limit.
+func parseToolTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, rawTimeout, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tool timeout %q, expected tool=duration", pair)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(rawTimeout))
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid timeout %q for tool %s, expected a duration such as 30s or 5m", rawTimeout, name)
+		}
+		timeouts[strings.TrimSpace(name)] = timeout
+	}
+	return timeouts, nil
+}
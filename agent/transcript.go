@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Transcript is a readable record of a session: what the user asked, what
+// the model answered and every tool call with its result.
+type Transcript struct {
+	SessionID string            `json:"session_id"`
+	Model     anthropic.Model   `json:"model"`
+	CreatedAt time.Time         `json:"created_at"`
+	Entries   []TranscriptEntry `json:"entries"`
+}
+
+// TranscriptEntry is one event in a transcript. Type is "text", "thinking",
+// "tool_call", "tool_result" or "image".
+type TranscriptEntry struct {
+	Role    string          `json:"role"`
+	Type    string          `json:"type"`
+	Text    string          `json:"text,omitempty"`
+	Tool    string          `json:"tool,omitempty"`
+	ToolID  string          `json:"tool_id,omitempty"`
+	Input   json.RawMessage `json:"input,omitempty"`
+	IsError bool            `json:"is_error,omitempty"`
+}
+
+// NewTranscript builds the transcript of session's messages.
+func NewTranscript(session *Session) (*Transcript, error) {
+	data, err := json.Marshal(session.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode messages: %w", err)
+	}
+	var messages []struct {
+		Role    string      `json:"role"`
+		Content []wireBlock `json:"content"`
+	}
+	err = json.Unmarshal(data, &messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	transcript := &Transcript{SessionID: session.ID, Model: session.Model, CreatedAt: session.CreatedAt, Entries: []TranscriptEntry{}}
+	// Results only carry the call's ID, so remember which tool each ID was.
+	toolNames := map[string]string{}
+	for _, message := range messages {
+		for _, block := range message.Content {
+			entry := TranscriptEntry{Role: message.Role, Type: block.Type}
+			switch block.Type {
+			case "text":
+				entry.Text = block.Text
+			case "thinking":
+				entry.Text = block.Thinking
+			case "tool_use":
+				entry.Type = "tool_call"
+				entry.Tool = block.Name
+				entry.ToolID = block.ID
+				entry.Input = block.Input
+				toolNames[block.ID] = block.Name
+			case "tool_result":
+				entry.Tool = toolNames[block.ToolUseID]
+				entry.ToolID = block.ToolUseID
+				entry.IsError = block.IsError
+				var parts []string
+				for _, content := range block.Content {
+					if content.Type == "text" {
+						parts = append(parts, content.Text)
+					}
+				}
+				entry.Text = strings.Join(parts, "\n")
+			case "image":
+				entry.Text = block.Source.MediaType
+			case "server_tool_use":
+				entry.Type = "tool_call"
+				entry.Tool = block.Name
+				entry.ToolID = block.ID
+				entry.Input = block.Input
+				toolNames[block.ID] = block.Name
+			default:
+				if !isServerToolResult(block.Type) {
+					continue
+				}
+				entry.Type = "tool_result"
+				entry.ToolID, entry.Text, entry.IsError = serverToolResult(block.Raw)
+				entry.Tool = toolNames[entry.ToolID]
+			}
+			transcript.Entries = append(transcript.Entries, entry)
+		}
+	}
+	return transcript, nil
+}
+
+// Markdown renders the transcript for reading, with tool inputs and outputs
+// in code blocks.
+func (t *Transcript) Markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# System 3 session %s\n\n", t.SessionID)
+	fmt.Fprintf(&out, "Model: %s  \nStarted: %s\n", t.Model, t.CreatedAt.Format(time.RFC1123))
+
+	lastHeading := ""
+	for _, entry := range t.Entries {
+		heading := "User"
+		if entry.Role == "assistant" {
+			heading = "Assistant"
+		}
+		// Tool results travel in user messages but belong with the
+		// assistant's calls.
+		if entry.Type == "tool_result" {
+			heading = "Assistant"
+		}
+		if heading != lastHeading {
+			fmt.Fprintf(&out, "\n## %s\n", heading)
+			lastHeading = heading
+		}
+
+		switch entry.Type {
+		case "text":
+			fmt.Fprintf(&out, "\n%s\n", strings.TrimSpace(entry.Text))
+		case "thinking":
+			fmt.Fprintf(&out, "\n<details><summary>Thinking</summary>\n\n%s\n\n</details>\n", strings.TrimSpace(entry.Text))
+		case "tool_call":
+			input := string(entry.Input)
+			if indented, err := json.MarshalIndent(entry.Input, "", "  "); err == nil {
+				input = string(indented)
+			}
+			fmt.Fprintf(&out, "\n**Tool call:** `%s`\n\n%s", entry.Tool, fenced("json", input))
+		case "tool_result":
+			label := "Result"
+			if entry.IsError {
+				kind, _ := parseToolError(entry.Text)
+				label = fmt.Sprintf("Error (%s)", kind)
+			}
+			fmt.Fprintf(&out, "\n**%s:** `%s`\n\n%s", label, entry.Tool, fenced("", entry.Text))
+		case "image":
+			fmt.Fprintf(&out, "\n*[image: %s]*\n", entry.Text)
+		}
+	}
+	return out.String()
+}
+
+// fenced wraps text in a code fence longer than any backtick run inside it.
+func fenced(language, text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s%s\n%s\n%s\n", fence, language, strings.TrimRight(text, "\n"), fence)
+}
+
+// writeTranscript exports session to path, as JSON when path ends in .json
+// and as markdown otherwise.
+func writeTranscript(session *Session, path string) error {
+	transcript, err := NewTranscript(session)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		content, err = json.MarshalIndent(transcript, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode transcript: %w", err)
+		}
+	} else {
+		content = []byte(transcript.Markdown())
+	}
+
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return nil
+}
+
+// exportTranscript writes the transcript requested with --transcript.
+func (a *Agent) exportTranscript() {
+	if a.config.Transcript == "" {
+		return
+	}
+	err := writeTranscript(a.session, a.config.Transcript)
+	if err != nil {
+		slog.Warn("failed to export transcript", "error", err)
+	}
+}
+
+// /export command
+
+var ExportCommand = SlashCommand{
+	Name:        "export",
+	Usage:       "[file.md|file.json]",
+	Description: "Write the conversation, including tool calls, to a markdown or JSON file",
+	Run: func(a *Agent, args string, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+		path := orDefault(args, "system3-"+a.session.ID+".md")
+		a.setConversation(conversation)
+		err := writeTranscript(a.session, path)
+		if err != nil {
+			return conversation, err
+		}
+		fmt.Printf("\u001b[93msystem\u001b[0m: wrote transcript to %s\n", path)
+		return conversation, nil
+	},
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// trustedFilesName records the project files the user trusted, in the user
+// data directory.
+const trustedFilesName = "trusted.json"
+
+// trustProjectFile reports whether a repo-local file, such as the hooks or
+// custom tools file, may be used. A cloned repository could otherwise run
+// any command as soon as the agent starts in it, so the user is shown the
+// file and asked once; what names what the file defines, and effect says
+// what those do. The answer is remembered by the file's SHA-256 hash, and
+// changing the file asks again. Without a terminal to ask at, the file is
+// not trusted.
+func trustProjectFile(path string, content []byte, what, effect string) (bool, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	trusted, err := loadTrustedFiles()
+	if err != nil {
+		return false, err
+	}
+	if trusted[absolute] == hash {
+		return true, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "warning: ignoring the %s in %s until it is trusted; start system3 in a terminal once to review it\n", what, path)
+		return false, nil
+	}
+	fmt.Fprintf(os.Stderr, "\u001b[93mtrust\u001b[0m: %s defines %s, which %s:\n%s\n", path, what, effect, prefixLines(strings.TrimRight(string(content), "\n"), "  "))
+	for {
+		fmt.Fprint(os.Stderr, "Trust this file? [y]es / [n]o: ")
+		answer, ok := readTerminalLine()
+		if !ok {
+			return false, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			trusted[absolute] = hash
+			return true, saveTrustedFiles(trusted)
+		case "n", "no", "":
+			fmt.Fprintf(os.Stderr, "Not trusting %s.\n", path)
+			return false, nil
+		}
+	}
+}
+
+// readTerminalLine reads one line from stdin a byte at a time, so that
+// nothing after it is taken from the chat input read later.
+func readTerminalLine() (string, bool) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line), true
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), len(line) > 0
+		}
+	}
+}
+
+func trustedFilesPath() (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, trustedFilesName), nil
+}
+
+// loadTrustedFiles returns the hash of every trusted file's content, by
+// absolute path.
+func loadTrustedFiles() (map[string]string, error) {
+	trusted := map[string]string{}
+	path, err := trustedFilesPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return trusted, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	err = json.Unmarshal(content, &trusted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return trusted, nil
+}
+
+func saveTrustedFiles(trusted map[string]string) error {
+	path, err := trustedFilesPath()
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trusted files: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	err = writeFileAtomic(path, content, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileStamp is how a file looked on disk when it was last checked.
+type fileStamp struct {
+	Exists  bool
+	ModTime time.Time
+	Size    int64
+	// Hash is the SHA-256 of the content. It is only computed when the
+	// cheaper fields differ.
+	Hash string
+}
+
+// stampFile stats path, hashing its content when withHash is set.
+func stampFile(path string, withHash bool) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	stamp := fileStamp{Exists: true, ModTime: info.ModTime(), Size: info.Size()}
+	if withHash {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fileStamp{}
+		}
+		sum := sha256.Sum256(content)
+		stamp.Hash = hex.EncodeToString(sum[:])
+	}
+	return stamp
+}
+
+// sameMetadata reports whether the file looks untouched without reading it.
+func (s fileStamp) sameMetadata(other fileStamp) bool {
+	return s.Exists == other.Exists && s.Size == other.Size && s.ModTime.Equal(other.ModTime)
+}
+
+// fileWatcher tracks the files an agent has read or written, with a hash of
+// their content at the time, so changes made by anyone else can be reported
+// to the model and edits based on stale contents refused. It follows them
+// with fsnotify, watching their directories rather than the files
+// themselves, because editors often save by replacing a file, which ends a
+// watch on the old one. Without fsnotify (for example when inotify watches
+// run out) changes are still caught by comparing the files on disk when
+// they are checked.
+type fileWatcher struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	// unavailable records that the fsnotify watcher could not be started.
+	unavailable bool
+	dirs        map[string]bool
+	// files holds each file as the agent last saw it.
+	files map[string]fileStamp
+	// changed holds the files changed by someone else since the agent last
+	// saw them, with whether the model has been told.
+	changed map[string]bool
+}
+
+func newFileWatcher() *fileWatcher {
+	return &fileWatcher{dirs: map[string]bool{}, files: map[string]fileStamp{}, changed: map[string]bool{}}
+}
+
+// seen records path as the agent now knows it, after reading or writing it.
+func (w *fileWatcher) seen(path string) {
+	stamp := stampFile(path, true)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files[path] = stamp
+	delete(w.changed, path)
+	w.watchDir(filepath.Dir(path))
+}
+
+// watchDir adds dir to the fsnotify watch, starting the watcher on first
+// use. w.mu must be held.
+func (w *fileWatcher) watchDir(dir string) {
+	if w.dirs[dir] || w.unavailable {
+		return
+	}
+	if w.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			slog.Debug("file watcher unavailable, checking files on use instead", "error", err)
+			w.unavailable = true
+			return
+		}
+		w.watcher = watcher
+		go w.run(watcher)
+	}
+	err := w.watcher.Add(dir)
+	if err != nil {
+		slog.Debug("failed to watch directory", "dir", dir, "error", err)
+		return
+	}
+	w.dirs[dir] = true
+}
+
+func (w *fileWatcher) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			w.check(event.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Debug("file watcher error", "error", err)
+		}
+	}
+}
+
+// check compares a file the agent has seen with the disk and reports
+// whether its content changed since the agent last saw it. A file that was
+// only touched, or saved without changes, has not changed.
+func (w *fileWatcher) check(path string) bool {
+	w.mu.Lock()
+	seen, ok := w.files[path]
+	_, flagged := w.changed[path]
+	w.mu.Unlock()
+	if !ok || flagged {
+		return flagged
+	}
+	stamp := stampFile(path, false)
+	if stamp.sameMetadata(seen) {
+		return false
+	}
+	stamp = stampFile(path, true)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if current, ok := w.files[path]; !ok || current != seen {
+		// The agent saw the file again while it was being hashed.
+		return false
+	}
+	if stamp.Exists == seen.Exists && stamp.Hash == seen.Hash {
+		w.files[path] = stamp
+		return false
+	}
+	slog.Debug("file changed outside the agent", "path", relativeToWorkspace(path))
+	w.changed[path] = false
+	return true
+}
+
+// unreported returns the files changed since the agent last saw them that
+// the model has not been told about yet, and marks them told.
+func (w *fileWatcher) unreported() []string {
+	w.mu.Lock()
+	var paths []string
+	for path := range w.files {
+		paths = append(paths, path)
+	}
+	w.mu.Unlock()
+	// Files are checked again in case fsnotify is unavailable or its events
+	// have not arrived yet.
+	for _, path := range paths {
+		w.check(path)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var changed []string
+	for path, reported := range w.changed {
+		if !reported {
+			changed = append(changed, relativeToWorkspace(path))
+			w.changed[path] = true
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Close stops the fsnotify watcher and forgets the files seen, so an agent
+// started later, possibly in another workspace, is not told about them.
+func (w *fileWatcher) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watcher != nil {
+		w.watcher.Close()
+		w.watcher = nil
+		w.dirs = map[string]bool{}
+	}
+	w.files = map[string]fileStamp{}
+	w.changed = map[string]bool{}
+}
+
+// errChangedSinceRead refuses a change to a file whose content no longer
+// matches what the agent read, so someone else's edits are not overwritten.
+func errChangedSinceRead(path string) error {
+	return fmt.Errorf("file changed since read: %s was modified outside the agent after you last read it; read it again and redo the change so those modifications are not lost", relativeToWorkspace(path))
+}
+
+// externalChangesNotice tells the model which files others changed since it
+// last saw them, or returns "" when there are none. Only the chat's own
+// agent reports them, so a sub-agent cannot swallow the notice.
+func (a *Agent) externalChangesNotice() string {
+	if a.parent != nil {
+		return ""
+	}
+	changed := a.state.watched.unreported()
+	if len(changed) == 0 {
+		return ""
+	}
+	a.results.clear()
+	return fmt.Sprintf("<system>These files were changed outside the agent since you last read them; read them again before relying on or editing them:\n%s</system>", strings.Join(changed, "\n"))
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// workspaceRoot is the directory every tool path is resolved against and
+// confined to. It is an absolute path with symlinks resolved; empty means
+// the working directory System 3 was started in.
+var workspaceRoot string
+
+// SetWorkspaceRoot confines file tools to dir.
+func SetWorkspaceRoot(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace %s: %w", dir, err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace %s: %w", dir, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("workspace %s is not a directory", dir)
+	}
+
+	workspaceRoot = resolved
+	return nil
+}
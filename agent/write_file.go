@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// write_file tool
+
+var WriteFileDefinition = ToolDefinition{
+	Name: "write_file",
+	Description: `Write the full content of a file, creating parent directories as needed.
+
+Use this to create a new file or to replace a file's entire content. Existing files are only replaced when overwrite is true. For small changes to an existing file, prefer edit_file.`,
+	InputSchema: WriteFileInputSchema,
+	Function:    WriteFile,
+	Preview:     PreviewWriteFile,
+	Mutating:    alwaysMutating,
+}
+
+type WriteFileInput struct {
+	Path      string `json:"path" jsonschema_description:"The relative path of the file to write"`
+	Content   string `json:"content" jsonschema_description:"The complete content to write to the file"`
+	Overwrite bool   `json:"overwrite,omitempty" jsonschema_description:"Replace the file if it already exists. Defaults to false."`
+}
+
+var WriteFileInputSchema = GenerateSchema[WriteFileInput]()
+
+func WriteFile(ctx context.Context, input json.RawMessage) (string, error) {
+	writeFileInput := WriteFileInput{}
+	err := json.Unmarshal(input, &writeFileInput)
+	if err != nil {
+		return "", err
+	}
+
+	state := toolStateFor(ctx)
+	if writeFileInput.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+
+	path, err := resolvePath(writeFileInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory", writeFileInput.Path)
+		}
+		if !writeFileInput.Overwrite {
+			return "", fmt.Errorf("file %s already exists, set overwrite to replace it", writeFileInput.Path)
+		}
+		if state.watched.check(path) {
+			return "", errChangedSinceRead(path)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	err = state.journal.Record("write_file", path)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	err = writeFileAtomic(path, []byte(writeFileInput.Content), fileMode(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	state.watched.seen(path)
+
+	if exists {
+		return fmt.Sprintf("Overwrote %s (%d bytes)", writeFileInput.Path, len(writeFileInput.Content)), nil
+	}
+	return fmt.Sprintf("Created %s (%d bytes)", writeFileInput.Path, len(writeFileInput.Content)), nil
+}
+
+func PreviewWriteFile(input json.RawMessage) (string, bool) {
+	writeFileInput := WriteFileInput{}
+	err := json.Unmarshal(input, &writeFileInput)
+	if err != nil {
+		return fmt.Sprintf("write with invalid input: %s", input), true
+	}
+
+	path, err := resolvePath(writeFileInput.Path)
+	if err != nil {
+		return fmt.Sprintf("write %s: %v", writeFileInput.Path, err), true
+	}
+
+	action := "create"
+	if _, err := os.Stat(path); err == nil {
+		action = "overwrite"
+	}
+	return fmt.Sprintf("%s %s:\n%s", action, writeFileInput.Path, prefixLines(writeFileInput.Content, "+ ")), true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"system_3/agent"
+)
+
+const evalUsage = "usage: system3 eval <suite.yaml> [flags]"
+
+// errEvalFailed is returned when a task of the suite did not pass, so the
+// command exits with an error in CI.
+var errEvalFailed = errors.New("some tasks failed")
+
+// eval runs `system3 eval <suite.yaml> [flags]`: the suite's tasks against
+// the model and settings chosen with the usual flags, reporting each task's
+// outcome, cost and turns, then the pass rate.
+func eval(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New(evalUsage)
+	}
+	suite, err := agent.LoadEvalSuite(args[0])
+	if err != nil {
+		return err
+	}
+	config, err := agent.LoadConfig(args[1:])
+	if err != nil {
+		return err
+	}
+	err = agent.Setup(config)
+	if err != nil {
+		return err
+	}
+	provider, err := agent.NewProvider(config)
+	if err != nil {
+		return err
+	}
+
+	closeLog := agent.SetupLogging(config.LogLevel, "eval")
+	defer closeLog()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("System 3 version %s (%s model: %s), %d tasks\n", Version, provider.Name(), config.Model, len(suite.Tasks))
+	tools := append(agent.DefaultTools(), config.CustomTools...)
+	tools = append(tools, agent.LoadPlugins(tools)...)
+	results := agent.RunEval(ctx, provider, tools, config, suite, func(result agent.EvalResult) {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %-30s %3d turns  $%.2f  %s  session %s\n", status, result.Task, result.Turns, result.Cost, result.Duration.Round(time.Second), result.SessionID)
+		for _, failure := range result.Failures {
+			fmt.Printf("     %s\n", strings.ReplaceAll(failure, "\n", "\n     "))
+		}
+	})
+
+	passed, turns, cost := 0, 0, 0.0
+	for _, result := range results {
+		if result.Passed {
+			passed++
+		}
+		turns += result.Turns
+		cost += result.Cost
+	}
+	fmt.Printf("\n%d/%d tasks passed (%.0f%%), %d turns, $%.2f\n", passed, len(suite.Tasks), 100*float64(passed)/float64(len(suite.Tasks)), turns, cost)
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted after %d of %d tasks", len(results), len(suite.Tasks))
+	}
+	if passed < len(suite.Tasks) {
+		return errEvalFailed
+	}
+	return nil
+}
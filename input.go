@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	// multilineDelimiter on a line of its own opens and closes a block of
+	// lines sent as one message.
+	multilineDelimiter = `"""`
+	// editorEscape is what the terminal sends for Ctrl+X. Pressing Ctrl+X
+	// then Enter composes the message in $EDITOR.
+	editorEscape = "\x18"
+)
+
+// multilineInput wraps a line reader so a single message can span lines:
+// a trailing backslash continues onto the next line, a """ block is read
+// verbatim until the closing """, and Ctrl+X opens $EDITOR.
+func multilineInput(readLine func() (string, bool)) func() (string, bool) {
+	return func() (string, bool) {
+		line, ok := readLine()
+		if !ok {
+			return "", false
+		}
+
+		switch {
+		case strings.TrimSpace(line) == editorEscape:
+			message, err := editMessage()
+			if err != nil {
+				slog.Warn("failed to edit message", "error", err)
+				return "", true
+			}
+			fmt.Println(message)
+			return message, true
+		case strings.HasPrefix(strings.TrimSpace(line), multilineDelimiter):
+			return readBlock(readLine, strings.TrimPrefix(strings.TrimSpace(line), multilineDelimiter))
+		case strings.HasSuffix(line, `\`):
+			return readContinuation(readLine, line)
+		}
+		return line, true
+	}
+}
+
+func readBlock(readLine func() (string, bool), first string) (string, bool) {
+	var lines []string
+	if first != "" {
+		lines = append(lines, first)
+	}
+	for {
+		fmt.Print("... ")
+		line, ok := readLine()
+		if !ok {
+			return strings.Join(lines, "\n"), len(lines) > 0
+		}
+		if strings.TrimSpace(line) == multilineDelimiter {
+			return strings.Join(lines, "\n"), true
+		}
+		lines = append(lines, line)
+	}
+}
+
+func readContinuation(readLine func() (string, bool), line string) (string, bool) {
+	var lines []string
+	for strings.HasSuffix(line, `\`) {
+		lines = append(lines, strings.TrimSuffix(line, `\`))
+		fmt.Print("... ")
+		next, ok := readLine()
+		if !ok {
+			return strings.Join(lines, "\n"), true
+		}
+		line = next
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, "\n"), true
+}
+
+// editMessage opens $VISUAL or $EDITOR on a temporary file and returns what
+// was saved.
+func editMessage() (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if strings.TrimSpace(editor) == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	file, err := os.CreateTemp("", "system3-message-*.md")
+	if err != nil {
+		return "", fmt.Errorf("failed to create message file: %w", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	// The editor command may carry arguments, e.g. "code --wait". Windows
+	// has no sh to split them, so they are split on spaces there.
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", file.Name())
+	if runtime.GOOS == "windows" {
+		fields := strings.Fields(editor)
+		cmd = exec.Command(fields[0], append(fields[1:], file.Name())...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read message file: %w", err)
+	}
+	return strings.TrimRight(string(content), "\n"), nil
+}
@@ -3,876 +3,176 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"strings"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/anthropics/anthropic-sdk-go"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/invopop/jsonschema"
+
+	"system_3/agent"
 )
 
 // Version is set during build through ldflags
 var Version = "dev"
 
 func main() {
-	tools := []ToolDefinition{ReadFileToolDefinition, ListFilesDefinition, EditFileDefinition, GitToolDefinition}
-	client := anthropic.NewClient()
-
-	fmt.Printf("System 3 version %s\n", Version)
-
-	scanner := bufio.NewScanner(os.Stdin)
-	getUserMessage := func() (string, bool) {
-		if !scanner.Scan() {
-			return "", false
-		}
-
-		return scanner.Text(), true
-	}
-
-	agent := NewAgent(&client, getUserMessage, tools)
-	err := agent.Run(context.TODO())
-	if err != nil {
-		fmt.Printf("error: %v\n", err)
-	}
-}
-
-func NewAgent(client *anthropic.Client, getUserMessage func() (string, bool), tools []ToolDefinition) *Agent {
-	return &Agent{
-		client:         client,
-		getUserMessage: getUserMessage,
-		tools:          tools,
-	}
-}
-
-type Agent struct {
-	client         *anthropic.Client
-	getUserMessage func() (string, bool)
-	tools          []ToolDefinition
-}
-
-func (a *Agent) Run(ctx context.Context) error {
-	var conversation []anthropic.MessageParam
-
-	fmt.Println("Chat with Claude (press Ctrl+C to exit)")
-
-	readUserInput := true
-	for {
-		if readUserInput {
-			fmt.Print("\u001b[94mYou\u001b[0m: ")
-			userInput, ok := a.getUserMessage()
-			if !ok {
-				break
-			}
-
-			userMessage := anthropic.NewUserMessage(anthropic.NewTextBlock(userInput))
-			conversation = append(conversation, userMessage)
-		}
-
-		message, err := a.runInterface(ctx, conversation)
-		if err != nil {
-			return err
-		}
-		conversation = append(conversation, message.ToParam())
-
-		// tool usage
-		var toolResults []anthropic.ContentBlockParamUnion
-		for _, content := range message.Content {
-			switch content.Type {
-			case "text":
-				fmt.Printf("\u001b[92mClaude\u001b[0m: %s\n", content.Text)
-			case "tool_use":
-				result := a.executeTool(content.ID, content.Name, content.Input)
-				toolResults = append(toolResults, result)
+	os.Exit(run())
+}
+
+// run runs system3 and returns its exit code, so that main exits only once
+// the deferred calls that flush logs and telemetry have run.
+func run() int {
+	agent.Version = Version
+	agent.EnableConsoleColors()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "sessions" {
+		resumeArgs, ok := sessionsOpenArgs(args[1:])
+		if !ok {
+			err := sessions(args[1:])
+			if err != nil {
+				fmt.Printf("error: %v\n", err)
+				return 1
 			}
+			return 0
 		}
-
-		if len(toolResults) == 0 {
-			readUserInput = true
-			continue
-		}
-
-		readUserInput = false
-		conversation = append(conversation, anthropic.NewUserMessage(toolResults...))
+		args = resumeArgs
 	}
-
-	return nil
-}
-
-func (a *Agent) executeTool(id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
-	var toolDef ToolDefinition
-	var found bool
-	for _, tool := range a.tools {
-		if tool.Name == name {
-			toolDef = tool
-			found = true
-			break
+	if len(args) > 0 && args[0] == "eval" {
+		err := eval(args[1:])
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return 1
 		}
+		return 0
 	}
-
-	if !found {
-		return anthropic.NewToolResultBlock(id, "tool not found", true)
+	serveMode := len(args) > 0 && args[0] == "serve"
+	if serveMode {
+		args = args[1:]
 	}
-
-	fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", name, input)
-	response, err := toolDef.Function(input)
+	config, err := agent.LoadConfig(args)
 	if err != nil {
-		return anthropic.NewToolResultBlock(id, err.Error(), true)
-	}
-
-	return anthropic.NewToolResultBlock(id, response, false)
-}
-
-func (a *Agent) runInterface(ctc context.Context, conversation []anthropic.MessageParam) (*anthropic.Message, error) {
-	var anthropicTools []anthropic.ToolUnionParam
-	for _, tool := range a.tools {
-		anthropicTools = append(anthropicTools, anthropic.ToolUnionParam{
-			OfTool: &anthropic.ToolParam{
-				Name:        tool.Name,
-				Description: anthropic.String(tool.Description),
-				InputSchema: tool.InputSchema,
-			},
-		})
-	}
-	return a.client.Messages.New(ctc, anthropic.MessageNewParams{
-		// Model:     anthropic.ModelClaude3_7SonnetLatest,
-		// Claude 3.5 Sonnet 2024-10-22
-		Model:     anthropic.ModelClaude3_5Sonnet20241022,
-		MaxTokens: int64(1024),
-		Messages:  conversation,
-		Tools:     anthropicTools,
-	})
-}
-
-type ToolDefinition struct {
-	Name        string                         `json:"name"`
-	Description string                         `json:"description"`
-	InputSchema anthropic.ToolInputSchemaParam `json:"input_schema"`
-	Function    func(input json.RawMessage) (string, error)
-}
-
-func GenerateSchema[T any]() anthropic.ToolInputSchemaParam {
-	reflector := jsonschema.Reflector{
-		AllowAdditionalProperties: false,
-		DoNotReference:            true,
-	}
-
-	var v T
-	schema := reflector.Reflect(v)
-
-	return anthropic.ToolInputSchemaParam{
-		Properties: schema.Properties,
-	}
-}
-
-// read_file tool
-
-var ReadFileToolDefinition = ToolDefinition{
-	Name:        "read_file",
-	Description: "Reads a file's contents, given a relative path. Useful for inspecting a file but does not work with directory names.",
-	InputSchema: ReadFileInputSchema,
-	Function:    ReadFile,
-}
-
-type ReadFileInput struct {
-	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
-}
-
-var ReadFileInputSchema = GenerateSchema[ReadFileInput]()
-
-func ReadFile(input json.RawMessage) (string, error) {
-	readFileInput := ReadFileInput{}
-	err := json.Unmarshal(input, &readFileInput)
-	if err != nil {
-		panic(err)
+		fmt.Printf("error: %v\n", err)
+		return 2
 	}
 
-	content, err := os.ReadFile(readFileInput.Path)
+	err = agent.Setup(config)
 	if err != nil {
-		return "", err
+		fmt.Printf("error: %v\n", err)
+		return 2
 	}
 
-	return string(content), nil
-}
-
-// list_files tool
-
-var ListFilesDefinition = ToolDefinition{
-	Name:        "list_files",
-	Description: "List files and directories at a given path. If no path is provided, lists files in the current directory.",
-	InputSchema: ListFilesInputSchema,
-	Function:    ListFiles,
-}
-
-type ListFilesInput struct {
-	Path string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
-}
-
-var ListFilesInputSchema = GenerateSchema[ListFilesInput]()
-
-func ListFiles(input json.RawMessage) (string, error) {
-	listFilesInput := ListFilesInput{}
-	err := json.Unmarshal(input, &listFilesInput)
+	tools := append(agent.DefaultTools(), config.CustomTools...)
+	provider, err := agent.NewProvider(config)
 	if err != nil {
-		panic(err)
-	}
-
-	dir := "."
-	if listFilesInput.Path != "" {
-		dir = listFilesInput.Path
+		fmt.Printf("error: %v\n", err)
+		return 2
 	}
 
-	var files []string
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(dir, path)
+	if serveMode {
+		fmt.Printf("System 3 version %s (%s model: %s)\n", Version, provider.Name(), config.Model)
+		err = serve(config, provider, tools)
 		if err != nil {
-			return err
-		}
-
-		if relPath != "." {
-			if info.IsDir() {
-				files = append(files, relPath+"/")
-			} else {
-				files = append(files, relPath)
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	result, err := json.Marshal(files)
-	if err != nil {
-		return "", err
-	}
-
-	return string(result), nil
-}
-
-// edit_file tool
-
-var EditFileDefinition = ToolDefinition{
-	Name: "edit_file",
-	Description: `Make edits to a text file.
-
-Replaces 'old_str' with 'new_str' in the given file. 'old_str' and 'new_str' MUST be different from each other.
-
-If the file specified with path doesn't exist, it will be created.
-`,
-	InputSchema: EditFileInputSchema,
-	Function:    EditFile,
-}
-
-type EditFileInput struct {
-	Path   string `json:"path" jsonschema_description:"The path to the file"`
-	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly and must only have one match exactly"`
-	NewStr string `json:"new_str" jsonschema_description:"Text to replace old_str with"`
-}
-
-var EditFileInputSchema = GenerateSchema[EditFileInput]()
-
-func EditFile(input json.RawMessage) (string, error) {
-	editFileInput := EditFileInput{}
-	err := json.Unmarshal(input, &editFileInput)
-	if err != nil {
-		return "", err
-	}
-
-	if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
-		return "", fmt.Errorf("invalid input parameters")
-	}
-
-	content, err := os.ReadFile(editFileInput.Path)
-	if err != nil {
-		if os.IsNotExist(err) && editFileInput.OldStr == "" {
-			return createNewFile(editFileInput.Path, editFileInput.NewStr)
+			fmt.Printf("error: %v\n", err)
+			return 1
 		}
-		return "", err
+		return 0
 	}
 
-	oldContent := string(content)
-	newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, -1)
-
-	if oldContent == newContent && editFileInput.OldStr != "" {
-		return "", fmt.Errorf("old_str not found in file")
+	// In one-shot mode progress output goes to stderr so that stdout carries
+	// only the final answer and can be piped into other tools.
+	stdout := os.Stdout
+	if config.Prompt != "" {
+		os.Stdout = os.Stderr
 	}
 
-	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
-	if err != nil {
-		return "", err
-	}
+	fmt.Printf("System 3 version %s (%s model: %s)\n", Version, provider.Name(), config.Model)
 
-	return "OK", nil
-}
-
-func createNewFile(filePath, content string) (string, error) {
-	dirPath := filepath.Dir(filePath)
-	if dirPath != "." {
-		err := os.MkdirAll(dirPath, 0755)
+	session := agent.NewSession(config.Model)
+	if config.Resume != "" {
+		session, err = agent.LoadSession(config.Resume)
 		if err != nil {
-			return "", fmt.Errorf("failed to create directory: %w", err)
+			fmt.Printf("error: %v\n", err)
+			return 1
 		}
+		fmt.Printf("Resumed session %s (%d messages)\n", session.ID, len(session.Messages))
+	} else {
+		fmt.Printf("Session %s\n", session.ID)
 	}
 
-	err := os.WriteFile(filePath, []byte(content), 0644)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
-	}
-
-	return fmt.Sprintf("Successfully created file %s", filePath), nil
-}
-
-// Git tool definition
-
-var GitToolDefinition = ToolDefinition{
-	Name:        "git",
-	Description: "Perform Git operations like init, clone, add, commit, fetch, and status on repositories",
-	InputSchema: GitInputSchema,
-	Function:    GitOperation,
-}
-
-type GitInput struct {
-	Command    string `json:"command" jsonschema_description:"Git command to execute. Supported commands: init, clone, add, commit, status, log, branch, diff, reset, fetch, remote-update, checkout"`
-	Path       string `json:"path,omitempty" jsonschema_description:"Path where the repository is located or should be created"`
-	URL        string `json:"url,omitempty" jsonschema_description:"URL of the repository to clone"`
-	Files      string `json:"files,omitempty" jsonschema_description:"Files to add, comma-separated or glob pattern"`
-	Message    string `json:"message,omitempty" jsonschema_description:"Commit message"`
-	BranchName string `json:"branch_name,omitempty" jsonschema_description:"Branch name for branch operations"`
-}
-
-var GitInputSchema = GenerateSchema[GitInput]()
-
-func GitOperation(input json.RawMessage) (string, error) {
-	gitInput := GitInput{}
-	err := json.Unmarshal(input, &gitInput)
-	if err != nil {
-		return "", err
-	}
+	closeLog := agent.SetupLogging(config.LogLevel, session.ID)
+	defer closeLog()
 
-	// Set default path to current directory if not provided
-	if gitInput.Path == "" {
-		gitInput.Path = "."
-	}
-
-	switch gitInput.Command {
-	case "init":
-		return gitInit(gitInput.Path)
-	case "clone":
-		return gitClone(gitInput.URL, gitInput.Path)
-	case "add":
-		return gitAdd(gitInput.Path, gitInput.Files)
-	case "commit":
-		return gitCommit(gitInput.Path, gitInput.Message)
-	case "status":
-		return gitStatus(gitInput.Path)
-	case "log":
-		return gitLog(gitInput.Path)
-	case "branch":
-		return gitBranch(gitInput.Path, gitInput.BranchName)
-	case "reset":
-		return gitReset(gitInput.Path)
-	case "diff":
-		return gitDiff(gitInput.Path, gitInput.Files)
-	case "fetch":
-		return gitFetch(gitInput.Path, gitInput.BranchName)
-	case "checkout":
-		return gitCheckout(gitInput.Path, gitInput.BranchName)
-	default:
-		return "", fmt.Errorf("unsupported git command: %s", gitInput.Command)
-	}
-}
-
-func gitInit(path string) (string, error) {
-	_, err := git.PlainInit(path, false)
-	if err != nil {
-		return "", fmt.Errorf("failed to initialize git repository: %w", err)
-	}
-
-	return fmt.Sprintf("Initialized empty Git repository in %s", path), nil
-}
-
-func gitClone(url, path string) (string, error) {
-	if url == "" {
-		return "", fmt.Errorf("URL is required for clone operation")
-	}
-
-	_, err := git.PlainClone(path, false, &git.CloneOptions{
-		URL: url,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to clone repository: %w", err)
-	}
-
-	return fmt.Sprintf("Cloned repository %s to %s", url, path), nil
-}
-
-func gitAdd(path, files string) (string, error) {
-	if files == "" {
-		return "", fmt.Errorf("files parameter is required for add operation")
-	}
-
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	// Handle comma-separated file list
-	fileList := strings.Split(files, ",")
-	for _, file := range fileList {
-		file = strings.TrimSpace(file)
-		_, err := w.Add(file)
+	shutdownTelemetry := func() {}
+	if config.OTLPEndpoint != "" {
+		shutdown, err := agent.SetupTelemetry(config.OTLPEndpoint, session.ID)
 		if err != nil {
-			return "", fmt.Errorf("failed to add file %s: %w", file, err)
+			slog.Warn("telemetry disabled", "error", err)
+		} else {
+			shutdownTelemetry = shutdown
 		}
 	}
+	defer shutdownTelemetry()
 
-	return fmt.Sprintf("Added files: %s", files), nil
-}
-
-func gitCommit(path, message string) (string, error) {
-	if message == "" {
-		return "", fmt.Errorf("commit message is required")
-	}
-
-	// Load global git config to get user name and email
-	cfg, err := config.LoadConfig(config.GlobalScope)
-	if err != nil {
-		return "", fmt.Errorf("failed to load git config: %w", err)
-	}
-	if cfg.User.Name == "" || cfg.User.Email == "" {
-		return "", fmt.Errorf("git config user.name or user.email not set globally")
-	}
-
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	commit, err := w.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  cfg.User.Name,
-			Email: cfg.User.Email,
-			When:  time.Now(),
-		},
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to commit: %w", err)
-	}
-
-	obj, err := r.CommitObject(commit)
-	if err != nil {
-		return "", fmt.Errorf("failed to get commit object: %w", err)
-	}
-
-	return fmt.Sprintf("Created commit: %s with message: %s", obj.Hash, message), nil
-}
-
-func gitStatus(path string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	status, err := w.Status()
-	if err != nil {
-		return "", fmt.Errorf("failed to get status: %w", err)
-	}
-
-	return status.String(), nil
-}
-
-func gitLog(path string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	// Get HEAD reference
-	ref, err := r.Head()
-	if err != nil {
-		return "", fmt.Errorf("failed to get HEAD: %w", err)
-	}
-
-	// Get commit history
-	logIter, err := r.Log(&git.LogOptions{From: ref.Hash()})
-	if err != nil {
-		return "", fmt.Errorf("failed to get log: %w", err)
-	}
-
-	var commits []string
-	// Only get the last 10 commits to avoid overwhelming output
-	count := 0
-	err = logIter.ForEach(func(c *object.Commit) error {
-		if count >= 10 {
-			return nil
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	getUserMessage := multilineInput(func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
 		}
-		commitInfo := fmt.Sprintf("commit %s\nAuthor: %s <%s>\nDate: %s\n\n    %s\n",
-			c.Hash,
-			c.Author.Name,
-			c.Author.Email,
-			c.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"),
-			c.Message)
-		commits = append(commits, commitInfo)
-		count++
-		return nil
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to iterate over commits: %w", err)
-	}
-
-	if len(commits) == 0 {
-		return "No commits found", nil
-	}
-
-	return strings.Join(commits, "\n"), nil
-}
-
-func gitBranch(path, branchName string) (string, error) {
-	if branchName == "" {
-		// List branches if no branch name provided
-		return listBranches(path)
-	}
 
-	// Create new branch
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	// Get HEAD reference
-	head, err := r.Head()
-	if err != nil {
-		return "", fmt.Errorf("failed to get HEAD: %w", err)
-	}
-
-	// Create new branch reference
-	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branchName), head.Hash())
-
-	// Save branch
-	err = r.Storer.SetReference(ref)
-	if err != nil {
-		return "", fmt.Errorf("failed to create branch: %w", err)
-	}
-
-	return fmt.Sprintf("Created branch: %s", branchName), nil
-}
-
-func gitReset(path string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	err = w.Reset(&git.ResetOptions{
-		Mode: git.HardReset,
+		return scanner.Text(), true
 	})
-	if err != nil {
-		return "", fmt.Errorf("failed to reset: %w", err)
-	}
 
-	return fmt.Sprintf("Reset to HEAD"), nil
-}
-
-func gitDiff(path, files string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	// Get the current worktree status
-	status, err := w.Status()
-	if err != nil {
-		return "", fmt.Errorf("failed to get status: %w", err)
-	}
+	tools = append(tools, agent.LoadPlugins(tools)...)
+	chat := agent.NewAgent(provider, getUserMessage, tools, config, session)
+	// Ctrl+C at the prompt exits without returning here, so the deferred
+	// calls run from the agent's exit instead.
+	chat.OnExit(func() {
+		shutdownTelemetry()
+		closeLog()
+	})
 
-	// If no files are specified, show diff for all modified files
-	if files == "" {
-		var output strings.Builder
-		for filePath, fileStatus := range status {
-			if fileStatus.Worktree != git.Unmodified || fileStatus.Staging != git.Unmodified {
-				diffOutput, err := diffFile(r, w, filePath)
-				if err != nil {
-					output.WriteString(fmt.Sprintf("Error getting diff for %s: %s\n", filePath, err))
-					continue
-				}
-				if diffOutput != "" {
-					output.WriteString(fmt.Sprintf("--- a/%s\n+++ b/%s\n%s\n", filePath, filePath, diffOutput))
-				}
-			}
-		}
-		if output.Len() == 0 {
-			return "No changes detected", nil
-		}
-		return output.String(), nil
+	// The chat handles Ctrl+C itself, cancelling only the turn in flight. A
+	// one-shot run has no prompt to return to, so Ctrl+C cancels it outright.
+	ctx := context.Background()
+	if config.Prompt != "" {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
 	}
 
-	// Show diff for specific files
-	fileList := strings.Split(files, ",")
-	var output strings.Builder
-	for _, filePath := range fileList {
-		filePath = strings.TrimSpace(filePath)
-		diffOutput, err := diffFile(r, w, filePath)
+	if config.Prompt != "" {
+		content := []anthropic.ContentBlockParamUnion{}
+		piped, err := readPipedInput()
 		if err != nil {
-			output.WriteString(fmt.Sprintf("Error getting diff for %s: %s\n", filePath, err))
-			continue
+			slog.Error("failed to read piped input", "error", err)
+			return 1
 		}
-		if diffOutput != "" {
-			output.WriteString(fmt.Sprintf("--- a/%s\n+++ b/%s\n%s\n", filePath, filePath, diffOutput))
+		if piped != "" {
+			content = append(content, anthropic.NewTextBlock(fmt.Sprintf("<stdin>\n%s\n</stdin>", piped)))
 		}
-	}
+		content = append(content, anthropic.NewTextBlock(config.Prompt))
 
-	if output.Len() == 0 {
-		return "No changes detected in specified files", nil
-	}
-
-	return output.String(), nil
-}
-
-// Helper function to get diff for a single file
-func diffFile(r *git.Repository, w *git.Worktree, filePath string) (string, error) {
-	// Get the current file content
-	currentContentBytes, err := os.ReadFile(filepath.Join(w.Filesystem.Root(), filePath))
-	if err != nil {
-		// File might be deleted
-		if os.IsNotExist(err) {
-			return "File deleted", nil
+		answer, err := chat.RunOnce(ctx, content...)
+		chat.Close()
+		if ctx.Err() != nil {
+			fmt.Println("interrupted")
+			return 130
 		}
-		return "", err
-	}
-	currentContent := string(currentContentBytes)
-
-	// Try to get HEAD commit
-	head, err := r.Head()
-	if err != nil {
-		// Repository might be empty or HEAD might not exist yet
-		return fmt.Sprintf("New file: %s\n%s", filePath, currentContent), nil
-	}
-
-	// Get the commit object
-	commit, err := r.CommitObject(head.Hash())
-	if err != nil {
-		return "", err
-	}
-
-	// Get the file from HEAD
-	fileInHead, err := commit.File(filePath)
-	if err != nil {
-		// File might be new
-		return fmt.Sprintf("New file: %s\n%s", filePath, currentContent), nil
-	}
-
-	// Get the content from HEAD
-	previousContent, err := fileInHead.Contents()
-	if err != nil {
-		return "", err
-	}
-
-	// No changes
-	if previousContent == currentContent {
-		return "", nil
-	}
-
-	// Simple line-by-line diff
-	prevLines := strings.Split(previousContent, "\n")
-	currLines := strings.Split(currentContent, "\n")
-
-	// Basic diff output
-	var output strings.Builder
-	for i, line := range currLines {
-		if i < len(prevLines) {
-			if line != prevLines[i] {
-				output.WriteString(fmt.Sprintf("-  %s\n+  %s\n", prevLines[i], line))
-			}
-		} else {
-			// New lines added
-			output.WriteString(fmt.Sprintf("+  %s\n", line))
-		}
-	}
-
-	// Check for removed lines
-	if len(prevLines) > len(currLines) {
-		for i := len(currLines); i < len(prevLines); i++ {
-			output.WriteString(fmt.Sprintf("-  %s\n", prevLines[i]))
-		}
-	}
-
-	return output.String(), nil
-}
-
-func gitFetch(path string, branchName string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	// Get the default remote (origin)
-	remoteName := "origin"
-	_, err = r.Remote(remoteName)
-	if err != nil {
-		// Try to find any remote if origin doesn't exist
-		remotes, remErr := r.Remotes()
-		if remErr != nil || len(remotes) == 0 {
-			return "", fmt.Errorf("no remotes found: %w", err)
-		}
-		// Use the first available remote
-		remoteName = remotes[0].Config().Name
-	}
-
-	// Create fetch options
-	fetchOpts := &git.FetchOptions{
-		RemoteName: remoteName,
-		Force:      false,
-	}
-
-	// If a specific branch is requested, fetch only that branch
-	if branchName != "" {
-		// Construct proper refspec for the branch
-		refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/remotes/%s/%s", branchName, remoteName, branchName))
-		fetchOpts.RefSpecs = []config.RefSpec{refSpec}
-	}
-
-	// Perform the fetch
-	err = r.Fetch(fetchOpts)
-
-	// Handle common errors
-	if err != nil {
-		if errors.Is(err, git.NoErrAlreadyUpToDate) {
-			if branchName != "" {
-				return fmt.Sprintf("Branch '%s' is already up-to-date", branchName), nil
-			}
-			return "Repository is already up-to-date", nil
-		} else if err.Error() == "authentication required" || strings.Contains(strings.ToLower(err.Error()), "auth") {
-			return "Authentication failed: please provide valid credentials using the username and password parameters", nil
-		} else {
-			return "", fmt.Errorf("fetch failed: %w", err)
-		}
-	}
-
-	// Success message
-	if branchName != "" {
-		return fmt.Sprintf("Successfully fetched updates from '%s' for branch '%s'", remoteName, branchName), nil
-	}
-	return fmt.Sprintf("Successfully fetched all updates from '%s'", remoteName), nil
-}
-
-func gitCheckout(path, branchName string) (string, error) {
-	if branchName == "" {
-		return "", fmt.Errorf("branch name is required for checkout operation")
-	}
-
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
-	}
-
-	// First try to find the branch locally
-	branchRef := plumbing.NewBranchReferenceName(branchName)
-
-	// Check if the branch exists locally
-	_, err = r.Reference(branchRef, true)
-
-	// If the branch doesn't exist locally, check if it exists as a remote branch
-	if err != nil {
-		// Check if it's a remote branch that we need to create locally
-		remoteRef := plumbing.NewRemoteReferenceName("origin", branchName)
-		remoteRefObj, err := r.Reference(remoteRef, true)
-
-		if err == nil {
-			// Remote branch exists, create a local branch tracking the remote one
-			// Equivalent to: git checkout -b <branch> origin/<branch>
-			refName := plumbing.NewBranchReferenceName(branchName)
-			ref := plumbing.NewSymbolicReference(refName, remoteRefObj.Name())
-
-			err = r.Storer.SetReference(ref)
-			if err != nil {
-				return "", fmt.Errorf("failed to create local branch from remote: %w", err)
-			}
+		if err != nil {
+			slog.Error("task failed", "error", err)
+			return 1
 		}
+		fmt.Fprintln(stdout, answer)
+		return 0
 	}
 
-	// Perform the checkout
-	err = w.Checkout(&git.CheckoutOptions{
-		Branch: branchRef,
-		Force:  false,
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to checkout branch '%s': %w", branchName, err)
-	}
-
-	return fmt.Sprintf("Switched to branch '%s'", branchName), nil
-}
-
-func listBranches(path string) (string, error) {
-	r, err := git.PlainOpen(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	branchRefs, err := r.Branches()
-	if err != nil {
-		return "", fmt.Errorf("failed to list branches: %w", err)
-	}
-
-	var branches []string
-	err = branchRefs.ForEach(func(ref *plumbing.Reference) error {
-		branch := ref.Name().Short()
-		if branch != "" {
-			branches = append(branches, branch)
-		}
-		return nil
-	})
+	err = chat.Run(ctx)
+	chat.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to iterate over branches: %w", err)
+		slog.Error("chat ended", "error", err)
 	}
-
-	if len(branches) == 0 {
-		return "No branches found", nil
-	}
-
-	return strings.Join(branches, "\n"), nil
+	return 0
 }
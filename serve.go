@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"system_3/agent"
+)
+
+// serve runs `system3 serve`: the agent API over HTTP instead of the chat,
+// until interrupted.
+func serve(config agent.Config, provider agent.Provider, tools []agent.ToolDefinition) error {
+	closeLog := agent.SetupLogging(config.LogLevel, "serve")
+	defer closeLog()
+
+	if config.OTLPEndpoint != "" {
+		shutdownTelemetry, err := agent.SetupTelemetry(config.OTLPEndpoint, "serve")
+		if err != nil {
+			slog.Warn("telemetry disabled", "error", err)
+		} else {
+			defer shutdownTelemetry()
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tools = append(tools, agent.LoadPlugins(tools)...)
+	server, err := agent.NewServer(provider, tools, config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving the agent API on http://%s (Ctrl+C to stop)\n", config.ServeAddr)
+	if config.ServeToken == "" {
+		fmt.Printf("Send this bearer token with every request, or set SYSTEM3_SERVE_TOKEN to choose one:\n%s\n", server.Token())
+	}
+	return server.ListenAndServe(ctx, config.ServeAddr)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"system_3/agent"
+)
+
+const sessionsUsage = "usage: system3 sessions [list | open <id> | delete <id>]"
+
+// sessions runs `system3 sessions list` and `system3 sessions delete <id>`.
+// `system3 sessions open <id>` is handled by main as --resume.
+func sessions(args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch {
+	case action == "list" && len(args) <= 1:
+		summaries, err := agent.ListSessions()
+		if err != nil {
+			return err
+		}
+		if len(summaries) == 0 {
+			fmt.Println("No saved sessions")
+			return nil
+		}
+		for _, summary := range summaries {
+			fmt.Println(summary)
+		}
+		return nil
+	case action == "delete" && len(args) == 2:
+		err := agent.DeleteSession(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted session %s\n", args[1])
+		return nil
+	}
+	return errors.New(sessionsUsage)
+}
+
+// sessionsOpenArgs turns `system3 sessions open <id> [flags]` into the
+// arguments of the chat resuming that session.
+func sessionsOpenArgs(args []string) ([]string, bool) {
+	if len(args) < 2 || args[0] != "open" {
+		return nil, false
+	}
+	return append([]string{"--resume", args[1]}, args[2:]...), true
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"system_3/agent"
+)
+
+// maxPipedInput caps how much piped stdin is attached to a one-shot prompt.
+// Longer input keeps its head and tail, which is where logs usually carry
+// the interesting parts.
+const maxPipedInput = 100000
+
+// readPipedInput returns whatever was piped into stdin, or "" when stdin is
+// a terminal.
+func readPipedInput() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return "", nil
+	}
+
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return agent.TruncateOutput(string(content), maxPipedInput), nil
+}